@@ -4,93 +4,179 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golden-vcr/server-common/entry"
 	"github.com/google/uuid"
 )
 
-var ErrNotEnoughPoints = errors.New("not enough points")
-
 type TransactionContext interface {
 	Accept(ctx context.Context) error
 	Finalize(ctx context.Context) error
 }
 
+// Client allows internal services to request transactions from the ledger server, and
+// to read a user's balance, transaction history, and live transaction notifications.
+//
+// Each inflow method accepts an eventId, identifying the Twitch event that triggered
+// the request: if non-empty, it's sent to the ledger as an idempotency key, so that
+// retrying a request for the same event will never credit the user more than once.
 type Client interface {
-	RequestCreditFromCheer(ctx context.Context, accessToken string, numPointsToCredit int, message string) (uuid.UUID, error)
-	RequestCreditFromSubscription(ctx context.Context, accessToken string, basePointsToCredit int, isInitial bool, isGift bool, message string, creditMultiplier float64) (uuid.UUID, error)
-	RequestCreditFromGiftSub(ctx context.Context, accessToken string, basePointsToCredit int, numSubscriptions int, creditMultiplier float64) (uuid.UUID, error)
+	RequestCreditFromCheer(ctx context.Context, accessToken string, eventId string, numBits int, message string) (uuid.UUID, error)
+	RequestCreditFromSubscription(ctx context.Context, accessToken string, eventId string, tier SubscriptionTier, isInitial bool, isGift bool, message string) (uuid.UUID, error)
+	RequestCreditFromGiftSub(ctx context.Context, accessToken string, eventId string, tier SubscriptionTier, numSubscriptions int) (uuid.UUID, error)
+	RequestCreditFromRaid(ctx context.Context, accessToken string, eventId string, numViewers int) (uuid.UUID, error)
+	RequestCreditFromFollow(ctx context.Context, accessToken string, eventId string) (uuid.UUID, error)
+	RequestCreditFromHypeTrain(ctx context.Context, accessToken string, eventId string, level int) (uuid.UUID, error)
+	RequestCreditFromWatchTime(ctx context.Context, accessToken string, streamId string, intervalId string, viewers []WatchTimeViewer) (WatchTimeResult, error)
 	RequestAlertRedemption(ctx context.Context, accessToken string, numPointsToDebit int, alertType string, alertMetadata *json.RawMessage) (TransactionContext, error)
+	RequestOutflow(ctx context.Context, accessToken string, outflowType TransactionType, numPointsToDebit int, metadata json.RawMessage) (TransactionContext, error)
+	GetBalance(ctx context.Context, accessToken string) (Balance, error)
+	GetHistory(ctx context.Context, accessToken string, options HistoryOptions) (TransactionHistory, error)
+	IterateHistory(accessToken string, options HistoryOptions) *HistoryIterator
+	SubscribeNotifications(ctx context.Context, accessToken string) (<-chan Transaction, error)
 }
 
 // NewClient initializes an HTTP client configured to make requests against the
 // golden-vcr/ledger server running at the given URL
 func NewClient(ledgerUrl string) Client {
 	return &client{
-		ledgerUrl: ledgerUrl,
+		ledgerUrl:         ledgerUrl,
+		minReconnectDelay: notificationsMinReconnectDelay,
+		maxReconnectDelay: notificationsMaxReconnectDelay,
 	}
 }
 
 type client struct {
 	http.Client
-	ledgerUrl string
+	ledgerUrl         string
+	minReconnectDelay time.Duration
+	maxReconnectDelay time.Duration
 }
 
-func (c *client) RequestCreditFromCheer(ctx context.Context, accessToken string, numPointsToCredit int, message string) (uuid.UUID, error) {
+func (c *client) RequestCreditFromCheer(ctx context.Context, accessToken string, eventId string, numBits int, message string) (uuid.UUID, error) {
 	// Make a request to POST /inflow/cheer
 	payload := CheerRequest{
-		NumPointsToCredit: numPointsToCredit,
-		Message:           message,
+		NumBits: numBits,
+		Message: message,
 	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
-	return c.postInflow(ctx, accessToken, "/inflow/cheer", payloadBytes)
+	return c.postInflow(ctx, accessToken, eventId, "/inflow/cheer", payloadBytes)
 }
 
-func (c *client) RequestCreditFromSubscription(ctx context.Context, accessToken string, basePointsToCredit int, isInitial bool, isGift bool, message string, creditMultiplier float64) (uuid.UUID, error) {
+func (c *client) RequestCreditFromSubscription(ctx context.Context, accessToken string, eventId string, tier SubscriptionTier, isInitial bool, isGift bool, message string) (uuid.UUID, error) {
 	// Make a request to POST /inflow/subscription
 	payload := SubscriptionRequest{
-		BasePointsToCredit: basePointsToCredit,
-		IsInitial:          isInitial,
-		IsGift:             isGift,
-		Message:            message,
-		CreditMultiplier:   creditMultiplier,
+		Tier:      tier,
+		IsInitial: isInitial,
+		IsGift:    isGift,
+		Message:   message,
 	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
-	return c.postInflow(ctx, accessToken, "/inflow/subscription", payloadBytes)
+	return c.postInflow(ctx, accessToken, eventId, "/inflow/subscription", payloadBytes)
 }
 
-func (c *client) RequestCreditFromGiftSub(ctx context.Context, accessToken string, basePointsToCredit int, numSubscriptions int, creditMultiplier float64) (uuid.UUID, error) {
+func (c *client) RequestCreditFromGiftSub(ctx context.Context, accessToken string, eventId string, tier SubscriptionTier, numSubscriptions int) (uuid.UUID, error) {
 	// Make a request to POST /inflow/gift-sub
 	payload := GiftSubRequest{
-		BasePointsToCredit: basePointsToCredit,
-		NumSubscriptions:   numSubscriptions,
-		CreditMultiplier:   creditMultiplier,
+		Tier:             tier,
+		NumSubscriptions: numSubscriptions,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return c.postInflow(ctx, accessToken, eventId, "/inflow/gift-sub", payloadBytes)
+}
+
+func (c *client) RequestCreditFromRaid(ctx context.Context, accessToken string, eventId string, numViewers int) (uuid.UUID, error) {
+	// Make a request to POST /inflow/raid
+	payload := RaidRequest{
+		NumViewers: numViewers,
 	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
-	return c.postInflow(ctx, accessToken, "/inflow/gift-sub", payloadBytes)
+	return c.postInflow(ctx, accessToken, eventId, "/inflow/raid", payloadBytes)
+}
+
+func (c *client) RequestCreditFromFollow(ctx context.Context, accessToken string, eventId string) (uuid.UUID, error) {
+	// Make a request to POST /inflow/follow: if the user has already been credited for
+	// following, the error will wrap ErrFollowAlreadyCredited
+	payload := FollowRequest{}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return c.postInflow(ctx, accessToken, eventId, "/inflow/follow", payloadBytes)
+}
+
+func (c *client) RequestCreditFromHypeTrain(ctx context.Context, accessToken string, eventId string, level int) (uuid.UUID, error) {
+	// Make a request to POST /inflow/hype-train
+	payload := HypeTrainRequest{
+		Level: level,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return c.postInflow(ctx, accessToken, eventId, "/inflow/hype-train", payloadBytes)
+}
+
+func (c *client) RequestCreditFromWatchTime(ctx context.Context, accessToken string, streamId string, intervalId string, viewers []WatchTimeViewer) (WatchTimeResult, error) {
+	// Make a request to POST /inflow/watch-time: the stream and interval IDs identify
+	// the batch, so a retried request will not credit any viewer twice
+	payload := WatchTimeRequest{
+		StreamId:   streamId,
+		IntervalId: intervalId,
+		Viewers:    viewers,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return WatchTimeResult{}, err
+	}
+	var result WatchTimeResult
+	if err := c.postJSON(ctx, accessToken, "/inflow/watch-time", payloadBytes, &result); err != nil {
+		return WatchTimeResult{}, err
+	}
+	return result, nil
 }
 
 func (c *client) RequestAlertRedemption(ctx context.Context, accessToken string, numPointsToDebit int, alertType string, alertMetadata *json.RawMessage) (TransactionContext, error) {
+	// Alert redemptions are recorded as outflows of the registered 'alert-redemption'
+	// type, with the alert type recorded in metadata.type
+	metadata := make(map[string]interface{})
+	if alertMetadata != nil {
+		if err := json.Unmarshal(*alertMetadata, &metadata); err != nil {
+			return nil, fmt.Errorf("alert metadata must be a JSON object: %w", err)
+		}
+	}
+	metadata["type"] = alertType
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestOutflow(ctx, accessToken, TransactionTypeAlertRedemption, numPointsToDebit, metadataBytes)
+}
+
+func (c *client) RequestOutflow(ctx context.Context, accessToken string, outflowType TransactionType, numPointsToDebit int, metadata json.RawMessage) (TransactionContext, error) {
 	// Build a request payload for POST /outflow
-	payload := AlertRedemptionRequest{
-		Type:             TransactionTypeAlertRedemption,
+	payload := OutflowRequest{
+		Type:             outflowType,
 		NumPointsToDebit: numPointsToDebit,
-		AlertType:        alertType,
-		AlertMetadata:    alertMetadata,
+	}
+	if metadata != nil {
+		payload.Metadata = &metadata
 	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -114,15 +200,13 @@ func (c *client) RequestAlertRedemption(ctx context.Context, accessToken string,
 		return nil, err
 	}
 
-	// A 409 response indicates that the user identified by the auth token does not have
-	// enough points available; propagate that error as ErrNotEnoughPoints
-	if res.StatusCode == http.StatusConflict {
-		return nil, ErrNotEnoughPoints
-	}
+	defer res.Body.Close()
 
-	// For any unexpected or non-OK response, propagate an error and halt
+	// For any unexpected or non-OK response, propagate an error and halt: if the user
+	// identified by the auth token does not have enough points available, the error
+	// will wrap ErrNotEnoughPoints
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("got response %d from POST %s", res.StatusCode, url)
+		return nil, parseErrorResponse(res, http.MethodPost, url)
 	}
 
 	// We have an OK response; parse the response body to get our transaction ID
@@ -142,7 +226,92 @@ func (c *client) RequestAlertRedemption(ctx context.Context, accessToken string,
 	}, nil
 }
 
-func (c *client) postInflow(ctx context.Context, accessToken string, relativeUrl string, payloadBytes []byte) (uuid.UUID, error) {
+func (c *client) GetBalance(ctx context.Context, accessToken string) (Balance, error) {
+	var balance Balance
+	if err := c.getJSON(ctx, accessToken, "/balance", &balance); err != nil {
+		return Balance{}, err
+	}
+	return balance, nil
+}
+
+func (c *client) GetHistory(ctx context.Context, accessToken string, options HistoryOptions) (TransactionHistory, error) {
+	relativeUrl := "/history"
+	if query := options.query().Encode(); query != "" {
+		relativeUrl += "?" + query
+	}
+	var history TransactionHistory
+	if err := c.getJSON(ctx, accessToken, relativeUrl, &history); err != nil {
+		return TransactionHistory{}, err
+	}
+	return history, nil
+}
+
+func (c *client) IterateHistory(accessToken string, options HistoryOptions) *HistoryIterator {
+	return NewHistoryIterator(func(ctx context.Context, options HistoryOptions) (TransactionHistory, error) {
+		return c.GetHistory(ctx, accessToken, options)
+	}, options)
+}
+
+func (c *client) getJSON(ctx context.Context, accessToken string, relativeUrl string, result interface{}) error {
+	// Prepare a GET request to the desired URL, authorized as the user identified by
+	// the given access token
+	url := c.ledgerUrl + relativeUrl
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	// Initiate the request and make sure it completes successfully
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// For any unexpected or non-OK response, propagate an error and halt
+	if res.StatusCode != http.StatusOK {
+		return parseErrorResponse(res, http.MethodGet, url)
+	}
+
+	// We have an OK response; parse the JSON response body into the result value
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding response body: %w", err)
+	}
+	return nil
+}
+
+func (c *client) postJSON(ctx context.Context, accessToken string, relativeUrl string, payloadBytes []byte, result interface{}) error {
+	// Prepare a POST request to the desired URL, authorized by the given access token
+	url := c.ledgerUrl + relativeUrl
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	// Initiate the request and make sure it completes successfully
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// For any unexpected or non-OK response, propagate an error and halt
+	if res.StatusCode != http.StatusOK {
+		return parseErrorResponse(res, http.MethodPost, url)
+	}
+
+	// We have an OK response; parse the JSON response body into the result value
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding response body: %w", err)
+	}
+	return nil
+}
+
+func (c *client) postInflow(ctx context.Context, accessToken string, eventId string, relativeUrl string, payloadBytes []byte) (uuid.UUID, error) {
 	// Prepare a POST request to the desired URL that will create and finalize an inflow
 	// that credits an appropriate number of points to the user identified by the JWT,
 	// with the request authorized by virtue of the fact that the JWT was signed and
@@ -155,19 +324,24 @@ func (c *client) postInflow(ctx context.Context, accessToken string, relativeUrl
 	req = entry.ConveyRequestId(ctx, req)
 	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
 
+	// If we know which event triggered this inflow, identify it by way of an
+	// idempotency key: the ledger will then ignore any duplicate request for the same
+	// event, returning the original transaction ID instead of crediting the user again
+	if eventId != "" {
+		req.Header.Set(IdempotencyKeyHeader, eventId)
+	}
+
 	// Initiate the request and make sure it completes successfully
 	res, err := c.Do(req)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
 
+	defer res.Body.Close()
+
 	// For any unexpected or non-OK response, propagate an error and halt
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		suffix := ""
-		if body, err := io.ReadAll(res.Body); err == nil {
-			suffix = fmt.Sprintf(": %s", body)
-		}
-		return uuid.UUID{}, fmt.Errorf("got response %d from POST %s%s", res.StatusCode, url, suffix)
+		return uuid.UUID{}, parseErrorResponse(res, http.MethodPost, url)
 	}
 
 	// We have an OK response; parse the response body to get our transaction ID
@@ -202,8 +376,9 @@ func (c *client) finalize(ctx context.Context, accessToken string, flowId uuid.U
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("got response %d from %s %s", res.StatusCode, method, url)
+		return parseErrorResponse(res, method, url)
 	}
 	return nil
 }
@@ -217,7 +392,7 @@ type transactionContext struct {
 
 func (t *transactionContext) Accept(ctx context.Context) error {
 	if t.finalized {
-		return fmt.Errorf("transaction has already been finalized upon call to Accept")
+		return fmt.Errorf("%w upon call to Accept", ErrFlowAlreadyFinalized)
 	}
 	if err := t.c.finalize(ctx, t.accessToken, t.flowId, true); err != nil {
 		return err
@@ -0,0 +1,279 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/auth"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_client_GetBalance(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/balance" || req.Header.Get("authorization") != "Bearer mock-token" {
+			http.Error(res, "access denied", http.StatusUnauthorized)
+			return
+		}
+		res.Write([]byte(`{"totalPoints":1000,"availablePoints":800}`))
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	balance, err := c.GetBalance(context.Background(), "mock-token")
+	assert.NoError(t, err)
+	assert.Equal(t, Balance{TotalPoints: 1000, AvailablePoints: 800}, balance)
+
+	_, err = c.GetBalance(context.Background(), "bad-token")
+	assert.EqualError(t, err, fmt.Sprintf("got response 401 from GET %s/balance: access denied", srv.URL))
+	assert.ErrorIs(t, err, auth.ErrUnauthorized)
+}
+
+func Test_client_RequestCreditFromWatchTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/inflow/watch-time" || req.Header.Get("authorization") != "Bearer internal-jwt" {
+			http.Error(res, "access denied", http.StatusUnauthorized)
+			return
+		}
+		var payload WatchTimeRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil || payload.StreamId != "stream-1" || payload.IntervalId != "interval-1" {
+			http.Error(res, "bad request", http.StatusBadRequest)
+			return
+		}
+		numPoints := 0
+		for _, viewer := range payload.Viewers {
+			numPoints += 2 * viewer.MinutesWatched
+		}
+		fmt.Fprintf(res, `{"numViewersCredited":%d,"numPointsCredited":%d}`, len(payload.Viewers), numPoints)
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	result, err := c.RequestCreditFromWatchTime(context.Background(), "internal-jwt", "stream-1", "interval-1", []WatchTimeViewer{
+		{TwitchUserId: "1001", MinutesWatched: 5},
+		{TwitchUserId: "1002", MinutesWatched: 3},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, WatchTimeResult{NumViewersCredited: 2, NumPointsCredited: 16}, result)
+
+	_, err = c.RequestCreditFromWatchTime(context.Background(), "bad-token", "stream-1", "interval-1", nil)
+	assert.EqualError(t, err, fmt.Sprintf("got response 401 from POST %s/inflow/watch-time: access denied", srv.URL))
+	assert.ErrorIs(t, err, auth.ErrUnauthorized)
+}
+
+func Test_client_IterateHistory(t *testing.T) {
+	pages := map[string]string{
+		"":       `{"items":[{"id":"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f","deltaPoints":-200},{"id":"18d3d13c-625e-46df-bd34-e2cc2b7be15e","deltaPoints":5000}],"nextCursor":"page-2"}`,
+		"page-2": `{"items":[{"id":"0db47d1c-41f9-4808-bc8d-bf097eeb6319","deltaPoints":2500}],"prevCursor":"page-1"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/history", req.URL.Path)
+		assert.Equal(t, "2", req.URL.Query().Get("max"))
+		assert.Equal(t, []string{"cheer", "manual-credit"}, req.URL.Query()["type"])
+		assert.Equal(t, "1997-09-01T00:00:00Z", req.URL.Query().Get("since"))
+		page, ok := pages[req.URL.Query().Get("from")]
+		if !ok {
+			http.Error(res, "unknown cursor", http.StatusBadRequest)
+			return
+		}
+		res.Write([]byte(page))
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	it := c.IterateHistory("mock-token", HistoryOptions{
+		Max:   2,
+		Types: []TransactionType{TransactionTypeCheer, TransactionTypeManualCredit},
+		Since: time.Date(1997, 9, 1, 0, 0, 0, 0, time.UTC),
+	})
+	ids := make([]uuid.UUID, 0)
+	for it.Next(context.Background()) {
+		for _, item := range it.Page().Items {
+			ids = append(ids, item.Id)
+		}
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+		uuid.MustParse("18d3d13c-625e-46df-bd34-e2cc2b7be15e"),
+		uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+	}, ids)
+
+	it = c.IterateHistory("mock-token", HistoryOptions{
+		Max:   2,
+		Types: []TransactionType{TransactionTypeCheer, TransactionTypeManualCredit},
+		Since: time.Date(1997, 9, 1, 0, 0, 0, 0, time.UTC),
+		From:  "bogus",
+	})
+	assert.False(t, it.Next(context.Background()))
+	assert.ErrorIs(t, it.Err(), ErrInvalidRequest)
+	assert.EqualError(t, it.Err(), fmt.Sprintf("got response 400 from GET %s/history?from=bogus&max=2&since=1997-09-01T00%%3A00%%3A00Z&type=cheer&type=manual-credit: unknown cursor", srv.URL))
+}
+
+func Test_client_errors(t *testing.T) {
+	// Simulate a server that responds to each request with a problem details body,
+	// carrying the error code specified by the test case
+	code := ErrorCode("")
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost && req.URL.Path == "/outflow" && code == "" {
+			res.Write([]byte(`{"flowId":"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"}`))
+			return
+		}
+		res.Header().Set("content-type", ProblemContentType)
+		res.WriteHeader(code.Status())
+		json.NewEncoder(res).Encode(Problem{
+			Title:  http.StatusText(code.Status()),
+			Status: code.Status(),
+			Code:   code,
+			Detail: "something went wrong",
+		})
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+
+	code = ErrorCodeNotEnoughPoints
+	_, err := c.RequestAlertRedemption(context.Background(), "mock-token", 300, "foo", nil)
+	assert.ErrorIs(t, err, ErrNotEnoughPoints)
+	assert.EqualError(t, err, fmt.Sprintf("got response 409 from POST %s/outflow: something went wrong", srv.URL))
+
+	code = ErrorCodeIdempotencyConflict
+	_, err = c.RequestCreditFromCheer(context.Background(), "mock-token", "event-1", 500, "")
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	code = ErrorCodeFollowAlreadyCredited
+	_, err = c.RequestCreditFromFollow(context.Background(), "mock-token", "event-1")
+	assert.ErrorIs(t, err, ErrFollowAlreadyCredited)
+	assert.EqualError(t, err, fmt.Sprintf("got response 409 from POST %s/inflow/follow: something went wrong", srv.URL))
+
+	code = ErrorCodeInvalidRequest
+	_, err = c.RequestCreditFromCheer(context.Background(), "mock-token", "event-1", 500, "")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	var e *Error
+	assert.ErrorAs(t, err, &e)
+	assert.Equal(t, ErrorCodeInvalidRequest, e.Problem.Code)
+	assert.Equal(t, "something went wrong", e.Problem.Detail)
+
+	code = ""
+	transaction, err := c.RequestAlertRedemption(context.Background(), "mock-token", 300, "foo", nil)
+	assert.NoError(t, err)
+	code = ErrorCodeFlowAlreadyFinalized
+	assert.ErrorIs(t, transaction.Accept(context.Background()), ErrFlowAlreadyFinalized)
+	code = ErrorCodeFlowNotFound
+	assert.ErrorIs(t, transaction.Accept(context.Background()), ErrFlowNotFound)
+
+	code = ErrorCodeInternal
+	_, err = c.GetBalance(context.Background(), "mock-token")
+	assert.Error(t, err)
+	assert.Nil(t, errors.Unwrap(err))
+}
+
+func Test_client_SubscribeNotifications(t *testing.T) {
+	// Simulate a server that issues SSE tokens, then serves each connection one
+	// notification before dropping it, so that the client has to reconnect
+	var mu sync.Mutex
+	numTokensIssued := 0
+	revoked := false
+	lastEventIds := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Method == http.MethodPost {
+			if revoked || req.Header.Get("authorization") != "Bearer mock-token" {
+				http.Error(res, "access denied", http.StatusUnauthorized)
+				return
+			}
+			numTokensIssued++
+			res.Write([]byte(fmt.Sprintf("sse-token-%d", numTokensIssued)))
+			return
+		}
+		token := req.URL.Query().Get("token")
+		if token != fmt.Sprintf("sse-token-%d", numTokensIssued) {
+			http.Error(res, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		lastEventIds = append(lastEventIds, req.Header.Get("last-event-id"))
+		res.Header().Set("content-type", "text/event-stream")
+		res.WriteHeader(http.StatusOK)
+		res.Write([]byte(":\n\n"))
+		data, _ := json.Marshal(Transaction{DeltaPoints: numTokensIssued * 100, Description: "line one"})
+		fmt.Fprintf(res, "id: %d\ndata: %s\n\n", numTokensIssued, data)
+		if numTokensIssued == 2 {
+			revoked = true
+		}
+	}))
+	defer srv.Close()
+	c := &client{
+		ledgerUrl:         srv.URL,
+		minReconnectDelay: time.Millisecond,
+		maxReconnectDelay: 10 * time.Millisecond,
+	}
+
+	_, err := c.SubscribeNotifications(context.Background(), "bad-token")
+	assert.ErrorIs(t, err, auth.ErrUnauthorized)
+
+	ch, err := c.SubscribeNotifications(context.Background(), "mock-token")
+	assert.NoError(t, err)
+	deltas := make([]int, 0)
+	for transaction := range ch {
+		deltas = append(deltas, transaction.DeltaPoints)
+	}
+
+	// Once our access token is no longer accepted, the channel should be closed
+	assert.Equal(t, []int{100, 200}, deltas)
+
+	// Each time we reconnected, we should have asked the server to replay any events
+	// since the last one we saw
+	assert.Equal(t, []string{"", "1"}, lastEventIds)
+}
+
+func Test_readTransactionEvents(t *testing.T) {
+	ch := make(chan Transaction, 8)
+	body := ":\n\n" +
+		"data: {\"deltaPoints\":100,\n" +
+		"data: \"description\":\"split across lines\"}\n\n" +
+		"id: 1\n" +
+		"data:{\"deltaPoints\":200}\n" +
+		"\n" +
+		"data: not json\n\n"
+	lastEventId, err := readTransactionEvents(context.Background(), strings.NewReader(body), ch)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", lastEventId)
+	close(ch)
+
+	transactions := make([]Transaction, 0)
+	for transaction := range ch {
+		transactions = append(transactions, transaction)
+	}
+	assert.Equal(t, []Transaction{
+		{DeltaPoints: 100, Description: "split across lines"},
+		{DeltaPoints: 200},
+	}, transactions)
+}
+
+func Test_readTransactionEvents_resync(t *testing.T) {
+	ch := make(chan Transaction, 8)
+	body := "id: 7\ndata: {\"deltaPoints\":100}\n\n" +
+		"event: transaction\ndata: {\"deltaPoints\":150}\n\n" +
+		"event: unknown\ndata: {\"deltaPoints\":999}\n\n" +
+		"event: resync\ndata: {}\n\n" +
+		"id: 8\ndata: {\"deltaPoints\":200}\n\n"
+	lastEventId, err := readTransactionEvents(context.Background(), strings.NewReader(body), ch)
+	assert.ErrorIs(t, err, errResyncRequired)
+	assert.Equal(t, "7", lastEventId)
+	close(ch)
+
+	// We should stop reading at the resync event, and ignore events of unknown types
+	transactions := make([]Transaction, 0)
+	for transaction := range ch {
+		transactions = append(transactions, transaction)
+	}
+	assert.Equal(t, []Transaction{{DeltaPoints: 100}, {DeltaPoints: 150}}, transactions)
+}
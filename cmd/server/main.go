@@ -2,7 +2,6 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -10,16 +9,20 @@ import (
 	"github.com/codingconcepts/env"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	"github.com/lib/pq"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger/gen/queries"
 	"github.com/golden-vcr/ledger/internal/admin"
 	"github.com/golden-vcr/ledger/internal/cheer"
+	"github.com/golden-vcr/ledger/internal/community"
 	"github.com/golden-vcr/ledger/internal/notifications"
 	"github.com/golden-vcr/ledger/internal/outflow"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/golden-vcr/ledger/internal/promotions"
 	"github.com/golden-vcr/ledger/internal/records"
 	"github.com/golden-vcr/ledger/internal/subscription"
+	"github.com/golden-vcr/ledger/internal/watchtime"
+	"github.com/golden-vcr/ledger/internal/webhooks"
 	"github.com/golden-vcr/server-common/db"
 	"github.com/golden-vcr/server-common/entry"
 )
@@ -39,6 +42,30 @@ type Config struct {
 	DatabaseUser     string `env:"PGUSER" required:"true"`
 	DatabasePassword string `env:"PGPASSWORD" required:"true"`
 	DatabaseSslMode  string `env:"PGSSLMODE"`
+
+	PendingOutflowTtl      time.Duration `env:"PENDING_OUTFLOW_TTL" default:"10m"`
+	ExpiredOutflowInterval time.Duration `env:"EXPIRED_OUTFLOW_INTERVAL" default:"30s"`
+
+	PointsPerBit             int     `env:"POINTS_PER_BIT" default:"1"`
+	PointsPerSubscription    int     `env:"POINTS_PER_SUBSCRIPTION" default:"600"`
+	PointsPerGiftSub         int     `env:"POINTS_PER_GIFT_SUB" default:"200"`
+	PointsTier2Multiplier    float64 `env:"POINTS_TIER_2_MULTIPLIER" default:"2.0"`
+	PointsTier3Multiplier    float64 `env:"POINTS_TIER_3_MULTIPLIER" default:"5.0"`
+	InitialSubscriptionBonus int     `env:"INITIAL_SUBSCRIPTION_BONUS" default:"0"`
+	PointsPerRaidViewer      int     `env:"POINTS_PER_RAID_VIEWER" default:"10"`
+	PointsPerFollow          int     `env:"POINTS_PER_FOLLOW" default:"50"`
+	PointsPerHypeTrainLevel  int     `env:"POINTS_PER_HYPE_TRAIN_LEVEL" default:"100"`
+	PointsPerWatchMinute     int     `env:"POINTS_PER_WATCH_MINUTE" default:"2"`
+	WatchTimeDailyCap        int     `env:"WATCH_TIME_DAILY_CAP" default:"480"`
+
+	WebhookDeliveryInterval time.Duration `env:"WEBHOOK_DELIVERY_INTERVAL" default:"5s"`
+
+	SseQueueSize      int    `env:"SSE_QUEUE_SIZE" default:"32"`
+	SseOverflowPolicy string `env:"SSE_OVERFLOW_POLICY" default:"resync"`
+
+	SseTokenTtl           time.Duration `env:"SSE_TOKEN_TTL" default:"10m"`
+	SseTokenSingleUse     bool          `env:"SSE_TOKEN_SINGLE_USE" default:"false"`
+	SseTokenPurgeInterval time.Duration `env:"SSE_TOKEN_PURGE_INTERVAL" default:"5m"`
 }
 
 func main() {
@@ -54,6 +81,32 @@ func main() {
 	if err := env.Set(&config); err != nil {
 		app.Fail("Failed to load config", err)
 	}
+	sseOverflowPolicy, err := notifications.ParseOverflowPolicy(config.SseOverflowPolicy)
+	if err != nil {
+		app.Fail("Invalid value for SSE_OVERFLOW_POLICY", err)
+	}
+	if config.SseQueueSize < 1 {
+		app.Fail("Invalid value for SSE_QUEUE_SIZE", fmt.Errorf("queue size must be positive"))
+	}
+	if config.SseTokenTtl < time.Second {
+		app.Fail("Invalid value for SSE_TOKEN_TTL", fmt.Errorf("TTL must be at least 1s"))
+	}
+	pointsPolicy := points.Policy{
+		PointsPerBit:             config.PointsPerBit,
+		PointsPerSubscription:    config.PointsPerSubscription,
+		PointsPerGiftSub:         config.PointsPerGiftSub,
+		Tier2Multiplier:          config.PointsTier2Multiplier,
+		Tier3Multiplier:          config.PointsTier3Multiplier,
+		InitialSubscriptionBonus: config.InitialSubscriptionBonus,
+		PointsPerRaidViewer:      config.PointsPerRaidViewer,
+		PointsPerFollow:          config.PointsPerFollow,
+		PointsPerHypeTrainLevel:  config.PointsPerHypeTrainLevel,
+		PointsPerWatchMinute:     config.PointsPerWatchMinute,
+		WatchTimeDailyCap:        config.WatchTimeDailyCap,
+	}
+	if err := pointsPolicy.Validate(); err != nil {
+		app.Fail("Invalid points policy", err)
+	}
 
 	// Configure our database connection and initialize a Queries struct, so we can read
 	// and write to the 'showtime' schema in response to HTTP requests, EventSub
@@ -76,42 +129,22 @@ func main() {
 	}
 	q := queries.New(db)
 
+	// Record the points policy we've been configured with, so that the policy version
+	// recorded with each cheer or subscription can always be traced back to the values
+	// that determined how many points were credited
+	if err := pointsPolicy.Register(app.Context(), q); err != nil {
+		app.Fail("Failed to register points policy", err)
+	}
+
 	// Initialize a database listener that will notify us whenever transactions are
-	// created or updated
-	pqListener := pq.NewListener(connectionString, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
-		switch ev {
-		case pq.ListenerEventConnected:
-			fmt.Printf("pq listener connected (err: %v)\n", err)
-		case pq.ListenerEventDisconnected:
-			fmt.Printf("pq listener disconnected (err: %v)\n", err)
-		case pq.ListenerEventReconnected:
-			fmt.Printf("pq listener reconnected (err: %v)\n", err)
-		case pq.ListenerEventConnectionAttemptFailed:
-			fmt.Printf("pq listener connection attempt failed (err: %v)\n", err)
-		}
-		if err != nil {
-			app.Fail("pq.Listener failed", err)
-		}
-	})
-	if err := pqListener.Listen("ledger_flow_change"); err != nil {
-		app.Fail("Failed to issue LISTEN command for pq listener", err)
-	}
-	pqEvents := make(chan *notifications.FlowChangeNotification)
-	go func() {
-		for {
-			select {
-			case <-app.Context().Done():
-				return
-			case notification := <-pqListener.NotificationChannel():
-				var event notifications.FlowChangeNotification
-				if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
-					fmt.Printf("Failed to unmarshal extra data from notification: %v\n", err)
-					continue
-				}
-				pqEvents <- &event
-			}
-		}
-	}()
+	// created or updated. If the connection to the database is lost, the listener will
+	// keep trying to reconnect, and connected clients will be told to resync once it
+	// succeeds.
+	pqEvents, err := notifications.NewPostgresEventSource(connectionString)
+	if err != nil {
+		app.Fail("Failed to initialize pq listener", err)
+	}
+	go pqEvents.Run(app.Context())
 
 	// Prepare an auth client that we can use to validate (and identify users from)
 	// Twitch user access tokens
@@ -123,48 +156,109 @@ func main() {
 	// Start setting up our HTTP handlers, using gorilla/mux for routing
 	r := mux.NewRouter()
 
+	// Admin routes accept a Twitch display name in place of a user ID, resolving it via
+	// the Twitch API
+	resolveTwitchUserId := admin.NewResolveTwitchUserIdFunc(config.TwitchClientId, config.TwitchClientSecret)
+
+	// Activity reported via the firehose stream is attributed to users by display name,
+	// which is looked up via the Twitch API if not already known
+	lookupTwitchDisplayName := admin.NewLookupTwitchDisplayNameFunc(config.TwitchClientId, config.TwitchClientSecret)
+
 	// The webapp makes requests to GET /balance or GET /history, authenticated with the
-	// logged-in user's auth token, in order to get records for that user
+	// logged-in user's auth token, in order to get records for that user. The
+	// broadcaster may use GET /admin/users/:user/balance and /history to see the same
+	// records for any user. The broadcaster may also use GET /notifications/stats to
+	// monitor the health of all connected SSE clients, and GET /notifications/firehose to
+	// follow all activity across all users. Expired SSE tokens are purged in the
+	// background, and a user may revoke all of their tokens via DELETE
+	// /notifications/token.
 	{
-		recordsServer := records.NewServer(q)
+		recordsServer := records.NewServer(q, resolveTwitchUserId)
 		recordsServer.RegisterRoutes(authClient, r)
 
-		notificationsServer := notifications.NewServer(app.Context(), q, pqEvents)
-		go notificationsServer.ReadPostgresNotifications(app.Context())
+		sseTokenPolicy := notifications.TokenPolicy{
+			Ttl:       config.SseTokenTtl,
+			SingleUse: config.SseTokenSingleUse,
+		}
+		notificationsServer := notifications.NewServer(app.Context(), q, pqEvents, config.SseQueueSize, sseOverflowPolicy, sseTokenPolicy, lookupTwitchDisplayName)
+		go notificationsServer.ReadEvents(app.Context())
+		go notificationsServer.PurgeExpiredTokens(app.Context(), config.SseTokenPurgeInterval)
 		notificationsServer.RegisterRoutes(authClient, r)
 	}
 
 	// Admin-only sections of the webapp can make requests to POST /inflow/manual-credit
 	// in order to award discretionary points to any user
 	{
-		adminServer := admin.NewServer(q, config.TwitchClientId, config.TwitchClientSecret)
+		adminServer := admin.NewServer(q, admin.NewTxRunner(db), resolveTwitchUserId)
 		adminServer.RegisterRoutes(authClient, r)
 	}
 
-	// The showtime service can use POST /inflow/cheer to award bits in response to the
+	// The showtime service can use POST /inflow/cheer to award points in response to the
 	// Twitch channel.cheer webhook, which is called to signify the receipt of bits via
-	// Twitch. This route is authorized only when the request carries an authoritative
-	// JWT that was issued by the auth server to another internal service.
+	// Twitch: the number of points credited is determined by our points policy. This
+	// route is authorized only when the request carries an authoritative JWT that was
+	// issued by the auth server to another internal service.
 	{
-		cheerServer := cheer.NewServer(q)
+		cheerServer := cheer.NewServer(q, pointsPolicy)
 		cheerServer.RegisterRoutes(r, authClient)
 	}
 
 	// POST /inflow/subscription and POST /inflow/gift-sub work similarly, responding to
 	// Twitch events by granting points as thanks for subscriptions
 	{
-		subscriptionServer := subscription.NewServer(q)
+		subscriptionServer := subscription.NewServer(q, pointsPolicy)
 		subscriptionServer.RegisterRoutes(r, authClient)
 	}
 
+	// POST /inflow/raid, POST /inflow/follow, and POST /inflow/hype-train likewise
+	// grant points for raids, first-time follows, and hype train contributions: each
+	// user is credited for following no more than once
+	{
+		communityServer := community.NewServer(q, pointsPolicy)
+		communityServer.RegisterRoutes(r, authClient)
+	}
+
+	// POST /inflow/watch-time credits a batch of viewers for the time they spent watching
+	// a single interval of a stream, all in one database transaction: each interval is
+	// credited only once, no viewer may exceed their daily cap, and each viewer is
+	// credited via a separate transaction for each interval, which history combines into
+	// a single entry per stream
+	{
+		watchTimeServer := watchtime.NewServer(watchtime.NewTxRunner(db), pointsPolicy)
+		watchTimeServer.RegisterRoutes(r, authClient)
+	}
+
+	// The broadcaster can use POST /admin/promotions to schedule time-boxed promotions
+	// (e.g. "Double Points Night") that scale the points credited for cheers,
+	// subscriptions, gift subs, raids, follows, and hype trains, GET /admin/promotions to
+	// list promotions that are active or upcoming, and DELETE /admin/promotions/:id to
+	// cancel a promotion early
+	{
+		promotionsServer := promotions.NewServer(q)
+		promotionsServer.RegisterRoutes(authClient, r)
+	}
+
 	// Internal APIs can use POST /outflow to create pending transactions that deduct
 	// points in order to take advantage of app features, and PATCH|DELETE /outflow/:id
-	// to finalize those transactions
+	// to finalize those transactions. Any pending transaction that's not finalized
+	// before it expires will be rejected automatically in the background.
 	{
-		outflowServer := outflow.NewServer(q)
+		outflowServer := outflow.NewServer(q, config.PendingOutflowTtl)
+		go outflowServer.RejectExpiredOutflows(app.Context(), config.ExpiredOutflowInterval)
 		outflowServer.RegisterRoutes(authClient, r)
 	}
 
+	// The broadcaster can use POST /admin/webhooks to register URLs that other services
+	// expose in order to be notified of transactions of a given type. Each change is
+	// recorded in an outbox by the database, and delivered in the background with
+	// retries; GET /admin/webhooks/deliveries/failed lists any deliveries that we've
+	// given up on, which can then be redriven.
+	{
+		webhooksServer := webhooks.NewServer(q)
+		go webhooksServer.DeliverWebhooks(app.Context(), config.WebhookDeliveryInterval)
+		webhooksServer.RegisterRoutes(authClient, r)
+	}
+
 	// Handle incoming HTTP connections until our top-level context is canceled, at
 	// which point shut down cleanly
 	entry.RunServer(app, r, config.BindAddr, int(config.ListenPort))
@@ -0,0 +1,7 @@
+begin;
+
+drop trigger check_available_balance_on_flow_change on ledger.flow;
+
+drop function check_flow_available_balance;
+
+commit;
@@ -0,0 +1,53 @@
+begin;
+
+create function check_flow_available_balance() returns trigger as $trigger$
+declare
+    old_contribution integer := 0;
+    new_contribution integer := 0;
+    available        integer;
+begin
+    -- Only a change that reduces the user's available balance (e.g. a new pending
+    -- outflow) can drive that balance below zero; anything else is always permitted
+    if TG_OP = 'UPDATE' and OLD.affects_available_balance then
+        old_contribution := OLD.delta_points;
+    end if;
+    if NEW.affects_available_balance then
+        new_contribution := NEW.delta_points;
+    end if;
+    if new_contribution >= old_contribution then
+        return NEW;
+    end if;
+
+    -- Serialize all balance-reducing changes for the same user: once we hold this
+    -- lock, any concurrent transaction that got here first has committed or rolled
+    -- back, so the balance we read below reflects every competing outflow
+    perform pg_advisory_xact_lock(hashtext('ledger.flow'), hashtext(NEW.twitch_user_id));
+
+    select balance.available_points into available
+    from ledger.balance
+    where balance.twitch_user_id = NEW.twitch_user_id;
+
+    if coalesce(available, 0) < 0 then
+        raise exception 'available balance for user % may not go below zero', NEW.twitch_user_id
+            using
+                errcode = 'check_violation',
+                schema = 'ledger',
+                table = 'flow',
+                constraint = 'flow_available_balance_check';
+    end if;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger check_available_balance_on_flow_change
+    after insert or update on ledger.flow
+    for each row execute procedure check_flow_available_balance();
+
+comment on trigger check_available_balance_on_flow_change on ledger.flow is
+    'Ensures that no transaction may be recorded or updated in a way that would reduce '
+    'the user''s available balance below zero. Concurrent changes for the same user '
+    'are serialized via a transaction-scoped advisory lock, so two outflows that are '
+    'individually affordable can not both succeed if together they are not. Raises a '
+    'check_violation error naming flow_available_balance_check if violated.';
+
+commit;
@@ -0,0 +1,11 @@
+begin;
+
+drop index ledger.flow_pending_expires_at_index;
+
+alter table ledger.flow
+    drop constraint flow_expires_at_check;
+
+alter table ledger.flow
+    drop column expires_at;
+
+commit;
@@ -0,0 +1,27 @@
+begin;
+
+alter table ledger.flow
+    add column expires_at timestamptz;
+
+comment on column ledger.flow.expires_at is
+    'Time at which this transaction should be automatically rejected if it is still '
+    'pending, so that a caller which never finalizes an outflow can not lock up the '
+    'user''s available balance indefinitely. If NULL, the transaction never expires.';
+
+alter table ledger.flow
+    add constraint flow_expires_at_check
+    check (
+        flow.expires_at is null or flow.expires_at > flow.created_at
+    );
+
+comment on constraint flow_expires_at_check on ledger.flow is
+    'Ensures that a transaction may not expire before it was created.';
+
+create index flow_pending_expires_at_index
+    on ledger.flow (expires_at)
+    where finalized_at is null and expires_at is not null;
+
+comment on index ledger.flow_pending_expires_at_index is
+    'Allows pending transactions that have expired to be found efficiently.';
+
+commit;
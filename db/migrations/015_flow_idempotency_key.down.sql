@@ -0,0 +1,8 @@
+begin;
+
+drop index ledger.flow_type_idempotency_key_index;
+
+alter table ledger.flow
+    drop column idempotency_key;
+
+commit;
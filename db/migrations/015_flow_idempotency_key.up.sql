@@ -0,0 +1,20 @@
+begin;
+
+alter table ledger.flow
+    add column idempotency_key text;
+
+comment on column ledger.flow.idempotency_key is
+    'Optional caller-supplied key that uniquely identifies the event which caused this '
+    'transaction to be recorded (e.g. the ID of the originating Twitch event). If a '
+    'request to record a transaction is retried with the same key, the original '
+    'transaction is returned instead of a new one being recorded.';
+
+create unique index flow_type_idempotency_key_index
+    on ledger.flow (type, idempotency_key)
+    where idempotency_key is not null;
+
+comment on index ledger.flow_type_idempotency_key_index is
+    'Ensures that at most one transaction of any given type may be recorded for the '
+    'same idempotency key.';
+
+commit;
@@ -0,0 +1,28 @@
+begin;
+
+create or replace function emit_flow_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('ledger_flow_change', jsonb_build_object(
+	    'twitch_user_id', NEW.twitch_user_id,
+	    'id', NEW.id,
+	    'type', NEW.type,
+	    'metadata', NEW.metadata,
+	    'delta_points', NEW.delta_points,
+	    'created_at', NEW.created_at,
+	    'finalized_at', NEW.finalized_at,
+	    'accepted', NEW.accepted
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+alter table ledger.flow_type
+    drop column description_template;
+
+alter table ledger.flow_type
+    drop column metadata_schema;
+
+alter table ledger.flow_type
+    drop column is_registered_outflow;
+
+commit;
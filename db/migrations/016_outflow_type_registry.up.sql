@@ -0,0 +1,62 @@
+begin;
+
+alter table ledger.flow_type
+    add column is_registered_outflow boolean not null default false;
+
+comment on column ledger.flow_type.is_registered_outflow is
+    'If true, this flow type has been registered as a type of outflow that internal '
+    'APIs may create on a user''s behalf via POST /outflow, without any type-specific '
+    'support in the ledger service itself.';
+
+alter table ledger.flow_type
+    add column metadata_schema jsonb;
+
+comment on column ledger.flow_type.metadata_schema is
+    'JSON Schema document describing the metadata that must accompany a transaction '
+    'of this type. If set, the metadata of any registered outflow created via POST '
+    '/outflow is validated against this schema.';
+
+alter table ledger.flow_type
+    add column description_template text;
+
+comment on column ledger.flow_type.description_template is
+    'Go text/template string used to render a user-facing description of any '
+    'transaction of this type, executed with the transaction''s metadata object as its '
+    'data, e.g. "Redeemed alert of type ''{{.type}}''". If NULL, descriptions are '
+    'generated by the ledger service itself.';
+
+update ledger.flow_type set
+    is_registered_outflow = true,
+    metadata_schema = '{
+        "type": "object",
+        "required": ["type"],
+        "properties": {
+            "type": {"type": "string", "minLength": 1}
+        }
+    }'::jsonb,
+    description_template = 'Redeemed alert of type ''{{.type}}'''
+where flow_type.name = 'alert-redemption';
+
+-- Include the description template for the flow's type in every change notification,
+-- so that listeners can describe transactions of any registered type
+create or replace function emit_flow_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('ledger_flow_change', jsonb_build_object(
+	    'twitch_user_id', NEW.twitch_user_id,
+	    'id', NEW.id,
+	    'type', NEW.type,
+	    'metadata', NEW.metadata,
+	    'delta_points', NEW.delta_points,
+	    'created_at', NEW.created_at,
+	    'finalized_at', NEW.finalized_at,
+	    'accepted', NEW.accepted,
+	    'description_template', (
+	        select flow_type.description_template from ledger.flow_type
+	        where flow_type.name = NEW.type
+	    )
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+commit;
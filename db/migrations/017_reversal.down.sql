@@ -0,0 +1,16 @@
+begin;
+
+drop trigger check_reversal_amount_on_flow_insert on ledger.flow;
+drop function check_flow_reversal_amount;
+
+alter table ledger.flow
+    drop constraint flow_reversal_check;
+
+drop index ledger.flow_reversed_flow_id_index;
+
+alter table ledger.flow
+    drop column reversed_flow_id;
+
+delete from ledger.flow_type where flow_type.name = 'reversal';
+
+commit;
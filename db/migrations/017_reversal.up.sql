@@ -0,0 +1,100 @@
+begin;
+
+insert into ledger.flow_type (name, comment) values (
+    'reversal',
+    'Compensating transaction recorded by an admin in order to undo some or all of the '
+    'effect of a previously-accepted transaction, e.g. to take back points from a '
+    'wrongly-credited cheer, or to refund an alert that failed after it was accepted. '
+    'The reversal''s reversed_flow_id column identifies the original transaction, '
+    'which is never modified. The reversal''s delta_points must have the opposite sign '
+    'to that of the original transaction, and its metadata.note field must be set to '
+    'a non-empty string describing the reason for the reversal. metadata.reversed_type '
+    'and metadata.reversed_metadata record the type and metadata of the original '
+    'transaction, so that the reversal can be described without a lookup.'
+);
+
+alter table ledger.flow
+    add column reversed_flow_id uuid references ledger.flow (id);
+
+comment on column ledger.flow.reversed_flow_id is
+    'For a reversal, the ID of the original transaction whose effect is being undone. '
+    'NULL for any other type of transaction.';
+
+create index flow_reversed_flow_id_index
+    on ledger.flow (reversed_flow_id)
+    where reversed_flow_id is not null;
+
+comment on index ledger.flow_reversed_flow_id_index is
+    'Allows efficient lookup of all reversals recorded against a given transaction.';
+
+alter table ledger.flow
+    add constraint flow_reversal_check check (
+        case when flow.type != 'reversal' then flow.reversed_flow_id is null else
+            flow.reversed_flow_id is not null
+            and flow.delta_points != 0
+            and jsonb_typeof(flow.metadata->'note') = 'string'
+            and flow.metadata->>'note' != ''
+        end
+    );
+
+comment on constraint flow_reversal_check on ledger.flow is
+    'Ensures that any transaction representing a reversal references the transaction '
+    'it reverses and has a valid ''note'' field recorded in its metadata, and that no '
+    'other transaction references a reversed transaction.';
+
+create function check_flow_reversal_amount() returns trigger as $trigger$
+declare
+    original        ledger.flow%rowtype;
+    total_reversed  integer;
+begin
+    -- Lock the original transaction so that concurrent reversals of the same
+    -- transaction are serialized, then make sure it's eligible to be reversed
+    select * into original from ledger.flow
+    where flow.id = NEW.reversed_flow_id
+    for no key update;
+
+    if original.type = 'reversal'
+        or original.finalized_at is null
+        or not original.accepted
+        or sign(original.delta_points) = sign(NEW.delta_points)
+        or original.twitch_user_id != NEW.twitch_user_id
+    then
+        raise exception 'transaction % can not be reversed', NEW.reversed_flow_id
+            using
+                errcode = 'check_violation',
+                schema = 'ledger',
+                table = 'flow',
+                constraint = 'flow_reversal_amount_check';
+    end if;
+
+    -- The sum of all reversals may never exceed the amount of the original transaction
+    select coalesce(sum(flow.delta_points), 0) into total_reversed
+    from ledger.flow
+    where flow.reversed_flow_id = NEW.reversed_flow_id;
+
+    if abs(total_reversed) > abs(original.delta_points) then
+        raise exception 'reversals of transaction % may not exceed % points', NEW.reversed_flow_id, abs(original.delta_points)
+            using
+                errcode = 'check_violation',
+                schema = 'ledger',
+                table = 'flow',
+                constraint = 'flow_reversal_amount_check';
+    end if;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger check_reversal_amount_on_flow_insert
+    after insert on ledger.flow
+    for each row
+    when (NEW.reversed_flow_id is not null)
+    execute procedure check_flow_reversal_amount();
+
+comment on trigger check_reversal_amount_on_flow_insert on ledger.flow is
+    'Ensures that a reversal may only be recorded against an accepted, non-reversal '
+    'transaction belonging to the same user, that it has the opposite sign to the '
+    'original, and that the total of all reversals recorded against a transaction '
+    'never exceeds the amount of the original. Raises a check_violation error naming '
+    'flow_reversal_amount_check if violated.';
+
+commit;
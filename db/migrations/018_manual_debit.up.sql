@@ -0,0 +1,81 @@
+begin;
+
+insert into ledger.flow_type (name, comment) values (
+    'manual-debit',
+    'Outflow triggered manually by an admin, in order to take an arbitrary number of '
+    'points from the user at the admin''s discretion (e.g. to penalize abuse or to '
+    'correct an over-credit). The outflow''s metadata.note field must be set to a '
+    'non-empty string describing the purpose of the debit, and its '
+    'metadata.allow_negative_balance field indicates whether the admin permitted the '
+    'debit to reduce the user''s available balance below zero.'
+);
+
+alter table ledger.flow
+    add constraint flow_manual_debit_check check (
+        case when flow.type != 'manual-debit' then true else
+            flow.delta_points < 0
+            and jsonb_typeof(flow.metadata->'note') = 'string'
+            and flow.metadata->>'note' != ''
+            and jsonb_typeof(flow.metadata->'allow_negative_balance') = 'boolean'
+        end
+    );
+
+comment on constraint flow_manual_debit_check on ledger.flow is
+    'Ensures that any transaction representing a manual debit is an outflow, has a '
+    'valid ''note'' field recorded in its metadata, and records whether it was '
+    'permitted to bring the user''s balance below zero.';
+
+create or replace function check_flow_available_balance() returns trigger as $trigger$
+declare
+    old_contribution integer := 0;
+    new_contribution integer := 0;
+    available        integer;
+begin
+    -- An admin may explicitly allow a manual debit to bring the user's available
+    -- balance below zero; that's the only exception to this rule
+    if NEW.type = 'manual-debit' and (NEW.metadata->>'allow_negative_balance')::boolean then
+        return NEW;
+    end if;
+
+    -- Only a change that reduces the user's available balance (e.g. a new pending
+    -- outflow) can drive that balance below zero; anything else is always permitted
+    if TG_OP = 'UPDATE' and OLD.affects_available_balance then
+        old_contribution := OLD.delta_points;
+    end if;
+    if NEW.affects_available_balance then
+        new_contribution := NEW.delta_points;
+    end if;
+    if new_contribution >= old_contribution then
+        return NEW;
+    end if;
+
+    -- Serialize all balance-reducing changes for the same user: once we hold this
+    -- lock, any concurrent transaction that got here first has committed or rolled
+    -- back, so the balance we read below reflects every competing outflow
+    perform pg_advisory_xact_lock(hashtext('ledger.flow'), hashtext(NEW.twitch_user_id));
+
+    select balance.available_points into available
+    from ledger.balance
+    where balance.twitch_user_id = NEW.twitch_user_id;
+
+    if coalesce(available, 0) < 0 then
+        raise exception 'available balance for user % may not go below zero', NEW.twitch_user_id
+            using
+                errcode = 'check_violation',
+                schema = 'ledger',
+                table = 'flow',
+                constraint = 'flow_available_balance_check';
+    end if;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+comment on trigger check_available_balance_on_flow_change on ledger.flow is
+    'Ensures that no transaction may be recorded or updated in a way that would reduce '
+    'the user''s available balance below zero, unless it''s a manual debit for which '
+    'an admin has set metadata.allow_negative_balance. Concurrent changes for the same '
+    'user are serialized via a transaction-scoped advisory lock, so two outflows that '
+    'are individually affordable can not both succeed if together they are not. Raises '
+    'a check_violation error naming flow_available_balance_check if violated.';
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+drop index ledger.flow_pending_twitch_user_id_created_at_index;
+
+drop index ledger.flow_twitch_user_id_type_created_at_index;
+
+drop index ledger.flow_twitch_user_id_created_at_index;
+
+commit;
@@ -0,0 +1,24 @@
+begin;
+
+create index flow_twitch_user_id_created_at_index
+    on ledger.flow (twitch_user_id, created_at desc);
+
+comment on index ledger.flow_twitch_user_id_created_at_index is
+    'Allows a user''s transaction history to be paged through in reverse '
+    'chronological order, optionally restricted to a range of creation times.';
+
+create index flow_twitch_user_id_type_created_at_index
+    on ledger.flow (twitch_user_id, type, created_at desc);
+
+comment on index ledger.flow_twitch_user_id_type_created_at_index is
+    'Allows a user''s transaction history to be efficiently filtered by transaction '
+    'type.';
+
+create index flow_pending_twitch_user_id_created_at_index
+    on ledger.flow (twitch_user_id, created_at desc)
+    where finalized_at is null;
+
+comment on index ledger.flow_pending_twitch_user_id_created_at_index is
+    'Allows a user''s pending transactions to be found efficiently.';
+
+commit;
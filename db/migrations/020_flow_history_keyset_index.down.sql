@@ -0,0 +1,12 @@
+begin;
+
+drop index ledger.flow_twitch_user_id_created_at_id_index;
+
+create index flow_twitch_user_id_created_at_index
+    on ledger.flow (twitch_user_id, created_at desc);
+
+comment on index ledger.flow_twitch_user_id_created_at_index is
+    'Allows a user''s transaction history to be paged through in reverse '
+    'chronological order, optionally restricted to a range of creation times.';
+
+commit;
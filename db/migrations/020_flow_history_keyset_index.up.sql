@@ -0,0 +1,13 @@
+begin;
+
+drop index ledger.flow_twitch_user_id_created_at_index;
+
+create index flow_twitch_user_id_created_at_id_index
+    on ledger.flow (twitch_user_id, created_at desc, id desc);
+
+comment on index ledger.flow_twitch_user_id_created_at_id_index is
+    'Allows a user''s transaction history to be paged through in reverse '
+    'chronological order, using (created_at, id) as a unique keyset cursor so that '
+    'transactions with identical timestamps are neither skipped nor repeated.';
+
+commit;
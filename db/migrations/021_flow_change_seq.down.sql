@@ -0,0 +1,37 @@
+begin;
+
+create or replace function emit_flow_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('ledger_flow_change', jsonb_build_object(
+	    'twitch_user_id', NEW.twitch_user_id,
+	    'id', NEW.id,
+	    'type', NEW.type,
+	    'metadata', NEW.metadata,
+	    'delta_points', NEW.delta_points,
+	    'created_at', NEW.created_at,
+	    'finalized_at', NEW.finalized_at,
+	    'accepted', NEW.accepted,
+	    'description_template', (
+	        select flow_type.description_template from ledger.flow_type
+	        where flow_type.name = NEW.type
+	    )
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+drop trigger advance_change_seq_on_flow_update on ledger.flow;
+
+drop trigger advance_change_seq_on_flow_insert on ledger.flow;
+
+drop function advance_flow_change_seq;
+
+drop table ledger.flow_change;
+
+alter table ledger.flow
+    drop column user_change_seq,
+    drop column change_seq;
+
+drop sequence ledger.flow_change_seq;
+
+commit;
@@ -0,0 +1,112 @@
+begin;
+
+create sequence ledger.flow_change_seq;
+
+comment on sequence ledger.flow_change_seq is
+    'Supplies a new, ever-increasing value for flow.change_seq whenever a transaction '
+    'is recorded or updated.';
+
+alter table ledger.flow
+    add column change_seq bigint not null default nextval('ledger.flow_change_seq');
+
+comment on column ledger.flow.change_seq is
+    'Sequence number assigned each time this transaction is created or updated, '
+    'unique across all users. Sent as the ID of each firehose event.';
+
+alter table ledger.flow
+    add column user_change_seq bigint not null default 0;
+
+comment on column ledger.flow.user_change_seq is
+    'Per-user sequence number of the most recent change to this transaction, as '
+    'recorded in flow_change. 0 for a transaction that has not changed since '
+    'flow_change was introduced.';
+
+create table ledger.flow_change (
+    twitch_user_id text not null,
+    seq            bigint not null,
+    flow_id        uuid not null references ledger.flow (id)
+        on delete cascade deferrable initially deferred,
+    primary key (twitch_user_id, seq)
+);
+
+comment on table ledger.flow_change is
+    'Record of each change to a user''s transactions, numbered contiguously for each '
+    'user. Each seq value is sent as the ID of the corresponding real-time '
+    'notification event, so that a client can tell when it has missed an event, and '
+    'so that a client which reconnects to the notifications stream can be sent every '
+    'change it missed.';
+comment on column ledger.flow_change.twitch_user_id is
+    'ID of the user whose transaction was changed.';
+comment on column ledger.flow_change.seq is
+    'Sequence number of this change among all changes to the user''s transactions: '
+    'the first change is 1, and each subsequent change is exactly one greater than '
+    'the last.';
+comment on column ledger.flow_change.flow_id is
+    'ID of the transaction that was created or updated.';
+
+-- Assign change_seq only once we hold the same per-user advisory lock that guards
+-- available balances: a transaction that changes a user's flows therefore can't be
+-- assigned a change_seq until every other transaction that has already changed that
+-- user's flows has committed or rolled back, so change_seq values become visible to
+-- readers in the same order that they're assigned. The same lock lets us number each
+-- user's changes contiguously: since no other transaction can record a change for the
+-- same user until we commit or roll back, the next seq is always one greater than the
+-- last one committed.
+create function advance_flow_change_seq() returns trigger as $trigger$
+begin
+    perform pg_advisory_xact_lock(hashtext('ledger.flow'), hashtext(NEW.twitch_user_id));
+    NEW.change_seq := nextval('ledger.flow_change_seq');
+    NEW.user_change_seq := coalesce((
+        select max(flow_change.seq) from ledger.flow_change
+        where flow_change.twitch_user_id = NEW.twitch_user_id
+    ), 0) + 1;
+    insert into ledger.flow_change (twitch_user_id, seq, flow_id)
+        values (NEW.twitch_user_id, NEW.user_change_seq, NEW.id);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger advance_change_seq_on_flow_insert
+    before insert on ledger.flow
+    for each row execute procedure advance_flow_change_seq();
+
+comment on trigger advance_change_seq_on_flow_insert on ledger.flow is
+    'Assigns a change_seq and a per-user seq to each new transaction while holding a '
+    'per-user advisory lock, so that changes to any one user''s transactions are '
+    'committed in sequence order and a client resuming from a given seq can never '
+    'miss a change that commits later with a lower value.';
+
+create trigger advance_change_seq_on_flow_update
+    before update on ledger.flow
+    for each row execute procedure advance_flow_change_seq();
+
+comment on trigger advance_change_seq_on_flow_update on ledger.flow is
+    'Assigns a new change_seq and per-user seq each time a transaction is updated, '
+    'while holding the same per-user advisory lock as '
+    'advance_change_seq_on_flow_insert.';
+
+-- Include the new change_seq and per-user seq values in every change notification, so
+-- that listeners can identify each change and tell when they've missed one
+create or replace function emit_flow_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('ledger_flow_change', jsonb_build_object(
+	    'twitch_user_id', NEW.twitch_user_id,
+	    'id', NEW.id,
+	    'type', NEW.type,
+	    'metadata', NEW.metadata,
+	    'delta_points', NEW.delta_points,
+	    'created_at', NEW.created_at,
+	    'finalized_at', NEW.finalized_at,
+	    'accepted', NEW.accepted,
+	    'description_template', (
+	        select flow_type.description_template from ledger.flow_type
+	        where flow_type.name = NEW.type
+	    ),
+	    'change_seq', NEW.change_seq,
+	    'user_change_seq', NEW.user_change_seq
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table ledger.sse_token
+    drop constraint sse_token_scope_valid;
+
+alter table ledger.sse_token
+    drop column scope;
+
+commit;
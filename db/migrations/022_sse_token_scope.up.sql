@@ -0,0 +1,16 @@
+begin;
+
+alter table ledger.sse_token
+    add column scope text not null default 'user';
+
+alter table ledger.sse_token
+    add constraint sse_token_scope_valid
+    check (scope in ('user', 'firehose'));
+
+comment on column ledger.sse_token.scope is
+    'Determines which stream the bearer of this token may subscribe to: ''user'' '
+    'grants access to notifications about the given user''s own transactions, via '
+    '/notifications; ''firehose'' grants the broadcaster access to notifications about '
+    'every user''s transactions, via /notifications/firehose.';
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+drop trigger enqueue_webhook_deliveries_on_flow_change on ledger.flow;
+drop function enqueue_webhook_deliveries;
+
+drop table ledger.webhook_delivery;
+drop table ledger.webhook;
+
+commit;
@@ -0,0 +1,120 @@
+begin;
+
+create table ledger.webhook (
+    id         uuid primary key,
+    url        text not null,
+    flow_type  text not null references ledger.flow_type (name) on update cascade on delete cascade,
+    secret     text not null,
+    created_at timestamptz not null default now()
+);
+
+comment on table ledger.webhook is
+    'Record of a URL that should be notified whenever a transaction of the given type '
+    'is created or updated, so that other services can react to ledger activity '
+    'without holding open a connection to the notifications stream.';
+comment on column ledger.webhook.id is
+    'Unique ID identifying this webhook.';
+comment on column ledger.webhook.url is
+    'URL to which each event is delivered via a POST request.';
+comment on column ledger.webhook.flow_type is
+    'Type of transaction for which events are delivered to this webhook.';
+comment on column ledger.webhook.secret is
+    'Hex-encoded secret key used to compute the HMAC-SHA256 signature that accompanies '
+    'each delivery, so that the recipient can verify that it was sent by the ledger.';
+comment on column ledger.webhook.created_at is
+    'Time at which the webhook was registered.';
+
+create table ledger.webhook_delivery (
+    id              uuid primary key,
+    webhook_id      uuid not null references ledger.webhook (id) on delete cascade,
+    flow_id         uuid not null references ledger.flow (id),
+    payload         jsonb not null,
+    created_at      timestamptz not null default now(),
+    num_attempts    integer not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_error      text,
+    delivered_at    timestamptz,
+    failed_at       timestamptz
+);
+
+comment on table ledger.webhook_delivery is
+    'Outbox recording each event that is to be delivered to a webhook. Rows are '
+    'written in the same database transaction as the change they describe, so no '
+    'event can be lost, and are delivered in the background, with retries, until the '
+    'recipient accepts them or we give up.';
+comment on column ledger.webhook_delivery.id is
+    'Unique ID identifying this delivery, sent to the recipient so that it can '
+    'recognize redelivered events.';
+comment on column ledger.webhook_delivery.webhook_id is
+    'ID of the webhook to which this event is to be delivered.';
+comment on column ledger.webhook_delivery.flow_id is
+    'ID of the transaction that was created or updated.';
+comment on column ledger.webhook_delivery.payload is
+    'State of the transaction at the time of the change, in the same format as the '
+    'payload of the corresponding ledger_flow_change notification.';
+comment on column ledger.webhook_delivery.created_at is
+    'Time at which the change occurred.';
+comment on column ledger.webhook_delivery.num_attempts is
+    'Number of times we have attempted to deliver this event.';
+comment on column ledger.webhook_delivery.next_attempt_at is
+    'Time after which the next delivery attempt may be made. While an attempt is in '
+    'progress, this is pushed into the future so that no other server will attempt '
+    'the same delivery concurrently.';
+comment on column ledger.webhook_delivery.last_error is
+    'Description of the error that caused the most recent delivery attempt to fail, '
+    'if any.';
+comment on column ledger.webhook_delivery.delivered_at is
+    'Time at which the event was successfully delivered. If set, no further attempts '
+    'will be made.';
+comment on column ledger.webhook_delivery.failed_at is
+    'Time at which we gave up on delivering this event. If set, no further attempts '
+    'will be made unless the delivery is explicitly redriven.';
+
+create index webhook_delivery_next_attempt_at_index
+    on ledger.webhook_delivery (next_attempt_at)
+    where delivered_at is null and failed_at is null;
+
+comment on index ledger.webhook_delivery_next_attempt_at_index is
+    'Allows deliveries that are due to be attempted to be found quickly.';
+
+create index webhook_delivery_failed_at_index
+    on ledger.webhook_delivery (failed_at)
+    where failed_at is not null;
+
+comment on index ledger.webhook_delivery_failed_at_index is
+    'Allows failed deliveries to be listed for redrive.';
+
+create function enqueue_webhook_deliveries() returns trigger as $trigger$
+begin
+    insert into ledger.webhook_delivery (id, webhook_id, flow_id, payload)
+    select
+        gen_random_uuid(),
+        webhook.id,
+        NEW.id,
+        jsonb_build_object(
+            'twitch_user_id', NEW.twitch_user_id,
+            'id', NEW.id,
+            'type', NEW.type,
+            'metadata', NEW.metadata,
+            'delta_points', NEW.delta_points,
+            'created_at', NEW.created_at,
+            'finalized_at', NEW.finalized_at,
+            'accepted', NEW.accepted,
+            'description_template', (
+                select flow_type.description_template from ledger.flow_type
+                where flow_type.name = NEW.type
+            ),
+            'change_seq', NEW.change_seq,
+            'user_change_seq', NEW.user_change_seq
+        )
+    from ledger.webhook
+    where webhook.flow_type = NEW.type;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger enqueue_webhook_deliveries_on_flow_change
+    after insert or update on ledger.flow
+    for each row execute procedure enqueue_webhook_deliveries();
+
+commit;
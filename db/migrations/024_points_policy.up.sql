@@ -0,0 +1,39 @@
+begin;
+
+create table ledger.points_policy (
+    version                    text primary key,
+    points_per_bit             integer not null,
+    points_per_subscription    integer not null,
+    points_per_gift_sub        integer not null,
+    tier_2_multiplier          float not null,
+    tier_3_multiplier          float not null,
+    initial_subscription_bonus integer not null,
+    created_at                 timestamptz not null default now()
+);
+
+comment on table ledger.points_policy is
+    'Record of a policy that has been used to determine how many points to credit to '
+    'users when they cheer or subscribe. Each cheer, subscription, and gift-sub inflow '
+    'records the version of the policy that was applied in its metadata.policy_version '
+    'field, so that the number of points credited can always be explained.';
+comment on column ledger.points_policy.version is
+    'Unique identifier for this policy, derived from the values of its parameters.';
+comment on column ledger.points_policy.points_per_bit is
+    'Number of points credited for each bit cheered.';
+comment on column ledger.points_policy.points_per_subscription is
+    'Number of points credited for a single month of a Tier 1 subscription, whether '
+    'purchased, renewed, or received as a gift.';
+comment on column ledger.points_policy.points_per_gift_sub is
+    'Number of points credited to the purchaser of a gift sub for each Tier 1 '
+    'subscription they gift.';
+comment on column ledger.points_policy.tier_2_multiplier is
+    'Factor by which subscription and gift sub credits are scaled for Tier 2 subs.';
+comment on column ledger.points_policy.tier_3_multiplier is
+    'Factor by which subscription and gift sub credits are scaled for Tier 3 subs.';
+comment on column ledger.points_policy.initial_subscription_bonus is
+    'Number of additional points credited when a user purchases an initial '
+    'subscription (not including gift subs or renewals).';
+comment on column ledger.points_policy.created_at is
+    'Time at which this policy first went into effect.';
+
+commit;
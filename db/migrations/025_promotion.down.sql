@@ -0,0 +1,5 @@
+begin;
+
+drop table ledger.promotion;
+
+commit;
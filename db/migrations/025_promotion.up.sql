@@ -0,0 +1,58 @@
+begin;
+
+create table ledger.promotion (
+    id          uuid primary key,
+    name        text not null,
+    multiplier  float not null,
+    flow_types  text[],
+    starts_at   timestamptz not null,
+    ends_at     timestamptz not null,
+    created_at  timestamptz not null default now(),
+    canceled_at timestamptz
+);
+
+comment on table ledger.promotion is
+    'Record of a time-boxed promotion scheduled by the broadcaster, during which '
+    'inflows earn a multiple of the points they would ordinarily be credited, e.g. '
+    'for a "Double Points Night". Each inflow to which a promotion applied records '
+    'that promotion in its metadata.promotion field.';
+comment on column ledger.promotion.id is
+    'Unique ID identifying this promotion.';
+comment on column ledger.promotion.name is
+    'User-facing name of the promotion, e.g. "Double Points Night".';
+comment on column ledger.promotion.multiplier is
+    'Factor by which the points credited by each eligible inflow are scaled while the '
+    'promotion is active.';
+comment on column ledger.promotion.flow_types is
+    'Types of inflow to which the promotion applies, or NULL if it applies to all '
+    'inflows that support promotions.';
+comment on column ledger.promotion.starts_at is
+    'Time at which the promotion becomes active.';
+comment on column ledger.promotion.ends_at is
+    'Time at which the promotion is no longer active.';
+comment on column ledger.promotion.created_at is
+    'Time at which the promotion was scheduled.';
+comment on column ledger.promotion.canceled_at is
+    'Time at which the broadcaster canceled the promotion, if applicable. A canceled '
+    'promotion no longer applies to any inflows, but inflows that were credited while '
+    'it was active are unaffected.';
+
+alter table ledger.promotion
+    add constraint promotion_multiplier_valid check (multiplier > 0);
+
+comment on constraint promotion_multiplier_valid on ledger.promotion is
+    'Ensures that a promotion can never cause an inflow to debit points.';
+
+alter table ledger.promotion
+    add constraint promotion_window_valid check (ends_at > starts_at);
+
+comment on constraint promotion_window_valid on ledger.promotion is
+    'Ensures that a promotion ends after it starts.';
+
+create index promotion_ends_at_index on ledger.promotion (ends_at)
+    where canceled_at is null;
+
+comment on index ledger.promotion_ends_at_index is
+    'Allows promotions that are active or yet to start to be found efficiently.';
+
+commit;
@@ -0,0 +1,11 @@
+begin;
+
+alter table ledger.points_policy
+    drop column points_per_raid_viewer;
+
+alter table ledger.flow
+    drop constraint flow_raid_check;
+
+delete from ledger.flow_type where name = 'raid';
+
+commit;
@@ -0,0 +1,29 @@
+begin;
+
+insert into ledger.flow_type (name, comment) values (
+    'raid',
+    'Inflow triggered in response to a channel.raid webhook notification, in order to '
+    'grant a user points when they raid the channel. The inflow''s metadata.num_viewers '
+    'field records the number of viewers who arrived with the raid.'
+);
+
+alter table ledger.flow
+    add constraint flow_raid_check check (
+        case when flow.type != 'raid' then true else
+            flow.delta_points > 0
+            and jsonb_typeof(flow.metadata->'num_viewers') = 'number'
+        end
+    );
+
+comment on constraint flow_raid_check on ledger.flow is
+    'Ensures that any transaction representing a raid is an inflow and has a '
+    '''num_viewers'' field.';
+
+alter table ledger.points_policy
+    add column points_per_raid_viewer integer;
+
+comment on column ledger.points_policy.points_per_raid_viewer is
+    'Number of points credited to a raider for each viewer who arrives with their raid. '
+    'NULL for policies that went into effect before raids were credited.';
+
+commit;
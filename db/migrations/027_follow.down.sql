@@ -0,0 +1,13 @@
+begin;
+
+alter table ledger.points_policy
+    drop column points_per_follow;
+
+drop index ledger.flow_follow_once_per_user_index;
+
+alter table ledger.flow
+    drop constraint flow_follow_check;
+
+delete from ledger.flow_type where name = 'follow';
+
+commit;
@@ -0,0 +1,35 @@
+begin;
+
+insert into ledger.flow_type (name, comment) values (
+    'follow',
+    'Inflow triggered in response to a channel.follow webhook notification, in order to '
+    'grant a user points when they first follow the channel. Each user may only be '
+    'credited for following once, even if they unfollow and follow again.'
+);
+
+alter table ledger.flow
+    add constraint flow_follow_check check (
+        case when flow.type != 'follow' then true else
+            flow.delta_points > 0
+        end
+    );
+
+comment on constraint flow_follow_check on ledger.flow is
+    'Ensures that any transaction representing a follow is an inflow.';
+
+create unique index flow_follow_once_per_user_index
+    on ledger.flow (twitch_user_id)
+    where type = 'follow';
+
+comment on index ledger.flow_follow_once_per_user_index is
+    'Ensures that at most one follow transaction may be recorded for each user, so that '
+    'a user can''t earn points repeatedly by unfollowing and following again.';
+
+alter table ledger.points_policy
+    add column points_per_follow integer;
+
+comment on column ledger.points_policy.points_per_follow is
+    'Number of points credited to a user when they first follow the channel. NULL for '
+    'policies that went into effect before follows were credited.';
+
+commit;
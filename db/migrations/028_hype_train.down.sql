@@ -0,0 +1,11 @@
+begin;
+
+alter table ledger.points_policy
+    drop column points_per_hype_train_level;
+
+alter table ledger.flow
+    drop constraint flow_hype_train_check;
+
+delete from ledger.flow_type where name = 'hype-train';
+
+commit;
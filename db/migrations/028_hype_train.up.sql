@@ -0,0 +1,30 @@
+begin;
+
+insert into ledger.flow_type (name, comment) values (
+    'hype-train',
+    'Inflow triggered in response to a channel.hype_train.end webhook notification, in '
+    'order to grant points to each user who contributed to a hype train. The inflow''s '
+    'metadata.level field records the level that the hype train reached.'
+);
+
+alter table ledger.flow
+    add constraint flow_hype_train_check check (
+        case when flow.type != 'hype-train' then true else
+            flow.delta_points > 0
+            and jsonb_typeof(flow.metadata->'level') = 'number'
+        end
+    );
+
+comment on constraint flow_hype_train_check on ledger.flow is
+    'Ensures that any transaction representing a hype train contribution is an inflow '
+    'and has a ''level'' field.';
+
+alter table ledger.points_policy
+    add column points_per_hype_train_level integer;
+
+comment on column ledger.points_policy.points_per_hype_train_level is
+    'Number of points credited to each contributor to a hype train for each level that '
+    'the hype train reached. NULL for policies that went into effect before hype '
+    'trains were credited.';
+
+commit;
@@ -0,0 +1,77 @@
+begin;
+
+create or replace function emit_flow_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('ledger_flow_change', jsonb_build_object(
+        'twitch_user_id', NEW.twitch_user_id,
+        'id', NEW.id,
+        'type', NEW.type,
+        'metadata', NEW.metadata,
+        'delta_points', NEW.delta_points,
+        'created_at', NEW.created_at,
+        'finalized_at', NEW.finalized_at,
+        'accepted', NEW.accepted,
+        'description_template', (
+            select flow_type.description_template from ledger.flow_type
+            where flow_type.name = NEW.type
+        ),
+        'change_seq', NEW.change_seq,
+        'user_change_seq', NEW.user_change_seq
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create or replace function enqueue_webhook_deliveries() returns trigger as $trigger$
+begin
+    insert into ledger.webhook_delivery (id, webhook_id, flow_id, payload)
+    select
+        gen_random_uuid(),
+        webhook.id,
+        NEW.id,
+        jsonb_build_object(
+            'twitch_user_id', NEW.twitch_user_id,
+            'id', NEW.id,
+            'type', NEW.type,
+            'metadata', NEW.metadata,
+            'delta_points', NEW.delta_points,
+            'created_at', NEW.created_at,
+            'finalized_at', NEW.finalized_at,
+            'accepted', NEW.accepted,
+            'description_template', (
+                select flow_type.description_template from ledger.flow_type
+                where flow_type.name = NEW.type
+            ),
+            'change_seq', NEW.change_seq,
+            'user_change_seq', NEW.user_change_seq
+        )
+    from ledger.webhook
+    where webhook.flow_type = NEW.type;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+drop function flow_change_payload;
+
+drop trigger record_watch_time_stream_on_flow_insert on ledger.flow;
+drop function record_watch_time_stream;
+
+drop table ledger.watch_time_stream;
+
+alter table ledger.points_policy
+    drop column watch_time_daily_cap,
+    drop column points_per_watch_minute;
+
+drop table ledger.watch_time_daily_total;
+
+drop table ledger.watch_time_interval;
+
+drop index ledger.flow_twitch_user_id_created_at_id_excluding_watch_time_index;
+drop index ledger.flow_watch_time_interval_index;
+
+alter table ledger.flow
+    drop constraint flow_watch_time_check;
+
+delete from ledger.flow_type where name = 'watch-time';
+
+commit;
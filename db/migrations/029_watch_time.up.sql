@@ -0,0 +1,226 @@
+begin;
+
+insert into ledger.flow_type (name, comment) values (
+    'watch-time',
+    'Inflow that grants a user points for the time they spent watching a stream. A '
+    'separate, immutable inflow is recorded for each user for each interval of watch '
+    'time that is credited: the inflow''s metadata.stream_id and metadata.interval_id '
+    'fields identify the stream and interval, and metadata.minutes_watched records the '
+    'number of minutes credited for that interval. A user''s history shows a single '
+    'entry for each stream, as summarized in watch_time_stream.'
+);
+
+alter table ledger.flow
+    add constraint flow_watch_time_check check (
+        case when flow.type != 'watch-time' then true else
+            flow.delta_points > 0
+            and jsonb_typeof(flow.metadata->'stream_id') = 'string'
+            and jsonb_typeof(flow.metadata->'interval_id') = 'string'
+            and jsonb_typeof(flow.metadata->'minutes_watched') = 'number'
+        end
+    );
+
+comment on constraint flow_watch_time_check on ledger.flow is
+    'Ensures that any transaction representing watch time is an inflow and has '
+    '''stream_id'', ''interval_id'' and ''minutes_watched'' fields.';
+
+create unique index flow_watch_time_interval_index
+    on ledger.flow (twitch_user_id, (metadata->>'stream_id'), (metadata->>'interval_id'))
+    where type = 'watch-time';
+
+comment on index ledger.flow_watch_time_interval_index is
+    'Ensures that at most one watch-time transaction is recorded for each user for '
+    'each interval of a stream, and allows all of the transactions recorded for a '
+    'user for a given stream to be found quickly.';
+
+create index flow_twitch_user_id_created_at_id_excluding_watch_time_index
+    on ledger.flow (twitch_user_id, created_at desc, id desc)
+    where type != 'watch-time';
+
+comment on index ledger.flow_twitch_user_id_created_at_id_excluding_watch_time_index is
+    'Allows a user''s transaction history to be paged through without reading the '
+    'individual watch-time transactions recorded for each interval, since history '
+    'pages through watch_time_stream for those instead.';
+
+create table ledger.watch_time_stream (
+    twitch_user_id  text not null,
+    stream_id       text not null,
+    flow_id         uuid not null references ledger.flow (id)
+        on delete cascade deferrable initially deferred,
+    created_at      timestamptz not null,
+    minutes_watched integer not null,
+    delta_points    integer not null,
+    primary key (twitch_user_id, stream_id)
+);
+
+comment on table ledger.watch_time_stream is
+    'Summary of all the watch-time transactions recorded for each user for each '
+    'stream, maintained as each transaction is recorded, so that a user''s history '
+    'can show a single entry per stream without aggregating every interval on each '
+    'read. The transactions themselves are never modified.';
+comment on column ledger.watch_time_stream.twitch_user_id is
+    'ID of the user who was credited.';
+comment on column ledger.watch_time_stream.stream_id is
+    'Caller-supplied ID of the stream during which the user was credited.';
+comment on column ledger.watch_time_stream.flow_id is
+    'ID of the transaction recorded for the first interval of the stream for which '
+    'the user was credited, which identifies the stream''s entry in their history.';
+comment on column ledger.watch_time_stream.created_at is
+    'Time at which the first interval''s transaction was recorded.';
+comment on column ledger.watch_time_stream.minutes_watched is
+    'Total number of minutes credited across all of the stream''s transactions.';
+comment on column ledger.watch_time_stream.delta_points is
+    'Total number of points credited across all of the stream''s transactions.';
+
+create index watch_time_stream_twitch_user_id_created_at_flow_id_index
+    on ledger.watch_time_stream (twitch_user_id, created_at desc, flow_id desc);
+
+comment on index ledger.watch_time_stream_twitch_user_id_created_at_flow_id_index is
+    'Allows a user''s watch-time history to be paged through in reverse chronological '
+    'order, using the same keyset cursor as the rest of their history.';
+
+create function record_watch_time_stream() returns trigger as $trigger$
+begin
+    insert into ledger.watch_time_stream (
+        twitch_user_id,
+        stream_id,
+        flow_id,
+        created_at,
+        minutes_watched,
+        delta_points
+    ) values (
+        NEW.twitch_user_id,
+        NEW.metadata->>'stream_id',
+        NEW.id,
+        NEW.created_at,
+        (NEW.metadata->>'minutes_watched')::integer,
+        NEW.delta_points
+    )
+    on conflict (twitch_user_id, stream_id) do update set
+        minutes_watched = watch_time_stream.minutes_watched + excluded.minutes_watched,
+        delta_points = watch_time_stream.delta_points + excluded.delta_points;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger record_watch_time_stream_on_flow_insert
+    before insert on ledger.flow
+    for each row when (NEW.type = 'watch-time')
+    execute procedure record_watch_time_stream();
+
+comment on trigger record_watch_time_stream_on_flow_insert on ledger.flow is
+    'Adds each new watch-time transaction to the summary of its stream in '
+    'watch_time_stream.';
+
+-- Describe each change in the same way as the user's history does: a change to any of
+-- a stream's watch-time transactions is described as a change to the stream's entry,
+-- identified by its first interval and showing the totals credited so far
+create function flow_change_payload(flow ledger.flow) returns jsonb as $function$
+    select jsonb_build_object(
+        'twitch_user_id', flow.twitch_user_id,
+        'id', coalesce(watch_time_stream.flow_id, flow.id),
+        'type', flow.type,
+        'metadata', case when watch_time_stream.flow_id is null then flow.metadata else
+            (first_interval.metadata - 'interval_id')
+                || jsonb_build_object('minutes_watched', watch_time_stream.minutes_watched)
+        end,
+        'delta_points', coalesce(watch_time_stream.delta_points, flow.delta_points),
+        'created_at', coalesce(watch_time_stream.created_at, flow.created_at),
+        'finalized_at', coalesce(first_interval.finalized_at, flow.finalized_at),
+        'accepted', coalesce(first_interval.accepted, flow.accepted),
+        'description_template', flow_type.description_template,
+        'change_seq', flow.change_seq,
+        'user_change_seq', flow.user_change_seq
+    )
+    from ledger.flow_type
+    left join ledger.watch_time_stream
+        on flow.type = 'watch-time'
+        and watch_time_stream.twitch_user_id = flow.twitch_user_id
+        and watch_time_stream.stream_id = flow.metadata->>'stream_id'
+    left join ledger.flow as first_interval
+        on first_interval.id = watch_time_stream.flow_id
+    where flow_type.name = flow.type;
+$function$ language sql stable;
+
+create or replace function emit_flow_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('ledger_flow_change', flow_change_payload(NEW)::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create or replace function enqueue_webhook_deliveries() returns trigger as $trigger$
+begin
+    insert into ledger.webhook_delivery (id, webhook_id, flow_id, payload)
+    select
+        gen_random_uuid(),
+        webhook.id,
+        NEW.id,
+        flow_change_payload(NEW)
+    from ledger.webhook
+    where webhook.flow_type = NEW.type;
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create table ledger.watch_time_interval (
+    stream_id            text not null,
+    interval_id          text not null,
+    num_viewers_credited integer not null default 0,
+    num_points_credited  integer not null default 0,
+    created_at           timestamptz not null default now(),
+    primary key (stream_id, interval_id)
+);
+
+comment on table ledger.watch_time_interval is
+    'Record of an interval of a stream for which watch time has been credited, so that '
+    'a retried batch for the same interval is not credited twice.';
+comment on column ledger.watch_time_interval.stream_id is
+    'Caller-supplied ID of the stream during which the interval occurred.';
+comment on column ledger.watch_time_interval.interval_id is
+    'Caller-supplied ID that uniquely identifies the interval within its stream.';
+comment on column ledger.watch_time_interval.num_viewers_credited is
+    'Number of viewers who were credited with points for this interval: viewers who '
+    'had already reached their daily cap are not included.';
+comment on column ledger.watch_time_interval.num_points_credited is
+    'Total number of points credited to all viewers for this interval.';
+comment on column ledger.watch_time_interval.created_at is
+    'Time at which watch time was credited for this interval.';
+
+create table ledger.watch_time_daily_total (
+    twitch_user_id text not null,
+    day            date not null,
+    num_points     integer not null,
+    primary key (twitch_user_id, day)
+);
+
+comment on table ledger.watch_time_daily_total is
+    'Running total of the points credited to each user for watch time on each day '
+    '(in UTC), so that no user can be credited beyond the daily cap set by the points '
+    'policy.';
+comment on column ledger.watch_time_daily_total.twitch_user_id is
+    'ID of the user who was credited.';
+comment on column ledger.watch_time_daily_total.day is
+    'Day (in UTC) on which the points were credited.';
+comment on column ledger.watch_time_daily_total.num_points is
+    'Total number of points credited to the user for watch time on that day.';
+
+alter table ledger.watch_time_daily_total
+    add constraint watch_time_daily_total_num_points_check check (num_points >= 0);
+
+comment on constraint watch_time_daily_total_num_points_check on ledger.watch_time_daily_total is
+    'Ensures that a daily total is never negative.';
+
+alter table ledger.points_policy
+    add column points_per_watch_minute integer,
+    add column watch_time_daily_cap integer;
+
+comment on column ledger.points_policy.points_per_watch_minute is
+    'Number of points credited to a viewer for each minute they spend watching a '
+    'stream. NULL for policies that went into effect before watch time was credited.';
+comment on column ledger.points_policy.watch_time_daily_cap is
+    'Maximum number of points that may be credited to any one user for watch time on '
+    'a single day (in UTC). NULL for policies that went into effect before watch time '
+    'was credited.';
+
+commit;
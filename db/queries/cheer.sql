@@ -7,15 +7,23 @@ insert into ledger.flow (
     delta_points,
     created_at,
     finalized_at,
-    accepted
+    accepted,
+    idempotency_key
 ) values (
     gen_random_uuid(),
     'cheer',
-    jsonb_build_object('message', @message::text),
+    jsonb_build_object(
+        'message', @message::text,
+        'num_bits', @num_bits::integer,
+        'policy_version', @policy_version::text,
+        'promotion', sqlc.narg('promotion')::jsonb
+    ),
     @twitch_user_id,
     @num_points_to_credit,
     now(),
     now(),
-    true
+    true,
+    sqlc.narg('idempotency_key')::text
 )
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
 returning flow.id;
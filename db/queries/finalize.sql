@@ -9,7 +9,24 @@ where flow.id = @flow_id;
 -- name: FinalizeFlow :execresult
 update ledger.flow set
     finalized_at = now(),
-    accepted = @accepted
+    accepted = @accepted,
+    metadata = case when sqlc.narg('rejection_reason')::text is null
+        then flow.metadata
+        else flow.metadata || jsonb_build_object('rejection_reason', sqlc.narg('rejection_reason')::text)
+    end
 where
     flow.id = @flow_id
-    and finalized_at is null;
+    and finalized_at is null
+    -- An outflow that has expired may only be rejected, even if we have yet to reject
+    -- it automatically
+    and (not @accepted or flow.expires_at is null or flow.expires_at > now());
+
+-- name: GetExpiredPendingFlowIds :many
+select
+    flow.id
+from ledger.flow
+where flow.finalized_at is null
+    and flow.expires_at is not null
+    and flow.expires_at <= now()
+order by flow.expires_at
+limit @num_records;
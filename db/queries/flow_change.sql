@@ -0,0 +1,29 @@
+-- name: GetFlowChangesSince :many
+-- A change to any of a stream's watch-time transactions is described as a change to the
+-- stream's entry in the user's history, as in the notifications sent by the database
+select
+    coalesce(watch_time_stream.flow_id, flow.id) as id,
+    flow.type,
+    case when watch_time_stream.flow_id is null then flow.metadata else
+        (first_interval.metadata - 'interval_id')
+            || jsonb_build_object('minutes_watched', watch_time_stream.minutes_watched)
+    end as metadata,
+    coalesce(watch_time_stream.delta_points, flow.delta_points) as delta_points,
+    coalesce(watch_time_stream.created_at, flow.created_at) as created_at,
+    coalesce(first_interval.finalized_at, flow.finalized_at) as finalized_at,
+    coalesce(first_interval.accepted, flow.accepted) as accepted,
+    flow_type.description_template,
+    flow_change.seq
+from ledger.flow_change
+join ledger.flow on flow.id = flow_change.flow_id
+join ledger.flow_type on flow_type.name = flow.type
+left join ledger.watch_time_stream
+    on flow.type = 'watch-time'
+    and watch_time_stream.twitch_user_id = flow.twitch_user_id
+    and watch_time_stream.stream_id = flow.metadata->>'stream_id'
+left join ledger.flow as first_interval
+    on first_interval.id = watch_time_stream.flow_id
+where flow_change.twitch_user_id = @twitch_user_id
+    and flow_change.seq > @seq
+order by flow_change.seq
+limit @num_records;
@@ -0,0 +1,34 @@
+-- name: RecordFollowInflow :one
+insert into ledger.flow (
+    id,
+    type,
+    metadata,
+    twitch_user_id,
+    delta_points,
+    created_at,
+    finalized_at,
+    accepted,
+    idempotency_key
+) values (
+    gen_random_uuid(),
+    'follow',
+    jsonb_build_object(
+        'policy_version', @policy_version::text,
+        'promotion', sqlc.narg('promotion')::jsonb
+    ),
+    @twitch_user_id,
+    @num_points_to_credit,
+    now(),
+    now(),
+    true,
+    sqlc.narg('idempotency_key')::text
+)
+on conflict do nothing
+returning flow.id;
+
+-- name: GetFollowInflowId :one
+select
+    flow.id
+from ledger.flow
+where flow.type = 'follow'
+    and flow.twitch_user_id = @twitch_user_id;
@@ -7,18 +7,24 @@ insert into ledger.flow (
     delta_points,
     created_at,
     finalized_at,
-    accepted
+    accepted,
+    idempotency_key
 ) values (
     gen_random_uuid(),
     'gift-sub',
     jsonb_build_object(
         'num_subscriptions', @num_subscriptions::integer,
-        'credit_multiplier', @credit_multiplier::float
+        'tier', @tier::text,
+        'credit_multiplier', @credit_multiplier::float,
+        'policy_version', @policy_version::text,
+        'promotion', sqlc.narg('promotion')::jsonb
     ),
     @twitch_user_id,
     @num_points_to_credit,
     now(),
     now(),
-    true
+    true,
+    sqlc.narg('idempotency_key')::text
 )
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
 returning flow.id;
@@ -1,19 +1,201 @@
 -- name: GetTransactionHistory :many
 select
-    flow.id,
-    flow.type,
-    flow.metadata,
-    flow.delta_points,
-    flow.created_at,
-    flow.finalized_at,
-    flow.accepted
-from ledger.flow
-where flow.twitch_user_id = @twitch_user_id
-and case when sqlc.narg('start_id')::uuid is null
-    then true
-    else flow.created_at <= (
-        select flow.created_at from ledger.flow where flow.id = sqlc.narg('start_id')::uuid
-    )
-end
-order by flow.created_at desc
+    entry.id,
+    entry.type,
+    entry.metadata,
+    entry.delta_points,
+    entry.created_at,
+    entry.finalized_at,
+    entry.accepted,
+    flow_type.description_template,
+    entry.reversed_delta_points
+-- Watch time is credited via a separate, immutable transaction for each interval, but
+-- we show a single entry per stream, paging through the per-stream summaries kept in
+-- watch_time_stream rather than aggregating every interval on each read. Each branch
+-- reads no more than a page of rows from its own index before the two are merged.
+from ((
+    select
+        flow.id,
+        flow.type,
+        flow.metadata,
+        flow.delta_points,
+        flow.created_at,
+        flow.finalized_at,
+        flow.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as reversal
+            where reversal.reversed_flow_id = flow.id
+        ), 0)::integer as reversed_delta_points
+    from ledger.flow
+    where flow.twitch_user_id = @twitch_user_id
+    and flow.type != 'watch-time'
+    and (sqlc.narg('cursor_id')::uuid is null or (flow.created_at, flow.id) < (
+        sqlc.narg('cursor_created_at')::timestamptz,
+        sqlc.narg('cursor_id')::uuid
+    ))
+    and (coalesce(cardinality(@types::text[]), 0) = 0 or flow.type = any(@types::text[]))
+    and case sqlc.narg('state')::text
+        when 'pending' then flow.finalized_at is null
+        when 'accepted' then flow.finalized_at is not null and flow.accepted
+        when 'rejected' then flow.finalized_at is not null and not flow.accepted
+        else true
+    end
+    and (sqlc.narg('since')::timestamptz is null or flow.created_at >= sqlc.narg('since')::timestamptz)
+    and (sqlc.narg('until')::timestamptz is null or flow.created_at < sqlc.narg('until')::timestamptz)
+    and case sqlc.narg('direction')::text
+        when 'inflow' then flow.delta_points > 0
+        when 'outflow' then flow.delta_points < 0
+        else true
+    end
+    order by flow.created_at desc, flow.id desc
+    limit @num_records
+) union all (
+    select
+        watch_time_stream.flow_id as id,
+        'watch-time' as type,
+        (first_interval.metadata - 'interval_id') || jsonb_build_object(
+            'minutes_watched', watch_time_stream.minutes_watched
+        ) as metadata,
+        watch_time_stream.delta_points,
+        watch_time_stream.created_at,
+        first_interval.finalized_at,
+        first_interval.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as watch_time
+            join ledger.flow as reversal on reversal.reversed_flow_id = watch_time.id
+            where watch_time.twitch_user_id = watch_time_stream.twitch_user_id
+                and watch_time.type = 'watch-time'
+                and watch_time.metadata->>'stream_id' = watch_time_stream.stream_id
+        ), 0)::integer as reversed_delta_points
+    from ledger.watch_time_stream
+    join ledger.flow as first_interval on first_interval.id = watch_time_stream.flow_id
+    where watch_time_stream.twitch_user_id = @twitch_user_id
+    and (sqlc.narg('cursor_id')::uuid is null or (watch_time_stream.created_at, watch_time_stream.flow_id) < (
+        sqlc.narg('cursor_created_at')::timestamptz,
+        sqlc.narg('cursor_id')::uuid
+    ))
+    and (coalesce(cardinality(@types::text[]), 0) = 0 or 'watch-time' = any(@types::text[]))
+    and case sqlc.narg('state')::text
+        when 'pending' then first_interval.finalized_at is null
+        when 'accepted' then first_interval.finalized_at is not null and first_interval.accepted
+        when 'rejected' then first_interval.finalized_at is not null and not first_interval.accepted
+        else true
+    end
+    and (sqlc.narg('since')::timestamptz is null or watch_time_stream.created_at >= sqlc.narg('since')::timestamptz)
+    and (sqlc.narg('until')::timestamptz is null or watch_time_stream.created_at < sqlc.narg('until')::timestamptz)
+    and case sqlc.narg('direction')::text
+        when 'inflow' then watch_time_stream.delta_points > 0
+        when 'outflow' then watch_time_stream.delta_points < 0
+        else true
+    end
+    order by watch_time_stream.created_at desc, watch_time_stream.flow_id desc
+    limit @num_records
+)) as entry
+join ledger.flow_type on flow_type.name = entry.type
+order by entry.created_at desc, entry.id desc
 limit @num_records;
+
+-- name: GetNewerTransactionHistory :many
+select
+    entry.id,
+    entry.type,
+    entry.metadata,
+    entry.delta_points,
+    entry.created_at,
+    entry.finalized_at,
+    entry.accepted,
+    flow_type.description_template,
+    entry.reversed_delta_points
+-- Watch time is credited via a separate, immutable transaction for each interval, but
+-- we show a single entry per stream, paging through the per-stream summaries kept in
+-- watch_time_stream rather than aggregating every interval on each read. Each branch
+-- reads no more than a page of rows from its own index before the two are merged.
+from ((
+    select
+        flow.id,
+        flow.type,
+        flow.metadata,
+        flow.delta_points,
+        flow.created_at,
+        flow.finalized_at,
+        flow.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as reversal
+            where reversal.reversed_flow_id = flow.id
+        ), 0)::integer as reversed_delta_points
+    from ledger.flow
+    where flow.twitch_user_id = @twitch_user_id
+    and flow.type != 'watch-time'
+    and (sqlc.narg('cursor_id')::uuid is null or (flow.created_at, flow.id) > (
+        sqlc.narg('cursor_created_at')::timestamptz,
+        sqlc.narg('cursor_id')::uuid
+    ))
+    and (coalesce(cardinality(@types::text[]), 0) = 0 or flow.type = any(@types::text[]))
+    and case sqlc.narg('state')::text
+        when 'pending' then flow.finalized_at is null
+        when 'accepted' then flow.finalized_at is not null and flow.accepted
+        when 'rejected' then flow.finalized_at is not null and not flow.accepted
+        else true
+    end
+    and (sqlc.narg('since')::timestamptz is null or flow.created_at >= sqlc.narg('since')::timestamptz)
+    and (sqlc.narg('until')::timestamptz is null or flow.created_at < sqlc.narg('until')::timestamptz)
+    and case sqlc.narg('direction')::text
+        when 'inflow' then flow.delta_points > 0
+        when 'outflow' then flow.delta_points < 0
+        else true
+    end
+    order by flow.created_at, flow.id
+    limit @num_records
+) union all (
+    select
+        watch_time_stream.flow_id as id,
+        'watch-time' as type,
+        (first_interval.metadata - 'interval_id') || jsonb_build_object(
+            'minutes_watched', watch_time_stream.minutes_watched
+        ) as metadata,
+        watch_time_stream.delta_points,
+        watch_time_stream.created_at,
+        first_interval.finalized_at,
+        first_interval.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as watch_time
+            join ledger.flow as reversal on reversal.reversed_flow_id = watch_time.id
+            where watch_time.twitch_user_id = watch_time_stream.twitch_user_id
+                and watch_time.type = 'watch-time'
+                and watch_time.metadata->>'stream_id' = watch_time_stream.stream_id
+        ), 0)::integer as reversed_delta_points
+    from ledger.watch_time_stream
+    join ledger.flow as first_interval on first_interval.id = watch_time_stream.flow_id
+    where watch_time_stream.twitch_user_id = @twitch_user_id
+    and (sqlc.narg('cursor_id')::uuid is null or (watch_time_stream.created_at, watch_time_stream.flow_id) > (
+        sqlc.narg('cursor_created_at')::timestamptz,
+        sqlc.narg('cursor_id')::uuid
+    ))
+    and (coalesce(cardinality(@types::text[]), 0) = 0 or 'watch-time' = any(@types::text[]))
+    and case sqlc.narg('state')::text
+        when 'pending' then first_interval.finalized_at is null
+        when 'accepted' then first_interval.finalized_at is not null and first_interval.accepted
+        when 'rejected' then first_interval.finalized_at is not null and not first_interval.accepted
+        else true
+    end
+    and (sqlc.narg('since')::timestamptz is null or watch_time_stream.created_at >= sqlc.narg('since')::timestamptz)
+    and (sqlc.narg('until')::timestamptz is null or watch_time_stream.created_at < sqlc.narg('until')::timestamptz)
+    and case sqlc.narg('direction')::text
+        when 'inflow' then watch_time_stream.delta_points > 0
+        when 'outflow' then watch_time_stream.delta_points < 0
+        else true
+    end
+    order by watch_time_stream.created_at, watch_time_stream.flow_id
+    limit @num_records
+)) as entry
+join ledger.flow_type on flow_type.name = entry.type
+order by entry.created_at, entry.id
+limit @num_records;
+
+-- name: HistoryCursorExists :one
+select exists (
+    select 1 from ledger.flow
+    where flow.twitch_user_id = @twitch_user_id
+        and flow.id = @id
+        and flow.created_at = @created_at
+);
@@ -0,0 +1,28 @@
+-- name: RecordHypeTrainInflow :one
+insert into ledger.flow (
+    id,
+    type,
+    metadata,
+    twitch_user_id,
+    delta_points,
+    created_at,
+    finalized_at,
+    accepted,
+    idempotency_key
+) values (
+    gen_random_uuid(),
+    'hype-train',
+    jsonb_build_object(
+        'level', @level::integer,
+        'policy_version', @policy_version::text,
+        'promotion', sqlc.narg('promotion')::jsonb
+    ),
+    @twitch_user_id,
+    @num_points_to_credit,
+    now(),
+    now(),
+    true,
+    sqlc.narg('idempotency_key')::text
+)
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
+returning flow.id;
@@ -0,0 +1,7 @@
+-- name: GetFlowIdByIdempotencyKey :one
+select
+    flow.id
+from ledger.flow
+where flow.type = @type
+    and flow.idempotency_key = @idempotency_key::text
+    and flow.twitch_user_id = @twitch_user_id;
@@ -0,0 +1,37 @@
+-- name: LockFlowsForUser :exec
+select pg_advisory_xact_lock(hashtext('ledger.flow'), hashtext(@twitch_user_id::text));
+
+-- name: RecordManualDebitOutflow :one
+insert into ledger.flow (
+    id,
+    type,
+    metadata,
+    twitch_user_id,
+    delta_points,
+    created_at,
+    finalized_at,
+    accepted
+)
+select
+    gen_random_uuid(),
+    'manual-debit',
+    jsonb_build_object(
+        'note', @note::text,
+        'allow_negative_balance', @allow_negative_balance::boolean
+    ),
+    @twitch_user_id::text,
+    -1 * debit.num_points,
+    now(),
+    now(),
+    true
+from (
+    select case when @allow_negative_balance::boolean
+        then @num_points_to_debit::integer
+        else least(@num_points_to_debit::integer, coalesce((
+            select balance.available_points from ledger.balance
+            where balance.twitch_user_id = @twitch_user_id::text
+        ), 0)::integer)
+    end as num_points
+) as debit
+where debit.num_points > 0
+returning flow.id, (-1 * flow.delta_points)::integer as num_points_debited;
@@ -0,0 +1,22 @@
+-- name: RecordPendingOutflow :one
+insert into ledger.flow (
+    id,
+    type,
+    metadata,
+    twitch_user_id,
+    delta_points,
+    created_at,
+    expires_at
+)
+select
+    gen_random_uuid(),
+    flow_type.name,
+    @metadata::jsonb,
+    @twitch_user_id::text,
+    -1 * @num_points_to_debit::integer,
+    now(),
+    now() + make_interval(secs => sqlc.narg('expires_in_seconds')::integer)
+from ledger.flow_type
+where flow_type.name = @type::text
+    and flow_type.is_registered_outflow
+returning flow.id;
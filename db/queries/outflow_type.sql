@@ -0,0 +1,41 @@
+-- name: GetOutflowType :one
+select
+    flow_type.name,
+    flow_type.comment,
+    flow_type.metadata_schema,
+    flow_type.description_template
+from ledger.flow_type
+where flow_type.name = @name
+    and flow_type.is_registered_outflow;
+
+-- name: ListOutflowTypes :many
+select
+    flow_type.name,
+    flow_type.comment,
+    flow_type.metadata_schema,
+    flow_type.description_template
+from ledger.flow_type
+where flow_type.is_registered_outflow
+order by flow_type.name;
+
+-- name: RegisterOutflowType :execresult
+insert into ledger.flow_type (
+    name,
+    comment,
+    is_registered_outflow,
+    metadata_schema,
+    description_template
+) values (
+    @name,
+    @comment,
+    true,
+    sqlc.narg('metadata_schema')::jsonb,
+    sqlc.narg('description_template')::text
+)
+on conflict (name) do update set
+    comment = excluded.comment,
+    -- A schema or template omitted from the request leaves the existing value in place,
+    -- so that re-registering a type never blanks out its descriptions
+    metadata_schema = coalesce(excluded.metadata_schema, flow_type.metadata_schema),
+    description_template = coalesce(excluded.description_template, flow_type.description_template)
+where flow_type.is_registered_outflow;
@@ -0,0 +1,29 @@
+-- name: RegisterPointsPolicy :exec
+insert into ledger.points_policy (
+    version,
+    points_per_bit,
+    points_per_subscription,
+    points_per_gift_sub,
+    tier_2_multiplier,
+    tier_3_multiplier,
+    initial_subscription_bonus,
+    points_per_raid_viewer,
+    points_per_follow,
+    points_per_hype_train_level,
+    points_per_watch_minute,
+    watch_time_daily_cap
+) values (
+    @version,
+    @points_per_bit,
+    @points_per_subscription,
+    @points_per_gift_sub,
+    @tier_2_multiplier,
+    @tier_3_multiplier,
+    @initial_subscription_bonus,
+    @points_per_raid_viewer,
+    @points_per_follow,
+    @points_per_hype_train_level,
+    @points_per_watch_minute,
+    @watch_time_daily_cap
+)
+on conflict (version) do nothing;
@@ -0,0 +1,51 @@
+-- name: SchedulePromotion :one
+insert into ledger.promotion (
+    id,
+    name,
+    multiplier,
+    flow_types,
+    starts_at,
+    ends_at
+) values (
+    gen_random_uuid(),
+    @name,
+    @multiplier,
+    sqlc.narg('flow_types')::text[],
+    @starts_at,
+    @ends_at
+)
+returning promotion.id, promotion.created_at;
+
+-- name: ListPromotions :many
+select
+    promotion.id,
+    promotion.name,
+    promotion.multiplier,
+    promotion.flow_types,
+    promotion.starts_at,
+    promotion.ends_at,
+    promotion.created_at
+from ledger.promotion
+where promotion.canceled_at is null
+    and promotion.ends_at > now()
+order by promotion.starts_at, promotion.id;
+
+-- name: CancelPromotion :execresult
+update ledger.promotion set
+    canceled_at = now()
+where promotion.id = @promotion_id
+    and promotion.canceled_at is null
+    and promotion.ends_at > now();
+
+-- name: GetActivePromotion :one
+select
+    promotion.id,
+    promotion.name,
+    promotion.multiplier
+from ledger.promotion
+where promotion.canceled_at is null
+    and promotion.starts_at <= now()
+    and promotion.ends_at > now()
+    and (promotion.flow_types is null or @flow_type::text = any(promotion.flow_types))
+order by promotion.multiplier desc, promotion.starts_at, promotion.id
+limit 1;
@@ -0,0 +1,44 @@
+-- name: GetFlowForReversal :one
+select
+    flow.id,
+    flow.type,
+    flow.twitch_user_id,
+    flow.delta_points,
+    flow.finalized_at,
+    flow.accepted,
+    coalesce((
+        select sum(reversal.delta_points) from ledger.flow as reversal
+        where reversal.reversed_flow_id = flow.id
+    ), 0)::integer as reversed_delta_points
+from ledger.flow
+where flow.id = @flow_id;
+
+-- name: RecordReversal :one
+insert into ledger.flow (
+    id,
+    type,
+    metadata,
+    twitch_user_id,
+    delta_points,
+    created_at,
+    finalized_at,
+    accepted,
+    reversed_flow_id
+)
+select
+    gen_random_uuid(),
+    'reversal',
+    jsonb_build_object(
+        'note', @note::text,
+        'reversed_type', original.type,
+        'reversed_metadata', original.metadata
+    ),
+    original.twitch_user_id,
+    -1 * sign(original.delta_points)::integer * @num_points::integer,
+    now(),
+    now(),
+    true,
+    original.id
+from ledger.flow as original
+where original.id = @flow_id
+returning flow.id;
@@ -2,11 +2,13 @@
 insert into ledger.sse_token (
     twitch_user_id,
     value,
-    expires_at
+    expires_at,
+    scope
 ) values (
     @twitch_user_id,
     @token_value,
-    now() + ((@ttl_seconds::int)::text || 's')::interval
+    now() + ((@ttl_seconds::int)::text || 's')::interval,
+    @scope
 );
 
 -- name: PurgeSseTokensForUser :exec
@@ -19,5 +21,21 @@ select
     sse_token.twitch_user_id
 from ledger.sse_token
 where sse_token.value = @token_value
+    and sse_token.scope = @scope
     and sse_token.expires_at > now()
 limit 1;
+
+-- name: ConsumeSseToken :one
+delete from ledger.sse_token
+where sse_token.value = @token_value
+    and sse_token.scope = @scope
+    and sse_token.expires_at > now()
+returning sse_token.twitch_user_id;
+
+-- name: RevokeSseTokensForUser :execresult
+delete from ledger.sse_token
+where sse_token.twitch_user_id = @twitch_user_id;
+
+-- name: PurgeExpiredSseTokens :execresult
+delete from ledger.sse_token
+where sse_token.expires_at <= now();
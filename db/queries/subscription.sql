@@ -7,7 +7,8 @@ insert into ledger.flow (
     delta_points,
     created_at,
     finalized_at,
-    accepted
+    accepted,
+    idempotency_key
 ) values (
     gen_random_uuid(),
     'subscription',
@@ -15,12 +16,17 @@ insert into ledger.flow (
         'message', @message::text,
         'is_initial', @is_initial::boolean,
         'is_gift', @is_gift::boolean,
-        'credit_multiplier', @credit_multiplier::float
+        'tier', @tier::text,
+        'credit_multiplier', @credit_multiplier::float,
+        'policy_version', @policy_version::text,
+        'promotion', sqlc.narg('promotion')::jsonb
     ),
     @twitch_user_id,
     @num_points_to_credit,
     now(),
     now(),
-    true
+    true,
+    sqlc.narg('idempotency_key')::text
 )
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
 returning flow.id;
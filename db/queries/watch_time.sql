@@ -0,0 +1,71 @@
+-- name: RecordWatchTimeInterval :execresult
+insert into ledger.watch_time_interval (
+    stream_id,
+    interval_id
+) values (
+    @stream_id,
+    @interval_id
+)
+on conflict (stream_id, interval_id) do nothing;
+
+-- name: GetWatchTimeInterval :one
+select
+    watch_time_interval.num_viewers_credited,
+    watch_time_interval.num_points_credited
+from ledger.watch_time_interval
+where watch_time_interval.stream_id = @stream_id
+    and watch_time_interval.interval_id = @interval_id;
+
+-- name: FinishWatchTimeInterval :exec
+update ledger.watch_time_interval set
+    num_viewers_credited = @num_viewers_credited,
+    num_points_credited = @num_points_credited
+where watch_time_interval.stream_id = @stream_id
+    and watch_time_interval.interval_id = @interval_id;
+
+-- name: LockWatchTimeDailyTotal :one
+insert into ledger.watch_time_daily_total (
+    twitch_user_id,
+    day,
+    num_points
+) values (
+    @twitch_user_id,
+    (now() at time zone 'utc')::date,
+    0
+)
+on conflict (twitch_user_id, day) do update set
+    num_points = watch_time_daily_total.num_points
+returning watch_time_daily_total.num_points;
+
+-- name: IncrementWatchTimeDailyTotal :exec
+update ledger.watch_time_daily_total set
+    num_points = watch_time_daily_total.num_points + @num_points::integer
+where watch_time_daily_total.twitch_user_id = @twitch_user_id
+    and watch_time_daily_total.day = (now() at time zone 'utc')::date;
+
+-- name: RecordWatchTimeInflow :one
+insert into ledger.flow (
+    id,
+    type,
+    metadata,
+    twitch_user_id,
+    delta_points,
+    created_at,
+    finalized_at,
+    accepted
+) values (
+    gen_random_uuid(),
+    'watch-time',
+    jsonb_build_object(
+        'stream_id', @stream_id::text,
+        'interval_id', @interval_id::text,
+        'minutes_watched', @minutes_watched::integer,
+        'policy_version', @policy_version::text
+    ),
+    @twitch_user_id,
+    @num_points_to_credit,
+    now(),
+    now(),
+    true
+)
+returning flow.id;
@@ -0,0 +1,86 @@
+-- name: RegisterWebhook :one
+insert into ledger.webhook (
+    id,
+    url,
+    flow_type,
+    secret
+) values (
+    gen_random_uuid(),
+    @url,
+    @flow_type,
+    @secret
+)
+returning webhook.id, webhook.created_at;
+
+-- name: ListWebhooks :many
+select
+    webhook.id,
+    webhook.url,
+    webhook.flow_type,
+    webhook.created_at
+from ledger.webhook
+order by webhook.created_at, webhook.id;
+
+-- name: DeleteWebhook :execresult
+delete from ledger.webhook
+where webhook.id = @webhook_id;
+
+-- name: ClaimWebhookDeliveries :many
+update ledger.webhook_delivery set
+    next_attempt_at = now() + make_interval(secs => @lease_seconds::integer)
+from ledger.webhook
+where webhook.id = webhook_delivery.webhook_id
+    and webhook_delivery.id in (
+        select pending.id from ledger.webhook_delivery as pending
+        where pending.delivered_at is null
+            and pending.failed_at is null
+            and pending.next_attempt_at <= now()
+        order by pending.next_attempt_at
+        limit @num_records
+        for update skip locked
+    )
+returning
+    webhook_delivery.id,
+    webhook_delivery.payload,
+    webhook_delivery.num_attempts,
+    webhook.url,
+    webhook.secret;
+
+-- name: RecordWebhookDeliverySuccess :exec
+update ledger.webhook_delivery set
+    num_attempts = webhook_delivery.num_attempts + 1,
+    delivered_at = now()
+where webhook_delivery.id = @delivery_id;
+
+-- name: RecordWebhookDeliveryFailure :exec
+update ledger.webhook_delivery set
+    num_attempts = webhook_delivery.num_attempts + 1,
+    last_error = @last_error,
+    next_attempt_at = now() + make_interval(secs => @retry_delay_seconds::integer),
+    failed_at = case when @give_up::boolean then now() else null end
+where webhook_delivery.id = @delivery_id;
+
+-- name: ListFailedWebhookDeliveries :many
+select
+    webhook_delivery.id,
+    webhook_delivery.webhook_id,
+    webhook.url,
+    webhook.flow_type,
+    webhook_delivery.flow_id,
+    webhook_delivery.created_at,
+    webhook_delivery.num_attempts,
+    webhook_delivery.last_error,
+    webhook_delivery.failed_at
+from ledger.webhook_delivery
+join ledger.webhook on webhook.id = webhook_delivery.webhook_id
+where webhook_delivery.failed_at is not null
+order by webhook_delivery.failed_at desc, webhook_delivery.id
+limit @num_records;
+
+-- name: RedriveWebhookDelivery :execresult
+update ledger.webhook_delivery set
+    num_attempts = 0,
+    next_attempt_at = now(),
+    failed_at = null
+where webhook_delivery.id = @delivery_id
+    and webhook_delivery.failed_at is not null;
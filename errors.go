@@ -0,0 +1,195 @@
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+)
+
+var (
+	// ErrInvalidRequest indicates that the ledger refused a request because it was
+	// malformed or its parameters were invalid
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrNotEnoughPoints indicates that the user does not have enough points available
+	// to cover the requested transaction
+	ErrNotEnoughPoints = errors.New("not enough points")
+	// ErrFlowNotFound indicates that the transaction identified in a request does not
+	// exist, or does not belong to the requesting user
+	ErrFlowNotFound = errors.New("no such transaction")
+	// ErrFlowAlreadyFinalized indicates an attempt to accept or reject a transaction that
+	// is no longer pending
+	ErrFlowAlreadyFinalized = errors.New("transaction has already been finalized")
+	// ErrIdempotencyConflict indicates that an idempotency key was reused for a request
+	// on behalf of a different user
+	ErrIdempotencyConflict = errors.New("idempotency key has already been used for another user")
+	// ErrReversalNotAllowed indicates that a transaction can not be reversed as requested
+	ErrReversalNotAllowed = errors.New("transaction can not be reversed")
+	// ErrConflict indicates that a request conflicts with the current state of the
+	// ledger in some other way, e.g. because a concurrent request changed the state that
+	// it depended on
+	ErrConflict = errors.New("conflict")
+	// ErrWebhookNotFound indicates that the webhook identified in a request does not
+	// exist
+	ErrWebhookNotFound = errors.New("no such webhook")
+	// ErrWebhookDeliveryNotFound indicates that the webhook delivery identified in a
+	// request does not exist, or has not failed
+	ErrWebhookDeliveryNotFound = errors.New("no such failed webhook delivery")
+	// ErrPromotionNotFound indicates that the promotion identified in a request does
+	// not exist, or has already ended or been canceled
+	ErrPromotionNotFound = errors.New("no such promotion")
+	// ErrFollowAlreadyCredited indicates that a user can not be credited for following
+	// the channel, because they've already been credited for an earlier follow
+	ErrFollowAlreadyCredited = errors.New("user has already been credited for following")
+	// ErrUserNotFound indicates that the user named in a request does not exist
+	ErrUserNotFound = errors.New("no such user")
+)
+
+// ErrorCode is a stable, machine-readable identifier for a class of error, reported in
+// the 'code' field of every error response from the ledger
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest          ErrorCode = "invalid_request"
+	ErrorCodeUnauthorized            ErrorCode = "unauthorized"
+	ErrorCodeNotEnoughPoints         ErrorCode = "not_enough_points"
+	ErrorCodeFlowNotFound            ErrorCode = "flow_not_found"
+	ErrorCodeFlowAlreadyFinalized    ErrorCode = "flow_already_finalized"
+	ErrorCodeIdempotencyConflict     ErrorCode = "idempotency_conflict"
+	ErrorCodeReversalNotAllowed      ErrorCode = "reversal_not_allowed"
+	ErrorCodeConflict                ErrorCode = "conflict"
+	ErrorCodeWebhookNotFound         ErrorCode = "webhook_not_found"
+	ErrorCodeWebhookDeliveryNotFound ErrorCode = "webhook_delivery_not_found"
+	ErrorCodePromotionNotFound       ErrorCode = "promotion_not_found"
+	ErrorCodeFollowAlreadyCredited   ErrorCode = "follow_already_credited"
+	ErrorCodeUserNotFound            ErrorCode = "user_not_found"
+	ErrorCodeInternal                ErrorCode = "internal_error"
+)
+
+// Status returns the HTTP status code with which errors of this type are reported
+func (c ErrorCode) Status() int {
+	switch c {
+	case ErrorCodeInvalidRequest:
+		return http.StatusBadRequest
+	case ErrorCodeUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorCodeFlowNotFound, ErrorCodeWebhookNotFound, ErrorCodeWebhookDeliveryNotFound, ErrorCodePromotionNotFound, ErrorCodeUserNotFound:
+		return http.StatusNotFound
+	case ErrorCodeNotEnoughPoints, ErrorCodeFlowAlreadyFinalized, ErrorCodeIdempotencyConflict, ErrorCodeReversalNotAllowed, ErrorCodeConflict, ErrorCodeFollowAlreadyCredited:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// sentinel returns the error value that corresponds to this error code, or nil if
+// there is none
+func (c ErrorCode) sentinel() error {
+	switch c {
+	case ErrorCodeInvalidRequest:
+		return ErrInvalidRequest
+	case ErrorCodeUnauthorized:
+		return auth.ErrUnauthorized
+	case ErrorCodeNotEnoughPoints:
+		return ErrNotEnoughPoints
+	case ErrorCodeFlowNotFound:
+		return ErrFlowNotFound
+	case ErrorCodeFlowAlreadyFinalized:
+		return ErrFlowAlreadyFinalized
+	case ErrorCodeIdempotencyConflict:
+		return ErrIdempotencyConflict
+	case ErrorCodeReversalNotAllowed:
+		return ErrReversalNotAllowed
+	case ErrorCodeConflict:
+		return ErrConflict
+	case ErrorCodeWebhookNotFound:
+		return ErrWebhookNotFound
+	case ErrorCodeWebhookDeliveryNotFound:
+		return ErrWebhookDeliveryNotFound
+	case ErrorCodePromotionNotFound:
+		return ErrPromotionNotFound
+	case ErrorCodeFollowAlreadyCredited:
+		return ErrFollowAlreadyCredited
+	case ErrorCodeUserNotFound:
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ProblemContentType is the content-type of the JSON body sent with error responses
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body of every error response from the ledger, in the format described
+// by RFC 7807 ("Problem Details for HTTP APIs"), extended with a stable error code
+type Problem struct {
+	Title  string    `json:"title"`
+	Status int       `json:"status"`
+	Code   ErrorCode `json:"code"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Error is returned by Client methods when the ledger responds to a request with an
+// error. It wraps the sentinel error corresponding to the reported error code, so that
+// callers can check for specific conditions with errors.Is, e.g.:
+//
+//	if errors.Is(err, ledger.ErrNotEnoughPoints) {
+//		...
+//	}
+type Error struct {
+	Method  string
+	Url     string
+	Problem Problem
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("got response %d from %s %s", e.Problem.Status, e.Method, e.Url)
+	if e.Problem.Detail != "" {
+		s += fmt.Sprintf(": %s", e.Problem.Detail)
+	}
+	return s
+}
+
+func (e *Error) Unwrap() error {
+	return e.Problem.Code.sentinel()
+}
+
+// parseErrorResponse builds an Error from a non-OK response. If the response doesn't
+// carry a problem details body (e.g. because it was generated by auth middleware rather
+// than by a ledger handler), the error is classified based on its status code alone.
+func parseErrorResponse(res *http.Response, method string, url string) error {
+	e := &Error{
+		Method: method,
+		Url:    url,
+		Problem: Problem{
+			Title:  http.StatusText(res.StatusCode),
+			Status: res.StatusCode,
+		},
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return e
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("content-type"))
+	if mediaType == ProblemContentType {
+		var problem Problem
+		if err := json.Unmarshal(body, &problem); err == nil {
+			e.Problem = problem
+			e.Problem.Status = res.StatusCode
+			return e
+		}
+	}
+
+	e.Problem.Detail = strings.TrimSpace(string(body))
+	switch res.StatusCode {
+	case http.StatusBadRequest:
+		e.Problem.Code = ErrorCodeInvalidRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		e.Problem.Code = ErrorCodeUnauthorized
+	}
+	return e
+}
@@ -7,8 +7,10 @@ package queries
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 )
 
 const recordCheerInflow = `-- name: RecordCheerInflow :one
@@ -20,28 +22,48 @@ insert into ledger.flow (
     delta_points,
     created_at,
     finalized_at,
-    accepted
+    accepted,
+    idempotency_key
 ) values (
     gen_random_uuid(),
     'cheer',
-    jsonb_build_object('message', $1::text),
-    $2,
-    $3,
+    jsonb_build_object(
+        'message', $1::text,
+        'num_bits', $2::integer,
+        'policy_version', $3::text,
+        'promotion', $4::jsonb
+    ),
+    $5,
+    $6,
     now(),
     now(),
-    true
+    true,
+    $7::text
 )
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
 returning flow.id
 `
 
 type RecordCheerInflowParams struct {
 	Message           string
+	NumBits           int32
+	PolicyVersion     string
+	Promotion         pqtype.NullRawMessage
 	TwitchUserID      string
 	NumPointsToCredit int32
+	IdempotencyKey    sql.NullString
 }
 
 func (q *Queries) RecordCheerInflow(ctx context.Context, arg RecordCheerInflowParams) (uuid.UUID, error) {
-	row := q.db.QueryRowContext(ctx, recordCheerInflow, arg.Message, arg.TwitchUserID, arg.NumPointsToCredit)
+	row := q.db.QueryRowContext(ctx, recordCheerInflow,
+		arg.Message,
+		arg.NumBits,
+		arg.PolicyVersion,
+		arg.Promotion,
+		arg.TwitchUserID,
+		arg.NumPointsToCredit,
+		arg.IdempotencyKey,
+	)
 	var id uuid.UUID
 	err := row.Scan(&id)
 	return id, err
@@ -15,19 +15,61 @@ import (
 const finalizeFlow = `-- name: FinalizeFlow :execresult
 update ledger.flow set
     finalized_at = now(),
-    accepted = $1
+    accepted = $1,
+    metadata = case when $2::text is null
+        then flow.metadata
+        else flow.metadata || jsonb_build_object('rejection_reason', $2::text)
+    end
 where
-    flow.id = $2
+    flow.id = $3
     and finalized_at is null
+    -- An outflow that has expired may only be rejected, even if we have yet to reject
+    -- it automatically
+    and (not $1 or flow.expires_at is null or flow.expires_at > now())
 `
 
 type FinalizeFlowParams struct {
-	Accepted bool
-	FlowID   uuid.UUID
+	Accepted        bool
+	RejectionReason sql.NullString
+	FlowID          uuid.UUID
 }
 
 func (q *Queries) FinalizeFlow(ctx context.Context, arg FinalizeFlowParams) (sql.Result, error) {
-	return q.db.ExecContext(ctx, finalizeFlow, arg.Accepted, arg.FlowID)
+	return q.db.ExecContext(ctx, finalizeFlow, arg.Accepted, arg.RejectionReason, arg.FlowID)
+}
+
+const getExpiredPendingFlowIds = `-- name: GetExpiredPendingFlowIds :many
+select
+    flow.id
+from ledger.flow
+where flow.finalized_at is null
+    and flow.expires_at is not null
+    and flow.expires_at <= now()
+order by flow.expires_at
+limit $1
+`
+
+func (q *Queries) GetExpiredPendingFlowIds(ctx context.Context, numRecords int32) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, getExpiredPendingFlowIds, numRecords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getFlow = `-- name: GetFlow :one
@@ -127,3 +127,59 @@ func Test_FinalizeFlow(t *testing.T) {
 		querytest.AssertNumRowsChanged(t, res, 0)
 	}
 }
+
+func Test_FinalizeFlow_rejectionReason(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('8e3b5d0b-71ac-4a7d-a0e5-2df3b1e1c5d4', 'manual-credit', '{"note":"unit test"}'::jsonb, '54321', 500, now(), now(), true);
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points) VALUES
+			('5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21', 'alert-redemption', '{"type":"foo"}'::jsonb, '54321', -100);
+	`)
+	assert.NoError(t, err)
+
+	res, err := q.FinalizeFlow(context.Background(), queries.FinalizeFlowParams{
+		FlowID:          uuid.MustParse("5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21"),
+		Accepted:        false,
+		RejectionReason: sql.NullString{Valid: true, String: "expired"},
+	})
+	assert.NoError(t, err)
+	querytest.AssertNumRowsChanged(t, res, 1)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM ledger.flow
+			WHERE id = '5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21'
+			AND metadata = '{"type":"foo","rejection_reason":"expired"}'::jsonb
+			AND finalized_at = now()
+			AND accepted = false
+	`)
+}
+
+func Test_GetExpiredPendingFlowIds(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('8e3b5d0b-71ac-4a7d-a0e5-2df3b1e1c5d4', 'manual-credit', '{"note":"unit test"}'::jsonb, '54321', 500, now() - '1h'::interval, now() - '1h'::interval, true);
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, expires_at, finalized_at, accepted) VALUES
+			('5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21', 'alert-redemption', '{"type":"foo"}'::jsonb, '54321', -100, now() - '20m'::interval, now() - '10m'::interval, NULL, false),
+			('b6a1a7f6-0f2d-4c9e-8a3e-7b7d2c5e1f90', 'alert-redemption', '{"type":"foo"}'::jsonb, '54321', -100, now() - '30m'::interval, now() - '20m'::interval, NULL, false),
+			('e0f3c2a4-9b1d-4e6f-a7c8-3d2b1a0f9e8d', 'alert-redemption', '{"type":"foo"}'::jsonb, '54321', -100, now() - '20m'::interval, now() + '10m'::interval, NULL, false),
+			('2c4e6a8b-1d3f-4a5c-9e7b-0f2d4c6e8a1b', 'alert-redemption', '{"type":"foo"}'::jsonb, '54321', -100, now() - '20m'::interval, now() - '10m'::interval, now() - '15m'::interval, true);
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at) VALUES
+			('7a9c1e3f-5b7d-4f1a-8c3e-5a7c9e1b3d5f', 'alert-redemption', '{"type":"foo"}'::jsonb, '54321', -100, now() - '20m'::interval);
+	`)
+	assert.NoError(t, err)
+
+	// Only pending flows whose expiration time has passed should be returned, with the
+	// longest-expired first
+	ids, err := q.GetExpiredPendingFlowIds(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("b6a1a7f6-0f2d-4c9e-8a3e-7b7d2c5e1f90"),
+		uuid.MustParse("5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21"),
+	}, ids)
+}
@@ -0,0 +1,178 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetFlowChangesSince(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('03270514-a9e8-4c6c-97e2-78fa9d72ab8c', 'manual-credit', '{"note":"test1"}'::jsonb, '12345', 111, now() - '3h'::interval, now() - '3h'::interval, true),
+			('f0c6f086-6885-4ed2-a693-25b5a4205e91', 'manual-credit', '{"note":"test2"}'::jsonb, '67890', 1000, now() - '2h'::interval, now() - '2h'::interval, true),
+			('dd9348ef-7277-47aa-9d40-bd67a5909a07', 'alert-redemption', '{"type":"test"}'::jsonb, '12345', -50, now() - '1h'::interval, NULL, false);
+	`)
+	assert.NoError(t, err)
+
+	rows, err := q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: "12345",
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, uuid.MustParse("03270514-a9e8-4c6c-97e2-78fa9d72ab8c"), rows[0].ID)
+	assert.Equal(t, uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"), rows[1].ID)
+	assert.Equal(t, rows[0].Seq+1, rows[1].Seq)
+	assert.True(t, rows[1].DescriptionTemplate.Valid)
+	lastSeq := rows[1].Seq
+
+	// Nothing has changed since the last change we've seen
+	rows, err = q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: "12345",
+		Seq:          lastSeq,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 0)
+
+	// Finalizing a transaction should record a new change, numbered immediately after
+	// the last one, so that the change is replayed
+	_, err = tx.Exec(`
+		UPDATE ledger.flow SET finalized_at = now(), accepted = true
+		WHERE id = 'dd9348ef-7277-47aa-9d40-bd67a5909a07';
+	`)
+	assert.NoError(t, err)
+	rows, err = q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: "12345",
+		Seq:          lastSeq,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"), rows[0].ID)
+	assert.Equal(t, lastSeq+1, rows[0].Seq)
+	assert.True(t, rows[0].FinalizedAt.Valid)
+	assert.True(t, rows[0].Accepted)
+}
+
+func Test_GetFlowChangesSince_watchTime(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a01', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-1","minutes_watched":5,"policy_version":"0123456789ab"}'::jsonb, '12345', 10, now() - '1h'::interval, now() - '1h'::interval, true),
+			('7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a02', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-2","minutes_watched":3,"policy_version":"0123456789ab"}'::jsonb, '12345', 6, now() - '30m'::interval, now() - '30m'::interval, true);
+	`)
+	assert.NoError(t, err)
+
+	// Each interval is recorded as a separate change, but both should be described as
+	// changes to the stream's entry in the user's history, identified by its first
+	// interval and showing the totals credited for the stream
+	rows, err := q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: "12345",
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	for _, row := range rows {
+		assert.Equal(t, uuid.MustParse("7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a01"), row.ID)
+		assert.Equal(t, int32(16), row.DeltaPoints)
+		assert.JSONEq(t, `{"stream_id":"stream-1","minutes_watched":8,"policy_version":"0123456789ab"}`, string(row.Metadata))
+	}
+}
+
+func Test_GetFlowChangesSince_commitOrder(t *testing.T) {
+	// This test needs to commit changes from two concurrent transactions, so we can't
+	// use a single rolled-back transaction: use a dedicated user ID and clean up after
+	// ourselves instead
+	db := querytest.Prepare(t)
+	q := queries.New(db)
+	twitchUserId := "change-seq-order-test"
+	cleanup := func() {
+		if _, err := db.Exec("DELETE FROM ledger.flow WHERE twitch_user_id = $1", twitchUserId); err != nil {
+			t.Logf("failed to clean up flows for test user: %v", err)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	insertFlow := func(tx *sql.Tx, note string) error {
+		_, err := tx.Exec(`
+			INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+				(gen_random_uuid(), 'manual-credit', jsonb_build_object('note', $2::text), $1, 100, now(), now(), true);
+		`, twitchUserId, note)
+		return err
+	}
+
+	// Begin two transactions, and record a change for our user in the first
+	first, err := db.Begin()
+	assert.NoError(t, err)
+	defer first.Rollback()
+	second, err := db.Begin()
+	assert.NoError(t, err)
+	defer second.Rollback()
+	err = insertFlow(first, "first")
+	assert.NoError(t, err)
+
+	// Attempt to record and commit a change to the same user's flows in the second
+	// transaction: it should be unable to proceed while the first is still open, since
+	// otherwise it could commit a later change_seq than one that's not yet visible
+	secondDone := make(chan error, 1)
+	go func() {
+		if err := insertFlow(second, "second"); err != nil {
+			secondDone <- err
+			return
+		}
+		secondDone <- second.Commit()
+	}()
+	select {
+	case err := <-secondDone:
+		t.Fatalf("second transaction completed while first was still open: %v", err)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	// A reader that sees nothing yet should see the first change once it commits
+	rows, err := q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: twitchUserId,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 0)
+	err = first.Commit()
+	assert.NoError(t, err)
+	rows, err = q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: twitchUserId,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, rows, 1) {
+		return
+	}
+	lastSeq := rows[0].Seq
+
+	// Once the second transaction commits, a reader resuming from the last change it
+	// saw should not have missed anything
+	err = <-secondDone
+	assert.NoError(t, err)
+	rows, err = q.GetFlowChangesSince(context.Background(), queries.GetFlowChangesSinceParams{
+		TwitchUserID: twitchUserId,
+		Seq:          lastSeq,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.JSONEq(t, `{"note":"second"}`, string(rows[0].Metadata))
+		assert.Equal(t, lastSeq+1, rows[0].Seq)
+	}
+}
@@ -0,0 +1,57 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordFollowInflow(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// With no follow recorded, there should be no follow inflow for the user
+	_, err := q.GetFollowInflowId(context.Background(), "4444")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	flowUuid, err := q.RecordFollowInflow(context.Background(), queries.RecordFollowInflowParams{
+		PolicyVersion:     "0123456789ab",
+		TwitchUserID:      "4444",
+		NumPointsToCredit: 50,
+		IdempotencyKey:    sql.NullString{Valid: true, String: "follow-1"},
+	})
+	assert.NoError(t, err)
+	followUuid, err := q.GetFollowInflowId(context.Background(), "4444")
+	assert.NoError(t, err)
+	assert.Equal(t, flowUuid, followUuid)
+
+	// A second follow from the same user should never be credited, even if it carries
+	// a different idempotency key, or none at all
+	_, err = q.RecordFollowInflow(context.Background(), queries.RecordFollowInflowParams{
+		PolicyVersion:     "0123456789ab",
+		TwitchUserID:      "4444",
+		NumPointsToCredit: 50,
+		IdempotencyKey:    sql.NullString{Valid: true, String: "follow-2"},
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = q.RecordFollowInflow(context.Background(), queries.RecordFollowInflowParams{
+		PolicyVersion:     "0123456789ab",
+		TwitchUserID:      "4444",
+		NumPointsToCredit: 50,
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM ledger.flow WHERE type = 'follow'")
+
+	// Other users may still be credited for following
+	_, err = q.RecordFollowInflow(context.Background(), queries.RecordFollowInflowParams{
+		PolicyVersion:     "0123456789ab",
+		TwitchUserID:      "5555",
+		NumPointsToCredit: 50,
+	})
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 2, "SELECT COUNT(*) FROM ledger.flow WHERE type = 'follow'")
+}
@@ -7,8 +7,10 @@ package queries
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 )
 
 const recordGiftSubInflow = `-- name: RecordGiftSubInflow :one
@@ -20,36 +22,50 @@ insert into ledger.flow (
     delta_points,
     created_at,
     finalized_at,
-    accepted
+    accepted,
+    idempotency_key
 ) values (
     gen_random_uuid(),
     'gift-sub',
     jsonb_build_object(
         'num_subscriptions', $1::integer,
-        'credit_multiplier', $2::float
+        'tier', $2::text,
+        'credit_multiplier', $3::float,
+        'policy_version', $4::text,
+        'promotion', $5::jsonb
     ),
-    $3,
-    $4,
+    $6,
+    $7,
     now(),
     now(),
-    true
+    true,
+    $8::text
 )
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
 returning flow.id
 `
 
 type RecordGiftSubInflowParams struct {
 	NumSubscriptions  int32
+	Tier              string
 	CreditMultiplier  float64
+	PolicyVersion     string
+	Promotion         pqtype.NullRawMessage
 	TwitchUserID      string
 	NumPointsToCredit int32
+	IdempotencyKey    sql.NullString
 }
 
 func (q *Queries) RecordGiftSubInflow(ctx context.Context, arg RecordGiftSubInflowParams) (uuid.UUID, error) {
 	row := q.db.QueryRowContext(ctx, recordGiftSubInflow,
 		arg.NumSubscriptions,
+		arg.Tier,
 		arg.CreditMultiplier,
+		arg.PolicyVersion,
+		arg.Promotion,
 		arg.TwitchUserID,
 		arg.NumPointsToCredit,
+		arg.IdempotencyKey,
 	)
 	var id uuid.UUID
 	err := row.Scan(&id)
@@ -12,47 +12,306 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const getNewerTransactionHistory = `-- name: GetNewerTransactionHistory :many
+select
+    entry.id,
+    entry.type,
+    entry.metadata,
+    entry.delta_points,
+    entry.created_at,
+    entry.finalized_at,
+    entry.accepted,
+    flow_type.description_template,
+    entry.reversed_delta_points
+-- Watch time is credited via a separate, immutable transaction for each interval, but
+-- we show a single entry per stream, paging through the per-stream summaries kept in
+-- watch_time_stream rather than aggregating every interval on each read. Each branch
+-- reads no more than a page of rows from its own index before the two are merged.
+from ((
+    select
+        flow.id,
+        flow.type,
+        flow.metadata,
+        flow.delta_points,
+        flow.created_at,
+        flow.finalized_at,
+        flow.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as reversal
+            where reversal.reversed_flow_id = flow.id
+        ), 0)::integer as reversed_delta_points
+    from ledger.flow
+    where flow.twitch_user_id = $1
+    and flow.type != 'watch-time'
+    and ($2::uuid is null or (flow.created_at, flow.id) > (
+        $3::timestamptz,
+        $2::uuid
+    ))
+    and (coalesce(cardinality($4::text[]), 0) = 0 or flow.type = any($4::text[]))
+    and case $5::text
+        when 'pending' then flow.finalized_at is null
+        when 'accepted' then flow.finalized_at is not null and flow.accepted
+        when 'rejected' then flow.finalized_at is not null and not flow.accepted
+        else true
+    end
+    and ($6::timestamptz is null or flow.created_at >= $6::timestamptz)
+    and ($7::timestamptz is null or flow.created_at < $7::timestamptz)
+    and case $8::text
+        when 'inflow' then flow.delta_points > 0
+        when 'outflow' then flow.delta_points < 0
+        else true
+    end
+    order by flow.created_at, flow.id
+    limit $9
+) union all (
+    select
+        watch_time_stream.flow_id as id,
+        'watch-time' as type,
+        (first_interval.metadata - 'interval_id') || jsonb_build_object(
+            'minutes_watched', watch_time_stream.minutes_watched
+        ) as metadata,
+        watch_time_stream.delta_points,
+        watch_time_stream.created_at,
+        first_interval.finalized_at,
+        first_interval.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as watch_time
+            join ledger.flow as reversal on reversal.reversed_flow_id = watch_time.id
+            where watch_time.twitch_user_id = watch_time_stream.twitch_user_id
+                and watch_time.type = 'watch-time'
+                and watch_time.metadata->>'stream_id' = watch_time_stream.stream_id
+        ), 0)::integer as reversed_delta_points
+    from ledger.watch_time_stream
+    join ledger.flow as first_interval on first_interval.id = watch_time_stream.flow_id
+    where watch_time_stream.twitch_user_id = $1
+    and ($2::uuid is null or (watch_time_stream.created_at, watch_time_stream.flow_id) > (
+        $3::timestamptz,
+        $2::uuid
+    ))
+    and (coalesce(cardinality($4::text[]), 0) = 0 or 'watch-time' = any($4::text[]))
+    and case $5::text
+        when 'pending' then first_interval.finalized_at is null
+        when 'accepted' then first_interval.finalized_at is not null and first_interval.accepted
+        when 'rejected' then first_interval.finalized_at is not null and not first_interval.accepted
+        else true
+    end
+    and ($6::timestamptz is null or watch_time_stream.created_at >= $6::timestamptz)
+    and ($7::timestamptz is null or watch_time_stream.created_at < $7::timestamptz)
+    and case $8::text
+        when 'inflow' then watch_time_stream.delta_points > 0
+        when 'outflow' then watch_time_stream.delta_points < 0
+        else true
+    end
+    order by watch_time_stream.created_at, watch_time_stream.flow_id
+    limit $9
+)) as entry
+join ledger.flow_type on flow_type.name = entry.type
+order by entry.created_at, entry.id
+limit $9;`
+
+type GetNewerTransactionHistoryParams struct {
+	TwitchUserID    string
+	CursorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	Types           []string
+	State           sql.NullString
+	Since           sql.NullTime
+	Until           sql.NullTime
+	Direction       sql.NullString
+	NumRecords      int32
+}
+
+type GetNewerTransactionHistoryRow struct {
+	ID                  uuid.UUID
+	Type                string
+	Metadata            json.RawMessage
+	DeltaPoints         int32
+	CreatedAt           time.Time
+	FinalizedAt         sql.NullTime
+	Accepted            bool
+	DescriptionTemplate sql.NullString
+	ReversedDeltaPoints int32
+}
+
+func (q *Queries) GetNewerTransactionHistory(ctx context.Context, arg GetNewerTransactionHistoryParams) ([]GetNewerTransactionHistoryRow, error) {
+	rows, err := q.db.QueryContext(ctx, getNewerTransactionHistory,
+		arg.TwitchUserID,
+		arg.CursorID,
+		arg.CursorCreatedAt,
+		pq.Array(arg.Types),
+		arg.State,
+		arg.Since,
+		arg.Until,
+		arg.Direction,
+		arg.NumRecords,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetNewerTransactionHistoryRow
+	for rows.Next() {
+		var i GetNewerTransactionHistoryRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Metadata,
+			&i.DeltaPoints,
+			&i.CreatedAt,
+			&i.FinalizedAt,
+			&i.Accepted,
+			&i.DescriptionTemplate,
+			&i.ReversedDeltaPoints,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getTransactionHistory = `-- name: GetTransactionHistory :many
 select
-    flow.id,
-    flow.type,
-    flow.metadata,
-    flow.delta_points,
-    flow.created_at,
-    flow.finalized_at,
-    flow.accepted
-from ledger.flow
-where flow.twitch_user_id = $1
-and case when $2::uuid is null
-    then true
-    else flow.created_at <= (
-        select flow.created_at from ledger.flow where flow.id = $2::uuid
-    )
-end
-order by flow.created_at desc
-limit $3
-`
+    entry.id,
+    entry.type,
+    entry.metadata,
+    entry.delta_points,
+    entry.created_at,
+    entry.finalized_at,
+    entry.accepted,
+    flow_type.description_template,
+    entry.reversed_delta_points
+-- Watch time is credited via a separate, immutable transaction for each interval, but
+-- we show a single entry per stream, paging through the per-stream summaries kept in
+-- watch_time_stream rather than aggregating every interval on each read. Each branch
+-- reads no more than a page of rows from its own index before the two are merged.
+from ((
+    select
+        flow.id,
+        flow.type,
+        flow.metadata,
+        flow.delta_points,
+        flow.created_at,
+        flow.finalized_at,
+        flow.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as reversal
+            where reversal.reversed_flow_id = flow.id
+        ), 0)::integer as reversed_delta_points
+    from ledger.flow
+    where flow.twitch_user_id = $1
+    and flow.type != 'watch-time'
+    and ($2::uuid is null or (flow.created_at, flow.id) < (
+        $3::timestamptz,
+        $2::uuid
+    ))
+    and (coalesce(cardinality($4::text[]), 0) = 0 or flow.type = any($4::text[]))
+    and case $5::text
+        when 'pending' then flow.finalized_at is null
+        when 'accepted' then flow.finalized_at is not null and flow.accepted
+        when 'rejected' then flow.finalized_at is not null and not flow.accepted
+        else true
+    end
+    and ($6::timestamptz is null or flow.created_at >= $6::timestamptz)
+    and ($7::timestamptz is null or flow.created_at < $7::timestamptz)
+    and case $8::text
+        when 'inflow' then flow.delta_points > 0
+        when 'outflow' then flow.delta_points < 0
+        else true
+    end
+    order by flow.created_at desc, flow.id desc
+    limit $9
+) union all (
+    select
+        watch_time_stream.flow_id as id,
+        'watch-time' as type,
+        (first_interval.metadata - 'interval_id') || jsonb_build_object(
+            'minutes_watched', watch_time_stream.minutes_watched
+        ) as metadata,
+        watch_time_stream.delta_points,
+        watch_time_stream.created_at,
+        first_interval.finalized_at,
+        first_interval.accepted,
+        coalesce((
+            select sum(reversal.delta_points) from ledger.flow as watch_time
+            join ledger.flow as reversal on reversal.reversed_flow_id = watch_time.id
+            where watch_time.twitch_user_id = watch_time_stream.twitch_user_id
+                and watch_time.type = 'watch-time'
+                and watch_time.metadata->>'stream_id' = watch_time_stream.stream_id
+        ), 0)::integer as reversed_delta_points
+    from ledger.watch_time_stream
+    join ledger.flow as first_interval on first_interval.id = watch_time_stream.flow_id
+    where watch_time_stream.twitch_user_id = $1
+    and ($2::uuid is null or (watch_time_stream.created_at, watch_time_stream.flow_id) < (
+        $3::timestamptz,
+        $2::uuid
+    ))
+    and (coalesce(cardinality($4::text[]), 0) = 0 or 'watch-time' = any($4::text[]))
+    and case $5::text
+        when 'pending' then first_interval.finalized_at is null
+        when 'accepted' then first_interval.finalized_at is not null and first_interval.accepted
+        when 'rejected' then first_interval.finalized_at is not null and not first_interval.accepted
+        else true
+    end
+    and ($6::timestamptz is null or watch_time_stream.created_at >= $6::timestamptz)
+    and ($7::timestamptz is null or watch_time_stream.created_at < $7::timestamptz)
+    and case $8::text
+        when 'inflow' then watch_time_stream.delta_points > 0
+        when 'outflow' then watch_time_stream.delta_points < 0
+        else true
+    end
+    order by watch_time_stream.created_at desc, watch_time_stream.flow_id desc
+    limit $9
+)) as entry
+join ledger.flow_type on flow_type.name = entry.type
+order by entry.created_at desc, entry.id desc
+limit $9;`
 
 type GetTransactionHistoryParams struct {
-	TwitchUserID string
-	StartID      uuid.NullUUID
-	NumRecords   int32
+	TwitchUserID    string
+	CursorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	Types           []string
+	State           sql.NullString
+	Since           sql.NullTime
+	Until           sql.NullTime
+	Direction       sql.NullString
+	NumRecords      int32
 }
 
 type GetTransactionHistoryRow struct {
-	ID          uuid.UUID
-	Type        string
-	Metadata    json.RawMessage
-	DeltaPoints int32
-	CreatedAt   time.Time
-	FinalizedAt sql.NullTime
-	Accepted    bool
+	ID                  uuid.UUID
+	Type                string
+	Metadata            json.RawMessage
+	DeltaPoints         int32
+	CreatedAt           time.Time
+	FinalizedAt         sql.NullTime
+	Accepted            bool
+	DescriptionTemplate sql.NullString
+	ReversedDeltaPoints int32
 }
 
 func (q *Queries) GetTransactionHistory(ctx context.Context, arg GetTransactionHistoryParams) ([]GetTransactionHistoryRow, error) {
-	rows, err := q.db.QueryContext(ctx, getTransactionHistory, arg.TwitchUserID, arg.StartID, arg.NumRecords)
+	rows, err := q.db.QueryContext(ctx, getTransactionHistory,
+		arg.TwitchUserID,
+		arg.CursorID,
+		arg.CursorCreatedAt,
+		pq.Array(arg.Types),
+		arg.State,
+		arg.Since,
+		arg.Until,
+		arg.Direction,
+		arg.NumRecords,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -68,6 +327,8 @@ func (q *Queries) GetTransactionHistory(ctx context.Context, arg GetTransactionH
 			&i.CreatedAt,
 			&i.FinalizedAt,
 			&i.Accepted,
+			&i.DescriptionTemplate,
+			&i.ReversedDeltaPoints,
 		); err != nil {
 			return nil, err
 		}
@@ -81,3 +342,25 @@ func (q *Queries) GetTransactionHistory(ctx context.Context, arg GetTransactionH
 	}
 	return items, nil
 }
+
+const historyCursorExists = `-- name: HistoryCursorExists :one
+select exists (
+    select 1 from ledger.flow
+    where flow.twitch_user_id = $1
+        and flow.id = $2
+        and flow.created_at = $3
+)
+`
+
+type HistoryCursorExistsParams struct {
+	TwitchUserID string
+	ID           uuid.UUID
+	CreatedAt    time.Time
+}
+
+func (q *Queries) HistoryCursorExists(ctx context.Context, arg HistoryCursorExistsParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, historyCursorExists, arg.TwitchUserID, arg.ID, arg.CreatedAt)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
@@ -2,7 +2,10 @@ package queries_test
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/golden-vcr/ledger/gen/queries"
 	"github.com/golden-vcr/server-common/querytest"
@@ -50,11 +53,13 @@ func Test_GetTransactionHistory(t *testing.T) {
 	assert.Equal(t, int32(111), first.DeltaPoints)
 	assert.True(t, first.FinalizedAt.Valid)
 	assert.True(t, first.Accepted)
+	assert.False(t, first.DescriptionTemplate.Valid)
 
 	rows, err = q.GetTransactionHistory(context.Background(), queries.GetTransactionHistoryParams{
-		TwitchUserID: "12345",
-		StartID:      uuid.NullUUID{Valid: true, UUID: ids[len(ids)-1]},
-		NumRecords:   5,
+		TwitchUserID:    "12345",
+		CursorID:        uuid.NullUUID{Valid: true, UUID: ids[len(ids)-1]},
+		CursorCreatedAt: sql.NullTime{Valid: true, Time: rows[len(rows)-1].CreatedAt},
+		NumRecords:      5,
 	})
 	assert.NoError(t, err)
 	ids = make([]uuid.UUID, 0)
@@ -63,7 +68,6 @@ func Test_GetTransactionHistory(t *testing.T) {
 	}
 
 	assert.Equal(t, []uuid.UUID{
-		uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"),
 		uuid.MustParse("f1eb1e52-592f-4dd1-85a3-da7fbc3889e1"),
 	}, ids)
 
@@ -73,4 +77,276 @@ func Test_GetTransactionHistory(t *testing.T) {
 	assert.Equal(t, int32(-25), last.DeltaPoints)
 	assert.False(t, last.FinalizedAt.Valid)
 	assert.False(t, last.Accepted)
+	assert.Equal(t, "Redeemed alert of type '{{.type}}'", last.DescriptionTemplate.String)
+}
+
+func Test_GetTransactionHistory_filters(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('03270514-a9e8-4c6c-97e2-78fa9d72ab8c', 'manual-credit', '{"note":"test1"}'::jsonb, '12345', 111, '1997-09-01T12:00:00Z', '1997-09-01T12:00:00Z', true),
+			('acbcb23e-b037-428a-9df4-b04e7f8da6a3', 'manual-credit', '{"note":"test2"}'::jsonb, '12345', 222, '1997-09-01T11:00:00Z', '1997-09-01T11:00:00Z', false),
+			('398893a4-1d18-42c4-80fd-d2cd48e27a83', 'cheer', '{"message":""}'::jsonb, '12345', 333, '1997-09-01T10:00:00Z', '1997-09-01T10:00:00Z', true),
+			('dd9348ef-7277-47aa-9d40-bd67a5909a07', 'alert-redemption', '{"type":"test"}'::jsonb, '12345', -50, '1997-09-01T09:00:00Z', '1997-09-01T09:00:00Z', true),
+			('f1eb1e52-592f-4dd1-85a3-da7fbc3889e1', 'alert-redemption', '{"type":"test"}'::jsonb, '12345', -25, '1997-08-31T09:00:00Z', NULL, false);
+	`)
+	assert.NoError(t, err)
+
+	getIds := func(params queries.GetTransactionHistoryParams) []uuid.UUID {
+		params.TwitchUserID = "12345"
+		if params.NumRecords == 0 {
+			params.NumRecords = 10
+		}
+		rows, err := q.GetTransactionHistory(context.Background(), params)
+		assert.NoError(t, err)
+		ids := make([]uuid.UUID, 0)
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("398893a4-1d18-42c4-80fd-d2cd48e27a83"),
+		uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"),
+		uuid.MustParse("f1eb1e52-592f-4dd1-85a3-da7fbc3889e1"),
+	}, getIds(queries.GetTransactionHistoryParams{
+		Types: []string{"cheer", "alert-redemption"},
+	}))
+
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("f1eb1e52-592f-4dd1-85a3-da7fbc3889e1"),
+	}, getIds(queries.GetTransactionHistoryParams{
+		State: sql.NullString{Valid: true, String: "pending"},
+	}))
+
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("acbcb23e-b037-428a-9df4-b04e7f8da6a3"),
+	}, getIds(queries.GetTransactionHistoryParams{
+		State: sql.NullString{Valid: true, String: "rejected"},
+	}))
+
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"),
+		uuid.MustParse("f1eb1e52-592f-4dd1-85a3-da7fbc3889e1"),
+	}, getIds(queries.GetTransactionHistoryParams{
+		Direction: sql.NullString{Valid: true, String: "outflow"},
+	}))
+
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("acbcb23e-b037-428a-9df4-b04e7f8da6a3"),
+		uuid.MustParse("398893a4-1d18-42c4-80fd-d2cd48e27a83"),
+		uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"),
+	}, getIds(queries.GetTransactionHistoryParams{
+		Since: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 0, 0, 0, 0, time.UTC)},
+		Until: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)},
+	}))
+
+	// Pagination should work in combination with filters, even if the cursor refers to
+	// a transaction that's excluded by those filters
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("398893a4-1d18-42c4-80fd-d2cd48e27a83"),
+	}, getIds(queries.GetTransactionHistoryParams{
+		CursorID:        uuid.NullUUID{Valid: true, UUID: uuid.MustParse("acbcb23e-b037-428a-9df4-b04e7f8da6a3")},
+		CursorCreatedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 11, 0, 0, 0, time.UTC)},
+		State:           sql.NullString{Valid: true, String: "accepted"},
+		Direction:       sql.NullString{Valid: true, String: "inflow"},
+	}))
+}
+
+func Test_GetTransactionHistory_cursor(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// Several transactions share a timestamp: ties should be broken by ID
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('f0000000-0000-0000-0000-000000000000', 'manual-credit', '{"note":"test1"}'::jsonb, '12345', 100, '1997-09-01T12:01:00Z', '1997-09-01T12:01:00Z', true),
+			('c0000000-0000-0000-0000-000000000000', 'manual-credit', '{"note":"test2"}'::jsonb, '12345', 100, '1997-09-01T12:00:00Z', '1997-09-01T12:00:00Z', true),
+			('b0000000-0000-0000-0000-000000000000', 'manual-credit', '{"note":"test3"}'::jsonb, '12345', 100, '1997-09-01T12:00:00Z', '1997-09-01T12:00:00Z', true),
+			('a0000000-0000-0000-0000-000000000000', 'manual-credit', '{"note":"test4"}'::jsonb, '12345', 100, '1997-09-01T12:00:00Z', '1997-09-01T12:00:00Z', true),
+			('10000000-0000-0000-0000-000000000000', 'manual-credit', '{"note":"test5"}'::jsonb, '12345', 100, '1997-09-01T11:59:00Z', '1997-09-01T11:59:00Z', true);
+	`)
+	assert.NoError(t, err)
+
+	cursorId := uuid.NullUUID{Valid: true, UUID: uuid.MustParse("b0000000-0000-0000-0000-000000000000")}
+	cursorCreatedAt := sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)}
+
+	older, err := q.GetTransactionHistory(context.Background(), queries.GetTransactionHistoryParams{
+		TwitchUserID:    "12345",
+		CursorID:        cursorId,
+		CursorCreatedAt: cursorCreatedAt,
+		NumRecords:      10,
+	})
+	assert.NoError(t, err)
+	olderIds := make([]uuid.UUID, 0)
+	for _, row := range older {
+		olderIds = append(olderIds, row.ID)
+	}
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("a0000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("10000000-0000-0000-0000-000000000000"),
+	}, olderIds)
+
+	newer, err := q.GetNewerTransactionHistory(context.Background(), queries.GetNewerTransactionHistoryParams{
+		TwitchUserID:    "12345",
+		CursorID:        cursorId,
+		CursorCreatedAt: cursorCreatedAt,
+		NumRecords:      10,
+	})
+	assert.NoError(t, err)
+	newerIds := make([]uuid.UUID, 0)
+	for _, row := range newer {
+		newerIds = append(newerIds, row.ID)
+	}
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("c0000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("f0000000-0000-0000-0000-000000000000"),
+	}, newerIds)
+
+	exists, err := q.HistoryCursorExists(context.Background(), queries.HistoryCursorExistsParams{
+		TwitchUserID: "12345",
+		ID:           cursorId.UUID,
+		CreatedAt:    cursorCreatedAt.Time,
+	})
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = q.HistoryCursorExists(context.Background(), queries.HistoryCursorExistsParams{
+		TwitchUserID: "67890",
+		ID:           cursorId.UUID,
+		CreatedAt:    cursorCreatedAt.Time,
+	})
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = q.HistoryCursorExists(context.Background(), queries.HistoryCursorExistsParams{
+		TwitchUserID: "12345",
+		ID:           cursorId.UUID,
+		CreatedAt:    cursorCreatedAt.Time.Add(time.Second),
+	})
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func Test_GetTransactionHistory_watchTime(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a01', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-1","minutes_watched":5,"policy_version":"0123456789ab"}'::jsonb, '12345', 10, now() - '3h'::interval, now() - '3h'::interval, true),
+			('5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a02', 'manual-credit', '{"note":"test"}'::jsonb, '12345', 100, now() - '2h'::interval, now() - '2h'::interval, true),
+			('5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a03', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-2","minutes_watched":3,"policy_version":"0123456789ab"}'::jsonb, '12345', 6, now() - '1h'::interval, now() - '1h'::interval, true),
+			('5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a04', 'watch-time', '{"stream_id":"stream-2","interval_id":"interval-1","minutes_watched":5,"policy_version":"0123456789ab"}'::jsonb, '12345', 10, now() - '30m'::interval, now() - '30m'::interval, true),
+			('5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a05', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-1","minutes_watched":5,"policy_version":"0123456789ab"}'::jsonb, '67890', 10, now() - '3h'::interval, now() - '3h'::interval, true);
+	`)
+	assert.NoError(t, err)
+
+	// Each stream's intervals should be combined into a single entry, identified and
+	// ordered by the stream's first interval
+	rows, err := q.GetTransactionHistory(context.Background(), queries.GetTransactionHistoryParams{
+		TwitchUserID: "12345",
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	ids := make([]uuid.UUID, 0)
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a04"),
+		uuid.MustParse("5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a02"),
+		uuid.MustParse("5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a01"),
+	}, ids)
+
+	stream := rows[2]
+	assert.Equal(t, "watch-time", stream.Type)
+	assert.Equal(t, int32(16), stream.DeltaPoints)
+	assert.True(t, stream.FinalizedAt.Valid)
+	assert.True(t, stream.Accepted)
+	var metadata map[string]interface{}
+	assert.NoError(t, json.Unmarshal(stream.Metadata, &metadata))
+	assert.Equal(t, map[string]interface{}{
+		"stream_id":       "stream-1",
+		"minutes_watched": float64(8),
+		"policy_version":  "0123456789ab",
+	}, metadata)
+
+	// Paging with the combined entry as a cursor should pick up where it left off
+	newerRows, err := q.GetNewerTransactionHistory(context.Background(), queries.GetNewerTransactionHistoryParams{
+		TwitchUserID:    "12345",
+		CursorID:        uuid.NullUUID{Valid: true, UUID: stream.ID},
+		CursorCreatedAt: sql.NullTime{Valid: true, Time: stream.CreatedAt},
+		NumRecords:      10,
+	})
+	assert.NoError(t, err)
+	ids = make([]uuid.UUID, 0)
+	for _, row := range newerRows {
+		ids = append(ids, row.ID)
+	}
+	assert.Equal(t, []uuid.UUID{
+		uuid.MustParse("5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a02"),
+		uuid.MustParse("5d4b5b1e-94a4-4bd5-8b43-4d0d6f4c2a04"),
+	}, ids)
+}
+
+func Test_GetTransactionHistory_rowBounds(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// Give the user a long history: 100 manual credits, interleaved with 30 streams'
+	// worth of watch time, each credited over 4 intervals
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted)
+		SELECT gen_random_uuid(), 'manual-credit', jsonb_build_object('note', 'credit ' || n), '12345', 100, now() - n * '7m'::interval, now(), true
+		FROM generate_series(1, 100) AS n;
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted)
+		SELECT gen_random_uuid(), 'watch-time', jsonb_build_object('stream_id', 'stream-' || s, 'interval_id', 'interval-' || i, 'minutes_watched', 5, 'policy_version', '0123456789ab'), '12345', 10, now() - s * '20m'::interval + i * '1m'::interval, now(), true
+		FROM generate_series(1, 30) AS s, generate_series(1, 4) AS i
+		ORDER BY s, i;
+	`)
+	assert.NoError(t, err)
+
+	// With so few rows, the planner may prefer to scan whole tables even though the
+	// indexes would serve each page: disable that so we measure the index-backed plan
+	_, err = tx.Exec("SET LOCAL enable_seqscan = off; SET LOCAL enable_bitmapscan = off")
+	assert.NoError(t, err)
+
+	countTuplesRead := func() int64 {
+		var n int64
+		err := tx.QueryRow(`
+			SELECT coalesce(sum(seq_tup_read), 0) + coalesce(sum(idx_tup_fetch), 0)
+			FROM pg_stat_xact_user_tables
+			WHERE schemaname = 'ledger' AND relname IN ('flow', 'watch_time_stream')
+		`).Scan(&n)
+		assert.NoError(t, err)
+		return n
+	}
+
+	// Reading a page of history should only need to read a page's worth of rows from
+	// each index, not the user's entire history: that's 220 flows in total
+	before := countTuplesRead()
+	rows, err := q.GetTransactionHistory(context.Background(), queries.GetTransactionHistoryParams{
+		TwitchUserID: "12345",
+		NumRecords:   5,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 5)
+	assert.Less(t, countTuplesRead()-before, int64(50))
+
+	// The same should hold for a page from the middle of the user's history
+	cursor := rows[len(rows)-1]
+	before = countTuplesRead()
+	rows, err = q.GetTransactionHistory(context.Background(), queries.GetTransactionHistoryParams{
+		TwitchUserID:    "12345",
+		CursorID:        uuid.NullUUID{Valid: true, UUID: cursor.ID},
+		CursorCreatedAt: sql.NullTime{Valid: true, Time: cursor.CreatedAt},
+		NumRecords:      5,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 5)
+	assert.Less(t, countTuplesRead()-before, int64(50))
 }
@@ -0,0 +1,65 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetFlowIdByIdempotencyKey(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM ledger.flow")
+
+	params := queries.RecordCheerInflowParams{
+		TwitchUserID:      "4444",
+		Message:           "hello",
+		NumPointsToCredit: 200,
+		IdempotencyKey:    sql.NullString{Valid: true, String: "event-1"},
+	}
+	flowUuid, err := q.RecordCheerInflow(context.Background(), params)
+	assert.NoError(t, err)
+
+	// Recording the same inflow again with the same idempotency key should have no
+	// effect, and should return no rows
+	_, err = q.RecordCheerInflow(context.Background(), params)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM ledger.flow")
+
+	// The original inflow should be identifiable from its type, key and user
+	replayedUuid, err := q.GetFlowIdByIdempotencyKey(context.Background(), queries.GetFlowIdByIdempotencyKeyParams{
+		Type:           "cheer",
+		IdempotencyKey: "event-1",
+		TwitchUserID:   "4444",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, flowUuid, replayedUuid)
+
+	_, err = q.GetFlowIdByIdempotencyKey(context.Background(), queries.GetFlowIdByIdempotencyKeyParams{
+		Type:           "cheer",
+		IdempotencyKey: "event-1",
+		TwitchUserID:   "5555",
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// The same key may be used for a different type of inflow, and inflows without an
+	// idempotency key are never deduplicated
+	_, err = q.RecordSubscriptionInflow(context.Background(), queries.RecordSubscriptionInflowParams{
+		TwitchUserID:      "4444",
+		NumPointsToCredit: 600,
+		CreditMultiplier:  1.0,
+		IdempotencyKey:    sql.NullString{Valid: true, String: "event-1"},
+	})
+	assert.NoError(t, err)
+	params.IdempotencyKey = sql.NullString{}
+	_, err = q.RecordCheerInflow(context.Background(), params)
+	assert.NoError(t, err)
+	_, err = q.RecordCheerInflow(context.Background(), params)
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 4, "SELECT COUNT(*) FROM ledger.flow")
+}
@@ -0,0 +1,87 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordManualDebitOutflow(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			(gen_random_uuid(), 'manual-credit', '{"note":"unit test"}'::jsonb, '4444', 500, now(), now(), true);
+	`)
+	assert.NoError(t, err)
+
+	// We should be able to take the per-user lock that serializes balance-reducing
+	// changes, and it should be reentrant within the same transaction
+	err = q.LockFlowsForUser(context.Background(), "4444")
+	assert.NoError(t, err)
+	err = q.LockFlowsForUser(context.Background(), "4444")
+	assert.NoError(t, err)
+
+	row, err := q.RecordManualDebitOutflow(context.Background(), queries.RecordManualDebitOutflowParams{
+		Note:             "Test debit",
+		TwitchUserID:     "4444",
+		NumPointsToDebit: 200,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(200), row.NumPointsDebited)
+
+	querytest.AssertCount(t, tx, 1, `
+			SELECT COUNT(*) FROM ledger.flow
+				WHERE id = $1
+				AND type = 'manual-debit'
+				AND metadata = '{"note":"Test debit","allow_negative_balance":false}'::jsonb
+				AND twitch_user_id = '4444'
+				AND delta_points = -200
+				AND created_at = now()
+				AND finalized_at = now()
+				AND accepted = true
+		`, row.ID)
+
+	// By default, a debit is clamped to the user's available balance
+	row, err = q.RecordManualDebitOutflow(context.Background(), queries.RecordManualDebitOutflowParams{
+		Note:             "Test debit",
+		TwitchUserID:     "4444",
+		NumPointsToDebit: 1000,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(300), row.NumPointsDebited)
+
+	// Once the user has no points left, a clamped debit records nothing
+	_, err = q.RecordManualDebitOutflow(context.Background(), queries.RecordManualDebitOutflowParams{
+		Note:             "Test debit",
+		TwitchUserID:     "4444",
+		NumPointsToDebit: 1000,
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// If explicitly allowed, a debit may bring the user's balance below zero
+	row, err = q.RecordManualDebitOutflow(context.Background(), queries.RecordManualDebitOutflowParams{
+		Note:                 "Test debit",
+		AllowNegativeBalance: true,
+		TwitchUserID:         "4444",
+		NumPointsToDebit:     1000,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1000), row.NumPointsDebited)
+
+	balance, err := q.GetBalance(context.Background(), "4444")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(-1000), balance.AvailablePoints)
+
+	// Other outflows should still be refused while the balance is negative
+	_, err = tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at) VALUES
+			(gen_random_uuid(), 'alert-redemption', '{"type":"foo"}'::jsonb, '4444', -1, now());
+	`)
+	assertIsAvailableBalanceViolation(t, err)
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 )
 
 // Lookup describing the total and available point balance for each user, based on the aggregate of all inflows and outflows recorded for that user.
@@ -44,6 +45,26 @@ type LedgerFlow struct {
 	AffectsTotalBalance sql.NullBool
 	// Whether this transaction should affect the user's available point balance, computed as a function of delta_points, finalized_at, and accepted.
 	AffectsAvailableBalance sql.NullBool
+	// Time at which this transaction should be automatically rejected if it is still pending, so that a caller which never finalizes an outflow can not lock up the user's available balance indefinitely. If NULL, the transaction never expires.
+	ExpiresAt sql.NullTime
+	// Optional caller-supplied key that uniquely identifies the event which caused this transaction to be recorded (e.g. the ID of the originating Twitch event). If a request to record a transaction is retried with the same key, the original transaction is returned instead of a new one being recorded.
+	IdempotencyKey sql.NullString
+	// For a reversal, the ID of the original transaction whose effect is being undone. NULL for any other type of transaction.
+	ReversedFlowID uuid.NullUUID
+	// Sequence number assigned each time this transaction is created or updated, unique across all users. Sent as the ID of each firehose event.
+	ChangeSeq int64
+	// Per-user sequence number of the most recent change to this transaction, as recorded in flow_change. 0 for a transaction that has not changed since flow_change was introduced.
+	UserChangeSeq int64
+}
+
+// Record of each change to a user's transactions, numbered contiguously for each user. Each seq value is sent as the ID of the corresponding real-time notification event, so that a client can tell when it has missed an event, and so that a client which reconnects to the notifications stream can be sent every change it missed.
+type LedgerFlowChange struct {
+	// ID of the user whose transaction was changed.
+	TwitchUserID string
+	// Sequence number of this change among all changes to the user's transactions: the first change is 1, and each subsequent change is exactly one greater than the last.
+	Seq int64
+	// ID of the transaction that was created or updated.
+	FlowID uuid.UUID
 }
 
 // Internal record of a valid type of flow (i.e. inflow or outflow) by which points can be credited to or debited from a user.
@@ -52,6 +73,62 @@ type LedgerFlowType struct {
 	Name string
 	// Developer-facing description of this flow type; including its purpose and a description of any additional metadata required for transactions of this type.
 	Comment string
+	// If true, this flow type has been registered as a type of outflow that internal APIs may create on a user's behalf via POST /outflow, without any type-specific support in the ledger service itself.
+	IsRegisteredOutflow bool
+	// JSON Schema document describing the metadata that must accompany a transaction of this type. If set, the metadata of any registered outflow created via POST /outflow is validated against this schema.
+	MetadataSchema pqtype.NullRawMessage
+	// Go text/template string used to render a user-facing description of any transaction of this type, executed with the transaction's metadata object as its data, e.g. "Redeemed alert of type '{{.type}}'". If NULL, descriptions are generated by the ledger service itself.
+	DescriptionTemplate sql.NullString
+}
+
+// Record of a policy that has been used to determine how many points to credit to users when they cheer or subscribe. Each cheer, subscription, and gift-sub inflow records the version of the policy that was applied in its metadata.policy_version field, so that the number of points credited can always be explained.
+type LedgerPointsPolicy struct {
+	// Unique identifier for this policy, derived from the values of its parameters.
+	Version string
+	// Number of points credited for each bit cheered.
+	PointsPerBit int32
+	// Number of points credited for a single month of a Tier 1 subscription, whether purchased, renewed, or received as a gift.
+	PointsPerSubscription int32
+	// Number of points credited to the purchaser of a gift sub for each Tier 1 subscription they gift.
+	PointsPerGiftSub int32
+	// Factor by which subscription and gift sub credits are scaled for Tier 2 subs.
+	Tier2Multiplier float64
+	// Factor by which subscription and gift sub credits are scaled for Tier 3 subs.
+	Tier3Multiplier float64
+	// Number of additional points credited when a user purchases an initial subscription (not including gift subs or renewals).
+	InitialSubscriptionBonus int32
+	// Time at which this policy first went into effect.
+	CreatedAt time.Time
+	// Number of points credited to a raider for each viewer who arrives with their raid. NULL for policies that went into effect before raids were credited.
+	PointsPerRaidViewer sql.NullInt32
+	// Number of points credited to a user when they first follow the channel. NULL for policies that went into effect before follows were credited.
+	PointsPerFollow sql.NullInt32
+	// Number of points credited to each contributor to a hype train for each level that the hype train reached. NULL for policies that went into effect before hype trains were credited.
+	PointsPerHypeTrainLevel sql.NullInt32
+	// Number of points credited to a viewer for each minute they spend watching a stream. NULL for policies that went into effect before watch time was credited.
+	PointsPerWatchMinute sql.NullInt32
+	// Maximum number of points that may be credited to any one user for watch time on a single day (in UTC). NULL for policies that went into effect before watch time was credited.
+	WatchTimeDailyCap sql.NullInt32
+}
+
+// Record of a time-boxed promotion scheduled by the broadcaster, during which inflows earn a multiple of the points they would ordinarily be credited, e.g. for a "Double Points Night". Each inflow to which a promotion applied records that promotion in its metadata.promotion field.
+type LedgerPromotion struct {
+	// Unique ID identifying this promotion.
+	ID uuid.UUID
+	// User-facing name of the promotion, e.g. "Double Points Night".
+	Name string
+	// Factor by which the points credited by each eligible inflow are scaled while the promotion is active.
+	Multiplier float64
+	// Types of inflow to which the promotion applies, or NULL if it applies to all inflows that support promotions.
+	FlowTypes []string
+	// Time at which the promotion becomes active.
+	StartsAt time.Time
+	// Time at which the promotion is no longer active.
+	EndsAt time.Time
+	// Time at which the promotion was scheduled.
+	CreatedAt time.Time
+	// Time at which the broadcaster canceled the promotion, if applicable. A canceled promotion no longer applies to any inflows, but inflows that were credited while it was active are unaffected.
+	CanceledAt sql.NullTime
 }
 
 // Record of a short-lived cryptographic token used to authenticate the given user, solely for the purpose of allowing them access to real-time transaction data via the /notifications SSE endpoint.
@@ -62,4 +139,84 @@ type LedgerSseToken struct {
 	Value string
 	// Time at which the token should no longer be accepted (and may be purged).
 	ExpiresAt time.Time
+	// Determines which stream the bearer of this token may subscribe to: 'user' grants access to notifications about the given user's own transactions, via /notifications; 'firehose' grants the broadcaster access to notifications about every user's transactions, via /notifications/firehose.
+	Scope string
+}
+
+// Record of a URL that should be notified whenever a transaction of the given type is created or updated, so that other services can react to ledger activity without holding open a connection to the notifications stream.
+type LedgerWebhook struct {
+	// Unique ID identifying this webhook.
+	ID uuid.UUID
+	// URL to which each event is delivered via a POST request.
+	Url string
+	// Type of transaction for which events are delivered to this webhook.
+	FlowType string
+	// Hex-encoded secret key used to compute the HMAC-SHA256 signature that accompanies each delivery, so that the recipient can verify that it was sent by the ledger.
+	Secret string
+	// Time at which the webhook was registered.
+	CreatedAt time.Time
+}
+
+// Outbox recording each event that is to be delivered to a webhook. Rows are written in the same database transaction as the change they describe, so no event can be lost, and are delivered in the background, with retries, until the recipient accepts them or we give up.
+type LedgerWebhookDelivery struct {
+	// Unique ID identifying this delivery, sent to the recipient so that it can recognize redelivered events.
+	ID uuid.UUID
+	// ID of the webhook to which this event is to be delivered.
+	WebhookID uuid.UUID
+	// ID of the transaction that was created or updated.
+	FlowID uuid.UUID
+	// State of the transaction at the time of the change, in the same format as the payload of the corresponding ledger_flow_change notification.
+	Payload json.RawMessage
+	// Time at which the change occurred.
+	CreatedAt time.Time
+	// Number of times we have attempted to deliver this event.
+	NumAttempts int32
+	// Time after which the next delivery attempt may be made. While an attempt is in progress, this is pushed into the future so that no other server will attempt the same delivery concurrently.
+	NextAttemptAt time.Time
+	// Description of the error that caused the most recent delivery attempt to fail, if any.
+	LastError sql.NullString
+	// Time at which the event was successfully delivered. If set, no further attempts will be made.
+	DeliveredAt sql.NullTime
+	// Time at which we gave up on delivering this event. If set, no further attempts will be made unless the delivery is explicitly redriven.
+	FailedAt sql.NullTime
+}
+
+// Running total of the points credited to each user for watch time on each day (in UTC), so that no user can be credited beyond the daily cap set by the points policy.
+type LedgerWatchTimeDailyTotal struct {
+	// ID of the user who was credited.
+	TwitchUserID string
+	// Day (in UTC) on which the points were credited.
+	Day time.Time
+	// Total number of points credited to the user for watch time on that day.
+	NumPoints int32
+}
+
+// Record of an interval of a stream for which watch time has been credited, so that a retried batch for the same interval is not credited twice.
+type LedgerWatchTimeInterval struct {
+	// Caller-supplied ID of the stream during which the interval occurred.
+	StreamID string
+	// Caller-supplied ID that uniquely identifies the interval within its stream.
+	IntervalID string
+	// Number of viewers who were credited with points for this interval: viewers who had already reached their daily cap are not included.
+	NumViewersCredited int32
+	// Total number of points credited to all viewers for this interval.
+	NumPointsCredited int32
+	// Time at which watch time was credited for this interval.
+	CreatedAt time.Time
+}
+
+// Summary of all the watch-time transactions recorded for each user for each stream, maintained as each transaction is recorded, so that a user's history can show a single entry per stream without aggregating every interval on each read. The transactions themselves are never modified.
+type LedgerWatchTimeStream struct {
+	// ID of the user who was credited.
+	TwitchUserID string
+	// Caller-supplied ID of the stream during which the user was credited.
+	StreamID string
+	// ID of the transaction recorded for the first interval of the stream for which the user was credited, which identifies the stream's entry in their history.
+	FlowID uuid.UUID
+	// Time at which the first interval's transaction was recorded.
+	CreatedAt time.Time
+	// Total number of minutes credited across all of the stream's transactions.
+	MinutesWatched int32
+	// Total number of points credited across all of the stream's transactions.
+	DeltaPoints int32
 }
@@ -0,0 +1,178 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordPendingOutflow(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM ledger.flow")
+
+	// Grant the user enough points to afford the redemption
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('ed6e3d35-6c6d-4b6e-9d9e-0d7f3b6c8a11', 'manual-credit', '{"note":"unit test"}'::jsonb, '4444', 500, now(), now(), true);
+	`)
+	assert.NoError(t, err)
+
+	flowUuid, err := q.RecordPendingOutflow(context.Background(), queries.RecordPendingOutflowParams{
+		Type:             "alert-redemption",
+		Metadata:         json.RawMessage(`{"bar":"baz","type":"foo"}`),
+		TwitchUserID:     "4444",
+		NumPointsToDebit: 350,
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+			SELECT COUNT(*) FROM ledger.flow
+				WHERE id = $1
+				AND type = 'alert-redemption'
+				AND metadata = '{"bar":"baz","type":"foo"}'::jsonb
+				AND twitch_user_id = '4444'
+				AND delta_points = -350
+				AND created_at = now()
+				AND expires_at IS NULL
+				AND finalized_at IS NULL
+				AND accepted = false
+		`, flowUuid)
+
+	// If an expiration time is requested, the outflow should expire that many seconds
+	// from now
+	flowUuid, err = q.RecordPendingOutflow(context.Background(), queries.RecordPendingOutflowParams{
+		Type:             "alert-redemption",
+		Metadata:         json.RawMessage(`{"type":"foo"}`),
+		TwitchUserID:     "4444",
+		NumPointsToDebit: 50,
+		ExpiresInSeconds: sql.NullInt32{Valid: true, Int32: 90},
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+			SELECT COUNT(*) FROM ledger.flow
+				WHERE id = $1
+				AND expires_at = now() + '90s'::interval
+		`, flowUuid)
+}
+
+func Test_RecordPendingOutflow_unregisteredType(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			(gen_random_uuid(), 'manual-credit', '{"note":"unit test"}'::jsonb, '4444', 500, now(), now(), true);
+	`)
+	assert.NoError(t, err)
+
+	// We should not be able to record an outflow of a type that doesn't exist, nor of a
+	// type that exists but isn't registered as an outflow type
+	for _, flowType := range []string{"not-a-real-type", "manual-credit"} {
+		_, err = q.RecordPendingOutflow(context.Background(), queries.RecordPendingOutflowParams{
+			Type:             flowType,
+			Metadata:         json.RawMessage(`{"note":"foo"}`),
+			TwitchUserID:     "4444",
+			NumPointsToDebit: 100,
+		})
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	}
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM ledger.flow")
+}
+
+func Test_RecordPendingOutflow_insufficientBalance(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			(gen_random_uuid(), 'manual-credit', '{"note":"unit test"}'::jsonb, '4444', 300, now(), now(), true);
+	`)
+	assert.NoError(t, err)
+
+	_, err = q.RecordPendingOutflow(context.Background(), queries.RecordPendingOutflowParams{
+		Type:             "alert-redemption",
+		Metadata:         json.RawMessage(`{"type":"foo"}`),
+		TwitchUserID:     "4444",
+		NumPointsToDebit: 350,
+	})
+	assertIsAvailableBalanceViolation(t, err)
+}
+
+func Test_RecordPendingOutflow_concurrent(t *testing.T) {
+	// This test needs to commit its changes so that concurrent connections can see
+	// them, so we can't use a single rolled-back transaction: use a dedicated user ID
+	// and clean up after ourselves instead
+	db := querytest.Prepare(t)
+	q := queries.New(db)
+	twitchUserId := "concurrent-redemption-test"
+	cleanup := func() {
+		if _, err := db.Exec("DELETE FROM ledger.flow WHERE twitch_user_id = $1", twitchUserId); err != nil {
+			t.Logf("failed to clean up flows for test user: %v", err)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// Grant the user enough points to afford exactly 10 redemptions
+	_, err := db.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			(gen_random_uuid(), 'manual-credit', '{"note":"unit test"}'::jsonb, $1, 1000, now(), now(), true);
+	`, twitchUserId)
+	assert.NoError(t, err)
+
+	// Fire off 50 simultaneous redemptions of 100 points each
+	const numRequests = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, numRequests)
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := q.RecordPendingOutflow(context.Background(), queries.RecordPendingOutflowParams{
+				Type:             "alert-redemption",
+				Metadata:         json.RawMessage(`{"type":"foo"}`),
+				TwitchUserID:     twitchUserId,
+				NumPointsToDebit: 100,
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Only the redemptions that the user could afford should have succeeded; all
+	// others should have been refused by the database
+	numSucceeded := 0
+	for err := range errs {
+		if err == nil {
+			numSucceeded++
+		} else {
+			assertIsAvailableBalanceViolation(t, err)
+		}
+	}
+	assert.Equal(t, 10, numSucceeded)
+
+	balance, err := q.GetBalance(context.Background(), twitchUserId)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1000), balance.TotalPoints)
+	assert.Equal(t, int32(0), balance.AvailablePoints)
+}
+
+func assertIsAvailableBalanceViolation(t *testing.T, err error) {
+	var pqErr *pq.Error
+	if assert.True(t, errors.As(err, &pqErr), "expected *pq.Error; got %v", err) {
+		assert.Equal(t, "check_violation", pqErr.Code.Name())
+		assert.Equal(t, "flow_available_balance_check", pqErr.Constraint)
+	}
+}
@@ -0,0 +1,71 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/sqlc-dev/pqtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RegisterOutflowType(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// 'alert-redemption' should be registered by default
+	row, err := q.GetOutflowType(context.Background(), "alert-redemption")
+	assert.NoError(t, err)
+	assert.Equal(t, "alert-redemption", row.Name)
+	assert.True(t, row.MetadataSchema.Valid)
+	assert.Equal(t, "Redeemed alert of type '{{.type}}'", row.DescriptionTemplate.String)
+
+	// Inflow types should not be visible as registered outflow types
+	_, err = q.GetOutflowType(context.Background(), "manual-credit")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// We should be able to register a new outflow type
+	result, err := q.RegisterOutflowType(context.Background(), queries.RegisterOutflowTypeParams{
+		Name:                "sticker-purchase",
+		Comment:             "Outflow recorded when a user buys a sticker.",
+		MetadataSchema:      pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"type":"object"}`)},
+		DescriptionTemplate: sql.NullString{Valid: true, String: "Bought a sticker"},
+	})
+	assert.NoError(t, err)
+	numRows, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+
+	// Registering it again should update its details in place, leaving any schema or
+	// template that was not supplied unchanged
+	result, err = q.RegisterOutflowType(context.Background(), queries.RegisterOutflowTypeParams{
+		Name:    "sticker-purchase",
+		Comment: "Outflow recorded when a user buys a sticker of any kind.",
+	})
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+
+	rows, err := q.ListOutflowTypes(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "alert-redemption", rows[0].Name)
+	assert.Equal(t, "sticker-purchase", rows[1].Name)
+	assert.Equal(t, "Outflow recorded when a user buys a sticker of any kind.", rows[1].Comment)
+	assert.Equal(t, `{"type": "object"}`, string(rows[1].MetadataSchema.RawMessage))
+	assert.Equal(t, "Bought a sticker", rows[1].DescriptionTemplate.String)
+
+	// We should not be able to clobber an existing type that isn't an outflow type
+	result, err = q.RegisterOutflowType(context.Background(), queries.RegisterOutflowTypeParams{
+		Name:    "manual-credit",
+		Comment: "Not an outflow.",
+	})
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), numRows)
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM ledger.flow_type WHERE name = 'manual-credit' AND is_registered_outflow")
+}
@@ -0,0 +1,39 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RegisterPointsPolicy(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	params := queries.RegisterPointsPolicyParams{
+		Version:                  "0123456789ab",
+		PointsPerBit:             1,
+		PointsPerSubscription:    600,
+		PointsPerGiftSub:         200,
+		Tier2Multiplier:          2.0,
+		Tier3Multiplier:          5.0,
+		InitialSubscriptionBonus: 0,
+		PointsPerRaidViewer:      sql.NullInt32{Int32: 10, Valid: true},
+		PointsPerFollow:          sql.NullInt32{Int32: 50, Valid: true},
+		PointsPerHypeTrainLevel:  sql.NullInt32{Int32: 100, Valid: true},
+		PointsPerWatchMinute:     sql.NullInt32{Int32: 2, Valid: true},
+		WatchTimeDailyCap:        sql.NullInt32{Int32: 480, Valid: true},
+	}
+	err := q.RegisterPointsPolicy(context.Background(), params)
+	assert.NoError(t, err)
+
+	// Registering the same policy again, as we do each time the server starts, should
+	// have no effect
+	err = q.RegisterPointsPolicy(context.Background(), params)
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM ledger.points_policy")
+}
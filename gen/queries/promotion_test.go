@@ -0,0 +1,89 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Promotions(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// With no promotions scheduled, no promotion should be active
+	_, err := q.GetActivePromotion(context.Background(), "cheer")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Schedule a promotion that applies to all inflows, one that applies only to
+	// subscriptions, and one that hasn't started yet
+	now := time.Now()
+	doublePoints, err := q.SchedulePromotion(context.Background(), queries.SchedulePromotionParams{
+		Name:       "Double Points Night",
+		Multiplier: 2.0,
+		StartsAt:   now.Add(-time.Hour),
+		EndsAt:     now.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+	subBonanza, err := q.SchedulePromotion(context.Background(), queries.SchedulePromotionParams{
+		Name:       "Sub Bonanza",
+		Multiplier: 3.0,
+		FlowTypes:  []string{"subscription"},
+		StartsAt:   now.Add(-time.Hour),
+		EndsAt:     now.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+	_, err = q.SchedulePromotion(context.Background(), queries.SchedulePromotionParams{
+		Name:       "Tomorrow's Promotion",
+		Multiplier: 10.0,
+		StartsAt:   now.Add(23 * time.Hour),
+		EndsAt:     now.Add(25 * time.Hour),
+	})
+	assert.NoError(t, err)
+
+	// The most generous active promotion that applies to each flow type should win
+	promotion, err := q.GetActivePromotion(context.Background(), "cheer")
+	assert.NoError(t, err)
+	assert.Equal(t, doublePoints.ID, promotion.ID)
+	promotion, err = q.GetActivePromotion(context.Background(), "subscription")
+	assert.NoError(t, err)
+	assert.Equal(t, subBonanza.ID, promotion.ID)
+	assert.Equal(t, "Sub Bonanza", promotion.Name)
+	assert.Equal(t, 3.0, promotion.Multiplier)
+
+	promotions, err := q.ListPromotions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, promotions, 3)
+
+	// Once canceled, a promotion should no longer apply, and it can't be canceled twice
+	result, err := q.CancelPromotion(context.Background(), subBonanza.ID)
+	assert.NoError(t, err)
+	numRows, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+	result, err = q.CancelPromotion(context.Background(), subBonanza.ID)
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), numRows)
+
+	promotion, err = q.GetActivePromotion(context.Background(), "subscription")
+	assert.NoError(t, err)
+	assert.Equal(t, doublePoints.ID, promotion.ID)
+	promotions, err = q.ListPromotions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, promotions, 2)
+
+	// A promotion that ends before it starts should be rejected
+	_, err = q.SchedulePromotion(context.Background(), queries.SchedulePromotionParams{
+		Name:       "Backwards Promotion",
+		Multiplier: 2.0,
+		StartsAt:   now.Add(time.Hour),
+		EndsAt:     now.Add(-time.Hour),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package queries_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordReversal(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('0b8b1f3e-3c1e-4a9a-8d7e-2f4c6b8a0d11', 'manual-credit', '{"note":"unit test"}'::jsonb, '4444', 1000, now(), now(), true),
+			('5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21', 'alert-redemption', '{"type":"ghost"}'::jsonb, '4444', -250, now(), now(), true),
+			('b6a1a7f6-0f2d-4c9e-8a3e-7b7d2c5e1f90', 'alert-redemption', '{"type":"ghost"}'::jsonb, '4444', -250, now(), NULL, false);
+	`)
+	assert.NoError(t, err)
+	alertId := uuid.MustParse("5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21")
+
+	// Partially refund the alert redemption
+	reversalId, err := q.RecordReversal(context.Background(), queries.RecordReversalParams{
+		Note:      "alert failed on stream",
+		NumPoints: 100,
+		FlowID:    alertId,
+	})
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 1, `
+			SELECT COUNT(*) FROM ledger.flow
+				WHERE id = $1
+				AND type = 'reversal'
+				AND metadata = '{"note":"alert failed on stream","reversed_type":"alert-redemption","reversed_metadata":{"type":"ghost"}}'::jsonb
+				AND twitch_user_id = '4444'
+				AND delta_points = 100
+				AND finalized_at = now()
+				AND accepted = true
+				AND reversed_flow_id = $2
+		`, reversalId, alertId)
+
+	// The original transaction should be untouched
+	querytest.AssertCount(t, tx, 1, `
+			SELECT COUNT(*) FROM ledger.flow
+				WHERE id = $1
+				AND delta_points = -250
+				AND accepted = true
+				AND reversed_flow_id IS NULL
+		`, alertId)
+
+	row, err := q.GetFlowForReversal(context.Background(), alertId)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(-250), row.DeltaPoints)
+	assert.Equal(t, int32(100), row.ReversedDeltaPoints)
+
+	balance, err := q.GetBalance(context.Background(), "4444")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(850), balance.TotalPoints)
+
+	// We should not be able to reverse more than the remaining 150 points
+	_, err = q.RecordReversal(context.Background(), queries.RecordReversalParams{
+		Note:      "too much",
+		NumPoints: 151,
+		FlowID:    alertId,
+	})
+	assertIsReversalAmountViolation(t, err)
+}
+
+func Test_RecordReversal_notAccepted(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('0b8b1f3e-3c1e-4a9a-8d7e-2f4c6b8a0d11', 'manual-credit', '{"note":"unit test"}'::jsonb, '4444', 1000, now(), now(), true),
+			('b6a1a7f6-0f2d-4c9e-8a3e-7b7d2c5e1f90', 'alert-redemption', '{"type":"ghost"}'::jsonb, '4444', -250, now(), NULL, false);
+	`)
+	assert.NoError(t, err)
+
+	_, err = q.RecordReversal(context.Background(), queries.RecordReversalParams{
+		Note:      "still pending",
+		NumPoints: 250,
+		FlowID:    uuid.MustParse("b6a1a7f6-0f2d-4c9e-8a3e-7b7d2c5e1f90"),
+	})
+	assertIsReversalAmountViolation(t, err)
+}
+
+func Test_RecordReversal_spentPoints(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// If the user has already spent the points credited to them, we should not be able
+	// to take them back
+	_, err := tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('0b8b1f3e-3c1e-4a9a-8d7e-2f4c6b8a0d11', 'cheer', '{"message":""}'::jsonb, '4444', 500, now(), now(), true),
+			('5d9e6a1f-3f0b-4a88-9d55-0e4a2f7c9b21', 'alert-redemption', '{"type":"ghost"}'::jsonb, '4444', -400, now(), now(), true);
+	`)
+	assert.NoError(t, err)
+
+	_, err = q.RecordReversal(context.Background(), queries.RecordReversalParams{
+		Note:      "cheer was refunded",
+		NumPoints: 500,
+		FlowID:    uuid.MustParse("0b8b1f3e-3c1e-4a9a-8d7e-2f4c6b8a0d11"),
+	})
+	assertIsAvailableBalanceViolation(t, err)
+}
+
+func assertIsReversalAmountViolation(t *testing.T, err error) {
+	var pqErr *pq.Error
+	if assert.True(t, errors.As(err, &pqErr), "expected *pq.Error; got %v", err) {
+		assert.Equal(t, "check_violation", pqErr.Code.Name())
+		assert.Equal(t, "flow_reversal_amount_check", pqErr.Constraint)
+	}
+}
@@ -7,24 +7,60 @@ package queries
 
 import (
 	"context"
+	"database/sql"
 )
 
+const consumeSseToken = `-- name: ConsumeSseToken :one
+delete from ledger.sse_token
+where sse_token.value = $1
+    and sse_token.scope = $2
+    and sse_token.expires_at > now()
+returning sse_token.twitch_user_id
+`
+
+type ConsumeSseTokenParams struct {
+	TokenValue string
+	Scope      string
+}
+
+func (q *Queries) ConsumeSseToken(ctx context.Context, arg ConsumeSseTokenParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, consumeSseToken, arg.TokenValue, arg.Scope)
+	var twitch_user_id string
+	err := row.Scan(&twitch_user_id)
+	return twitch_user_id, err
+}
+
 const identifyUserFromSseToken = `-- name: IdentifyUserFromSseToken :one
 select
     sse_token.twitch_user_id
 from ledger.sse_token
 where sse_token.value = $1
+    and sse_token.scope = $2
     and sse_token.expires_at > now()
 limit 1
 `
 
-func (q *Queries) IdentifyUserFromSseToken(ctx context.Context, tokenValue string) (string, error) {
-	row := q.db.QueryRowContext(ctx, identifyUserFromSseToken, tokenValue)
+type IdentifyUserFromSseTokenParams struct {
+	TokenValue string
+	Scope      string
+}
+
+func (q *Queries) IdentifyUserFromSseToken(ctx context.Context, arg IdentifyUserFromSseTokenParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, identifyUserFromSseToken, arg.TokenValue, arg.Scope)
 	var twitch_user_id string
 	err := row.Scan(&twitch_user_id)
 	return twitch_user_id, err
 }
 
+const purgeExpiredSseTokens = `-- name: PurgeExpiredSseTokens :execresult
+delete from ledger.sse_token
+where sse_token.expires_at <= now()
+`
+
+func (q *Queries) PurgeExpiredSseTokens(ctx context.Context) (sql.Result, error) {
+	return q.db.ExecContext(ctx, purgeExpiredSseTokens)
+}
+
 const purgeSseTokensForUser = `-- name: PurgeSseTokensForUser :exec
 delete from ledger.sse_token
 where sse_token.twitch_user_id = $1
@@ -36,15 +72,26 @@ func (q *Queries) PurgeSseTokensForUser(ctx context.Context, twitchUserID string
 	return err
 }
 
+const revokeSseTokensForUser = `-- name: RevokeSseTokensForUser :execresult
+delete from ledger.sse_token
+where sse_token.twitch_user_id = $1
+`
+
+func (q *Queries) RevokeSseTokensForUser(ctx context.Context, twitchUserID string) (sql.Result, error) {
+	return q.db.ExecContext(ctx, revokeSseTokensForUser, twitchUserID)
+}
+
 const storeSseToken = `-- name: StoreSseToken :exec
 insert into ledger.sse_token (
     twitch_user_id,
     value,
-    expires_at
+    expires_at,
+    scope
 ) values (
     $1,
     $2,
-    now() + (($3::int)::text || 's')::interval
+    now() + (($3::int)::text || 's')::interval,
+    $4
 )
 `
 
@@ -52,9 +99,15 @@ type StoreSseTokenParams struct {
 	TwitchUserID string
 	TokenValue   string
 	TtlSeconds   int32
+	Scope        string
 }
 
 func (q *Queries) StoreSseToken(ctx context.Context, arg StoreSseTokenParams) error {
-	_, err := q.db.ExecContext(ctx, storeSseToken, arg.TwitchUserID, arg.TokenValue, arg.TtlSeconds)
+	_, err := q.db.ExecContext(ctx, storeSseToken,
+		arg.TwitchUserID,
+		arg.TokenValue,
+		arg.TtlSeconds,
+		arg.Scope,
+	)
 	return err
 }
@@ -0,0 +1,76 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SseTokens(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// Store a live token for each of two users, plus an expired token for one of them
+	for _, token := range []queries.StoreSseTokenParams{
+		{TwitchUserID: "1001", TokenValue: "token-a", TtlSeconds: 600, Scope: "user"},
+		{TwitchUserID: "1001", TokenValue: "token-b", TtlSeconds: -60, Scope: "user"},
+		{TwitchUserID: "1002", TokenValue: "token-c", TtlSeconds: 600, Scope: "user"},
+	} {
+		err := q.StoreSseToken(context.Background(), token)
+		assert.NoError(t, err)
+	}
+
+	// Only live tokens should identify their users, and only in the requested scope
+	twitchUserId, err := q.IdentifyUserFromSseToken(context.Background(), queries.IdentifyUserFromSseTokenParams{
+		TokenValue: "token-a",
+		Scope:      "user",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", twitchUserId)
+	_, err = q.IdentifyUserFromSseToken(context.Background(), queries.IdentifyUserFromSseTokenParams{
+		TokenValue: "token-a",
+		Scope:      "firehose",
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = q.IdentifyUserFromSseToken(context.Background(), queries.IdentifyUserFromSseTokenParams{
+		TokenValue: "token-b",
+		Scope:      "user",
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Consuming a token should identify its user exactly once
+	twitchUserId, err = q.ConsumeSseToken(context.Background(), queries.ConsumeSseTokenParams{
+		TokenValue: "token-c",
+		Scope:      "user",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1002", twitchUserId)
+	_, err = q.ConsumeSseToken(context.Background(), queries.ConsumeSseTokenParams{
+		TokenValue: "token-c",
+		Scope:      "user",
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Purging expired tokens should leave only the live token in place
+	result, err := q.PurgeExpiredSseTokens(context.Background())
+	assert.NoError(t, err)
+	numRows, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+
+	// Revoking a user's tokens should delete all of them
+	result, err = q.RevokeSseTokensForUser(context.Background(), "1001")
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+	_, err = q.IdentifyUserFromSseToken(context.Background(), queries.IdentifyUserFromSseTokenParams{
+		TokenValue: "token-a",
+		Scope:      "user",
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -7,8 +7,10 @@ package queries
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 )
 
 const recordSubscriptionInflow = `-- name: RecordSubscriptionInflow :one
@@ -20,7 +22,8 @@ insert into ledger.flow (
     delta_points,
     created_at,
     finalized_at,
-    accepted
+    accepted,
+    idempotency_key
 ) values (
     gen_random_uuid(),
     'subscription',
@@ -28,14 +31,19 @@ insert into ledger.flow (
         'message', $1::text,
         'is_initial', $2::boolean,
         'is_gift', $3::boolean,
-        'credit_multiplier', $4::float
+        'tier', $4::text,
+        'credit_multiplier', $5::float,
+        'policy_version', $6::text,
+        'promotion', $7::jsonb
     ),
-    $5,
-    $6,
+    $8,
+    $9,
     now(),
     now(),
-    true
+    true,
+    $10::text
 )
+on conflict (type, idempotency_key) where idempotency_key is not null do nothing
 returning flow.id
 `
 
@@ -43,9 +51,13 @@ type RecordSubscriptionInflowParams struct {
 	Message           string
 	IsInitial         bool
 	IsGift            bool
+	Tier              string
 	CreditMultiplier  float64
+	PolicyVersion     string
+	Promotion         pqtype.NullRawMessage
 	TwitchUserID      string
 	NumPointsToCredit int32
+	IdempotencyKey    sql.NullString
 }
 
 func (q *Queries) RecordSubscriptionInflow(ctx context.Context, arg RecordSubscriptionInflowParams) (uuid.UUID, error) {
@@ -53,9 +65,13 @@ func (q *Queries) RecordSubscriptionInflow(ctx context.Context, arg RecordSubscr
 		arg.Message,
 		arg.IsInitial,
 		arg.IsGift,
+		arg.Tier,
 		arg.CreditMultiplier,
+		arg.PolicyVersion,
+		arg.Promotion,
 		arg.TwitchUserID,
 		arg.NumPointsToCredit,
+		arg.IdempotencyKey,
 	)
 	var id uuid.UUID
 	err := row.Scan(&id)
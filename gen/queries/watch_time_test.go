@@ -0,0 +1,121 @@
+package queries_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WatchTimeInterval(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// The first attempt to record an interval should claim it
+	result, err := q.RecordWatchTimeInterval(context.Background(), queries.RecordWatchTimeIntervalParams{
+		StreamID:   "stream-1",
+		IntervalID: "interval-1",
+	})
+	assert.NoError(t, err)
+	numRows, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+	err = q.FinishWatchTimeInterval(context.Background(), queries.FinishWatchTimeIntervalParams{
+		NumViewersCredited: 2,
+		NumPointsCredited:  16,
+		StreamID:           "stream-1",
+		IntervalID:         "interval-1",
+	})
+	assert.NoError(t, err)
+
+	// Recording the same interval again should have no effect, and the outcome of the
+	// original batch should be available
+	result, err = q.RecordWatchTimeInterval(context.Background(), queries.RecordWatchTimeIntervalParams{
+		StreamID:   "stream-1",
+		IntervalID: "interval-1",
+	})
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), numRows)
+	row, err := q.GetWatchTimeInterval(context.Background(), queries.GetWatchTimeIntervalParams{
+		StreamID:   "stream-1",
+		IntervalID: "interval-1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, queries.GetWatchTimeIntervalRow{NumViewersCredited: 2, NumPointsCredited: 16}, row)
+
+	// The same interval ID may be used for a different stream
+	result, err = q.RecordWatchTimeInterval(context.Background(), queries.RecordWatchTimeIntervalParams{
+		StreamID:   "stream-2",
+		IntervalID: "interval-1",
+	})
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+}
+
+func Test_WatchTimeDailyTotal(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// A user who has not yet been credited today should have a total of zero
+	numPoints, err := q.LockWatchTimeDailyTotal(context.Background(), "4444")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), numPoints)
+
+	// Increments should accumulate
+	for _, n := range []int32{10, 25} {
+		err = q.IncrementWatchTimeDailyTotal(context.Background(), queries.IncrementWatchTimeDailyTotalParams{
+			NumPoints:    n,
+			TwitchUserID: "4444",
+		})
+		assert.NoError(t, err)
+	}
+	numPoints, err = q.LockWatchTimeDailyTotal(context.Background(), "4444")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(35), numPoints)
+}
+
+func Test_RecordWatchTimeInflow(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// Each interval should record a new, finalized inflow
+	flowUuid, err := q.RecordWatchTimeInflow(context.Background(), queries.RecordWatchTimeInflowParams{
+		StreamID:          "stream-1",
+		IntervalID:        "interval-1",
+		MinutesWatched:    5,
+		PolicyVersion:     "0123456789ab",
+		TwitchUserID:      "4444",
+		NumPointsToCredit: 10,
+	})
+	assert.NoError(t, err)
+	nextFlowUuid, err := q.RecordWatchTimeInflow(context.Background(), queries.RecordWatchTimeInflowParams{
+		StreamID:          "stream-1",
+		IntervalID:        "interval-2",
+		MinutesWatched:    3,
+		PolicyVersion:     "0123456789ab",
+		TwitchUserID:      "4444",
+		NumPointsToCredit: 6,
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, flowUuid, nextFlowUuid)
+	querytest.AssertCount(t, tx, 2, "SELECT COUNT(*) FROM ledger.flow WHERE type = 'watch-time'")
+
+	// Recording a later interval should not modify the inflow for an earlier one
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM ledger.flow
+		WHERE id = $1
+			AND delta_points = 10
+			AND finalized_at IS NOT NULL
+			AND accepted
+			AND metadata->>'stream_id' = 'stream-1'
+			AND metadata->>'interval_id' = 'interval-1'
+			AND (metadata->>'minutes_watched')::integer = 5
+	`, flowUuid)
+	querytest.AssertCount(t, tx, 16, "SELECT total_points FROM ledger.balance WHERE twitch_user_id = '4444'")
+}
@@ -0,0 +1,162 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WebhookDeliveries(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// Register a webhook for alert redemptions
+	webhook, err := q.RegisterWebhook(context.Background(), queries.RegisterWebhookParams{
+		Url:      "https://alerts.example.com/ledger",
+		FlowType: "alert-redemption",
+		Secret:   "mock-secret",
+	})
+	assert.NoError(t, err)
+	webhooks, err := q.ListWebhooks(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, webhooks, 1)
+	assert.Equal(t, webhook.ID, webhooks[0].ID)
+
+	// Recording an alert redemption should enqueue a delivery, but recording a
+	// transaction of any other type should not
+	_, err = tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('03270514-a9e8-4c6c-97e2-78fa9d72ab8c', 'manual-credit', '{"note":"test1"}'::jsonb, '12345', 111, now(), now(), true),
+			('dd9348ef-7277-47aa-9d40-bd67a5909a07', 'alert-redemption', '{"type":"test"}'::jsonb, '12345', -50, now(), NULL, false);
+	`)
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM ledger.webhook_delivery")
+
+	// Claiming deliveries should return the pending delivery, along with the details of
+	// its webhook, and hide it from subsequent claims until its lease expires
+	rows, err := q.ClaimWebhookDeliveries(context.Background(), queries.ClaimWebhookDeliveriesParams{
+		LeaseSeconds: 60,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "https://alerts.example.com/ledger", rows[0].Url)
+	assert.Equal(t, "mock-secret", rows[0].Secret)
+	var payload struct {
+		Id          uuid.UUID `json:"id"`
+		DeltaPoints int       `json:"delta_points"`
+	}
+	assert.NoError(t, json.Unmarshal(rows[0].Payload, &payload))
+	assert.Equal(t, uuid.MustParse("dd9348ef-7277-47aa-9d40-bd67a5909a07"), payload.Id)
+	assert.Equal(t, -50, payload.DeltaPoints)
+	deliveryId := rows[0].ID
+
+	rows, err = q.ClaimWebhookDeliveries(context.Background(), queries.ClaimWebhookDeliveriesParams{
+		LeaseSeconds: 60,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 0)
+
+	// Giving up on a delivery should cause it to be listed as failed, and redriving it
+	// should make it immediately available to be claimed again
+	err = q.RecordWebhookDeliveryFailure(context.Background(), queries.RecordWebhookDeliveryFailureParams{
+		LastError:         sql.NullString{Valid: true, String: "got response 500"},
+		RetryDelaySeconds: 30,
+		GiveUp:            true,
+		DeliveryID:        deliveryId,
+	})
+	assert.NoError(t, err)
+	failed, err := q.ListFailedWebhookDeliveries(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, deliveryId, failed[0].ID)
+	assert.Equal(t, "alert-redemption", failed[0].FlowType)
+	assert.Equal(t, int32(1), failed[0].NumAttempts)
+	assert.Equal(t, "got response 500", failed[0].LastError.String)
+
+	result, err := q.RedriveWebhookDelivery(context.Background(), deliveryId)
+	assert.NoError(t, err)
+	numRows, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+	rows, err = q.ClaimWebhookDeliveries(context.Background(), queries.ClaimWebhookDeliveriesParams{
+		LeaseSeconds: 60,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, int32(0), rows[0].NumAttempts)
+
+	// Once delivered, the delivery should never be claimed again, and a delivery that
+	// hasn't failed can't be redriven
+	err = q.RecordWebhookDeliverySuccess(context.Background(), deliveryId)
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM ledger.webhook_delivery WHERE delivered_at IS NOT NULL")
+	result, err = q.RedriveWebhookDelivery(context.Background(), deliveryId)
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), numRows)
+
+	// Deleting the webhook should delete its deliveries as well
+	result, err = q.DeleteWebhook(context.Background(), webhook.ID)
+	assert.NoError(t, err)
+	numRows, err = result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), numRows)
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM ledger.webhook_delivery")
+}
+
+func Test_WebhookDeliveries_watchTime(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := q.RegisterWebhook(context.Background(), queries.RegisterWebhookParams{
+		Url:      "https://overlay.example.com/ledger",
+		FlowType: "watch-time",
+		Secret:   "mock-secret",
+	})
+	assert.NoError(t, err)
+
+	// Each interval is recorded as a separate transaction, but each delivery should
+	// describe it as an update to the stream's entry in the user's history (as do the
+	// notifications emitted by the database), showing the totals credited so far
+	_, err = tx.Exec(`
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a01', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-1","minutes_watched":5,"policy_version":"0123456789ab"}'::jsonb, '12345', 10, now() - '1h'::interval, now() - '1h'::interval, true);
+		INSERT INTO ledger.flow (id, type, metadata, twitch_user_id, delta_points, created_at, finalized_at, accepted) VALUES
+			('7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a02', 'watch-time', '{"stream_id":"stream-1","interval_id":"interval-2","minutes_watched":3,"policy_version":"0123456789ab"}'::jsonb, '12345', 6, now() - '30m'::interval, now() - '30m'::interval, true);
+	`)
+	assert.NoError(t, err)
+
+	rows, err := q.ClaimWebhookDeliveries(context.Background(), queries.ClaimWebhookDeliveriesParams{
+		LeaseSeconds: 60,
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	type watchTimePayload struct {
+		Id          uuid.UUID       `json:"id"`
+		DeltaPoints int             `json:"delta_points"`
+		Metadata    json.RawMessage `json:"metadata"`
+	}
+	var first, second watchTimePayload
+	assert.NoError(t, json.Unmarshal(rows[0].Payload, &first))
+	assert.NoError(t, json.Unmarshal(rows[1].Payload, &second))
+	if first.DeltaPoints > second.DeltaPoints {
+		first, second = second, first
+	}
+	assert.Equal(t, uuid.MustParse("7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a01"), first.Id)
+	assert.Equal(t, 10, first.DeltaPoints)
+	assert.JSONEq(t, `{"stream_id":"stream-1","minutes_watched":5,"policy_version":"0123456789ab"}`, string(first.Metadata))
+	assert.Equal(t, uuid.MustParse("7a1e2f0c-3c5d-4b59-9a4e-6f0d1b2c3a01"), second.Id)
+	assert.Equal(t, 16, second.DeltaPoints)
+	assert.JSONEq(t, `{"stream_id":"stream-1","minutes_watched":8,"policy_version":"0123456789ab"}`, string(second.Metadata))
+}
@@ -0,0 +1,121 @@
+package ledger
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// HistoryOptions describes which page of a user's transaction history to fetch, and
+// how that history should be filtered. The zero value requests the first page of
+// unfiltered history, using the server's default page size.
+type HistoryOptions struct {
+	// Max is the maximum number of transactions to return per page, if positive
+	Max int
+	// From is a cursor from the NextCursor or PrevCursor value of a previous page
+	From string
+	// Types restricts results to transactions of any of the given types, if set
+	Types []TransactionType
+	// State restricts results to transactions in the given state, if set
+	State TransactionState
+	// Direction restricts results to inflows or outflows, if set
+	Direction TransactionDirection
+	// Since restricts results to transactions created at or after this time, if set
+	Since time.Time
+	// Until restricts results to transactions created before this time, if set
+	Until time.Time
+}
+
+// query encodes the options as the URL query parameters accepted by GET /history
+func (o *HistoryOptions) query() url.Values {
+	values := url.Values{}
+	if o.Max > 0 {
+		values.Set("max", strconv.Itoa(o.Max))
+	}
+	if o.From != "" {
+		values.Set("from", o.From)
+	}
+	for _, t := range o.Types {
+		values.Add("type", string(t))
+	}
+	if o.State != "" {
+		values.Set("state", string(o.State))
+	}
+	if o.Direction != "" {
+		values.Set("direction", string(o.Direction))
+	}
+	if !o.Since.IsZero() {
+		values.Set("since", o.Since.Format(time.RFC3339Nano))
+	}
+	if !o.Until.IsZero() {
+		values.Set("until", o.Until.Format(time.RFC3339Nano))
+	}
+	return values
+}
+
+// HistoryPageFunc fetches a single page of transaction history
+type HistoryPageFunc func(ctx context.Context, options HistoryOptions) (TransactionHistory, error)
+
+// HistoryIterator pages through a user's transaction history, from the most recent
+// transaction to the oldest, following NextCursor from each page to the next:
+//
+//	it := client.IterateHistory(accessToken, ledger.HistoryOptions{})
+//	for it.Next(ctx) {
+//		for _, transaction := range it.Page().Items {
+//			...
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type HistoryIterator struct {
+	fetch   HistoryPageFunc
+	options HistoryOptions
+	page    TransactionHistory
+	started bool
+	err     error
+}
+
+// NewHistoryIterator returns a HistoryIterator that uses the given function to fetch
+// each page, starting from the page identified by options
+func NewHistoryIterator(fetch HistoryPageFunc, options HistoryOptions) *HistoryIterator {
+	return &HistoryIterator{
+		fetch:   fetch,
+		options: options,
+	}
+}
+
+// Next fetches the next page of history, returning false once there are no more pages
+// or if an error occurs, in which case Err will return that error
+func (it *HistoryIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	options := it.options
+	if it.started {
+		if it.page.NextCursor == "" {
+			return false
+		}
+		options.From = it.page.NextCursor
+	}
+
+	page, err := it.fetch(ctx, options)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.started = true
+	it.page = page
+	return len(page.Items) > 0
+}
+
+// Page returns the page of history most recently fetched by Next
+func (it *HistoryIterator) Page() TransactionHistory {
+	return it.page
+}
+
+// Err returns the error, if any, that caused Next to return false
+func (it *HistoryIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// reversalAmountConstraint is the name reported by the database when a reversal is
+// refused because its original transaction can not be reversed by the given amount
+const reversalAmountConstraint = "flow_reversal_amount_check"
+
+// isReversalAmountError returns true if the given error was raised by the database in
+// response to a reversal that would undo more than the original transaction's amount
+func isReversalAmountError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Name() == "check_violation" && pqErr.Constraint == reversalAmountConstraint
+	}
+	return false
+}
@@ -2,25 +2,32 @@ package admin
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 type Server struct {
 	q                   Queries
+	runInTx             RunInTxFunc
 	resolveTwitchUserId ResolveTwitchUserIdFunc
 }
 
-func NewServer(q Queries, twitchClientId string, twitchClientSecret string) *Server {
+func NewServer(q Queries, runInTx RunInTxFunc, resolveTwitchUserId ResolveTwitchUserIdFunc) *Server {
 	return &Server{
 		q:                   q,
-		resolveTwitchUserId: makeResolveTwitchUserIdFunc(twitchClientId, twitchClientSecret),
+		runInTx:             runInTx,
+		resolveTwitchUserId: resolveTwitchUserId,
 	}
 }
 
@@ -30,47 +37,57 @@ func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
 			http.HandlerFunc(s.handlePostManualCredit),
 		),
 	)
+	r.Path("/outflow/manual-debit").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostManualDebit),
+		),
+	)
+	r.Path("/admin/flow/{id}/reverse").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostReversal),
+		),
+	)
 }
 
 func (s *Server) handlePostManualCredit(res http.ResponseWriter, req *http.Request) {
 	// The request's Content-Type must indicate JSON if set
 	contentType := req.Header.Get("content-type")
 	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
-		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
 		return
 	}
 
 	// Parse the payload from the request body
 	var payload ManualCreditRequest
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
-		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
 		return
 	}
 	hasDisplayName := payload.TwitchDisplayName != ""
 	hasUserId := payload.TwitchUserId != ""
 	if hasDisplayName == hasUserId {
-		http.Error(res, "invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required")
 		return
 	}
 	if payload.NumPointsToCredit <= 0 {
-		http.Error(res, "invalid request payload: 'numPointsToCredit' must be set to a positive integer", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'numPointsToCredit' must be set to a positive integer")
 		return
 	}
 	if payload.Note == "" {
-		http.Error(res, "invalid request payload: 'note' must be set to a non-empty string", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'note' must be set to a non-empty string")
 		return
 	}
 
 	// If the caller supplied a username instead of a user ID, resolve the corresponding
 	// user ID using the Twitch API
-	twitchUserId := payload.TwitchUserId
-	if twitchUserId == "" {
-		resolved, err := s.resolveTwitchUserId(req.Context(), payload.TwitchDisplayName)
-		if err != nil {
-			http.Error(res, fmt.Sprintf("failed to resolve twitch user ID from username: %v", err), http.StatusInternalServerError)
-			return
-		}
-		twitchUserId = resolved
+	twitchUserId, err := s.resolveTargetUserId(req.Context(), payload.TwitchUserId, payload.TwitchDisplayName)
+	if errors.Is(err, ErrTwitchUserNotFound) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
 	}
 
 	// Create a finalized flow record representing the inflow transaction that credits
@@ -81,13 +98,207 @@ func (s *Server) handlePostManualCredit(res http.ResponseWriter, req *http.Reque
 		NumPointsToCredit: int32(payload.NumPointsToCredit),
 	})
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
 	// Return a JSON-serialized TransactionResult struct to the user
 	result := &TransactionResult{FlowId: flowId}
 	if err := json.NewEncoder(res).Encode(result); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handlePostManualDebit(res http.ResponseWriter, req *http.Request) {
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ManualDebitRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	hasDisplayName := payload.TwitchDisplayName != ""
+	hasUserId := payload.TwitchUserId != ""
+	if hasDisplayName == hasUserId {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required")
+		return
+	}
+	if payload.NumPointsToDebit <= 0 {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'numPointsToDebit' must be set to a positive integer")
+		return
+	}
+	if payload.Note == "" {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'note' must be set to a non-empty string")
+		return
+	}
+
+	// If the caller supplied a username instead of a user ID, resolve the corresponding
+	// user ID using the Twitch API
+	twitchUserId, err := s.resolveTargetUserId(req.Context(), payload.TwitchUserId, payload.TwitchDisplayName)
+	if errors.Is(err, ErrTwitchUserNotFound) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Create a finalized flow record representing the outflow transaction that debits
+	// points from the target user: unless the caller has explicitly allowed the user's
+	// balance to go negative, the debit is clamped to their available balance. We take
+	// the same per-user lock as our available-balance guard before reading that
+	// balance, so that a concurrent outflow can't invalidate the clamped amount.
+	var row queries.RecordManualDebitOutflowRow
+	err = s.runInTx(req.Context(), func(q Queries) error {
+		if err := q.LockFlowsForUser(req.Context(), twitchUserId); err != nil {
+			return err
+		}
+		result, err := q.RecordManualDebitOutflow(req.Context(), queries.RecordManualDebitOutflowParams{
+			Note:                 payload.Note,
+			AllowNegativeBalance: payload.AllowNegativeBalance,
+			TwitchUserID:         twitchUserId,
+			NumPointsToDebit:     int32(payload.NumPointsToDebit),
+		})
+		row = result
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		util.Error(res, ledger.ErrorCodeNotEnoughPoints, "user has no points available to debit")
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Return a JSON-serialized ManualDebitResult struct to the user, indicating how many
+	// points were actually debited
+	result := &ManualDebitResult{
+		FlowId:           row.ID,
+		NumPointsDebited: int(row.NumPointsDebited),
+	}
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handlePostReversal(res http.ResponseWriter, req *http.Request) {
+	// Parse the ID of the transaction to be reversed from the URL
+	flowId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid flow ID")
+		return
+	}
+
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ReversalRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if payload.NumPoints < 0 {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'numPoints' must be a positive integer if set")
+		return
+	}
+	if payload.Note == "" {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'note' must be set to a non-empty string")
+		return
+	}
+
+	// Look up the original transaction, and make sure that it can be reversed: only an
+	// accepted transaction has any effect to undo
+	original, err := s.q.GetFlowForReversal(req.Context(), flowId)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.Error(res, ledger.ErrorCodeFlowNotFound, "no such transaction")
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	if original.Type == string(ledger.TransactionTypeReversal) {
+		util.Error(res, ledger.ErrorCodeReversalNotAllowed, "a reversal can not itself be reversed")
+		return
+	}
+	if !original.FinalizedAt.Valid || !original.Accepted {
+		util.Error(res, ledger.ErrorCodeReversalNotAllowed, "only an accepted transaction can be reversed")
+		return
+	}
+
+	// Reverse the full remaining amount unless the caller requested a partial reversal,
+	// and don't allow the total of all reversals to exceed the original amount
+	numPointsRemaining := abs(int(original.DeltaPoints)) - abs(int(original.ReversedDeltaPoints))
+	if numPointsRemaining <= 0 {
+		util.Error(res, ledger.ErrorCodeReversalNotAllowed, "transaction has already been fully reversed")
+		return
+	}
+	numPoints := payload.NumPoints
+	if numPoints == 0 {
+		numPoints = numPointsRemaining
+	} else if numPoints > numPointsRemaining {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: 'numPoints' may not exceed the %d points that remain to be reversed", numPointsRemaining))
+		return
+	}
+
+	// Record a new, finalized reversal that compensates for the original transaction,
+	// leaving the original untouched: the database will refuse to record the reversal
+	// if a concurrent reversal has already undone the same points, or if reversing an
+	// inflow would take back points that the user has already spent
+	reversalId, err := s.q.RecordReversal(req.Context(), queries.RecordReversalParams{
+		Note:      payload.Note,
+		NumPoints: int32(numPoints),
+		FlowID:    flowId,
+	})
+	if isReversalAmountError(err) {
+		util.Error(res, ledger.ErrorCodeReversalNotAllowed, "transaction can not be reversed by the requested amount")
+		return
+	}
+	if util.IsInsufficientBalanceError(err) {
+		util.Error(res, ledger.ErrorCodeNotEnoughPoints, "user does not have enough points available to reverse this transaction")
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Return a JSON-serialized TransactionResult struct to the user
+	result := &TransactionResult{FlowId: reversalId}
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+// resolveTargetUserId returns the Twitch user ID of the user identified in an admin
+// request, which may supply either a user ID or a display name
+func (s *Server) resolveTargetUserId(ctx context.Context, twitchUserId string, twitchDisplayName string) (string, error) {
+	if twitchUserId != "" {
+		return twitchUserId, nil
+	}
+	resolved, err := s.resolveTwitchUserId(ctx, twitchDisplayName)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve twitch user ID from username: %w", err)
+	}
+	return resolved, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
 	}
+	return x
 }
@@ -2,15 +2,20 @@ package admin
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,32 +42,39 @@ func Test_Server_handlePostManualCredit(t *testing.T) {
 			`{"flowId":"59c7fe68-b49e-42cc-a2c7-dbc4ddc6f9c8"}`,
 		},
 		{
-			"failure to resolve user ID from twitch username is a 500 error",
+			"unknown twitch username is a 400 error",
 			&mockQueries{},
 			`{"twitchDisplayName":"nobody","numPointsToCredit":400,"note":"test"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"failed to resolve twitch user ID from username: no such Twitch user: 'nobody'"}`,
+		},
+		{
+			"failure to resolve user ID from twitch username is a 500 error",
+			&mockQueries{},
+			`{"twitchDisplayName":"twitchdown","numPointsToCredit":400,"note":"test"}`,
 			http.StatusInternalServerError,
-			"failed to resolve twitch user ID from username: no such user",
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"failed to resolve twitch user ID from username: got status 503: Service Unavailable"}`,
 		},
 		{
 			"supplying both display name and username is an error",
 			&mockQueries{},
 			`{"twitchUserId":"1337","twitchDisplayName":"somebody","numPointsToCredit":400,"note":"test"}`,
 			http.StatusBadRequest,
-			"invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required",
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required"}`,
 		},
 		{
 			"supplying neither display name nor username is an error",
 			&mockQueries{},
 			`{"numPointsToCredit":400,"note":"test"}`,
 			http.StatusBadRequest,
-			"invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required",
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required"}`,
 		},
 		{
 			"failing to supply a non-empty note is an error",
 			&mockQueries{},
 			`{"twitchUserId":"1337","numPointsToCredit":400,"note":""}`,
 			http.StatusBadRequest,
-			"invalid request payload: 'note' must be set to a non-empty string",
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'note' must be set to a non-empty string"}`,
 		},
 		{
 			"failure to update database is a 500 error",
@@ -71,7 +83,7 @@ func Test_Server_handlePostManualCredit(t *testing.T) {
 			},
 			`{"twitchUserId":"1337","numPointsToCredit":400,"note":"test"}`,
 			http.StatusInternalServerError,
-			"mock error",
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"mock error"}`,
 		},
 	}
 	for _, tt := range tests {
@@ -93,16 +105,298 @@ func Test_Server_handlePostManualCredit(t *testing.T) {
 	}
 }
 
+func Test_Server_handlePostManualDebit(t *testing.T) {
+	tests := []struct {
+		name       string
+		q          *mockQueries
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"points can be debited via user ID",
+			&mockQueries{available: map[string]int32{"1337": 1000}},
+			`{"twitchUserId":"1337","numPointsToDebit":400,"note":"spamming"}`,
+			http.StatusOK,
+			`{"flowId":"3e7c1a9d-2b4f-4c6e-8a1d-5f9b3c7e2a40","numPointsDebited":400}`,
+		},
+		{
+			"points can be debited via username",
+			&mockQueries{available: map[string]int32{"1337": 1000}},
+			`{"twitchDisplayName":"somebody","numPointsToDebit":400,"note":"spamming"}`,
+			http.StatusOK,
+			`{"flowId":"3e7c1a9d-2b4f-4c6e-8a1d-5f9b3c7e2a40","numPointsDebited":400}`,
+		},
+		{
+			"debit is clamped to available balance by default",
+			&mockQueries{available: map[string]int32{"1337": 150}},
+			`{"twitchUserId":"1337","numPointsToDebit":400,"note":"over-credited"}`,
+			http.StatusOK,
+			`{"flowId":"3e7c1a9d-2b4f-4c6e-8a1d-5f9b3c7e2a40","numPointsDebited":150}`,
+		},
+		{
+			"debit may bring balance negative if explicitly allowed",
+			&mockQueries{available: map[string]int32{"1337": 150}},
+			`{"twitchUserId":"1337","numPointsToDebit":400,"note":"over-credited","allowNegativeBalance":true}`,
+			http.StatusOK,
+			`{"flowId":"3e7c1a9d-2b4f-4c6e-8a1d-5f9b3c7e2a40","numPointsDebited":400}`,
+		},
+		{
+			"clamped debit of a user with no available points is a 409 error",
+			&mockQueries{},
+			`{"twitchUserId":"1337","numPointsToDebit":400,"note":"over-credited"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"not_enough_points","detail":"user has no points available to debit"}`,
+		},
+		{
+			"supplying both display name and username is an error",
+			&mockQueries{},
+			`{"twitchUserId":"1337","twitchDisplayName":"somebody","numPointsToDebit":400,"note":"test"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: exactly one of 'twitchDisplayName' and 'twitchUserId' is required"}`,
+		},
+		{
+			"failing to supply a positive debit amount is an error",
+			&mockQueries{},
+			`{"twitchUserId":"1337","numPointsToDebit":0,"note":"test"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'numPointsToDebit' must be set to a positive integer"}`,
+		},
+		{
+			"failing to supply a non-empty note is an error",
+			&mockQueries{},
+			`{"twitchUserId":"1337","numPointsToDebit":400,"note":""}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'note' must be set to a non-empty string"}`,
+		},
+		{
+			"unknown twitch username is a 400 error",
+			&mockQueries{},
+			`{"twitchDisplayName":"nobody","numPointsToDebit":400,"note":"test"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"failed to resolve twitch user ID from username: no such Twitch user: 'nobody'"}`,
+		},
+		{
+			"failure to resolve user ID from twitch username is a 500 error",
+			&mockQueries{},
+			`{"twitchDisplayName":"twitchdown","numPointsToDebit":400,"note":"test"}`,
+			http.StatusInternalServerError,
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"failed to resolve twitch user ID from username: got status 503: Service Unavailable"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				q:                   tt.q,
+				runInTx:             tt.q.runInTx,
+				resolveTwitchUserId: mockResolveTwitchUserId,
+			}
+			req := httptest.NewRequest(http.MethodPost, "/outflow/manual-debit", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+			s.handlePostManualDebit(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+		})
+	}
+}
+
+func Test_Server_handlePostReversal(t *testing.T) {
+	acceptedAlert := queries.GetFlowForReversalRow{
+		ID:           uuid.MustParse("a1f1e3d2-5c4b-4a39-8e7d-6f5a4b3c2d1e"),
+		Type:         "alert-redemption",
+		TwitchUserID: "1337",
+		DeltaPoints:  -250,
+		FinalizedAt:  sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)},
+		Accepted:     true,
+	}
+	partiallyReversedAlert := acceptedAlert
+	partiallyReversedAlert.ReversedDeltaPoints = 200
+	pendingAlert := acceptedAlert
+	pendingAlert.FinalizedAt = sql.NullTime{}
+	pendingAlert.Accepted = false
+	reversal := queries.GetFlowForReversalRow{
+		ID:           acceptedAlert.ID,
+		Type:         "reversal",
+		TwitchUserID: "1337",
+		DeltaPoints:  250,
+		FinalizedAt:  acceptedAlert.FinalizedAt,
+		Accepted:     true,
+	}
+
+	tests := []struct {
+		name          string
+		q             *mockQueries
+		flowId        string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantReversals []queries.RecordReversalParams
+	}{
+		{
+			"full amount is reversed by default",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{acceptedAlert}},
+			acceptedAlert.ID.String(),
+			`{"note":"alert failed on stream"}`,
+			http.StatusOK,
+			`{"flowId":"c1e4f0b6-7b5e-4f4a-9f3c-2a6d8e1b0c57"}`,
+			[]queries.RecordReversalParams{
+				{Note: "alert failed on stream", NumPoints: 250, FlowID: acceptedAlert.ID},
+			},
+		},
+		{
+			"partial amount may be reversed",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{acceptedAlert}},
+			acceptedAlert.ID.String(),
+			`{"numPoints":100,"note":"partial refund"}`,
+			http.StatusOK,
+			`{"flowId":"c1e4f0b6-7b5e-4f4a-9f3c-2a6d8e1b0c57"}`,
+			[]queries.RecordReversalParams{
+				{Note: "partial refund", NumPoints: 100, FlowID: acceptedAlert.ID},
+			},
+		},
+		{
+			"only the remaining amount is reversed by default",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{partiallyReversedAlert}},
+			acceptedAlert.ID.String(),
+			`{"note":"refund the rest"}`,
+			http.StatusOK,
+			`{"flowId":"c1e4f0b6-7b5e-4f4a-9f3c-2a6d8e1b0c57"}`,
+			[]queries.RecordReversalParams{
+				{Note: "refund the rest", NumPoints: 50, FlowID: acceptedAlert.ID},
+			},
+		},
+		{
+			"reversing more than the remaining amount is an error",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{partiallyReversedAlert}},
+			acceptedAlert.ID.String(),
+			`{"numPoints":100,"note":"too much"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'numPoints' may not exceed the 50 points that remain to be reversed"}`,
+			nil,
+		},
+		{
+			"note is required",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{acceptedAlert}},
+			acceptedAlert.ID.String(),
+			`{"numPoints":100}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'note' must be set to a non-empty string"}`,
+			nil,
+		},
+		{
+			"unknown transaction is a 404 error",
+			&mockQueries{},
+			acceptedAlert.ID.String(),
+			`{"note":"test"}`,
+			http.StatusNotFound,
+			`{"title":"Not Found","status":404,"code":"flow_not_found","detail":"no such transaction"}`,
+			nil,
+		},
+		{
+			"pending transaction can not be reversed",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{pendingAlert}},
+			acceptedAlert.ID.String(),
+			`{"note":"test"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"reversal_not_allowed","detail":"only an accepted transaction can be reversed"}`,
+			nil,
+		},
+		{
+			"reversal can not be reversed",
+			&mockQueries{flows: []queries.GetFlowForReversalRow{reversal}},
+			acceptedAlert.ID.String(),
+			`{"note":"test"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"reversal_not_allowed","detail":"a reversal can not itself be reversed"}`,
+			nil,
+		},
+		{
+			"concurrent reversal rejected by database is a 409 error",
+			&mockQueries{
+				flows: []queries.GetFlowForReversalRow{acceptedAlert},
+				err:   &pq.Error{Code: "23514", Constraint: "flow_reversal_amount_check"},
+			},
+			acceptedAlert.ID.String(),
+			`{"note":"test"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"reversal_not_allowed","detail":"transaction can not be reversed by the requested amount"}`,
+			nil,
+		},
+		{
+			"reversing points the user has already spent is a 409 error",
+			&mockQueries{
+				flows: []queries.GetFlowForReversalRow{acceptedAlert},
+				err:   &pq.Error{Code: "23514", Constraint: util.AvailableBalanceConstraint},
+			},
+			acceptedAlert.ID.String(),
+			`{"note":"test"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"not_enough_points","detail":"user does not have enough points available to reverse this transaction"}`,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				q:                   tt.q,
+				resolveTwitchUserId: mockResolveTwitchUserId,
+			}
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/flow/%s/reverse", tt.flowId), strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"id": tt.flowId})
+			res := httptest.NewRecorder()
+			s.handlePostReversal(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantReversals, tt.q.reversalCalls)
+		})
+	}
+}
+
 func mockResolveTwitchUserId(ctx context.Context, username string) (string, error) {
 	if strings.ToLower(username) == "somebody" {
 		return "1337", nil
 	}
-	return "", fmt.Errorf("no such user")
+	if strings.ToLower(username) == "twitchdown" {
+		return "", fmt.Errorf("got status 503: Service Unavailable")
+	}
+	return "", fmt.Errorf("%w: '%s'", ErrTwitchUserNotFound, username)
 }
 
 type mockQueries struct {
-	err   error
-	calls []queries.RecordManualCreditInflowParams
+	err           error
+	calls         []queries.RecordManualCreditInflowParams
+	flows         []queries.GetFlowForReversalRow
+	reversalCalls []queries.RecordReversalParams
+	available     map[string]int32
+	debitCalls    []queries.RecordManualDebitOutflowParams
+	lockedUserIds []string
+}
+
+func (m *mockQueries) runInTx(ctx context.Context, f func(q Queries) error) error {
+	m.lockedUserIds = nil
+	return f(m)
+}
+
+func (m *mockQueries) isLocked(twitchUserId string) bool {
+	for _, lockedUserId := range m.lockedUserIds {
+		if lockedUserId == twitchUserId {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockQueries) LockFlowsForUser(ctx context.Context, twitchUserID string) error {
+	m.lockedUserIds = append(m.lockedUserIds, twitchUserID)
+	return nil
 }
 
 func (m *mockQueries) RecordManualCreditInflow(ctx context.Context, arg queries.RecordManualCreditInflowParams) (uuid.UUID, error) {
@@ -112,3 +406,41 @@ func (m *mockQueries) RecordManualCreditInflow(ctx context.Context, arg queries.
 	m.calls = append(m.calls, arg)
 	return uuid.MustParse("59c7fe68-b49e-42cc-a2c7-dbc4ddc6f9c8"), nil
 }
+
+func (m *mockQueries) RecordManualDebitOutflow(ctx context.Context, arg queries.RecordManualDebitOutflowParams) (queries.RecordManualDebitOutflowRow, error) {
+	if m.err != nil {
+		return queries.RecordManualDebitOutflowRow{}, m.err
+	}
+	if !m.isLocked(arg.TwitchUserID) {
+		return queries.RecordManualDebitOutflowRow{}, fmt.Errorf("balance read without holding lock for user %s", arg.TwitchUserID)
+	}
+	numPoints := arg.NumPointsToDebit
+	if !arg.AllowNegativeBalance && m.available[arg.TwitchUserID] < numPoints {
+		numPoints = m.available[arg.TwitchUserID]
+	}
+	if numPoints <= 0 {
+		return queries.RecordManualDebitOutflowRow{}, sql.ErrNoRows
+	}
+	m.debitCalls = append(m.debitCalls, arg)
+	return queries.RecordManualDebitOutflowRow{
+		ID:               uuid.MustParse("3e7c1a9d-2b4f-4c6e-8a1d-5f9b3c7e2a40"),
+		NumPointsDebited: numPoints,
+	}, nil
+}
+
+func (m *mockQueries) GetFlowForReversal(ctx context.Context, flowID uuid.UUID) (queries.GetFlowForReversalRow, error) {
+	for _, flow := range m.flows {
+		if flow.ID == flowID {
+			return flow, nil
+		}
+	}
+	return queries.GetFlowForReversalRow{}, sql.ErrNoRows
+}
+
+func (m *mockQueries) RecordReversal(ctx context.Context, arg queries.RecordReversalParams) (uuid.UUID, error) {
+	if m.err != nil {
+		return uuid.UUID{}, m.err
+	}
+	m.reversalCalls = append(m.reversalCalls, arg)
+	return uuid.MustParse("c1e4f0b6-7b5e-4f4a-9f3c-2a6d8e1b0c57"), nil
+}
@@ -2,15 +2,22 @@ package admin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/nicklaw5/helix/v2"
 )
 
+// ErrTwitchUserNotFound is returned by a ResolveTwitchUserIdFunc when the Twitch API
+// reports that no user has the given username, as opposed to failing to answer at all
+var ErrTwitchUserNotFound = errors.New("no such Twitch user")
+
 type ResolveTwitchUserIdFunc func(ctx context.Context, username string) (string, error)
 
-func makeResolveTwitchUserIdFunc(clientId string, clientSecret string) ResolveTwitchUserIdFunc {
+// NewResolveTwitchUserIdFunc returns a function that resolves a Twitch username to the
+// corresponding user ID via the Twitch API, using the given app credentials
+func NewResolveTwitchUserIdFunc(clientId string, clientSecret string) ResolveTwitchUserIdFunc {
 	return func(ctx context.Context, username string) (string, error) {
 		return resolveTwitchUserId(ctx, clientId, clientSecret, username)
 	}
@@ -41,10 +48,56 @@ func resolveTwitchUserId(ctx context.Context, clientId string, clientSecret stri
 		err = fmt.Errorf("got status %d: %s", res.StatusCode, res.ErrorMessage)
 	}
 	if err != nil {
-		return "", fmt.Errorf("failed to resolve Twitch user ID from username:%w", err)
+		return "", fmt.Errorf("failed to resolve Twitch user ID from username: %w", err)
+	}
+	if len(res.Data.Users) == 0 {
+		return "", fmt.Errorf("%w: '%s'", ErrTwitchUserNotFound, username)
 	}
 	if len(res.Data.Users) != 1 {
 		return "", fmt.Errorf("got %d results in response to a single-username lookup", len(res.Data.Users))
 	}
 	return res.Data.Users[0].ID, nil
 }
+
+type LookupTwitchDisplayNameFunc func(ctx context.Context, twitchUserId string) (string, error)
+
+// NewLookupTwitchDisplayNameFunc returns a function that looks up the display name of
+// the Twitch user with the given ID via the Twitch API, using the given app credentials
+func NewLookupTwitchDisplayNameFunc(clientId string, clientSecret string) LookupTwitchDisplayNameFunc {
+	return func(ctx context.Context, twitchUserId string) (string, error) {
+		return lookupTwitchDisplayName(ctx, clientId, clientSecret, twitchUserId)
+	}
+}
+
+func lookupTwitchDisplayName(ctx context.Context, clientId string, clientSecret string, twitchUserId string) (string, error) {
+	c, err := helix.NewClientWithContext(ctx, &helix.Options{
+		ClientID:     clientId,
+		ClientSecret: clientSecret,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize Twitch API client: %v", err)
+	}
+
+	tokenRes, err := c.RequestAppAccessToken(nil)
+	if err == nil && tokenRes.StatusCode != http.StatusOK {
+		err = fmt.Errorf("got status %d: %s", tokenRes.StatusCode, tokenRes.ErrorMessage)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get app access token from Twitch API: %w", err)
+	}
+	c.SetAppAccessToken(tokenRes.Data.AccessToken)
+
+	res, err := c.GetUsers(&helix.UsersParams{
+		IDs: []string{twitchUserId},
+	})
+	if err == nil && res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("got status %d: %s", res.StatusCode, res.ErrorMessage)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up Twitch display name from user ID: %w", err)
+	}
+	if len(res.Data.Users) != 1 {
+		return "", fmt.Errorf("got %d results in response to a single-user lookup", len(res.Data.Users))
+	}
+	return res.Data.Users[0].DisplayName, nil
+}
@@ -2,6 +2,7 @@ package admin
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/golden-vcr/ledger/gen/queries"
 	"github.com/google/uuid"
@@ -9,6 +10,30 @@ import (
 
 type Queries interface {
 	RecordManualCreditInflow(ctx context.Context, arg queries.RecordManualCreditInflowParams) (uuid.UUID, error)
+	LockFlowsForUser(ctx context.Context, twitchUserID string) error
+	RecordManualDebitOutflow(ctx context.Context, arg queries.RecordManualDebitOutflowParams) (queries.RecordManualDebitOutflowRow, error)
+	GetFlowForReversal(ctx context.Context, flowID uuid.UUID) (queries.GetFlowForReversalRow, error)
+	RecordReversal(ctx context.Context, arg queries.RecordReversalParams) (uuid.UUID, error)
+}
+
+// RunInTxFunc calls f with a Queries value that's bound to a single database
+// transaction, committing the transaction only if f succeeds
+type RunInTxFunc func(ctx context.Context, f func(q Queries) error) error
+
+// NewTxRunner returns a RunInTxFunc that runs each transaction against the given
+// database
+func NewTxRunner(db *sql.DB) RunInTxFunc {
+	return func(ctx context.Context, f func(q Queries) error) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := f(queries.New(tx)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
 }
 
 type ManualCreditRequest struct {
@@ -18,6 +43,32 @@ type ManualCreditRequest struct {
 	Note              string `json:"note"`
 }
 
+type ManualDebitRequest struct {
+	TwitchUserId      string `json:"twitchUserId,omitempty"`
+	TwitchDisplayName string `json:"twitchDisplayName,omitempty"`
+	NumPointsToDebit  int    `json:"numPointsToDebit"`
+	Note              string `json:"note"`
+	// AllowNegativeBalance permits the debit to bring the user's available balance
+	// below zero; if false, the debit is clamped to the user's available balance
+	AllowNegativeBalance bool `json:"allowNegativeBalance,omitempty"`
+}
+
+// ManualDebitResult is returned in response to a manual debit, indicating the number
+// of points actually debited, which may be less than requested if clamped
+type ManualDebitResult struct {
+	FlowId           uuid.UUID `json:"flowId"`
+	NumPointsDebited int       `json:"numPointsDebited"`
+}
+
+// ReversalRequest is the payload sent with a POST /admin/flow/{id}/reverse request
+type ReversalRequest struct {
+	// NumPoints is the number of points to reverse; if omitted, the full remaining
+	// amount of the original transaction is reversed
+	NumPoints int `json:"numPoints,omitempty"`
+	// Note is a required explanation of why the transaction is being reversed
+	Note string `json:"note"`
+}
+
 type TransactionResult struct {
 	FlowId uuid.UUID `json:"flowId"`
 }
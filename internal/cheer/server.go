@@ -2,7 +2,9 @@ package cheer
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,18 +12,24 @@ import (
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/gorilla/mux"
 )
 
 const MaxStoredMessageLen = 128
 
 type Server struct {
-	q Queries
+	q      Queries
+	policy points.Policy
 }
 
-func NewServer(q Queries) *Server {
+// NewServer initializes a server that credits points for cheers in accordance with the
+// given policy
+func NewServer(q Queries, policy points.Policy) *Server {
 	return &Server{
-		q: q,
+		q:      q,
+		policy: policy,
 	}
 }
 
@@ -37,24 +45,32 @@ func (s *Server) handlePostCheer(res http.ResponseWriter, req *http.Request) {
 	// Identify the user from the supplied JWT
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 
 	// The request's Content-Type must indicate JSON if set
 	contentType := req.Header.Get("content-type")
 	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
-		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
 		return
 	}
 
 	// Parse the payload from the request body
 	var payload ledger.CheerRequest
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
-		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
 		return
 	}
-	if payload.NumPointsToCredit <= 0 {
-		http.Error(res, "invalid request payload: 'numPointsToCredit' must be set to a positive integer", http.StatusBadRequest)
+	if payload.NumBits <= 0 {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'numBits' must be set to a positive integer")
+		return
+	}
+
+	// If the caller has identified the originating event, use its ID as an idempotency
+	// key so that a retried request can't credit the user more than once
+	idempotencyKey, err := util.ResolveIdempotencyKey(req, payload.EventId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request: %v", err))
 		return
 	}
 
@@ -65,20 +81,53 @@ func (s *Server) handlePostCheer(res http.ResponseWriter, req *http.Request) {
 	}
 
 	// Create a finalized flow record representing the inflow transaction that credits
-	// our desired number of points to the target user
+	// the target user with the number of points our policy awards for their bits,
+	// scaled by any active promotion, recording which version of the policy (and which
+	// promotion, if any) was applied
+	numPointsToCredit, err := s.policy.CheerCredit(payload.NumBits)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	numPointsToCredit, promotion, err := points.ApplyPromotion(req.Context(), s.q, ledger.TransactionTypeCheer, numPointsToCredit)
+	if errors.Is(err, points.ErrCreditOverflow) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
 	flowId, err := s.q.RecordCheerInflow(context.Background(), queries.RecordCheerInflowParams{
 		TwitchUserID:      claims.User.Id,
-		NumPointsToCredit: int32(payload.NumPointsToCredit),
+		NumPointsToCredit: numPointsToCredit,
 		Message:           message,
+		NumBits:           int32(payload.NumBits),
+		PolicyVersion:     s.policy.Version(),
+		Promotion:         promotion,
+		IdempotencyKey:    idempotencyKey,
 	})
+	if errors.Is(err, sql.ErrNoRows) && idempotencyKey.Valid {
+		// No row was inserted because a cheer has already been recorded with this
+		// idempotency key: this is a replay, so respond with the original transaction
+		flowId, err = s.q.GetFlowIdByIdempotencyKey(req.Context(), queries.GetFlowIdByIdempotencyKeyParams{
+			Type:           string(ledger.TransactionTypeCheer),
+			IdempotencyKey: idempotencyKey.String,
+			TwitchUserID:   claims.User.Id,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Error(res, ledger.ErrorCodeIdempotencyConflict, "idempotency key has already been used for another user")
+			return
+		}
+	}
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
 	// Return a JSON-serialized TransactionResult struct to the user
 	result := &TransactionResult{FlowId: flowId}
 	if err := json.NewEncoder(res).Encode(result); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 }
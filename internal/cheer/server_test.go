@@ -2,6 +2,7 @@ package cheer
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/golden-vcr/auth"
 	authmock "github.com/golden-vcr/auth/mock"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -29,7 +31,7 @@ func Test_Server_handlePostCheer(t *testing.T) {
 			"normal usage",
 			&mockQueries{},
 			"internal-jwt",
-			`{"numPointsToCredit":400,"message":"hello"}`,
+			`{"numBits":400,"message":"hello"}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 		},
@@ -37,17 +39,25 @@ func Test_Server_handlePostCheer(t *testing.T) {
 			"message is optional",
 			&mockQueries{},
 			"internal-jwt",
-			`{"numPointsToCredit":400}`,
+			`{"numBits":400}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 		},
 		{
-			"points to credit must be positive",
+			"number of bits must be positive",
+			&mockQueries{},
+			"internal-jwt",
+			`{"numBits":0}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'numBits' must be set to a positive integer"}`,
+		},
+		{
+			"legacy payload without number of bits is rejected",
 			&mockQueries{},
 			"internal-jwt",
-			`{"numPointsToCredit":0}`,
+			`{"numPointsToCredit":400}`,
 			http.StatusBadRequest,
-			"invalid request payload: 'numPointsToCredit' must be set to a positive integer",
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'numBits' must be set to a positive integer"}`,
 		},
 		{
 			"malformed JSON payload is a 400 error",
@@ -55,13 +65,13 @@ func Test_Server_handlePostCheer(t *testing.T) {
 			"internal-jwt",
 			`{""}`,
 			http.StatusBadRequest,
-			"invalid request payload: invalid character '}' after object key",
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: invalid character '}' after object key"}`,
 		},
 		{
 			"invalid JWT is a 401 error",
 			&mockQueries{},
 			"twitch-user-access-token",
-			`{"numPointsToCredit":400,"message":"hello"}`,
+			`{"numBits":400,"message":"hello"}`,
 			http.StatusUnauthorized,
 			"access denied",
 		},
@@ -69,7 +79,7 @@ func Test_Server_handlePostCheer(t *testing.T) {
 			"missing JWT is a 401 error",
 			&mockQueries{},
 			"",
-			`{"numPointsToCredit":400,"message":"hello"}`,
+			`{"numBits":400,"message":"hello"}`,
 			http.StatusBadRequest,
 			"Internal JWT must be supplied in Authorization header",
 		},
@@ -79,9 +89,9 @@ func Test_Server_handlePostCheer(t *testing.T) {
 				err: fmt.Errorf("mock error"),
 			},
 			"internal-jwt",
-			`{"numPointsToCredit":400,"message":"hello"}`,
+			`{"numBits":400,"message":"hello"}`,
 			http.StatusInternalServerError,
-			"mock error",
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"mock error"}`,
 		},
 	}
 	for _, tt := range tests {
@@ -96,7 +106,8 @@ func Test_Server_handlePostCheer(t *testing.T) {
 		})
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Server{
-				q: tt.q,
+				q:      tt.q,
+				policy: mockPolicy,
 			}
 			handler := auth.RequireAuthority(c, http.HandlerFunc(s.handlePostCheer))
 			req := httptest.NewRequest(http.MethodPost, "/inflow/cheer", strings.NewReader(tt.body))
@@ -114,6 +125,10 @@ func Test_Server_handlePostCheer(t *testing.T) {
 
 			if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusCreated {
 				assert.Len(t, tt.q.calls, 1)
+				assert.Equal(t, int32(400), tt.q.calls[0].NumBits)
+				assert.Equal(t, int32(800), tt.q.calls[0].NumPointsToCredit)
+				assert.Equal(t, mockPolicy.Version(), tt.q.calls[0].PolicyVersion)
+				assert.False(t, tt.q.calls[0].Promotion.Valid)
 			} else {
 				assert.Len(t, tt.q.calls, 0)
 			}
@@ -121,15 +136,194 @@ func Test_Server_handlePostCheer(t *testing.T) {
 	}
 }
 
+func Test_Server_handlePostCheer_promotion(t *testing.T) {
+	c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
+		Id:          "1337",
+		Login:       "leetman",
+		DisplayName: "LEETman",
+	})
+	q := &mockQueries{
+		promotion: &queries.GetActivePromotionRow{
+			ID:         uuid.MustParse("7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e"),
+			Name:       "Double Points Night",
+			Multiplier: 2.0,
+		},
+	}
+	s := &Server{
+		q:      q,
+		policy: mockPolicy,
+	}
+	handler := auth.RequireAuthority(c, http.HandlerFunc(s.handlePostCheer))
+	req := httptest.NewRequest(http.MethodPost, "/inflow/cheer", strings.NewReader(`{"numBits":400,"message":"hello"}`))
+	req.Header.Add("authorization", "Bearer internal-jwt")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// The active promotion should double the 800 points our policy awards for 400 bits,
+	// and the promotion should be recorded in the flow's metadata
+	assert.Len(t, q.calls, 1)
+	assert.Equal(t, int32(1600), q.calls[0].NumPointsToCredit)
+	assert.True(t, q.calls[0].Promotion.Valid)
+	assert.Equal(t, `{"id":"7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e","name":"Double Points Night","multiplier":2}`, string(q.calls[0].Promotion.RawMessage))
+}
+
+func Test_Server_handlePostCheer_idempotency(t *testing.T) {
+	tests := []struct {
+		name            string
+		q               *mockQueries
+		idempotencyKey  string
+		body            string
+		wantStatus      int
+		wantBody        string
+		wantNumCalls    int
+		wantRecordedKey sql.NullString
+	}{
+		{
+			"idempotency key may be supplied via header",
+			&mockQueries{},
+			"event-1",
+			`{"numBits":400,"message":"hello"}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1,
+			sql.NullString{Valid: true, String: "event-1"},
+		},
+		{
+			"idempotency key may be supplied via eventId",
+			&mockQueries{},
+			"",
+			`{"numBits":400,"message":"hello","eventId":"event-1"}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1,
+			sql.NullString{Valid: true, String: "event-1"},
+		},
+		{
+			"replayed request returns original transaction without crediting again",
+			&mockQueries{
+				calls: []queries.RecordCheerInflowParams{
+					{
+						TwitchUserID:      "1337",
+						NumPointsToCredit: 400,
+						Message:           "hello",
+						IdempotencyKey:    sql.NullString{Valid: true, String: "event-1"},
+					},
+				},
+			},
+			"event-1",
+			`{"numBits":400,"message":"hello"}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1,
+			sql.NullString{Valid: true, String: "event-1"},
+		},
+		{
+			"idempotency key already used for another user is a 409 error",
+			&mockQueries{
+				calls: []queries.RecordCheerInflowParams{
+					{
+						TwitchUserID:      "9999",
+						NumPointsToCredit: 400,
+						Message:           "hello",
+						IdempotencyKey:    sql.NullString{Valid: true, String: "event-1"},
+					},
+				},
+			},
+			"event-1",
+			`{"numBits":400,"message":"hello"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"idempotency_conflict","detail":"idempotency key has already been used for another user"}`,
+			1,
+			sql.NullString{Valid: true, String: "event-1"},
+		},
+		{
+			"mismatched header and eventId is a 400 error",
+			&mockQueries{},
+			"event-1",
+			`{"numBits":400,"message":"hello","eventId":"event-2"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request: Idempotency-Key header and 'eventId' must match if both are supplied"}`,
+			0,
+			sql.NullString{},
+		},
+	}
+	for _, tt := range tests {
+		c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
+			Id:          "1337",
+			Login:       "leetman",
+			DisplayName: "LEETman",
+		})
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				q:      tt.q,
+				policy: mockPolicy,
+			}
+			handler := auth.RequireAuthority(c, http.HandlerFunc(s.handlePostCheer))
+			req := httptest.NewRequest(http.MethodPost, "/inflow/cheer", strings.NewReader(tt.body))
+			req.Header.Add("authorization", "Bearer internal-jwt")
+			if tt.idempotencyKey != "" {
+				req.Header.Add("idempotency-key", tt.idempotencyKey)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+
+			assert.Len(t, tt.q.calls, tt.wantNumCalls)
+			if tt.wantNumCalls > 0 {
+				assert.Equal(t, tt.wantRecordedKey, tt.q.calls[0].IdempotencyKey)
+			}
+		})
+	}
+}
+
+// mockPolicy credits 2 points per bit
+var mockPolicy = points.Policy{
+	PointsPerBit:          2,
+	PointsPerSubscription: 600,
+	PointsPerGiftSub:      200,
+	Tier2Multiplier:       2.0,
+	Tier3Multiplier:       5.0,
+}
+
 type mockQueries struct {
-	err   error
-	calls []queries.RecordCheerInflowParams
+	err       error
+	promotion *queries.GetActivePromotionRow
+	calls     []queries.RecordCheerInflowParams
+}
+
+func (m *mockQueries) GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error) {
+	if m.promotion == nil {
+		return queries.GetActivePromotionRow{}, sql.ErrNoRows
+	}
+	return *m.promotion, nil
 }
 
 func (m *mockQueries) RecordCheerInflow(ctx context.Context, arg queries.RecordCheerInflowParams) (uuid.UUID, error) {
 	if m.err != nil {
 		return uuid.UUID{}, m.err
 	}
+	if arg.IdempotencyKey.Valid {
+		for _, call := range m.calls {
+			if call.IdempotencyKey == arg.IdempotencyKey {
+				return uuid.UUID{}, sql.ErrNoRows
+			}
+		}
+	}
 	m.calls = append(m.calls, arg)
 	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
 }
+
+func (m *mockQueries) GetFlowIdByIdempotencyKey(ctx context.Context, arg queries.GetFlowIdByIdempotencyKeyParams) (uuid.UUID, error) {
+	for _, call := range m.calls {
+		if call.IdempotencyKey.String == arg.IdempotencyKey && call.TwitchUserID == arg.TwitchUserID {
+			return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+		}
+	}
+	return uuid.UUID{}, sql.ErrNoRows
+}
@@ -8,6 +8,8 @@ import (
 )
 
 type Queries interface {
+	GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error)
+	GetFlowIdByIdempotencyKey(ctx context.Context, arg queries.GetFlowIdByIdempotencyKeyParams) (uuid.UUID, error)
 	RecordCheerInflow(ctx context.Context, arg queries.RecordCheerInflowParams) (uuid.UUID, error)
 }
 
@@ -0,0 +1,4 @@
+// Package community implements endpoints that allow our internal Twitch webhook handler
+// to credit points to users for supporting the stream without spending money: by
+// raiding, by following for the first time, and by contributing to hype trains
+package community
@@ -0,0 +1,273 @@
+package community
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/golden-vcr/ledger/internal/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Server struct {
+	q      Queries
+	policy points.Policy
+}
+
+// NewServer initializes a server that credits points for raids, follows, and hype
+// trains in accordance with the given policy
+func NewServer(q Queries, policy points.Policy) *Server {
+	return &Server{
+		q:      q,
+		policy: policy,
+	}
+}
+
+func (s *Server) RegisterRoutes(r *mux.Router, c auth.Client) {
+	// Only internal services may call these endpoints, by supplying the JWT they've
+	// been issued by the auth service (with the 'authoritative' claim)
+	r.Path("/inflow/raid").Methods("POST").Handler(
+		auth.RequireAuthority(c, http.HandlerFunc(s.handlePostRaid)),
+	)
+	r.Path("/inflow/follow").Methods("POST").Handler(
+		auth.RequireAuthority(c, http.HandlerFunc(s.handlePostFollow)),
+	)
+	r.Path("/inflow/hype-train").Methods("POST").Handler(
+		auth.RequireAuthority(c, http.HandlerFunc(s.handlePostHypeTrain)),
+	)
+}
+
+func (s *Server) handlePostRaid(res http.ResponseWriter, req *http.Request) {
+	// Identify the raiding broadcaster from the supplied JWT
+	claims, err := auth.GetClaims(req)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ledger.RaidRequest
+	if !decodePayload(res, req, &payload) {
+		return
+	}
+	if payload.NumViewers <= 0 {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'numViewers' must be set to a positive integer")
+		return
+	}
+	idempotencyKey, err := util.ResolveIdempotencyKey(req, payload.EventId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	// Credit the raiding broadcaster with the number of points our policy awards for
+	// the viewers they brought with them, scaled by any active promotion
+	numPointsToCredit, err := s.policy.RaidCredit(payload.NumViewers)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	numPointsToCredit, promotion, err := points.ApplyPromotion(req.Context(), s.q, ledger.TransactionTypeRaid, numPointsToCredit)
+	if errors.Is(err, points.ErrCreditOverflow) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	flowId, err := s.q.RecordRaidInflow(req.Context(), queries.RecordRaidInflowParams{
+		NumViewers:        int32(payload.NumViewers),
+		PolicyVersion:     s.policy.Version(),
+		Promotion:         promotion,
+		TwitchUserID:      claims.User.Id,
+		NumPointsToCredit: numPointsToCredit,
+		IdempotencyKey:    idempotencyKey,
+	})
+	if errors.Is(err, sql.ErrNoRows) && idempotencyKey.Valid {
+		flowId, err = s.getReplayedFlowId(req.Context(), ledger.TransactionTypeRaid, idempotencyKey.String, claims.User.Id)
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Error(res, ledger.ErrorCodeIdempotencyConflict, "idempotency key has already been used for another user")
+			return
+		}
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	respond(res, flowId)
+}
+
+func (s *Server) handlePostFollow(res http.ResponseWriter, req *http.Request) {
+	// Identify the user from the supplied JWT
+	claims, err := auth.GetClaims(req)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ledger.FollowRequest
+	if !decodePayload(res, req, &payload) {
+		return
+	}
+	idempotencyKey, err := util.ResolveIdempotencyKey(req, payload.EventId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	// Credit the user for following, scaled by any active promotion: the database
+	// permits only one follow inflow per user, so a user can't earn points by
+	// repeatedly unfollowing and following again
+	numPointsToCredit, err := s.policy.FollowCredit()
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	numPointsToCredit, promotion, err := points.ApplyPromotion(req.Context(), s.q, ledger.TransactionTypeFollow, numPointsToCredit)
+	if errors.Is(err, points.ErrCreditOverflow) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	flowId, err := s.q.RecordFollowInflow(req.Context(), queries.RecordFollowInflowParams{
+		PolicyVersion:     s.policy.Version(),
+		Promotion:         promotion,
+		TwitchUserID:      claims.User.Id,
+		NumPointsToCredit: numPointsToCredit,
+		IdempotencyKey:    idempotencyKey,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// No row was inserted: if this request is a replay, respond with the original
+		// transaction; otherwise the user has already been credited for an earlier
+		// follow, or the idempotency key belongs to someone else
+		if idempotencyKey.Valid {
+			flowId, err = s.getReplayedFlowId(req.Context(), ledger.TransactionTypeFollow, idempotencyKey.String, claims.User.Id)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = s.q.GetFollowInflowId(req.Context(), claims.User.Id)
+			if err == nil {
+				util.Error(res, ledger.ErrorCodeFollowAlreadyCredited, "user has already been credited for following")
+				return
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				util.Error(res, ledger.ErrorCodeIdempotencyConflict, "idempotency key has already been used for another user")
+				return
+			}
+		}
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	respond(res, flowId)
+}
+
+func (s *Server) handlePostHypeTrain(res http.ResponseWriter, req *http.Request) {
+	// Identify the contributing user from the supplied JWT
+	claims, err := auth.GetClaims(req)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ledger.HypeTrainRequest
+	if !decodePayload(res, req, &payload) {
+		return
+	}
+	if payload.Level <= 0 {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'level' must be set to a positive integer")
+		return
+	}
+	idempotencyKey, err := util.ResolveIdempotencyKey(req, payload.EventId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	// Credit the user with the number of points our policy awards for contributing to
+	// a hype train that reached the given level, scaled by any active promotion
+	numPointsToCredit, err := s.policy.HypeTrainCredit(payload.Level)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	numPointsToCredit, promotion, err := points.ApplyPromotion(req.Context(), s.q, ledger.TransactionTypeHypeTrain, numPointsToCredit)
+	if errors.Is(err, points.ErrCreditOverflow) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	flowId, err := s.q.RecordHypeTrainInflow(req.Context(), queries.RecordHypeTrainInflowParams{
+		Level:             int32(payload.Level),
+		PolicyVersion:     s.policy.Version(),
+		Promotion:         promotion,
+		TwitchUserID:      claims.User.Id,
+		NumPointsToCredit: numPointsToCredit,
+		IdempotencyKey:    idempotencyKey,
+	})
+	if errors.Is(err, sql.ErrNoRows) && idempotencyKey.Valid {
+		flowId, err = s.getReplayedFlowId(req.Context(), ledger.TransactionTypeHypeTrain, idempotencyKey.String, claims.User.Id)
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Error(res, ledger.ErrorCodeIdempotencyConflict, "idempotency key has already been used for another user")
+			return
+		}
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	respond(res, flowId)
+}
+
+// getReplayedFlowId returns the ID of the inflow that was already recorded for the
+// given user with the given idempotency key, or sql.ErrNoRows if the key was used for
+// another user
+func (s *Server) getReplayedFlowId(ctx context.Context, flowType ledger.TransactionType, idempotencyKey string, twitchUserId string) (uuid.UUID, error) {
+	return s.q.GetFlowIdByIdempotencyKey(ctx, queries.GetFlowIdByIdempotencyKeyParams{
+		Type:           string(flowType),
+		IdempotencyKey: idempotencyKey,
+		TwitchUserID:   twitchUserId,
+	})
+}
+
+// decodePayload parses a JSON request body into the given payload, responding with an
+// error and returning false if the request is invalid
+func decodePayload(res http.ResponseWriter, req *http.Request, payload interface{}) bool {
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return false
+	}
+	if err := json.NewDecoder(req.Body).Decode(payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return false
+	}
+	return true
+}
+
+// respond returns a JSON-serialized TransactionResult struct to the caller
+func respond(res http.ResponseWriter, flowId uuid.UUID) {
+	result := &TransactionResult{FlowId: flowId}
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
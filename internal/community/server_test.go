@@ -0,0 +1,410 @@
+package community
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golden-vcr/auth"
+	authmock "github.com/golden-vcr/auth/mock"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostRaid(t *testing.T) {
+	tests := []struct {
+		name          string
+		q             *mockQueries
+		authorization string
+		body          string
+		wantStatus    int
+		wantBody      string
+	}{
+		{
+			"normal usage",
+			&mockQueries{},
+			"internal-jwt",
+			`{"numViewers":42}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+		},
+		{
+			"number of viewers must be positive",
+			&mockQueries{},
+			"internal-jwt",
+			`{"numViewers":0}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'numViewers' must be set to a positive integer"}`,
+		},
+		{
+			"replayed request returns original transaction without crediting again",
+			&mockQueries{
+				flows: []mockFlow{{flowType: "raid", twitchUserId: "1337", idempotencyKey: "event-1"}},
+			},
+			"internal-jwt",
+			`{"numViewers":42,"eventId":"event-1"}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+		},
+		{
+			"invalid JWT is a 401 error",
+			&mockQueries{},
+			"twitch-user-access-token",
+			`{"numViewers":42}`,
+			http.StatusUnauthorized,
+			"access denied",
+		},
+		{
+			"failure to update database is a 500 error",
+			&mockQueries{
+				err: fmt.Errorf("mock error"),
+			},
+			"internal-jwt",
+			`{"numViewers":42}`,
+			http.StatusInternalServerError,
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"mock error"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{q: tt.q, policy: mockPolicy}
+			res := serve(s.handlePostRaid, tt.authorization, tt.body)
+			assertResponse(t, res, tt.wantStatus, tt.wantBody)
+
+			if tt.wantStatus == http.StatusOK && len(tt.q.raidCalls) > 0 {
+				assert.Equal(t, int32(42), tt.q.raidCalls[0].NumViewers)
+				assert.Equal(t, int32(420), tt.q.raidCalls[0].NumPointsToCredit)
+				assert.Equal(t, mockPolicy.Version(), tt.q.raidCalls[0].PolicyVersion)
+			}
+		})
+	}
+}
+
+func Test_Server_handlePostFollow(t *testing.T) {
+	tests := []struct {
+		name          string
+		q             *mockQueries
+		authorization string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantNumFlows  int
+	}{
+		{
+			"normal usage",
+			&mockQueries{},
+			"internal-jwt",
+			`{"eventId":"event-1"}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1,
+		},
+		{
+			"event ID is optional",
+			&mockQueries{},
+			"internal-jwt",
+			`{}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1,
+		},
+		{
+			"replayed request returns original transaction without crediting again",
+			&mockQueries{
+				flows: []mockFlow{{flowType: "follow", twitchUserId: "1337", idempotencyKey: "event-1"}},
+			},
+			"internal-jwt",
+			`{"eventId":"event-1"}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1,
+		},
+		{
+			"following again is a 409 error",
+			&mockQueries{
+				flows: []mockFlow{{flowType: "follow", twitchUserId: "1337", idempotencyKey: "event-1"}},
+			},
+			"internal-jwt",
+			`{"eventId":"event-2"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"follow_already_credited","detail":"user has already been credited for following"}`,
+			1,
+		},
+		{
+			"following again without an event ID is a 409 error",
+			&mockQueries{
+				flows: []mockFlow{{flowType: "follow", twitchUserId: "1337"}},
+			},
+			"internal-jwt",
+			`{}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"follow_already_credited","detail":"user has already been credited for following"}`,
+			1,
+		},
+		{
+			"idempotency key already used for another user is a 409 error",
+			&mockQueries{
+				flows: []mockFlow{{flowType: "follow", twitchUserId: "9999", idempotencyKey: "event-1"}},
+			},
+			"internal-jwt",
+			`{"eventId":"event-1"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"idempotency_conflict","detail":"idempotency key has already been used for another user"}`,
+			1,
+		},
+		{
+			"malformed JSON payload is a 400 error",
+			&mockQueries{},
+			"internal-jwt",
+			`{""}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: invalid character '}' after object key"}`,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{q: tt.q, policy: mockPolicy}
+			res := serve(s.handlePostFollow, tt.authorization, tt.body)
+			assertResponse(t, res, tt.wantStatus, tt.wantBody)
+			assert.Len(t, tt.q.flows, tt.wantNumFlows)
+
+			if len(tt.q.followCalls) > 0 {
+				assert.Equal(t, int32(50), tt.q.followCalls[0].NumPointsToCredit)
+				assert.Equal(t, mockPolicy.Version(), tt.q.followCalls[0].PolicyVersion)
+			}
+		})
+	}
+}
+
+func Test_Server_handlePostHypeTrain(t *testing.T) {
+	tests := []struct {
+		name          string
+		q             *mockQueries
+		authorization string
+		body          string
+		wantStatus    int
+		wantBody      string
+	}{
+		{
+			"normal usage",
+			&mockQueries{},
+			"internal-jwt",
+			`{"level":3}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+		},
+		{
+			"level must be positive",
+			&mockQueries{},
+			"internal-jwt",
+			`{"level":-1}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'level' must be set to a positive integer"}`,
+		},
+		{
+			"idempotency key already used for another user is a 409 error",
+			&mockQueries{
+				flows: []mockFlow{{flowType: "hype-train", twitchUserId: "9999", idempotencyKey: "event-1"}},
+			},
+			"internal-jwt",
+			`{"level":3,"eventId":"event-1"}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"idempotency_conflict","detail":"idempotency key has already been used for another user"}`,
+		},
+		{
+			"missing JWT is a 401 error",
+			&mockQueries{},
+			"",
+			`{"level":3}`,
+			http.StatusBadRequest,
+			"Internal JWT must be supplied in Authorization header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{q: tt.q, policy: mockPolicy}
+			res := serve(s.handlePostHypeTrain, tt.authorization, tt.body)
+			assertResponse(t, res, tt.wantStatus, tt.wantBody)
+
+			if tt.wantStatus == http.StatusOK {
+				assert.Len(t, tt.q.hypeTrainCalls, 1)
+				assert.Equal(t, int32(3), tt.q.hypeTrainCalls[0].Level)
+				assert.Equal(t, int32(300), tt.q.hypeTrainCalls[0].NumPointsToCredit)
+				assert.Equal(t, mockPolicy.Version(), tt.q.hypeTrainCalls[0].PolicyVersion)
+			}
+		})
+	}
+}
+
+func Test_Server_promotion(t *testing.T) {
+	promotion := &queries.GetActivePromotionRow{
+		ID:         uuid.MustParse("7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e"),
+		Name:       "Double Points Night",
+		Multiplier: 2.0,
+	}
+	wantPromotion := `{"id":"7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e","name":"Double Points Night","multiplier":2}`
+
+	// An active promotion should double the points our policy awards for each kind of
+	// community inflow, and should be recorded in the flow's metadata
+	q := &mockQueries{promotion: promotion}
+	s := &Server{q: q, policy: mockPolicy}
+
+	res := serve(s.handlePostRaid, "internal-jwt", `{"numViewers":42}`)
+	assert.Equal(t, http.StatusOK, res.Code)
+	if assert.Len(t, q.raidCalls, 1) {
+		assert.Equal(t, int32(840), q.raidCalls[0].NumPointsToCredit)
+		assert.Equal(t, wantPromotion, string(q.raidCalls[0].Promotion.RawMessage))
+	}
+
+	res = serve(s.handlePostFollow, "internal-jwt", `{}`)
+	assert.Equal(t, http.StatusOK, res.Code)
+	if assert.Len(t, q.followCalls, 1) {
+		assert.Equal(t, int32(100), q.followCalls[0].NumPointsToCredit)
+		assert.Equal(t, wantPromotion, string(q.followCalls[0].Promotion.RawMessage))
+	}
+
+	res = serve(s.handlePostHypeTrain, "internal-jwt", `{"level":3}`)
+	assert.Equal(t, http.StatusOK, res.Code)
+	if assert.Len(t, q.hypeTrainCalls, 1) {
+		assert.Equal(t, int32(600), q.hypeTrainCalls[0].NumPointsToCredit)
+		assert.Equal(t, wantPromotion, string(q.hypeTrainCalls[0].Promotion.RawMessage))
+	}
+}
+
+func serve(handlerFunc http.HandlerFunc, authorization string, body string) *httptest.ResponseRecorder {
+	c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
+		Id:          "1337",
+		Login:       "leetman",
+		DisplayName: "LEETman",
+	}).AllowTwitchUserAccessToken("twitch-user-access-token", auth.RoleViewer, auth.UserDetails{
+		Id:          "100",
+		Login:       "badman",
+		DisplayName: "Badman",
+	})
+	handler := auth.RequireAuthority(c, handlerFunc)
+	req := httptest.NewRequest(http.MethodPost, "/inflow/community", strings.NewReader(body))
+	if authorization != "" {
+		req.Header.Add("authorization", fmt.Sprintf("Bearer %s", authorization))
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+func assertResponse(t *testing.T, res *httptest.ResponseRecorder, wantStatus int, wantBody string) {
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	body := strings.TrimSuffix(string(b), "\n")
+	assert.Equal(t, wantStatus, res.Code)
+	assert.Equal(t, wantBody, body)
+}
+
+// mockPolicy credits 10 points per raid viewer, 50 points per follow, and 100 points
+// per hype train level
+var mockPolicy = points.Policy{
+	PointsPerBit:            2,
+	PointsPerSubscription:   600,
+	PointsPerGiftSub:        200,
+	Tier2Multiplier:         2.0,
+	Tier3Multiplier:         5.0,
+	PointsPerRaidViewer:     10,
+	PointsPerFollow:         50,
+	PointsPerHypeTrainLevel: 100,
+}
+
+type mockFlow struct {
+	flowType       string
+	twitchUserId   string
+	idempotencyKey string
+}
+
+type mockQueries struct {
+	err            error
+	promotion      *queries.GetActivePromotionRow
+	flows          []mockFlow
+	raidCalls      []queries.RecordRaidInflowParams
+	followCalls    []queries.RecordFollowInflowParams
+	hypeTrainCalls []queries.RecordHypeTrainInflowParams
+}
+
+func (m *mockQueries) GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error) {
+	if m.promotion == nil {
+		return queries.GetActivePromotionRow{}, sql.ErrNoRows
+	}
+	return *m.promotion, nil
+}
+
+func (m *mockQueries) GetFlowIdByIdempotencyKey(ctx context.Context, arg queries.GetFlowIdByIdempotencyKeyParams) (uuid.UUID, error) {
+	for _, flow := range m.flows {
+		if flow.flowType == arg.Type && flow.idempotencyKey == arg.IdempotencyKey && flow.twitchUserId == arg.TwitchUserID {
+			return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+		}
+	}
+	return uuid.UUID{}, sql.ErrNoRows
+}
+
+func (m *mockQueries) GetFollowInflowId(ctx context.Context, twitchUserID string) (uuid.UUID, error) {
+	for _, flow := range m.flows {
+		if flow.flowType == "follow" && flow.twitchUserId == twitchUserID {
+			return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+		}
+	}
+	return uuid.UUID{}, sql.ErrNoRows
+}
+
+func (m *mockQueries) RecordRaidInflow(ctx context.Context, arg queries.RecordRaidInflowParams) (uuid.UUID, error) {
+	if err := m.record("raid", arg.TwitchUserID, arg.IdempotencyKey, false); err != nil {
+		return uuid.UUID{}, err
+	}
+	m.raidCalls = append(m.raidCalls, arg)
+	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+}
+
+func (m *mockQueries) RecordFollowInflow(ctx context.Context, arg queries.RecordFollowInflowParams) (uuid.UUID, error) {
+	if err := m.record("follow", arg.TwitchUserID, arg.IdempotencyKey, true); err != nil {
+		return uuid.UUID{}, err
+	}
+	m.followCalls = append(m.followCalls, arg)
+	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+}
+
+func (m *mockQueries) RecordHypeTrainInflow(ctx context.Context, arg queries.RecordHypeTrainInflowParams) (uuid.UUID, error) {
+	if err := m.record("hype-train", arg.TwitchUserID, arg.IdempotencyKey, false); err != nil {
+		return uuid.UUID{}, err
+	}
+	m.hypeTrainCalls = append(m.hypeTrainCalls, arg)
+	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+}
+
+// record simulates the insertion of a flow, returning sql.ErrNoRows in the same cases
+// where the database would decline to insert a row
+func (m *mockQueries) record(flowType string, twitchUserId string, idempotencyKey sql.NullString, oncePerUser bool) error {
+	if m.err != nil {
+		return m.err
+	}
+	for _, flow := range m.flows {
+		if flow.flowType != flowType {
+			continue
+		}
+		if idempotencyKey.Valid && flow.idempotencyKey == idempotencyKey.String {
+			return sql.ErrNoRows
+		}
+		if oncePerUser && flow.twitchUserId == twitchUserId {
+			return sql.ErrNoRows
+		}
+	}
+	m.flows = append(m.flows, mockFlow{
+		flowType:       flowType,
+		twitchUserId:   twitchUserId,
+		idempotencyKey: idempotencyKey.String,
+	})
+	return nil
+}
@@ -0,0 +1,21 @@
+package community
+
+import (
+	"context"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error)
+	GetFlowIdByIdempotencyKey(ctx context.Context, arg queries.GetFlowIdByIdempotencyKeyParams) (uuid.UUID, error)
+	GetFollowInflowId(ctx context.Context, twitchUserID string) (uuid.UUID, error)
+	RecordRaidInflow(ctx context.Context, arg queries.RecordRaidInflowParams) (uuid.UUID, error)
+	RecordFollowInflow(ctx context.Context, arg queries.RecordFollowInflowParams) (uuid.UUID, error)
+	RecordHypeTrainInflow(ctx context.Context, arg queries.RecordHypeTrainInflowParams) (uuid.UUID, error)
+}
+
+type TransactionResult struct {
+	FlowId uuid.UUID `json:"flowId"`
+}
@@ -0,0 +1,74 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golden-vcr/ledger/internal/admin"
+)
+
+// displayNameLookupTimeout is how long we'll wait for the Twitch API to tell us a
+// user's display name
+const displayNameLookupTimeout = 10 * time.Second
+
+// displayNameCache remembers the display names of users, so that activity reported via
+// the firehose stream can be attributed to users by name. Names are learned from the
+// claims of users who request notifications tokens, and, failing that, looked up via
+// the Twitch API in the background.
+type displayNameCache struct {
+	mu      sync.Mutex
+	names   map[string]string
+	pending map[string]struct{}
+	lookup  admin.LookupTwitchDisplayNameFunc
+}
+
+func newDisplayNameCache(lookup admin.LookupTwitchDisplayNameFunc) *displayNameCache {
+	return &displayNameCache{
+		names:   make(map[string]string),
+		pending: make(map[string]struct{}),
+		lookup:  lookup,
+	}
+}
+
+// remember records the display name of the given user
+func (c *displayNameCache) remember(twitchUserId string, displayName string) {
+	if displayName == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names[twitchUserId] = displayName
+}
+
+// get returns the display name of the given user, or an empty string if it's not yet
+// known. Rather than blocking, get starts looking up an unknown name in the background,
+// so that the name will be known for subsequent activity.
+func (c *displayNameCache) get(ctx context.Context, twitchUserId string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.names[twitchUserId]; ok {
+		return name
+	}
+	if _, ok := c.pending[twitchUserId]; ok || c.lookup == nil {
+		return ""
+	}
+	c.pending[twitchUserId] = struct{}{}
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, displayNameLookupTimeout)
+		defer cancel()
+		name, err := c.lookup(ctx, twitchUserId)
+		if err != nil {
+			fmt.Printf("Failed to look up display name for Twitch user %s: %v\n", twitchUserId, err)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.pending, twitchUserId)
+		if name != "" {
+			c.names[twitchUserId] = name
+		}
+	}()
+	return ""
+}
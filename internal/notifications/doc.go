@@ -1,4 +1,20 @@
 // Package notifications contains code that facilitates real-time notifications:
 // whenever a 'flow' record is created or updated in the database, we respond by sending
-// an event to all connected clients that are authenticated as the affected user
+// an event to all connected clients that are authenticated as the affected user.
+//
+// Every server instance receives changes from its own EventSource: in production, that
+// is a LISTEN connection to the database, and since Postgres delivers each NOTIFY to
+// every listener, any number of replicas may serve notifications behind a load
+// balancer. Subscribers don't depend on that source delivering every change, though:
+// the database numbers each user's changes contiguously (see ledger.flow_change), so
+// if a live event doesn't immediately follow the last one we sent to a client, we read
+// the changes it missed from the database and send those first.
+//
+// The same numbers are sent to clients as event IDs, so a client can verify for itself
+// that it hasn't missed an event, and can resume from the last one it saw via
+// Last-Event-ID. Whenever an EventSource may have failed to deliver some changes (e.g.
+// because its connection was interrupted and then reestablished), or a subscriber falls
+// too far behind, the affected clients are also sent a 'resync' event, since a client
+// that's still waiting for its next event would otherwise have no way of knowing that
+// it had missed one.
 package notifications
@@ -0,0 +1,56 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// eventTypeTransaction names the event sent whenever a transaction is created or
+	// updated, carrying a ledger.Transaction
+	eventTypeTransaction = "transaction"
+	// eventTypeBalance names the event that carries the user's new ledger.Balance after
+	// each change to one of their transactions
+	eventTypeBalance = "balance"
+)
+
+// eventSelection records which events an SSE client has opted into via the 'events'
+// URL parameter
+type eventSelection struct {
+	// named is true if the client opted into named events; otherwise it only receives
+	// transactions, as unnamed events
+	named        bool
+	transactions bool
+	balance      bool
+}
+
+// parseEventSelection interprets the values of the 'events' URL parameter, each of
+// which may be a comma-separated list of event types. If no values are given, the
+// client receives unnamed transaction events, as it did before named events existed.
+func parseEventSelection(values []string) (eventSelection, error) {
+	if len(values) == 0 {
+		return eventSelection{transactions: true}, nil
+	}
+	selection := eventSelection{named: true}
+	for _, value := range values {
+		for _, eventType := range strings.Split(value, ",") {
+			switch strings.TrimSpace(eventType) {
+			case eventTypeTransaction:
+				selection.transactions = true
+			case eventTypeBalance:
+				selection.balance = true
+			default:
+				return eventSelection{}, fmt.Errorf("unsupported event type '%s'", eventType)
+			}
+		}
+	}
+	return selection, nil
+}
+
+// transactionEventType returns the name with which transaction events should be sent
+func (e eventSelection) transactionEventType() string {
+	if e.named {
+		return eventTypeTransaction
+	}
+	return ""
+}
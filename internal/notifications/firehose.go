@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/internal/util"
+)
+
+func (s *Server) handlePostFirehose(res http.ResponseWriter, req *http.Request) {
+	s.issueToken(res, req, tokenScopeFirehose)
+}
+
+func (s *Server) handleGetFirehose(res http.ResponseWriter, req *http.Request) {
+	// If a content-type is explicitly requested, require that it's text/event-stream
+	accept := req.Header.Get("accept")
+	if accept != "" && accept != "*/*" && !strings.HasPrefix(accept, "text/event-stream") {
+		message := fmt.Sprintf("content-type %s is not supported", accept)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, message)
+		return
+	}
+
+	if _, ok := s.identifySubscriber(res, req, tokenScopeFirehose); !ok {
+		return
+	}
+
+	// The broadcaster may choose to only hear about certain kinds of activity
+	filter, err := parseFirehoseFilter(req.URL.Query())
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if !s.consumeToken(res, req, tokenScopeFirehose) {
+		return
+	}
+	sub := s.subscribers.registerFirehose(filter)
+	defer s.subscribers.unregisterFirehose(sub)
+
+	// Send every matching change to the client for as long as the connection is open,
+	// attributed to the user it affects
+	openEventStream(res)
+	fmt.Printf("Opened firehose SSE connection to %s...\n", req.RemoteAddr)
+	for {
+		select {
+		case <-time.After(30 * time.Second):
+			res.Write([]byte(":\n\n"))
+			res.(http.Flusher).Flush()
+		case event := <-sub.events:
+			writeEvent(res, "", event.changeSeq, ledger.Activity{
+				TwitchUserId:      event.twitchUserId,
+				TwitchDisplayName: event.twitchDisplayName,
+				Transaction:       *event.transaction,
+			})
+		case <-sub.overflow:
+			if s.subscribers.handleOverflow(sub) {
+				fmt.Printf("Firehose SSE connection to %s has fallen behind; disconnecting.\n", req.RemoteAddr)
+				return
+			}
+			res.Write([]byte("event: resync\ndata: {}\n\n"))
+			res.(http.Flusher).Flush()
+		case <-sub.gap:
+			s.subscribers.handleGap()
+			res.Write([]byte("event: resync\ndata: {}\n\n"))
+			res.(http.Flusher).Flush()
+		case <-s.ctx.Done():
+			fmt.Printf("Server is shutting down; abandoning firehose SSE connection to %s.\n", req.RemoteAddr)
+			return
+		case <-req.Context().Done():
+			fmt.Printf("Firehose SSE connection to %s has been closed.\n", req.RemoteAddr)
+			return
+		}
+	}
+}
+
+// parseFirehoseFilter builds a filter from the URL parameters supplied to GET
+// /notifications/firehose: 'type' may be repeated to only include transactions of the
+// given types, and 'minDeltaPoints' excludes any transaction whose deltaPoints value
+// is smaller in magnitude than the given number, so that large outflows are included
+// along with large inflows
+func parseFirehoseFilter(values url.Values) (func(event *transactionEvent) bool, error) {
+	types := make(map[ledger.TransactionType]struct{})
+	for _, value := range values["type"] {
+		if value == "" {
+			return nil, fmt.Errorf("'type' URL parameter must not be empty")
+		}
+		types[ledger.TransactionType(value)] = struct{}{}
+	}
+
+	minDeltaPoints := 0
+	if value := values.Get("minDeltaPoints"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("'minDeltaPoints' URL parameter must be a non-negative integer")
+		}
+		minDeltaPoints = parsed
+	}
+
+	return func(event *transactionEvent) bool {
+		if len(types) > 0 {
+			if _, ok := types[event.transaction.Type]; !ok {
+				return false
+			}
+		}
+		deltaPoints := event.transaction.DeltaPoints
+		if deltaPoints < 0 {
+			deltaPoints = -deltaPoints
+		}
+		return deltaPoints >= minDeltaPoints
+	}, nil
+}
@@ -0,0 +1,171 @@
+package notifications
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handleGetFirehose(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"returns 401 if provided token is not a firehose token",
+			"/notifications/firehose?token=mock-sse-token",
+			http.StatusUnauthorized,
+			`{"title":"Unauthorized","status":401,"code":"unauthorized","detail":"invalid token"}`,
+		},
+		{
+			"returns 400 if minDeltaPoints is not an integer",
+			"/notifications/firehose?token=mock-firehose-token&minDeltaPoints=lots",
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"'minDeltaPoints' URL parameter must be a non-negative integer"}`,
+		},
+		{
+			"streams activity for all users, with display names where known",
+			"/notifications/firehose?token=mock-firehose-token",
+			http.StatusOK,
+			":\n\n" +
+				"id: 42\ndata: {\"twitchUserId\":\"1001\",\"twitchDisplayName\":\"TestUser\",\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: foo\"}\n\n" +
+				"id: 43\ndata: {\"twitchUserId\":\"1002\",\"id\":\"5a9b1ad5-1d5e-4bb6-8f0b-bf3b8e1f38a4\",\"timestamp\":\"1997-09-01T12:01:00Z\",\"type\":\"cheer\",\"state\":\"pending\",\"deltaPoints\":5,\"description\":\"Thank you for cheering!\"}\n\n" +
+				"id: 44\ndata: {\"twitchUserId\":\"1001\",\"twitchDisplayName\":\"TestUser\",\"id\":\"0e1f2b3c-4d5e-4f60-8a7b-9c0d1e2f3a4b\",\"timestamp\":\"1997-09-01T12:02:00Z\",\"type\":\"alert-redemption\",\"state\":\"pending\",\"deltaPoints\":-250,\"description\":\"Redeemed alert of type 'ghost'\"}\n\n",
+		},
+		{
+			"filters activity by type",
+			"/notifications/firehose?token=mock-firehose-token&type=cheer",
+			http.StatusOK,
+			":\n\n" +
+				"id: 43\ndata: {\"twitchUserId\":\"1002\",\"id\":\"5a9b1ad5-1d5e-4bb6-8f0b-bf3b8e1f38a4\",\"timestamp\":\"1997-09-01T12:01:00Z\",\"type\":\"cheer\",\"state\":\"pending\",\"deltaPoints\":5,\"description\":\"Thank you for cheering!\"}\n\n",
+		},
+		{
+			"filters activity by minimum magnitude of deltaPoints",
+			"/notifications/firehose?token=mock-firehose-token&minDeltaPoints=100",
+			http.StatusOK,
+			":\n\n" +
+				"id: 42\ndata: {\"twitchUserId\":\"1001\",\"twitchDisplayName\":\"TestUser\",\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: foo\"}\n\n" +
+				"id: 44\ndata: {\"twitchUserId\":\"1001\",\"twitchDisplayName\":\"TestUser\",\"id\":\"0e1f2b3c-4d5e-4f60-8a7b-9c0d1e2f3a4b\",\"timestamp\":\"1997-09-01T12:02:00Z\",\"type\":\"alert-redemption\",\"state\":\"pending\",\"deltaPoints\":-250,\"description\":\"Redeemed alert of type 'ghost'\"}\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewBus()
+			s := &Server{
+				ctx: context.Background(),
+				q: &mockQueries{
+					tokens: []mockSseToken{
+						{
+							userId:    "1001",
+							value:     "mock-sse-token",
+							scope:     "user",
+							expiresAt: time.Now().Add(5 * time.Minute),
+						},
+						{
+							userId:    "90790024",
+							value:     "mock-firehose-token",
+							scope:     "firehose",
+							expiresAt: time.Now().Add(5 * time.Minute),
+						},
+					},
+				},
+				source:       bus,
+				subscribers:  newSubscriberChannels(32, OverflowPolicyResync),
+				displayNames: newDisplayNameCache(nil),
+			}
+			s.displayNames.remember("1001", "TestUser")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.ReadEvents(ctx)
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil).WithContext(ctx)
+			res := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
+			res.Code = 0
+			done := make(chan struct{})
+			go func() {
+				s.handleGetFirehose(res, req)
+				done <- struct{}{}
+			}()
+			for res.status() == 0 {
+				time.Sleep(10 * time.Nanosecond)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				cancel()
+				<-done
+				assert.Equal(t, tt.wantStatus, res.Code)
+				b, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				body := strings.TrimSuffix(string(b), "\n")
+				assert.Equal(t, tt.wantBody, body)
+				return
+			}
+			if res.status() != http.StatusOK {
+				t.Fatalf("did not get 200 response")
+			}
+
+			// Simulate changes to two different users' transactions
+			bus.Publish(&FlowChangeNotification{
+				TwitchUserId: "1001",
+				Id:           uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+				Type:         "manual-credit",
+				Metadata:     []byte(`{"note":"foo"}`),
+				DeltaPoints:  150,
+				CreatedAt:    time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+				ChangeSeq:    42,
+			})
+			bus.Publish(&FlowChangeNotification{
+				TwitchUserId: "1002",
+				Id:           uuid.MustParse("5a9b1ad5-1d5e-4bb6-8f0b-bf3b8e1f38a4"),
+				Type:         "cheer",
+				Metadata:     []byte(`{"numBits":5}`),
+				DeltaPoints:  5,
+				CreatedAt:    time.Date(1997, 9, 1, 12, 1, 0, 0, time.UTC),
+				ChangeSeq:    43,
+			})
+			bus.Publish(&FlowChangeNotification{
+				TwitchUserId: "1001",
+				Id:           uuid.MustParse("0e1f2b3c-4d5e-4f60-8a7b-9c0d1e2f3a4b"),
+				Type:         "alert-redemption",
+				Metadata:     []byte(`{"type":"ghost"}`),
+				DeltaPoints:  -250,
+				CreatedAt:    time.Date(1997, 9, 1, 12, 2, 0, 0, time.UTC),
+				ChangeSeq:    44,
+			})
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			<-done
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(b))
+		})
+	}
+}
+
+func Test_displayNameCache(t *testing.T) {
+	lookups := make(chan string, 1)
+	c := newDisplayNameCache(func(ctx context.Context, twitchUserId string) (string, error) {
+		lookups <- twitchUserId
+		return "LookedUpUser", nil
+	})
+	c.remember("1001", "TestUser")
+	assert.Equal(t, "TestUser", c.get(context.Background(), "1001"))
+
+	// An unknown name should be looked up in the background, and known thereafter
+	assert.Equal(t, "", c.get(context.Background(), "1002"))
+	assert.Equal(t, "1002", <-lookups)
+	for i := 0; i < 100 && c.get(context.Background(), "1002") == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "LookedUpUser", c.get(context.Background(), "1002"))
+}
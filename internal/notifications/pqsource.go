@@ -0,0 +1,96 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pqChannel is the channel on which the database announces changes to flows, via
+// pg_notify in the emit_flow_change_notification trigger function
+const pqChannel = "ledger_flow_change"
+
+// pqPingInterval is how long we'll go without hearing from the database before we
+// ping it, to make sure that a silently-dropped connection is noticed and reestablished
+const pqPingInterval = 90 * time.Second
+
+// PostgresEventSource is an EventSource that receives notifications from the database
+// via LISTEN. If the connection is lost, it reconnects automatically rather than
+// failing, and reports a gap once reconnected, since any notifications sent in the
+// meantime are lost.
+type PostgresEventSource struct {
+	listener      *pq.Listener
+	notifications chan *FlowChangeNotification
+	gaps          chan struct{}
+}
+
+// NewPostgresEventSource connects to the database and issues a LISTEN command. Once
+// Run is called, notifications will be delivered until the context is canceled.
+func NewPostgresEventSource(connectionString string) (*PostgresEventSource, error) {
+	listener := pq.NewListener(connectionString, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventConnected:
+			fmt.Printf("pq listener connected\n")
+		case pq.ListenerEventDisconnected:
+			fmt.Printf("pq listener disconnected; will attempt to reconnect (err: %v)\n", err)
+		case pq.ListenerEventReconnected:
+			fmt.Printf("pq listener reconnected\n")
+		case pq.ListenerEventConnectionAttemptFailed:
+			fmt.Printf("pq listener connection attempt failed; will retry (err: %v)\n", err)
+		}
+	})
+	if err := listener.Listen(pqChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to issue LISTEN command: %w", err)
+	}
+	return &PostgresEventSource{
+		listener:      listener,
+		notifications: make(chan *FlowChangeNotification),
+		gaps:          make(chan struct{}, 1),
+	}, nil
+}
+
+func (s *PostgresEventSource) Notifications() <-chan *FlowChangeNotification {
+	return s.notifications
+}
+
+func (s *PostgresEventSource) Gaps() <-chan struct{} {
+	return s.gaps
+}
+
+// Run reads notifications from the database until the context is canceled, then
+// closes the underlying listener
+func (s *PostgresEventSource) Run(ctx context.Context) {
+	defer s.listener.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pqPingInterval):
+			go s.listener.Ping()
+		case notification := <-s.listener.NotificationChannel():
+			// The listener sends nil after reestablishing a lost connection, since any
+			// notifications sent while it was disconnected were never received
+			if notification == nil {
+				fmt.Printf("pq listener may have missed notifications while disconnected\n")
+				reportGap(s.gaps)
+				continue
+			}
+			var event FlowChangeNotification
+			if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+				fmt.Printf("Failed to unmarshal extra data from notification: %v\n", err)
+				continue
+			}
+			select {
+			case s.notifications <- &event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+var _ EventSource = (*PostgresEventSource)(nil)
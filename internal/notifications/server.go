@@ -4,36 +4,71 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/admin"
 	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/gorilla/mux"
 )
 
+// replayPageSize is the number of changes we read from the database at a time when
+// replaying the changes that an SSE client missed while it was disconnected
+const replayPageSize = 100
+
+var (
+	// publishStatsOnce ensures that we only publish our expvar once per process, since
+	// expvar.Publish panics if the same name is published again
+	publishStatsOnce sync.Once
+	// publishedSubscribers holds the subscribers of the most recently initialized
+	// Server, whose stats are reported via expvar
+	publishedSubscribers atomic.Pointer[subscriberChannels]
+)
+
 type Server struct {
 	ctx           context.Context
 	q             Queries
 	generateToken GenerateTokenFunc
-	eventsChan    <-chan *FlowChangeNotification
-	subscribers   subscriberChannels
+	tokens        TokenPolicy
+	source        EventSource
+	subscribers   *subscriberChannels
+	displayNames  *displayNameCache
 }
 
-func NewServer(ctx context.Context, q Queries, eventsChan <-chan *FlowChangeNotification) *Server {
-	return &Server{
+// NewServer initializes a notifications server that delivers events from the given
+// source to each SSE client through a queue of up to queueSize events, applying the
+// given overflow policy to any client that falls further behind than that. Clients
+// connect using tokens that are issued in accordance with the given token policy.
+// Subscriber metrics are published via expvar under the name 'notifications' (for
+// whichever Server was initialized most recently, if there are several). The
+// display names of users whose activity is reported via the firehose stream are looked
+// up with lookupDisplayName if not already known.
+func NewServer(ctx context.Context, q Queries, source EventSource, queueSize int, policy OverflowPolicy, tokens TokenPolicy, lookupDisplayName admin.LookupTwitchDisplayNameFunc) *Server {
+	s := &Server{
 		ctx:           ctx,
 		q:             q,
 		generateToken: generateToken,
-		eventsChan:    eventsChan,
-		subscribers: subscriberChannels{
-			chans: make(map[string][]chan *ledger.Transaction),
-		},
+		tokens:        tokens,
+		source:        source,
+		subscribers:   newSubscriberChannels(queueSize, policy),
+		displayNames:  newDisplayNameCache(lookupDisplayName),
 	}
+	publishedSubscribers.Store(s.subscribers)
+	publishStatsOnce.Do(func() {
+		expvar.Publish("notifications", expvar.Func(func() any {
+			return publishedSubscribers.Load().stats()
+		}))
+	})
+	return s
 }
 
 func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
@@ -43,42 +78,82 @@ func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
 		),
 	)
 	r.Path("/notifications").Methods("GET").HandlerFunc(s.handleGetNotifications)
+	r.Path("/notifications/token").Methods("DELETE").Handler(
+		auth.RequireAccess(c, auth.RoleViewer,
+			http.HandlerFunc(s.handleDeleteNotificationsToken),
+		),
+	)
+	r.Path("/notifications/ws").Methods("GET").HandlerFunc(s.handleGetNotificationsWs)
+	r.Path("/notifications/firehose").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostFirehose),
+		),
+	)
+	r.Path("/notifications/firehose").Methods("GET").HandlerFunc(s.handleGetFirehose)
+	r.Path("/notifications/stats").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleGetNotificationsStats),
+		),
+	)
 }
 
-func (s *Server) ReadPostgresNotifications(ctx context.Context) {
+// ReadEvents reads from our event source until the context is canceled, broadcasting
+// each change to all interested subscribers. If the source reports that it may have
+// missed any changes, all subscribers are told to resync.
+func (s *Server) ReadEvents(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case event := <-s.eventsChan:
-			finalizedAt := sql.NullTime{}
-			if event.FinalizedAt != nil {
-				finalizedAt.Valid = true
-				finalizedAt.Time = *event.FinalizedAt
+		case <-s.source.Gaps():
+			fmt.Printf("Event source may have missed notifications; resyncing all subscribers.\n")
+			s.subscribers.reportGap()
+		case event := <-s.source.Notifications():
+			transaction := event.Transaction()
+
+			// Firehose subscribers want to know who each transaction belongs to, so
+			// attribute it to the user by name if we can
+			displayName := ""
+			if s.subscribers.hasFirehose() {
+				displayName = s.displayNames.get(s.ctx, event.TwitchUserId)
 			}
-			transaction := util.BuildTransaction(event.Id, event.Type, event.Metadata, event.DeltaPoints, event.CreatedAt, finalizedAt, event.Accepted)
-			s.subscribers.broadcast(event.TwitchUserId, &transaction)
+			s.subscribers.broadcast(event.TwitchUserId, &transactionEvent{
+				seq:               event.UserChangeSeq,
+				transaction:       &transaction,
+				changeSeq:         event.ChangeSeq,
+				twitchUserId:      event.TwitchUserId,
+				twitchDisplayName: displayName,
+			})
 		}
 	}
 }
 
 func (s *Server) handlePostNotifications(res http.ResponseWriter, req *http.Request) {
-	// Identify the user from their Twitch user access token
+	s.issueToken(res, req, tokenScopeUser)
+}
+
+// issueToken responds to a request to POST /notifications (or a similar endpoint) by
+// storing and returning a new token with the given scope
+func (s *Server) issueToken(res http.ResponseWriter, req *http.Request, scope string) {
+	// Identify the user from their Twitch user access token, taking the opportunity to
+	// learn their display name so that we can attribute their activity by name
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
+	s.displayNames.remember(claims.User.Id, claims.User.DisplayName)
 
 	// Generate a random, cryptographically secure token which can be used as a
 	// short-lived auth mechanism: the user can supply this to the SSE endpoint
-	// (GET /notifications) as a URL parameter, bypassing EventSource API's lack of
-	// support for Authorization header. The token expires after a few minutes and can
-	// only be used to subscribe to transaction history events; it does not grant access
-	// to any other resources.
+	// (GET /notifications, or GET /notifications/firehose for the firehose scope) as a
+	// URL parameter, bypassing EventSource API's lack of support for Authorization
+	// header. The token expires after a few minutes (or, if our policy is to issue
+	// single-use tokens, as soon as it's used) and can only be used to subscribe to
+	// transaction history events; it does not grant access to any other resources.
 	token, err := s.generateToken()
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
@@ -86,15 +161,16 @@ func (s *Server) handlePostNotifications(res http.ResponseWriter, req *http.Requ
 	// the database so we can look up our user ID when presented with the same token
 	// later (as long as it's within our TTL window)
 	if err := s.q.PurgeSseTokensForUser(req.Context(), claims.User.Id); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 	if err := s.q.StoreSseToken(req.Context(), queries.StoreSseTokenParams{
 		TwitchUserID: claims.User.Id,
 		TokenValue:   token,
-		TtlSeconds:   600,
+		TtlSeconds:   int32(s.tokens.Ttl / time.Second),
+		Scope:        scope,
 	}); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
@@ -103,44 +179,89 @@ func (s *Server) handlePostNotifications(res http.ResponseWriter, req *http.Requ
 	res.Write([]byte(token))
 }
 
+// handleDeleteNotificationsToken revokes all tokens that have been issued to the
+// calling user, e.g. when they log out. Streams that are already open are unaffected.
+func (s *Server) handleDeleteNotificationsToken(res http.ResponseWriter, req *http.Request) {
+	claims, err := auth.GetClaims(req)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	if _, err := s.q.RevokeSseTokensForUser(req.Context(), claims.User.Id); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) handleGetNotifications(res http.ResponseWriter, req *http.Request) {
 	// If a content-type is explicitly requested, require that it's text/event-stream
 	accept := req.Header.Get("accept")
 	if accept != "" && accept != "*/*" && !strings.HasPrefix(accept, "text/event-stream") {
 		message := fmt.Sprintf("content-type %s is not supported", accept)
-		http.Error(res, message, http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, message)
 		return
 	}
 
-	token := req.URL.Query().Get("token")
-	if token == "" {
-		http.Error(res, "'token' URL parameter must be set", http.StatusUnauthorized)
+	twitchUserId, ok := s.identifySubscriber(res, req, tokenScopeUser)
+	if !ok {
 		return
 	}
-	twitchUserId, err := s.q.IdentifyUserFromSseToken(context.Background(), token)
-	if err == sql.ErrNoRows {
-		http.Error(res, "invalid token", http.StatusUnauthorized)
+
+	// Clients may opt into named 'transaction' and/or 'balance' events; by default, they
+	// only receive each transaction as an unnamed event
+	selection, err := parseEventSelection(req.URL.Query()["events"])
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
 		return
 	}
+
+	// If the client is reconnecting, it will tell us the ID of the last event it saw, so
+	// that we can replay any changes it missed in the meantime
+	lastEventId, err := parseLastEventId(req.Header.Get("last-event-id"))
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "Last-Event-ID header must be a valid event ID")
 		return
 	}
 
-	transactionsChan := s.subscribers.register(twitchUserId)
-	defer s.subscribers.unregister(twitchUserId, transactionsChan)
+	// Start listening for live events before we look up any changes to replay, so that
+	// no change can slip through the gap between the two
+	sub := s.subscribers.register(twitchUserId)
+	defer s.subscribers.unregister(twitchUserId, sub)
 
-	// Keep the connection alive and open a text/event-stream response body
-	res.Header().Set("content-type", "text/event-stream")
-	res.Header().Set("cache-control", "no-cache")
-	res.Header().Set("connection", "keep-alive")
-	res.WriteHeader(http.StatusOK)
-	res.(http.Flusher).Flush()
+	var replay []*transactionEvent
+	if lastEventId >= 0 {
+		replay, err = s.getChangesSince(req.Context(), twitchUserId, lastEventId)
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInternal, err.Error())
+			return
+		}
+	}
 
-	// Send an initial empty value to flush the connection and ensure that any
-	// intermediaries (Cloudflare etc) will send the initial HTTP response promptly
-	res.Write([]byte(":\n\n"))
-	res.(http.Flusher).Flush()
+	// The request is valid, so if our tokens are single-use, consume this one before we
+	// start streaming
+	if !s.consumeToken(res, req, tokenScopeUser) {
+		return
+	}
+	openEventStream(res)
+
+	// Catch the client up on any changes it missed, keeping track of the last change
+	// we've sent so that we don't repeat any of the same changes once they arrive as
+	// live events
+	lastSeq := lastEventId
+	lastReplayedSeq := int64(-1)
+	for _, event := range replay {
+		if selection.transactions {
+			writeEvent(res, selection.transactionEventType(), event.seq, event.transaction)
+		}
+		lastSeq = event.seq
+		lastReplayedSeq = event.seq
+	}
+
+	// If the client wants balance updates, start by telling it the current balance
+	if selection.balance {
+		s.writeBalanceEvent(req.Context(), res, twitchUserId, lastReplayedSeq)
+	}
 
 	// Send all incoming messages to the client for as long as the connection is open
 	fmt.Printf("Opened SSE connection to %s...\n", req.RemoteAddr)
@@ -149,14 +270,44 @@ func (s *Server) handleGetNotifications(res http.ResponseWriter, req *http.Reque
 		case <-time.After(30 * time.Second):
 			res.Write([]byte(":\n\n"))
 			res.(http.Flusher).Flush()
-		case transaction := <-transactionsChan:
-			data, err := json.Marshal(transaction)
+		case event := <-sub.events:
+			events, err := s.getEventsToSend(req.Context(), twitchUserId, lastSeq, event)
 			if err != nil {
-				fmt.Printf("Failed to serialize transaction as JSON: %v\n", err)
+				// We know that we never received some changes, but we can't look them
+				// up, so the client must resync in order to be sure it's up to date
+				fmt.Printf("Failed to look up changes missed by SSE client: %v\n", err)
+				s.subscribers.handleGap()
+				res.Write([]byte("event: resync\ndata: {}\n\n"))
+				res.(http.Flusher).Flush()
+				lastSeq = -1
 				continue
 			}
-			fmt.Fprintf(res, "data: %s\n\n", data)
+			for _, e := range events {
+				if selection.transactions {
+					writeEvent(res, selection.transactionEventType(), e.seq, e.transaction)
+				}
+				lastSeq = e.seq
+			}
+			if len(events) > 0 && selection.balance {
+				s.writeBalanceEvent(req.Context(), res, twitchUserId, lastSeq)
+			}
+		case <-sub.overflow:
+			// This client has fallen too far behind: either disconnect it, or discard
+			// its backlog and tell it to resync, depending on our overflow policy
+			if s.subscribers.handleOverflow(sub) {
+				fmt.Printf("SSE connection to %s has fallen behind; disconnecting.\n", req.RemoteAddr)
+				return
+			}
+			res.Write([]byte("event: resync\ndata: {}\n\n"))
+			res.(http.Flusher).Flush()
+			lastSeq = -1
+		case <-sub.gap:
+			// Changes may have been lost before they reached us, so the client must
+			// resync in order to be sure it's up to date
+			s.subscribers.handleGap()
+			res.Write([]byte("event: resync\ndata: {}\n\n"))
 			res.(http.Flusher).Flush()
+			lastSeq = -1
 		case <-s.ctx.Done():
 			fmt.Printf("Server is shutting down; abandoning SSE connection to %s.\n", req.RemoteAddr)
 			return
@@ -166,3 +317,191 @@ func (s *Server) handleGetNotifications(res http.ResponseWriter, req *http.Reque
 		}
 	}
 }
+
+// identifySubscriber resolves the short-lived token supplied in the 'token' URL
+// parameter to the ID of the user who requested it via POST /notifications (or, for
+// the firehose scope, via POST /notifications/firehose). If the token is missing or
+// invalid, an error response is written and ok is false.
+func (s *Server) identifySubscriber(res http.ResponseWriter, req *http.Request, scope string) (twitchUserId string, ok bool) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		util.Error(res, ledger.ErrorCodeUnauthorized, "'token' URL parameter must be set")
+		return "", false
+	}
+	twitchUserId, err := s.q.IdentifyUserFromSseToken(context.Background(), queries.IdentifyUserFromSseTokenParams{
+		TokenValue: token,
+		Scope:      scope,
+	})
+	if err == sql.ErrNoRows {
+		util.Error(res, ledger.ErrorCodeUnauthorized, "invalid token")
+		return "", false
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return "", false
+	}
+	return twitchUserId, true
+}
+
+// consumeToken deletes the token supplied in the 'token' URL parameter if our policy
+// is to issue single-use tokens, so that it can't be used to open another stream. It
+// should be called once a request has been validated and we're ready to respond. If
+// the token has already been consumed (e.g. by a concurrent request), an error response
+// is written and ok is false.
+func (s *Server) consumeToken(res http.ResponseWriter, req *http.Request, scope string) (ok bool) {
+	err := s.consumeTokenValue(req.Context(), req.URL.Query().Get("token"), scope)
+	if err == sql.ErrNoRows {
+		util.Error(res, ledger.ErrorCodeUnauthorized, "invalid token")
+		return false
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return false
+	}
+	return true
+}
+
+// consumeTokenValue deletes the given token if our policy is to issue single-use
+// tokens, returning sql.ErrNoRows if it has already been consumed
+func (s *Server) consumeTokenValue(ctx context.Context, token string, scope string) error {
+	if !s.tokens.SingleUse {
+		return nil
+	}
+	_, err := s.q.ConsumeSseToken(ctx, queries.ConsumeSseTokenParams{
+		TokenValue: token,
+		Scope:      scope,
+	})
+	return err
+}
+
+// parseLastEventId parses the ID of the last event a reconnecting client received,
+// returning -1 if no ID was supplied
+func parseLastEventId(value string) (int64, error) {
+	if value == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	if id < 0 {
+		return -1, fmt.Errorf("event ID must not be negative")
+	}
+	return id, nil
+}
+
+// getChangesSince returns an event for every change that has been made to the given
+// user's transactions since the change with the given sequence number, in order, so
+// that the events follow on from seq without any gaps. Each event describes the
+// current state of the changed transaction, so a transaction that changed several
+// times is described in the same way each time.
+func (s *Server) getChangesSince(ctx context.Context, twitchUserId string, seq int64) ([]*transactionEvent, error) {
+	events := make([]*transactionEvent, 0)
+	for {
+		rows, err := s.q.GetFlowChangesSince(ctx, queries.GetFlowChangesSinceParams{
+			TwitchUserID: twitchUserId,
+			Seq:          seq,
+			NumRecords:   replayPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			row := &rows[i]
+			transaction := util.BuildTransaction(row.ID, row.Type, row.Metadata, int(row.DeltaPoints), row.CreatedAt, row.FinalizedAt, row.Accepted, row.DescriptionTemplate.String, 0)
+			events = append(events, &transactionEvent{
+				seq:         row.Seq,
+				transaction: &transaction,
+			})
+			seq = row.Seq
+		}
+		if len(rows) < replayPageSize {
+			return events, nil
+		}
+	}
+}
+
+// getEventsToSend determines which events a user's client should be sent upon
+// receiving the given live event, given the sequence number of the last event that the
+// client was sent (or -1 if it has yet to be sent any, or has just been told to
+// resync). Since each user's changes are numbered contiguously, an event that the
+// client has already been sent (e.g. as part of a replay) is skipped, and an event
+// that doesn't immediately follow the last one shows that some changes never reached
+// us (e.g. because a notification was lost): in that case, we read every change that
+// the client missed from the database, so that it's caught up without any gaps.
+func (s *Server) getEventsToSend(ctx context.Context, twitchUserId string, lastSeq int64, event *transactionEvent) ([]*transactionEvent, error) {
+	if lastSeq < 0 || event.seq == lastSeq+1 {
+		return []*transactionEvent{event}, nil
+	}
+	if event.seq <= lastSeq {
+		return nil, nil
+	}
+	return s.getChangesSince(ctx, twitchUserId, lastSeq)
+}
+
+// openEventStream begins a text/event-stream response, which will be kept alive until
+// the caller returns
+func openEventStream(res http.ResponseWriter) {
+	// Keep the connection alive and open a text/event-stream response body
+	res.Header().Set("content-type", "text/event-stream")
+	res.Header().Set("cache-control", "no-cache")
+	res.Header().Set("connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.(http.Flusher).Flush()
+
+	// Send an initial empty value to flush the connection and ensure that any
+	// intermediaries (Cloudflare etc) will send the initial HTTP response promptly
+	res.Write([]byte(":\n\n"))
+	res.(http.Flusher).Flush()
+}
+
+// writeBalanceEvent looks up the given user's current balance and sends it to an SSE
+// client as a 'balance' event, identified by the sequence number of the change that
+// prompted it
+func (s *Server) writeBalanceEvent(ctx context.Context, res http.ResponseWriter, twitchUserId string, seq int64) {
+	balance, err := s.getBalance(ctx, twitchUserId)
+	if err != nil {
+		fmt.Printf("Failed to get balance for SSE client: %v\n", err)
+		return
+	}
+	writeEvent(res, eventTypeBalance, seq, balance)
+}
+
+// getBalance returns the given user's current balance, defaulting to 0 if no record
+// exists
+func (s *Server) getBalance(ctx context.Context, twitchUserId string) (ledger.Balance, error) {
+	balance := ledger.Balance{}
+	row, err := s.q.GetBalance(ctx, twitchUserId)
+	if err == nil {
+		balance.TotalPoints = int(row.TotalPoints)
+		balance.AvailablePoints = int(row.AvailablePoints)
+	} else if err != sql.ErrNoRows {
+		return ledger.Balance{}, err
+	}
+	return balance, nil
+}
+
+// writeEvent sends a single message to an SSE client, named with the given event type
+// unless it's empty. If seq is non-negative, it's used as the event ID so that the
+// client can resume from that point if it needs to reconnect.
+func writeEvent(res http.ResponseWriter, eventType string, seq int64, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		fmt.Printf("Failed to serialize %T as JSON: %v\n", value, err)
+		return
+	}
+	if eventType != "" {
+		fmt.Fprintf(res, "event: %s\n", eventType)
+	}
+	if seq >= 0 {
+		fmt.Fprintf(res, "id: %d\n", seq)
+	}
+	fmt.Fprintf(res, "data: %s\n\n", data)
+	res.(http.Flusher).Flush()
+}
+
+func (s *Server) handleGetNotificationsStats(res http.ResponseWriter, req *http.Request) {
+	if err := json.NewEncoder(res).Encode(s.subscribers.stats()); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
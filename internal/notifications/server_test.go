@@ -3,21 +3,37 @@ package notifications
 import (
 	"context"
 	"database/sql"
+	"expvar"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golden-vcr/auth"
 	authmock "github.com/golden-vcr/auth/mock"
-	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
+func Test_NewServer_publishesStatsOnce(t *testing.T) {
+	// Initializing more than one server in the same process should not panic, and
+	// expvar should report on the most recent one
+	tokens := TokenPolicy{Ttl: time.Minute}
+	NewServer(context.Background(), &mockQueries{}, NewBus(), 32, OverflowPolicyDrop, tokens, nil)
+	s := NewServer(context.Background(), &mockQueries{}, NewBus(), 32, OverflowPolicyDrop, tokens, nil)
+	s.subscribers.register("1001")
+
+	v := expvar.Get("notifications")
+	if assert.NotNil(t, v) {
+		assert.Contains(t, v.String(), `"numSubscribers":1`)
+	}
+}
+
 func Test_Server_handlePostNotifications(t *testing.T) {
 	tests := []struct {
 		name                string
@@ -42,6 +58,7 @@ func Test_Server_handlePostNotifications(t *testing.T) {
 					{
 						userId:    "1001",
 						value:     "old-sse-token",
+						scope:     "user",
 						expiresAt: time.Now().Add(-4 * time.Hour),
 					},
 				},
@@ -71,6 +88,8 @@ func Test_Server_handlePostNotifications(t *testing.T) {
 				ctx:           context.Background(),
 				q:             tt.q,
 				generateToken: mockGenerateToken,
+				tokens:        TokenPolicy{Ttl: 10 * time.Minute},
+				displayNames:  newDisplayNameCache(nil),
 			}
 			f := http.HandlerFunc(s.handlePostNotifications)
 			handler := auth.RequireAccess(authClient, auth.RoleViewer, f)
@@ -90,6 +109,8 @@ func Test_Server_handlePostNotifications(t *testing.T) {
 				assert.Len(t, tt.q.tokens, tt.wantNumTokensStored)
 				assert.Equal(t, "1001", tt.q.tokens[0].userId)
 				assert.Equal(t, "mock-sse-token", tt.q.tokens[0].value)
+				assert.Equal(t, "user", tt.q.tokens[0].scope)
+				assert.WithinDuration(t, time.Now().Add(10*time.Minute), tt.q.tokens[0].expiresAt, time.Minute)
 			} else {
 				assert.Empty(t, tt.q.tokens)
 			}
@@ -102,7 +123,8 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 		name                     string
 		q                        *mockQueries
 		url                      string
-		generateNotificationFunc func(ch chan *FlowChangeNotification)
+		lastEventId              string
+		generateNotificationFunc func(bus *Bus)
 		wantStatus               int
 		wantBody                 string
 	}{
@@ -110,17 +132,19 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 			"returns 401 if no sse token is provided",
 			&mockQueries{},
 			"/notifications",
-			func(ch chan *FlowChangeNotification) {},
+			"",
+			func(bus *Bus) {},
 			http.StatusUnauthorized,
-			"'token' URL parameter must be set",
+			`{"title":"Unauthorized","status":401,"code":"unauthorized","detail":"'token' URL parameter must be set"}`,
 		},
 		{
 			"returns 401 if provided sse token is invalid",
 			&mockQueries{},
 			"/notifications?token=bad-sse-token",
-			func(ch chan *FlowChangeNotification) {},
+			"",
+			func(bus *Bus) {},
 			http.StatusUnauthorized,
-			"invalid token",
+			`{"title":"Unauthorized","status":401,"code":"unauthorized","detail":"invalid token"}`,
 		},
 		{
 			"accepts sse token in URL and provides access to associated user's real-time transaction events",
@@ -129,35 +153,281 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 					{
 						userId:    "1001",
 						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+			},
+			"/notifications?token=mock-sse-token",
+			"",
+			func(bus *Bus) {
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"foo"}`),
+					DeltaPoints:   150,
+					CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+					UserChangeSeq: 42,
+				})
+			},
+			http.StatusOK,
+			":\n\nid: 42\ndata: {\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: foo\"}\n\n",
+		},
+		{
+			"returns 400 if Last-Event-ID is not a valid event ID",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+			},
+			"/notifications?token=mock-sse-token",
+			"foo",
+			func(bus *Bus) {},
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"Last-Event-ID header must be a valid event ID"}`,
+		},
+		{
+			"tells the client to resync if the event source may have missed changes",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+			},
+			"/notifications?token=mock-sse-token",
+			"",
+			func(bus *Bus) {
+				bus.ReportGap()
+			},
+			http.StatusOK,
+			":\n\nevent: resync\ndata: {}\n\n",
+		},
+		{
+			"replays changes since Last-Event-ID, then sends live events without repeating any",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
 						expiresAt: time.Now().Add(5 * time.Minute),
 					},
 				},
+				changes: []queries.GetFlowChangesSinceRow{
+					{
+						ID:          uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+						Type:        "manual-credit",
+						Metadata:    []byte(`{"note":"seen"}`),
+						DeltaPoints: 100,
+						CreatedAt:   time.Date(1997, 9, 1, 11, 0, 0, 0, time.UTC),
+						Seq:         41,
+					},
+					{
+						ID:          uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+						Type:        "manual-credit",
+						Metadata:    []byte(`{"note":"missed"}`),
+						DeltaPoints: 150,
+						CreatedAt:   time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						Seq:         42,
+					},
+				},
+			},
+			"/notifications?token=mock-sse-token",
+			"41",
+			func(bus *Bus) {
+				// The change we've already replayed arrives late, then a new one
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"missed"}`),
+					DeltaPoints:   150,
+					CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+					UserChangeSeq: 42,
+				})
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"live"}`),
+					DeltaPoints:   200,
+					CreatedAt:     time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+					UserChangeSeq: 43,
+				})
+			},
+			http.StatusOK,
+			":\n\n" +
+				"id: 42\ndata: {\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: missed\"}\n\n" +
+				"id: 43\ndata: {\"id\":\"0db47d1c-41f9-4808-bc8d-bf097eeb6319\",\"timestamp\":\"1997-09-01T13:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":200,\"description\":\"Manual credit: live\"}\n\n",
+		},
+		{
+			"catches up on changes that never arrived as live events",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+				changes: []queries.GetFlowChangesSinceRow{
+					{
+						ID:          uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+						Type:        "manual-credit",
+						Metadata:    []byte(`{"note":"first"}`),
+						DeltaPoints: 150,
+						CreatedAt:   time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						Seq:         42,
+					},
+					{
+						ID:          uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+						Type:        "manual-credit",
+						Metadata:    []byte(`{"note":"lost"}`),
+						DeltaPoints: 100,
+						CreatedAt:   time.Date(1997, 9, 1, 12, 30, 0, 0, time.UTC),
+						Seq:         43,
+					},
+					{
+						ID:          uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+						Type:        "manual-credit",
+						Metadata:    []byte(`{"note":"third"}`),
+						DeltaPoints: 200,
+						CreatedAt:   time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						Seq:         44,
+					},
+				},
 			},
 			"/notifications?token=mock-sse-token",
-			func(ch chan *FlowChangeNotification) {
-				ch <- &FlowChangeNotification{
-					TwitchUserId: "1001",
-					Id:           uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
-					Type:         "manual-credit",
-					Metadata:     []byte(`{"note":"foo"}`),
-					DeltaPoints:  150,
-					CreatedAt:    time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
-				}
+			"",
+			func(bus *Bus) {
+				// The notification for change 43 never arrives, so change 44 reveals a gap
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"first"}`),
+					DeltaPoints:   150,
+					CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+					UserChangeSeq: 42,
+				})
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"third"}`),
+					DeltaPoints:   200,
+					CreatedAt:     time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+					UserChangeSeq: 44,
+				})
+			},
+			http.StatusOK,
+			":\n\n" +
+				"id: 42\ndata: {\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: first\"}\n\n" +
+				"id: 43\ndata: {\"id\":\"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f\",\"timestamp\":\"1997-09-01T12:30:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":100,\"description\":\"Manual credit: lost\"}\n\n" +
+				"id: 44\ndata: {\"id\":\"0db47d1c-41f9-4808-bc8d-bf097eeb6319\",\"timestamp\":\"1997-09-01T13:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":200,\"description\":\"Manual credit: third\"}\n\n",
+		},
+		{
+			"returns 400 if an unsupported event type is requested",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+			},
+			"/notifications?token=mock-sse-token&events=transaction,foo",
+			"",
+			func(bus *Bus) {},
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"unsupported event type 'foo'"}`,
+		},
+		{
+			"sends named transaction and balance events if requested",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+				balances: map[string]queries.GetBalanceRow{
+					"1001": {TotalPoints: 150, AvailablePoints: 150},
+				},
+			},
+			"/notifications?token=mock-sse-token&events=transaction,balance",
+			"",
+			func(bus *Bus) {
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"foo"}`),
+					DeltaPoints:   150,
+					CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+					UserChangeSeq: 42,
+				})
+			},
+			http.StatusOK,
+			":\n\n" +
+				"event: balance\ndata: {\"totalPoints\":150,\"availablePoints\":150}\n\n" +
+				"event: transaction\nid: 42\ndata: {\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: foo\"}\n\n" +
+				"event: balance\nid: 42\ndata: {\"totalPoints\":150,\"availablePoints\":150}\n\n",
+		},
+		{
+			"sends only balance events if requested",
+			&mockQueries{
+				tokens: []mockSseToken{
+					{
+						userId:    "1001",
+						value:     "mock-sse-token",
+						scope:     "user",
+						expiresAt: time.Now().Add(5 * time.Minute),
+					},
+				},
+			},
+			"/notifications?token=mock-sse-token&events=balance",
+			"",
+			func(bus *Bus) {
+				bus.Publish(&FlowChangeNotification{
+					TwitchUserId:  "1001",
+					Id:            uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+					Type:          "manual-credit",
+					Metadata:      []byte(`{"note":"foo"}`),
+					DeltaPoints:   150,
+					CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+					UserChangeSeq: 42,
+				})
 			},
 			http.StatusOK,
-			":\n\ndata: {\"id\":\"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6\",\"timestamp\":\"1997-09-01T12:00:00Z\",\"type\":\"manual-credit\",\"state\":\"pending\",\"deltaPoints\":150,\"description\":\"Manual credit: foo\"}\n\n",
+			":\n\n" +
+				"event: balance\ndata: {\"totalPoints\":0,\"availablePoints\":0}\n\n" +
+				"event: balance\nid: 42\ndata: {\"totalPoints\":0,\"availablePoints\":0}\n\n",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			eventsChan := make(chan *FlowChangeNotification)
+			bus := NewBus()
 			s := &Server{
-				ctx:        context.Background(),
-				q:          tt.q,
-				eventsChan: eventsChan,
-				subscribers: subscriberChannels{
-					chans: make(map[string][]chan *ledger.Transaction),
-				},
+				ctx:         context.Background(),
+				q:           tt.q,
+				source:      bus,
+				subscribers: newSubscriberChannels(32, OverflowPolicyResync),
 			}
 
 			// Prepare a context that we can cancel in order to shut down all server
@@ -165,14 +435,17 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			// Read from eventsChan and fan out to all connected SSE clients for as long
+			// Read from our event bus and fan out to all connected SSE clients for as long
 			// as that context is alive
-			go s.ReadPostgresNotifications(ctx)
+			go s.ReadEvents(ctx)
 
 			// Preemptively clear our status code, then run our SSE request handler in
 			// another goroutine until our context is canceled
 			req := httptest.NewRequest(http.MethodGet, tt.url, nil).WithContext(ctx)
-			res := httptest.NewRecorder()
+			if tt.lastEventId != "" {
+				req.Header.Set("last-event-id", tt.lastEventId)
+			}
+			res := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
 			res.Code = 0
 			done := make(chan struct{})
 			go func() {
@@ -181,7 +454,7 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 			}()
 
 			// Wait until we get an initial response from the server
-			for res.Code == 0 {
+			for res.status() == 0 {
 				time.Sleep(10 * time.Nanosecond)
 			}
 
@@ -196,7 +469,7 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 				assert.Equal(t, tt.wantBody, body)
 				return
 			}
-			if res.Code != http.StatusOK {
+			if res.status() != http.StatusOK {
 				t.Fatalf("did not get 200 response")
 			}
 
@@ -206,7 +479,7 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 
 			// Simulate postgres notifications, then wait for the response to propagate
 			// over HTTP and verify that we got the expected message(s)
-			tt.generateNotificationFunc(eventsChan)
+			tt.generateNotificationFunc(bus)
 			time.Sleep(10 * time.Millisecond)
 			cancel()
 			<-done
@@ -217,17 +490,131 @@ func Test_Server_handleGetNotifications(t *testing.T) {
 	}
 }
 
+func Test_Server_handleGetNotifications_singleUseToken(t *testing.T) {
+	s := &Server{
+		ctx: context.Background(),
+		q: &mockQueries{
+			tokens: []mockSseToken{
+				{
+					userId:    "1001",
+					value:     "mock-sse-token",
+					scope:     "user",
+					expiresAt: time.Now().Add(5 * time.Minute),
+				},
+			},
+		},
+		tokens:      TokenPolicy{Ttl: 10 * time.Minute, SingleUse: true},
+		source:      NewBus(),
+		subscribers: newSubscriberChannels(32, OverflowPolicyResync),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An invalid request should not consume the token
+	req := httptest.NewRequest(http.MethodGet, "/notifications?token=mock-sse-token&events=foo", nil)
+	res := httptest.NewRecorder()
+	s.handleGetNotifications(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	// The first valid request should open a stream, consuming the token
+	req = httptest.NewRequest(http.MethodGet, "/notifications?token=mock-sse-token", nil).WithContext(ctx)
+	streamRes := &lockedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	streamRes.Code = 0
+	done := make(chan struct{})
+	go func() {
+		s.handleGetNotifications(streamRes, req)
+		done <- struct{}{}
+	}()
+	for streamRes.status() == 0 {
+		time.Sleep(10 * time.Nanosecond)
+	}
+	assert.Equal(t, http.StatusOK, streamRes.status())
+
+	// Any subsequent request with the same token should be rejected
+	req = httptest.NewRequest(http.MethodGet, "/notifications?token=mock-sse-token", nil)
+	res = httptest.NewRecorder()
+	s.handleGetNotifications(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	cancel()
+	<-done
+}
+
+func Test_Server_handleDeleteNotificationsToken(t *testing.T) {
+	authClient := authmock.NewClient().AllowTwitchUserAccessToken("mock-token", auth.RoleViewer, auth.UserDetails{
+		Id:          "1001",
+		Login:       "testuser",
+		DisplayName: "TestUser",
+	})
+	q := &mockQueries{
+		tokens: []mockSseToken{
+			{userId: "1001", value: "sse-token-a", scope: "user", expiresAt: time.Now().Add(5 * time.Minute)},
+			{userId: "1001", value: "sse-token-b", scope: "user", expiresAt: time.Now().Add(5 * time.Minute)},
+			{userId: "1002", value: "sse-token-c", scope: "user", expiresAt: time.Now().Add(5 * time.Minute)},
+		},
+	}
+	s := &Server{
+		ctx: context.Background(),
+		q:   q,
+	}
+	handler := auth.RequireAccess(authClient, auth.RoleViewer, http.HandlerFunc(s.handleDeleteNotificationsToken))
+
+	req := httptest.NewRequest(http.MethodDelete, "/notifications/token", nil)
+	req.Header.Set("authorization", "mock-token")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	// All of the caller's tokens should be revoked, leaving other users' tokens intact
+	assert.Len(t, q.tokens, 1)
+	assert.Equal(t, "sse-token-c", q.tokens[0].value)
+}
+
+// lockedRecorder wraps httptest.ResponseRecorder so that a test can safely poll for the
+// response status while a streaming handler is still writing to it
+type lockedRecorder struct {
+	*httptest.ResponseRecorder
+	mu sync.Mutex
+}
+
+func (r *lockedRecorder) WriteHeader(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func (r *lockedRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ResponseRecorder.Write(b)
+}
+
+func (r *lockedRecorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ResponseRecorder.Flush()
+}
+
+func (r *lockedRecorder) status() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Code
+}
+
 func mockGenerateToken() (string, error) {
 	return "mock-sse-token", nil
 }
 
 type mockQueries struct {
-	tokens []mockSseToken
+	tokens   []mockSseToken
+	changes  []queries.GetFlowChangesSinceRow
+	balances map[string]queries.GetBalanceRow
 }
 
 type mockSseToken struct {
 	userId    string
 	value     string
+	scope     string
 	expiresAt time.Time
 }
 
@@ -235,7 +622,8 @@ func (m *mockQueries) StoreSseToken(ctx context.Context, arg queries.StoreSseTok
 	m.tokens = append(m.tokens, mockSseToken{
 		userId:    arg.TwitchUserID,
 		value:     arg.TokenValue,
-		expiresAt: time.Now().Add(time.Duration(arg.TtlSeconds * int32(time.Second))),
+		scope:     arg.Scope,
+		expiresAt: time.Now().Add(time.Duration(arg.TtlSeconds) * time.Second),
 	})
 	return nil
 }
@@ -251,11 +639,75 @@ func (m *mockQueries) PurgeSseTokensForUser(ctx context.Context, twitchUserID st
 	return nil
 }
 
-func (m *mockQueries) IdentifyUserFromSseToken(ctx context.Context, tokenValue string) (string, error) {
+func (m *mockQueries) IdentifyUserFromSseToken(ctx context.Context, arg queries.IdentifyUserFromSseTokenParams) (string, error) {
 	for _, token := range m.tokens {
-		if token.value == tokenValue && token.expiresAt.After(time.Now()) {
+		if token.value == arg.TokenValue && token.scope == arg.Scope && token.expiresAt.After(time.Now()) {
+			return token.userId, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (m *mockQueries) ConsumeSseToken(ctx context.Context, arg queries.ConsumeSseTokenParams) (string, error) {
+	for i, token := range m.tokens {
+		if token.value == arg.TokenValue && token.scope == arg.Scope && token.expiresAt.After(time.Now()) {
+			m.tokens = append(m.tokens[:i], m.tokens[i+1:]...)
 			return token.userId, nil
 		}
 	}
 	return "", sql.ErrNoRows
 }
+
+func (m *mockQueries) RevokeSseTokensForUser(ctx context.Context, twitchUserID string) (sql.Result, error) {
+	tokensToKeep := make([]mockSseToken, 0, len(m.tokens))
+	for _, token := range m.tokens {
+		if token.userId != twitchUserID {
+			tokensToKeep = append(tokensToKeep, token)
+		}
+	}
+	numRevoked := len(m.tokens) - len(tokensToKeep)
+	m.tokens = tokensToKeep
+	return &mockSqlResult{numRows: int64(numRevoked)}, nil
+}
+
+func (m *mockQueries) PurgeExpiredSseTokens(ctx context.Context) (sql.Result, error) {
+	tokensToKeep := make([]mockSseToken, 0, len(m.tokens))
+	for _, token := range m.tokens {
+		if token.expiresAt.After(time.Now()) {
+			tokensToKeep = append(tokensToKeep, token)
+		}
+	}
+	numPurged := len(m.tokens) - len(tokensToKeep)
+	m.tokens = tokensToKeep
+	return &mockSqlResult{numRows: int64(numPurged)}, nil
+}
+
+func (m *mockQueries) GetFlowChangesSince(ctx context.Context, arg queries.GetFlowChangesSinceParams) ([]queries.GetFlowChangesSinceRow, error) {
+	rows := make([]queries.GetFlowChangesSinceRow, 0)
+	for _, change := range m.changes {
+		if change.Seq > arg.Seq && len(rows) < int(arg.NumRecords) {
+			rows = append(rows, change)
+		}
+	}
+	return rows, nil
+}
+
+func (m *mockQueries) GetBalance(ctx context.Context, twitchUserID string) (queries.GetBalanceRow, error) {
+	row, ok := m.balances[twitchUserID]
+	if !ok {
+		return queries.GetBalanceRow{}, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+type mockSqlResult struct {
+	numRows int64
+}
+
+func (m *mockSqlResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("not mocked")
+}
+
+func (m *mockSqlResult) RowsAffected() (int64, error) {
+	return m.numRows, nil
+}
@@ -0,0 +1,58 @@
+package notifications
+
+// EventSource delivers a notification whenever a flow is created or updated. Each
+// server instance reads from its own source, so any number of replicas can serve
+// notifications. A source that occasionally loses a notification is tolerated, since
+// each user's changes are numbered contiguously and any that a subscriber missed are
+// read from the database once the gap is noticed.
+type EventSource interface {
+	// Notifications returns the channel on which the source delivers notifications
+	Notifications() <-chan *FlowChangeNotification
+	// Gaps returns a channel that's signaled whenever the source may have failed to
+	// deliver some notifications (e.g. because its connection to the database was
+	// interrupted), in which case subscribers must resync
+	Gaps() <-chan struct{}
+}
+
+// Bus is an EventSource to which notifications are published directly, from within
+// the same process. It's primarily useful in tests.
+type Bus struct {
+	notifications chan *FlowChangeNotification
+	gaps          chan struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		notifications: make(chan *FlowChangeNotification),
+		gaps:          make(chan struct{}, 1),
+	}
+}
+
+func (b *Bus) Notifications() <-chan *FlowChangeNotification {
+	return b.notifications
+}
+
+func (b *Bus) Gaps() <-chan struct{} {
+	return b.gaps
+}
+
+// Publish delivers a notification, blocking until it's been received
+func (b *Bus) Publish(notification *FlowChangeNotification) {
+	b.notifications <- notification
+}
+
+// ReportGap signals that notifications may have been lost
+func (b *Bus) ReportGap() {
+	reportGap(b.gaps)
+}
+
+// reportGap signals a gap without blocking: if a previous gap has yet to be handled,
+// the two are coalesced, since a single resync will account for both
+func reportGap(gaps chan<- struct{}) {
+	select {
+	case gaps <- struct{}{}:
+	default:
+	}
+}
+
+var _ EventSource = (*Bus)(nil)
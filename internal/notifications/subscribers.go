@@ -1,50 +1,292 @@
 package notifications
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/golden-vcr/ledger"
 )
 
+// OverflowPolicy determines what happens when a subscriber falls so far behind that its
+// queue of undelivered events is full
+type OverflowPolicy string
+
+const (
+	// OverflowPolicyDrop disconnects a subscriber whose queue overflows. The client is
+	// expected to reconnect with a Last-Event-ID header, at which point it will be sent
+	// any changes it missed.
+	OverflowPolicyDrop OverflowPolicy = "drop"
+	// OverflowPolicyResync discards all events queued for a subscriber whose queue
+	// overflows, replacing them with a single 'resync' event that tells the client to
+	// refresh its state.
+	OverflowPolicyResync OverflowPolicy = "resync"
+)
+
+// ParseOverflowPolicy validates that the given string names a supported overflow policy
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(s); policy {
+	case OverflowPolicyDrop, OverflowPolicyResync:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported overflow policy '%s'", s)
+}
+
+// transactionEvent announces a change to one of a user's transactions, identified by
+// the per-user sequence number that the database assigned to that change
+type transactionEvent struct {
+	seq         int64
+	transaction *ledger.Transaction
+	// changeSeq is the change_seq value that the database assigned to the change, which
+	// identifies it among all users' changes, for the benefit of firehose subscribers
+	changeSeq int64
+	// twitchUserId and twitchDisplayName identify the user, for the benefit of firehose
+	// subscribers: twitchDisplayName is empty if the user's name is not yet known
+	twitchUserId      string
+	twitchDisplayName string
+}
+
+// subscriber is a single SSE connection's bounded queue of events that are waiting to
+// be delivered
+type subscriber struct {
+	events chan *transactionEvent
+	// overflow is signaled when an event could not be queued because events was full
+	overflow chan struct{}
+	// lagging is true from the time the queue overflows until the subscriber has been
+	// resynced or disconnected
+	lagging atomic.Bool
+	// gap is signaled when our event source may have failed to deliver some events, in
+	// which case the subscriber must resync regardless of our overflow policy
+	gap chan struct{}
+	// filter, if set, determines which events are delivered to a firehose subscriber
+	filter func(event *transactionEvent) bool
+}
+
+// drain discards all events that are currently queued for the subscriber
+func (s *subscriber) drain() {
+	for {
+		select {
+		case <-s.events:
+		default:
+			return
+		}
+	}
+}
+
+// SubscriberStats describes the state of all subscribers connected to the notifications
+// stream, for monitoring purposes
+type SubscriberStats struct {
+	// NumSubscribers is the number of currently-connected subscribers
+	NumSubscribers int64 `json:"numSubscribers"`
+	// NumLaggingSubscribers is the number of subscribers whose queues have overflowed
+	// and which are yet to be resynced or disconnected
+	NumLaggingSubscribers int64 `json:"numLaggingSubscribers"`
+	// NumDroppedSubscribers is the total number of subscribers that have been
+	// disconnected because their queues overflowed
+	NumDroppedSubscribers int64 `json:"numDroppedSubscribers"`
+	// NumResyncs is the total number of resync events sent in place of the events that
+	// were discarded when a subscriber's queue overflowed
+	NumResyncs int64 `json:"numResyncs"`
+	// NumDroppedEvents is the total number of events that could not be queued for a
+	// subscriber because its queue was full
+	NumDroppedEvents int64 `json:"numDroppedEvents"`
+	// NumSourceGaps is the total number of times our event source has reported that it
+	// may have failed to deliver some events, requiring all subscribers to resync
+	NumSourceGaps int64 `json:"numSourceGaps"`
+}
+
 type subscriberChannels struct {
-	chans map[string][]chan *ledger.Transaction
-	mu    sync.RWMutex
+	subscribers map[string][]*subscriber
+	firehose    []*subscriber
+	mu          sync.RWMutex
+	queueSize   int
+	policy      OverflowPolicy
+
+	numSubscribers        atomic.Int64
+	numLaggingSubscribers atomic.Int64
+	numDroppedSubscribers atomic.Int64
+	numResyncs            atomic.Int64
+	numDroppedEvents      atomic.Int64
+	numSourceGaps         atomic.Int64
 }
 
-func (s *subscriberChannels) register(twitchUserId string) chan *ledger.Transaction {
-	ch := make(chan *ledger.Transaction, 32)
+func newSubscriberChannels(queueSize int, policy OverflowPolicy) *subscriberChannels {
+	return &subscriberChannels{
+		subscribers: make(map[string][]*subscriber),
+		queueSize:   queueSize,
+		policy:      policy,
+	}
+}
+
+func (s *subscriberChannels) newSubscriber() *subscriber {
+	return &subscriber{
+		events:   make(chan *transactionEvent, s.queueSize),
+		overflow: make(chan struct{}, 1),
+		gap:      make(chan struct{}, 1),
+	}
+}
+
+func (s *subscriberChannels) register(twitchUserId string) *subscriber {
+	sub := s.newSubscriber()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.chans[twitchUserId] = append(s.chans[twitchUserId], ch)
-	return ch
+	s.subscribers[twitchUserId] = append(s.subscribers[twitchUserId], sub)
+	s.numSubscribers.Add(1)
+	return sub
 }
 
-func (s *subscriberChannels) unregister(twitchUserId string, ch chan *ledger.Transaction) {
+func (s *subscriberChannels) unregister(twitchUserId string, sub *subscriber) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	chs, ok := s.chans[twitchUserId]
+	subs, ok := s.subscribers[twitchUserId]
 	if ok {
-		for i := 0; i < len(chs); i++ {
-			if chs[i] == ch {
-				head := chs[:i]
-				tail := chs[i+1:]
-				s.chans[twitchUserId] = append(head, tail...)
+		for i := 0; i < len(subs); i++ {
+			if subs[i] == sub {
+				head := subs[:i]
+				tail := subs[i+1:]
+				if len(head)+len(tail) > 0 {
+					s.subscribers[twitchUserId] = append(head, tail...)
+				} else {
+					delete(s.subscribers, twitchUserId)
+				}
+				s.numSubscribers.Add(-1)
+				if sub.lagging.Load() {
+					s.numLaggingSubscribers.Add(-1)
+				}
 				return
 			}
 		}
 	}
 }
 
-func (s *subscriberChannels) broadcast(twitchUserId string, transaction *ledger.Transaction) {
+// registerFirehose registers a subscriber that receives events for all users, as long
+// as they satisfy the given filter
+func (s *subscriberChannels) registerFirehose(filter func(event *transactionEvent) bool) *subscriber {
+	sub := s.newSubscriber()
+	sub.filter = filter
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.firehose = append(s.firehose, sub)
+	s.numSubscribers.Add(1)
+	return sub
+}
+
+func (s *subscriberChannels) unregisterFirehose(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < len(s.firehose); i++ {
+		if s.firehose[i] == sub {
+			s.firehose = append(s.firehose[:i], s.firehose[i+1:]...)
+			s.numSubscribers.Add(-1)
+			if sub.lagging.Load() {
+				s.numLaggingSubscribers.Add(-1)
+			}
+			return
+		}
+	}
+}
+
+// hasFirehose returns true if any firehose subscribers are connected
+func (s *subscriberChannels) hasFirehose() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return len(s.firehose) > 0
+}
 
-	chs, ok := s.chans[twitchUserId]
-	if ok {
-		for _, ch := range chs {
-			ch <- transaction
+// broadcast queues an event for every subscriber associated with the given user, and
+// for every firehose subscriber whose filter it satisfies. It never blocks: if a
+// subscriber's queue is full, the event is discarded and the subscriber is signaled
+// that it has overflowed, so that one slow client can never hold up delivery to any
+// others.
+func (s *subscriberChannels) broadcast(twitchUserId string, event *transactionEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sub := range s.subscribers[twitchUserId] {
+		s.enqueue(sub, event)
+	}
+	for _, sub := range s.firehose {
+		if sub.filter == nil || sub.filter(event) {
+			s.enqueue(sub, event)
 		}
 	}
 }
+
+// enqueue adds an event to a subscriber's queue without blocking, signaling the
+// subscriber instead if its queue is full
+func (s *subscriberChannels) enqueue(sub *subscriber, event *transactionEvent) {
+	select {
+	case sub.events <- event:
+	default:
+		s.numDroppedEvents.Add(1)
+		if sub.lagging.CompareAndSwap(false, true) {
+			s.numLaggingSubscribers.Add(1)
+		}
+		select {
+		case sub.overflow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handleOverflow applies our overflow policy to a subscriber whose queue has
+// overflowed, returning true if the subscriber should be disconnected. Otherwise, the
+// subscriber's queue is emptied and the caller should send it a resync event.
+func (s *subscriberChannels) handleOverflow(sub *subscriber) bool {
+	if s.policy == OverflowPolicyDrop {
+		s.numDroppedSubscribers.Add(1)
+		return true
+	}
+	sub.drain()
+	if sub.lagging.CompareAndSwap(true, false) {
+		s.numLaggingSubscribers.Add(-1)
+	}
+	s.numResyncs.Add(1)
+	return false
+}
+
+// reportGap signals every subscriber that events may have been lost before they could
+// be broadcast, so that each one will resync
+func (s *subscriberChannels) reportGap() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.numSourceGaps.Add(1)
+	for _, subs := range s.subscribers {
+		for _, sub := range subs {
+			signalGap(sub)
+		}
+	}
+	for _, sub := range s.firehose {
+		signalGap(sub)
+	}
+}
+
+func signalGap(sub *subscriber) {
+	select {
+	case sub.gap <- struct{}{}:
+	default:
+	}
+}
+
+// handleGap records that a subscriber is being sent a resync event because it may have
+// missed some events, e.g. in response to a gap reported by our event source
+func (s *subscriberChannels) handleGap() {
+	s.numResyncs.Add(1)
+}
+
+// stats returns a snapshot of our subscriber metrics
+func (s *subscriberChannels) stats() SubscriberStats {
+	return SubscriberStats{
+		NumSubscribers:        s.numSubscribers.Load(),
+		NumLaggingSubscribers: s.numLaggingSubscribers.Load(),
+		NumDroppedSubscribers: s.numDroppedSubscribers.Load(),
+		NumResyncs:            s.numResyncs.Load(),
+		NumDroppedEvents:      s.numDroppedEvents.Load(),
+		NumSourceGaps:         s.numSourceGaps.Load(),
+	}
+}
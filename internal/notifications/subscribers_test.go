@@ -0,0 +1,141 @@
+package notifications
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_subscriberChannels_stuckSubscriberDoesNotBlockOthers(t *testing.T) {
+	const numEvents = 1000
+	s := newSubscriberChannels(4, OverflowPolicyResync)
+
+	// Connect one subscriber that never reads any events, alongside two healthy ones:
+	// one for the same user and one for another user
+	stuck := s.register("1001")
+	healthy := s.register("1001")
+	other := s.register("1002")
+
+	// Have other clients connect and disconnect for the duration of the test
+	var wg sync.WaitGroup
+	churnDone := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-churnDone:
+				return
+			default:
+				sub := s.register("1001")
+				s.unregister("1001", sub)
+			}
+		}
+	}()
+
+	// Broadcast a steady stream of events to both users, each of which should be
+	// delivered promptly to the healthy subscribers, failing if we're ever blocked
+	received := make([][]int64, 2)
+	broadcastDone := make(chan struct{})
+	go func() {
+		defer close(broadcastDone)
+		for i := 1; i <= numEvents; i++ {
+			s.broadcast("1001", &transactionEvent{seq: int64(i), transaction: &ledger.Transaction{}})
+			s.broadcast("1002", &transactionEvent{seq: int64(i), transaction: &ledger.Transaction{}})
+			for j, sub := range []*subscriber{healthy, other} {
+				select {
+				case event := <-sub.events:
+					received[j] = append(received[j], event.seq)
+				case <-time.After(time.Second):
+					return
+				}
+			}
+		}
+	}()
+	select {
+	case <-broadcastDone:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("broadcast was blocked by a stuck subscriber")
+	}
+	close(churnDone)
+	wg.Wait()
+
+	// Both healthy subscribers should have received every event, in order
+	want := make([]int64, 0, numEvents)
+	for i := 1; i <= numEvents; i++ {
+		want = append(want, int64(i))
+	}
+	assert.Equal(t, want, received[0])
+	assert.Equal(t, want, received[1])
+
+	// The stuck subscriber should have been signaled that it overflowed
+	select {
+	case <-stuck.overflow:
+	default:
+		t.Fatalf("stuck subscriber was not signaled")
+	}
+	assert.Equal(t, SubscriberStats{
+		NumSubscribers:        3,
+		NumLaggingSubscribers: 1,
+		NumDroppedEvents:      numEvents - 4,
+	}, s.stats())
+
+	// Resyncing the subscriber should discard its backlog
+	assert.False(t, s.handleOverflow(stuck))
+	assert.Len(t, stuck.events, 0)
+	assert.Equal(t, SubscriberStats{
+		NumSubscribers:   3,
+		NumResyncs:       1,
+		NumDroppedEvents: numEvents - 4,
+	}, s.stats())
+}
+
+func Test_subscriberChannels_dropPolicy(t *testing.T) {
+	s := newSubscriberChannels(1, OverflowPolicyDrop)
+	sub := s.register("1001")
+	s.broadcast("1001", &transactionEvent{seq: 1, transaction: &ledger.Transaction{}})
+	s.broadcast("1001", &transactionEvent{seq: 2, transaction: &ledger.Transaction{}})
+	assert.Equal(t, int64(1), s.stats().NumLaggingSubscribers)
+
+	// Under the drop policy, a subscriber that overflows should be disconnected
+	<-sub.overflow
+	assert.True(t, s.handleOverflow(sub))
+	s.unregister("1001", sub)
+	assert.Equal(t, SubscriberStats{
+		NumDroppedSubscribers: 1,
+		NumDroppedEvents:      1,
+	}, s.stats())
+}
+
+func Test_subscriberChannels_reportGap(t *testing.T) {
+	s := newSubscriberChannels(1, OverflowPolicyDrop)
+	sub := s.register("1001")
+	firehose := s.registerFirehose(nil)
+
+	// A gap in our event source should require every subscriber to resync, regardless of
+	// overflow policy, and repeated gaps should be coalesced until handled
+	s.reportGap()
+	s.reportGap()
+	for _, sub := range []*subscriber{sub, firehose} {
+		<-sub.gap
+		s.handleGap()
+		assert.Len(t, sub.gap, 0)
+	}
+	assert.Equal(t, SubscriberStats{
+		NumSubscribers: 2,
+		NumResyncs:     2,
+		NumSourceGaps:  2,
+	}, s.stats())
+}
+
+func Test_ParseOverflowPolicy(t *testing.T) {
+	policy, err := ParseOverflowPolicy("drop")
+	assert.NoError(t, err)
+	assert.Equal(t, OverflowPolicyDrop, policy)
+
+	_, err = ParseOverflowPolicy("ignore")
+	assert.EqualError(t, err, "unsupported overflow policy 'ignore'")
+}
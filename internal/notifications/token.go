@@ -1,10 +1,32 @@
 package notifications
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"time"
 )
 
+const (
+	// tokenScopeUser identifies a token that grants access to notifications about the
+	// user's own transactions
+	tokenScopeUser = "user"
+	// tokenScopeFirehose identifies a token that grants the broadcaster access to
+	// notifications about every user's transactions
+	tokenScopeFirehose = "firehose"
+)
+
+// TokenPolicy governs the short-lived tokens that clients use to connect to our
+// notification streams
+type TokenPolicy struct {
+	// Ttl is how long a token remains valid after it's issued
+	Ttl time.Duration
+	// SingleUse, if true, causes a token to be consumed as soon as it's used to open a
+	// stream: a client must then request a new token each time it reconnects
+	SingleUse bool
+}
+
 type GenerateTokenFunc func() (string, error)
 
 func generateToken() (string, error) {
@@ -15,3 +37,23 @@ func generateToken() (string, error) {
 	token := hex.EncodeToString(tokenBytes)
 	return token, nil
 }
+
+// PurgeExpiredTokens runs until the given context is canceled, deleting all expired
+// tokens from the database once every interval
+func (s *Server) PurgeExpiredTokens(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			result, err := s.q.PurgeExpiredSseTokens(ctx)
+			if err != nil {
+				fmt.Printf("Failed to purge expired SSE tokens: %v\n", err)
+				continue
+			}
+			if numPurged, err := result.RowsAffected(); err == nil && numPurged > 0 {
+				fmt.Printf("Purged %d expired SSE token(s).\n", numPurged)
+			}
+		}
+	}
+}
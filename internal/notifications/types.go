@@ -2,17 +2,25 @@ package notifications
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"time"
 
+	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/google/uuid"
 )
 
 type Queries interface {
 	StoreSseToken(ctx context.Context, arg queries.StoreSseTokenParams) error
 	PurgeSseTokensForUser(ctx context.Context, twitchUserID string) error
-	IdentifyUserFromSseToken(ctx context.Context, tokenValue string) (string, error)
+	IdentifyUserFromSseToken(ctx context.Context, arg queries.IdentifyUserFromSseTokenParams) (string, error)
+	ConsumeSseToken(ctx context.Context, arg queries.ConsumeSseTokenParams) (string, error)
+	RevokeSseTokensForUser(ctx context.Context, twitchUserID string) (sql.Result, error)
+	PurgeExpiredSseTokens(ctx context.Context) (sql.Result, error)
+	GetFlowChangesSince(ctx context.Context, arg queries.GetFlowChangesSinceParams) ([]queries.GetFlowChangesSinceRow, error)
+	GetBalance(ctx context.Context, twitchUserID string) (queries.GetBalanceRow, error)
 }
 
 type FlowChangeNotification struct {
@@ -24,4 +32,26 @@ type FlowChangeNotification struct {
 	CreatedAt    time.Time       `json:"created_at"`
 	FinalizedAt  *time.Time      `json:"finalized_at"`
 	Accepted     bool            `json:"accepted"`
+	// DescriptionTemplate is the description template registered for the flow's type,
+	// if any, used to render a user-facing description of the transaction
+	DescriptionTemplate string `json:"description_template"`
+	// ChangeSeq is the sequence number that the database assigned to this change,
+	// unique across all users, and sent to firehose clients as the event ID
+	ChangeSeq int64 `json:"change_seq"`
+	// UserChangeSeq numbers this change among all changes to the same user's
+	// transactions: each user's changes are numbered contiguously, starting from 1, and
+	// this value is sent to the user's own clients as the event ID
+	UserChangeSeq int64 `json:"user_change_seq"`
+}
+
+// Transaction describes the state of the changed transaction, as presented to users.
+// Reversals are announced via notifications of their own, so the original transaction
+// is never described as reversed here.
+func (n *FlowChangeNotification) Transaction() ledger.Transaction {
+	finalizedAt := sql.NullTime{}
+	if n.FinalizedAt != nil {
+		finalizedAt.Valid = true
+		finalizedAt.Time = *n.FinalizedAt
+	}
+	return util.BuildTransaction(n.Id, n.Type, n.Metadata, n.DeltaPoints, n.CreatedAt, finalizedAt, n.Accepted, n.DescriptionTemplate, 0)
 }
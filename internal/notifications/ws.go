@@ -0,0 +1,270 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/internal/util"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPingInterval is how often we send a ping frame to each WebSocket client
+	wsPingInterval = 30 * time.Second
+	// wsReadTimeout is how long we'll wait to hear anything from a WebSocket client
+	// (including a pong in response to our ping) before we consider it disconnected
+	wsReadTimeout = 2 * wsPingInterval
+	// wsWriteTimeout is how long we'll wait for a single message to be written to a
+	// WebSocket client
+	wsWriteTimeout = 10 * time.Second
+	// wsMaxMessageSize is the maximum size of a message that a client may send to us;
+	// clients have no reason to send anything other than pings
+	wsMaxMessageSize = 1024
+)
+
+const (
+	wsMessageTypeTransaction = eventTypeTransaction
+	wsMessageTypeBalance     = eventTypeBalance
+	wsMessageTypeResync      = "resync"
+	wsMessageTypePing        = "ping"
+	wsMessageTypePong        = "pong"
+)
+
+// wsMessage is the JSON envelope of every message sent over a /notifications/ws
+// connection. Id and Data carry the same values as the ID and data of the equivalent
+// event in the SSE stream.
+type wsMessage struct {
+	Type string      `json:"type"`
+	Id   *int64      `json:"id,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// wsUpgrader upgrades requests to GET /notifications/ws. We accept connections from any
+// origin, since clients such as OBS browser sources may not have a meaningful origin,
+// and the short-lived token supplied in the URL is what authorizes the connection.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (s *Server) handleGetNotificationsWs(res http.ResponseWriter, req *http.Request) {
+	twitchUserId, ok := s.identifySubscriber(res, req, tokenScopeUser)
+	if !ok {
+		return
+	}
+
+	// WebSocket clients receive both transaction and balance messages unless they
+	// opt into a narrower selection
+	selection := eventSelection{named: true, transactions: true, balance: true}
+	if values := req.URL.Query()["events"]; len(values) > 0 {
+		var err error
+		selection, err = parseEventSelection(values)
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	// Browsers can't set headers on WebSocket requests, so a reconnecting client
+	// supplies the ID of the last message it saw as a URL parameter instead
+	lastEventId, err := parseLastEventId(req.URL.Query().Get("lastEventId"))
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "'lastEventId' URL parameter must be a valid event ID")
+		return
+	}
+
+	// As with SSE, start listening for live events before looking up any changes to
+	// replay, so that no change can slip through the gap between the two
+	sub := s.subscribers.register(twitchUserId)
+	defer s.subscribers.unregister(twitchUserId, sub)
+
+	var replay []*transactionEvent
+	if lastEventId >= 0 {
+		replay, err = s.getChangesSince(req.Context(), twitchUserId, lastEventId)
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInternal, err.Error())
+			return
+		}
+	}
+
+	// Upgrade the connection; if that fails, the upgrader has already responded
+	conn, err := wsUpgrader.Upgrade(res, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Only consume a single-use token once the upgrade has succeeded, so that a client
+	// whose handshake fails can try again with the same token. If the token was
+	// consumed by a concurrent request in the meantime, we can no longer respond with an
+	// HTTP error, so we close the connection with a reason instead.
+	if err := s.consumeTokenValue(req.Context(), req.URL.Query().Get("token"), tokenScopeUser); err != nil {
+		reason := "invalid token"
+		closeCode := websocket.ClosePolicyViolation
+		if err != sql.ErrNoRows {
+			reason = "internal error"
+			closeCode = websocket.CloseInternalServerErr
+		}
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(wsWriteTimeout))
+		return
+	}
+	fmt.Printf("Opened WebSocket connection to %s...\n", req.RemoteAddr)
+
+	// Read from the connection in a separate goroutine, so that we notice if the client
+	// disconnects or stops responding, and so that we can answer its pings: ping frames
+	// are answered automatically, and 'ping' messages are answered below, via pings
+	pings := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	go readWsMessages(conn, pings, closed)
+
+	send := func(messageType string, seq int64, data interface{}) error {
+		message := wsMessage{Type: messageType, Data: data}
+		if seq >= 0 {
+			message.Id = &seq
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(message)
+	}
+	sendChanges := func(ctx context.Context, events []*transactionEvent) error {
+		if len(events) == 0 {
+			return nil
+		}
+		if selection.transactions {
+			for _, event := range events {
+				if err := send(wsMessageTypeTransaction, event.seq, event.transaction); err != nil {
+					return err
+				}
+			}
+		}
+		if selection.balance {
+			return s.sendWsBalance(ctx, send, twitchUserId, events[len(events)-1].seq)
+		}
+		return nil
+	}
+
+	// Catch the client up on any changes it missed, then send its current balance
+	lastSeq := lastEventId
+	lastReplayedSeq := int64(-1)
+	for _, event := range replay {
+		if selection.transactions {
+			if err := send(wsMessageTypeTransaction, event.seq, event.transaction); err != nil {
+				return
+			}
+		}
+		lastSeq = event.seq
+		lastReplayedSeq = event.seq
+	}
+	if selection.balance {
+		if err := s.sendWsBalance(req.Context(), send, twitchUserId, lastReplayedSeq); err != nil {
+			return
+		}
+	}
+
+	// Send all incoming messages to the client for as long as the connection is open
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case <-pings:
+			err = send(wsMessageTypePong, -1, nil)
+		case event := <-sub.events:
+			events, lookupErr := s.getEventsToSend(req.Context(), twitchUserId, lastSeq, event)
+			if lookupErr != nil {
+				fmt.Printf("Failed to look up changes missed by WebSocket client: %v\n", lookupErr)
+				s.subscribers.handleGap()
+				err = send(wsMessageTypeResync, -1, nil)
+				lastSeq = -1
+				break
+			}
+			err = sendChanges(req.Context(), events)
+			if len(events) > 0 {
+				lastSeq = events[len(events)-1].seq
+			}
+		case <-sub.overflow:
+			if s.subscribers.handleOverflow(sub) {
+				fmt.Printf("WebSocket connection to %s has fallen behind; disconnecting.\n", req.RemoteAddr)
+				closeWs(conn, websocket.CloseTryAgainLater, "client fell too far behind")
+				return
+			}
+			err = send(wsMessageTypeResync, -1, nil)
+			lastSeq = -1
+		case <-sub.gap:
+			s.subscribers.handleGap()
+			err = send(wsMessageTypeResync, -1, nil)
+			lastSeq = -1
+		case <-closed:
+			fmt.Printf("WebSocket connection to %s has been closed.\n", req.RemoteAddr)
+			return
+		case <-s.ctx.Done():
+			fmt.Printf("Server is shutting down; closing WebSocket connection to %s.\n", req.RemoteAddr)
+			closeWs(conn, websocket.CloseGoingAway, "server is shutting down")
+			return
+		}
+		if err != nil {
+			fmt.Printf("Failed to write to WebSocket connection to %s: %v\n", req.RemoteAddr, err)
+			return
+		}
+	}
+}
+
+// sendWsBalance looks up the given user's current balance and sends it to a WebSocket
+// client as a 'balance' message. A failure to look up the balance is logged rather
+// than returned, since the connection itself is still healthy.
+func (s *Server) sendWsBalance(ctx context.Context, send func(string, int64, interface{}) error, twitchUserId string, seq int64) error {
+	balance, err := s.getBalance(ctx, twitchUserId)
+	if err != nil {
+		fmt.Printf("Failed to get balance for WebSocket client: %v\n", err)
+		return nil
+	}
+	return send(wsMessageTypeBalance, seq, balance)
+}
+
+// readWsMessages reads from a WebSocket connection until it's closed or the client
+// stops responding, signaling pings whenever the client sends a 'ping' message. Since
+// a connection supports only one concurrent writer, replies are left to the caller.
+func readWsMessages(conn *websocket.Conn, pings chan<- struct{}, closed chan<- struct{}) {
+	defer close(closed)
+
+	// Any message or control frame from the client shows that it's still there
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+	defaultPingHandler := conn.PingHandler()
+	conn.SetPingHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		return defaultPingHandler(appData)
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		// Ignore anything other than a well-formed ping message
+		var message wsMessage
+		if err := json.Unmarshal(data, &message); err != nil || message.Type != wsMessageTypePing {
+			continue
+		}
+		select {
+		case pings <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// closeWs attempts to close a WebSocket connection cleanly with the given status code
+func closeWs(conn *websocket.Conn, code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+}
@@ -0,0 +1,143 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handleGetNotificationsWs(t *testing.T) {
+	bus := NewBus()
+	s := &Server{
+		ctx: context.Background(),
+		q: &mockQueries{
+			tokens: []mockSseToken{
+				{
+					userId:    "1001",
+					value:     "mock-sse-token",
+					scope:     "user",
+					expiresAt: time.Now().Add(5 * time.Minute),
+				},
+			},
+			balances: map[string]queries.GetBalanceRow{
+				"1001": {TotalPoints: 150, AvailablePoints: 150},
+			},
+		},
+		source:      bus,
+		subscribers: newSubscriberChannels(32, OverflowPolicyResync),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ReadEvents(ctx)
+
+	srv := httptest.NewServer(http.HandlerFunc(s.handleGetNotificationsWs))
+	defer srv.Close()
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// A connection can't be established without a valid token
+	_, res, err := websocket.DefaultDialer.Dial(wsUrl+"?token=bad-sse-token", nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	_, res, err = websocket.DefaultDialer.Dial(wsUrl+"?token=mock-sse-token&events=foo", nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"?token=mock-sse-token", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	readMessage := func() string {
+		_, data, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		return strings.TrimSuffix(string(data), "\n")
+	}
+
+	// We should be told our current balance as soon as we connect
+	assert.Equal(t, `{"type":"balance","data":{"totalPoints":150,"availablePoints":150}}`, readMessage())
+
+	// Each change should be announced with both a transaction and a balance message
+	bus.Publish(&FlowChangeNotification{
+		TwitchUserId:  "1001",
+		Id:            uuid.MustParse("ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6"),
+		Type:          "manual-credit",
+		Metadata:      []byte(`{"note":"foo"}`),
+		DeltaPoints:   150,
+		CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+		UserChangeSeq: 42,
+	})
+	assert.Equal(t, `{"type":"transaction","id":42,"data":{"id":"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6","timestamp":"1997-09-01T12:00:00Z","type":"manual-credit","state":"pending","deltaPoints":150,"description":"Manual credit: foo"}}`, readMessage())
+	assert.Equal(t, `{"type":"balance","id":42,"data":{"totalPoints":150,"availablePoints":150}}`, readMessage())
+
+	// Clients that can't send ping frames may send ping messages instead
+	data, _ := json.Marshal(wsMessage{Type: "ping"})
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, data))
+	assert.Equal(t, `{"type":"pong"}`, readMessage())
+
+	// Once the client disconnects, it should no longer be subscribed
+	conn.Close()
+	for i := 0; i < 100 && s.subscribers.stats().NumSubscribers > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), s.subscribers.stats().NumSubscribers)
+}
+
+func Test_Server_handleGetNotificationsWs_singleUseToken(t *testing.T) {
+	q := &mockQueries{
+		tokens: []mockSseToken{
+			{
+				userId:    "1001",
+				value:     "mock-sse-token",
+				scope:     "user",
+				expiresAt: time.Now().Add(5 * time.Minute),
+			},
+		},
+		balances: map[string]queries.GetBalanceRow{
+			"1001": {TotalPoints: 150, AvailablePoints: 150},
+		},
+	}
+	s := &Server{
+		ctx:         context.Background(),
+		q:           q,
+		tokens:      TokenPolicy{Ttl: 5 * time.Minute, SingleUse: true},
+		source:      NewBus(),
+		subscribers: newSubscriberChannels(32, OverflowPolicyResync),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleGetNotificationsWs))
+	defer srv.Close()
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// A request that fails to upgrade to a WebSocket should not consume the token
+	res, err := http.Get(srv.URL + "?token=mock-sse-token")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Len(t, q.tokens, 1)
+
+	// The token should be consumed once a connection is established with it
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"?token=mock-sse-token", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"balance","data":{"totalPoints":150,"availablePoints":150}}`, strings.TrimSuffix(string(data), "\n"))
+	assert.Len(t, q.tokens, 0)
+
+	// The same token can not be used to connect again
+	_, res, err = websocket.DefaultDialer.Dial(wsUrl+"?token=mock-sse-token", nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
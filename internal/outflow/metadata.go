@@ -0,0 +1,94 @@
+package outflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// flowTypeNameRegex matches a valid kebab-case flow type name, e.g. 'alert-redemption'
+var flowTypeNameRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// resolveOutflowMetadata returns the metadata object that should be recorded for the
+// requested outflow, falling back to the legacy alertType and alertMetadata fields if
+// the request doesn't supply metadata directly
+func resolveOutflowMetadata(payload *ledger.OutflowRequest) (json.RawMessage, error) {
+	if payload.Metadata != nil {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(*payload.Metadata, &obj); err != nil || obj == nil {
+			return nil, fmt.Errorf("metadata must be a JSON object")
+		}
+		return *payload.Metadata, nil
+	}
+	obj := make(map[string]interface{})
+	if payload.AlertMetadata != nil {
+		if err := json.Unmarshal(*payload.AlertMetadata, &obj); err != nil || obj == nil {
+			return nil, fmt.Errorf("alertMetadata must be a JSON object")
+		}
+	}
+	if payload.AlertType != "" {
+		obj["type"] = payload.AlertType
+	}
+	return json.Marshal(obj)
+}
+
+// compileSchema parses a JSON Schema document registered for an outflow type
+func compileSchema(schema json.RawMessage) (*jsonschema.Schema, error) {
+	const url = "mem:///metadata.json"
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	compiled, err := c.Compile(url)
+	if err != nil {
+		return nil, simplifyValidationError(err)
+	}
+	return compiled, nil
+}
+
+// validateMetadata returns an error if the given metadata object does not conform to
+// the given JSON Schema document
+func validateMetadata(schema json.RawMessage, metadata json.RawMessage) error {
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err := json.Unmarshal(metadata, &value); err != nil {
+		return err
+	}
+	return simplifyValidationError(compiled.Validate(value))
+}
+
+// simplifyValidationError replaces a schema validation error (which may be the result
+// of validating a schema against its metaschema) with a concise, user-facing summary
+func simplifyValidationError(err error) error {
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return errors.New(strings.Join(describeValidationError(validationErr), "; "))
+	}
+	return err
+}
+
+// describeValidationError flattens a schema validation error into a list of concise,
+// user-facing messages, one for each leaf-level failure
+func describeValidationError(err *jsonschema.ValidationError) []string {
+	if len(err.Causes) == 0 {
+		location := err.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{fmt.Sprintf("at '%s': %s", location, err.Message)}
+	}
+	messages := make([]string, 0, len(err.Causes))
+	for _, cause := range err.Causes {
+		messages = append(messages, describeValidationError(cause)...)
+	}
+	return messages
+}
@@ -0,0 +1,63 @@
+package outflow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
+)
+
+// maxExpiredFlowsPerPass limits the number of expired flows we'll reject in a single
+// pass, so that a large backlog is worked through gradually
+const maxExpiredFlowsPerPass = 100
+
+// RejectExpiredOutflows runs until the given context is canceled, checking for pending
+// transactions whose expiration time has elapsed once every interval. Any such
+// transaction is rejected just as if the caller had rejected it via DELETE
+// /outflow/:id, with the reason recorded in its metadata, so that the points it was
+// holding are made available to the user again.
+func (s *Server) RejectExpiredOutflows(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			numRejected, err := s.rejectExpiredOutflows(ctx)
+			if err != nil {
+				fmt.Printf("Failed to reject expired outflows: %v\n", err)
+			} else if numRejected > 0 {
+				fmt.Printf("Rejected %d expired outflow(s).\n", numRejected)
+			}
+		}
+	}
+}
+
+func (s *Server) rejectExpiredOutflows(ctx context.Context) (int, error) {
+	flowIds, err := s.q.GetExpiredPendingFlowIds(ctx, maxExpiredFlowsPerPass)
+	if err != nil {
+		return 0, err
+	}
+
+	numRejected := 0
+	for _, flowId := range flowIds {
+		// If the flow was finalized in the meantime, FinalizeFlow will simply have no
+		// effect, so there's no need to check whether it's still pending first
+		result, err := s.q.FinalizeFlow(ctx, queries.FinalizeFlowParams{
+			Accepted:        false,
+			RejectionReason: sql.NullString{Valid: true, String: util.RejectionReasonExpired},
+			FlowID:          flowId,
+		})
+		if err != nil {
+			return numRejected, fmt.Errorf("failed to reject flow %s: %w", flowId, err)
+		}
+		numRows, err := result.RowsAffected()
+		if err != nil {
+			return numRejected, err
+		}
+		numRejected += int(numRows)
+	}
+	return numRejected, nil
+}
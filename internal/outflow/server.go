@@ -1,28 +1,35 @@
 package outflow
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
+// MaxExpiresInSeconds is the longest timeout that a caller may request for a pending
+// outflow: no interaction should need to hold a user's points for more than a day
+const MaxExpiresInSeconds = 24 * 60 * 60
+
 type Server struct {
-	q Queries
+	q          Queries
+	defaultTtl time.Duration
 }
 
-func NewServer(q Queries) *Server {
+func NewServer(q Queries, defaultTtl time.Duration) *Server {
 	return &Server{
-		q: q,
+		q:          q,
+		defaultTtl: defaultTtl,
 	}
 }
 
@@ -32,6 +39,16 @@ func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
 			http.HandlerFunc(s.handleCreateOutflow),
 		),
 	)
+	r.Path("/outflow/types").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleViewer,
+			http.HandlerFunc(s.handleGetOutflowTypes),
+		),
+	)
+	r.Path("/outflow/types/{name}").Methods("PUT").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleRegisterOutflowType),
+		),
+	)
 	r.Path("/outflow/{id}").Methods("PATCH", "DELETE").Handler(
 		auth.RequireAccess(c, auth.RoleViewer,
 			http.HandlerFunc(s.handleFinalizeOutflow),
@@ -44,62 +61,90 @@ func (s *Server) handleCreateOutflow(res http.ResponseWriter, req *http.Request)
 	// we have an authenticated user to take the points from
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
-	// Parse the request payload: we currently only support a single outflow type
-	// ('alert-redemption'), so just expect that payload universally
+	// Parse the request payload, which must identify a registered outflow type
 	contentType := req.Header.Get("content-type")
 	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
-		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
 		return
 	}
-	var payload ledger.AlertRedemptionRequest
+	var payload ledger.OutflowRequest
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
-		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
 		return
 	}
 	if payload.NumPointsToDebit <= 0 {
-		http.Error(res, "numPointsToDebit must be positive", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "numPointsToDebit must be positive")
 		return
 	}
-
-	// Sanity-check: we only support 'alert-redemption' at the moment
-	if payload.Type != ledger.TransactionTypeAlertRedemption {
-		http.Error(res, "unsupported transaction type", http.StatusBadRequest)
+	if payload.ExpiresInSeconds < 0 {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "expiresInSeconds must be positive if set")
+		return
+	}
+	if payload.ExpiresInSeconds > MaxExpiresInSeconds {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("expiresInSeconds must not exceed %d", MaxExpiresInSeconds))
 		return
 	}
 
-	// Verify that the auth'd user has enough points in their available balance
-	balance, err := s.q.GetBalance(req.Context(), claims.User.Id)
+	// Look up the requested outflow type in the registry: we can only record outflows
+	// of types that have been registered
+	outflowType, err := s.q.GetOutflowType(req.Context(), string(payload.Type))
 	if errors.Is(err, sql.ErrNoRows) {
-		balance.AvailablePoints = 0
-		balance.TotalPoints = 0
-		err = nil
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "unsupported transaction type")
+		return
 	}
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
-	if balance.AvailablePoints < int32(payload.NumPointsToDebit) {
-		http.Error(res, "not enough points", http.StatusConflict)
+
+	// Resolve the metadata to be recorded with the transaction, and make sure it's valid
+	// for this outflow type
+	metadata, err := resolveOutflowMetadata(&payload)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
 		return
 	}
+	if outflowType.MetadataSchema.Valid {
+		if err := validateMetadata(outflowType.MetadataSchema.RawMessage, metadata); err != nil {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid metadata for outflow type '%s': %v", outflowType.Name, err))
+			return
+		}
+	}
 
-	// Record a new pending outflow in the database
-	params := queries.RecordPendingAlertRedemptionOutflowParams{
-		AlertType:        payload.AlertType,
+	// Record a new pending outflow in the database: the database will refuse to record
+	// the outflow if the user's available balance is insufficient, and it does so
+	// atomically, so concurrent requests from the same user can't overdraw their points
+	params := queries.RecordPendingOutflowParams{
+		Type:             outflowType.Name,
+		Metadata:         metadata,
 		TwitchUserID:     claims.User.Id,
 		NumPointsToDebit: int32(payload.NumPointsToDebit),
 	}
-	if payload.AlertMetadata != nil {
-		params.AlertMetadata.Valid = true
-		params.AlertMetadata.RawMessage = *payload.AlertMetadata
+
+	// Unless the caller has requested a specific timeout, use our default TTL, so that
+	// the outflow will be rejected automatically if the caller never finalizes it
+	if payload.ExpiresInSeconds > 0 {
+		params.ExpiresInSeconds.Valid = true
+		params.ExpiresInSeconds.Int32 = int32(payload.ExpiresInSeconds)
+	} else if s.defaultTtl > 0 {
+		params.ExpiresInSeconds.Valid = true
+		params.ExpiresInSeconds.Int32 = int32(s.defaultTtl.Seconds())
+	}
+	flowId, err := s.q.RecordPendingOutflow(req.Context(), params)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "unsupported transaction type")
+		return
+	}
+	if util.IsInsufficientBalanceError(err) {
+		util.Error(res, ledger.ErrorCodeNotEnoughPoints, "not enough points")
+		return
 	}
-	flowId, err := s.q.RecordPendingAlertRedemptionOutflow(req.Context(), params)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
@@ -108,16 +153,108 @@ func (s *Server) handleCreateOutflow(res http.ResponseWriter, req *http.Request)
 		FlowId: flowId,
 	}
 	if err := json.NewEncoder(res).Encode(result); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 }
 
+func (s *Server) handleGetOutflowTypes(res http.ResponseWriter, req *http.Request) {
+	rows, err := s.q.ListOutflowTypes(req.Context())
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	items := make([]ledger.OutflowType, 0, len(rows))
+	for _, row := range rows {
+		item := ledger.OutflowType{
+			Name:                ledger.TransactionType(row.Name),
+			Comment:             row.Comment,
+			DescriptionTemplate: row.DescriptionTemplate.String,
+		}
+		if row.MetadataSchema.Valid {
+			schema := json.RawMessage(row.MetadataSchema.RawMessage)
+			item.MetadataSchema = &schema
+		}
+		items = append(items, item)
+	}
+	if err := json.NewEncoder(res).Encode(ledger.OutflowTypeList{Items: items}); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handleRegisterOutflowType(res http.ResponseWriter, req *http.Request) {
+	// Parse the name of the outflow type from the URL
+	name := mux.Vars(req)["name"]
+	if !flowTypeNameRegex.MatchString(name) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "outflow type name must be kebab-case")
+		return
+	}
+
+	// Parse the request payload, and make sure that the schema and template are valid
+	// before we allow any outflows to be recorded with them
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return
+	}
+	var payload ledger.OutflowType
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if payload.Name != "" && string(payload.Name) != name {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "name in request payload does not match URL")
+		return
+	}
+	if payload.Comment == "" {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "comment is required")
+		return
+	}
+	params := queries.RegisterOutflowTypeParams{
+		Name:    name,
+		Comment: payload.Comment,
+	}
+	if payload.MetadataSchema != nil {
+		if _, err := compileSchema(*payload.MetadataSchema); err != nil {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid metadataSchema: %v", err))
+			return
+		}
+		params.MetadataSchema.Valid = true
+		params.MetadataSchema.RawMessage = *payload.MetadataSchema
+	}
+	if payload.DescriptionTemplate != "" {
+		if _, err := util.ParseDescriptionTemplate(payload.DescriptionTemplate); err != nil {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid descriptionTemplate: %v", err))
+			return
+		}
+		params.DescriptionTemplate.Valid = true
+		params.DescriptionTemplate.String = payload.DescriptionTemplate
+	}
+
+	// Register the type, or update its details if it's already registered: if a flow
+	// type by that name exists but isn't an outflow type, no rows will be affected
+	result, err := s.q.RegisterOutflowType(req.Context(), params)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	if numRows != 1 {
+		util.Error(res, ledger.ErrorCodeConflict, fmt.Sprintf("flow type '%s' already exists and is not a registered outflow type", name))
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) handleFinalizeOutflow(res http.ResponseWriter, req *http.Request) {
 	// Parse the target flowId from the URL
 	idStr := mux.Vars(req)["id"]
 	flowId, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
@@ -125,7 +262,7 @@ func (s *Server) handleFinalizeOutflow(res http.ResponseWriter, req *http.Reques
 	// request if the given transaction is associated with the auth'd user
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
@@ -133,19 +270,19 @@ func (s *Server) handleFinalizeOutflow(res http.ResponseWriter, req *http.Reques
 	// belongs to that user and is not yet finalized
 	row, err := s.q.GetFlow(req.Context(), flowId)
 	if errors.Is(err, sql.ErrNoRows) {
-		http.Error(res, "no such transaction", http.StatusNotFound)
+		util.Error(res, ledger.ErrorCodeFlowNotFound, "no such transaction")
 		return
 	}
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 	if row.TwitchUserID != claims.User.Id {
-		http.Error(res, "no such transaction", http.StatusNotFound)
+		util.Error(res, ledger.ErrorCodeFlowNotFound, "no such transaction")
 		return
 	}
 	if row.FinalizedAt.Valid {
-		http.Error(res, "transaction is not pending", http.StatusConflict)
+		util.Error(res, ledger.ErrorCodeFlowAlreadyFinalized, "transaction is not pending")
 		return
 	}
 
@@ -158,17 +295,23 @@ func (s *Server) handleFinalizeOutflow(res http.ResponseWriter, req *http.Reques
 
 	// Attempt to finalize the transaction, either making its effect permanent (if
 	// accepted) or reverting any pending effect (if rejected)
-	result, err := s.q.FinalizeFlow(context.Background(), queries.FinalizeFlowParams{
+	result, err := s.q.FinalizeFlow(req.Context(), queries.FinalizeFlowParams{
 		Accepted: accepted,
 		FlowID:   flowId,
 	})
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
 	numRows, err := result.RowsAffected()
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
-	if numRows != 1 {
-		http.Error(res, fmt.Sprintf("FinalizeFlow affected %d rows; expected 1", numRows), http.StatusInternalServerError)
+	if numRows == 0 {
+		// The transaction was finalized after we looked it up (e.g. by our reaper), or
+		// it has expired and may no longer be accepted
+		util.Error(res, ledger.ErrorCodeFlowAlreadyFinalized, "transaction is not pending")
 		return
 	}
 	res.WriteHeader(http.StatusNoContent)
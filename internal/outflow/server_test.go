@@ -3,6 +3,7 @@ package outflow
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,21 +15,23 @@ import (
 	"github.com/golden-vcr/auth"
 	authmock "github.com/golden-vcr/auth/mock"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/sqlc-dev/pqtype"
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_Server_handleCreateOutflow(t *testing.T) {
 	tests := []struct {
-		name                 string
-		q                    *mockQueries
-		authorization        string
-		requestBody          string
-		wantStatus           int
-		wantBody             string
-		wantAlertRedemptions []mockAlertRedemptionOutflow
+		name          string
+		q             *mockQueries
+		authorization string
+		requestBody   string
+		wantStatus    int
+		wantBody      string
+		wantOutflows  []mockOutflow
 	}{
 		{
 			"unrecognized outflow type is error",
@@ -36,7 +39,7 @@ func Test_Server_handleCreateOutflow(t *testing.T) {
 			"mock-token",
 			`{"type":"bad-type","numPointsToDebit":250,"alertType":"foo","alertMetadata":{"x":42}}`,
 			http.StatusBadRequest,
-			"unsupported transaction type",
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"unsupported transaction type"}`,
 			nil,
 		},
 		{
@@ -56,18 +59,94 @@ func Test_Server_handleCreateOutflow(t *testing.T) {
 			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo","alertMetadata":{"x":42}}`,
 			http.StatusOK,
 			`{"flowId":"7784d456-c499-4d50-80ed-7feaa2757409"}`,
-			[]mockAlertRedemptionOutflow{
+			[]mockOutflow{
 				{
 					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 					userId:           "1001",
 					numPointsToDebit: 250,
-					alertType:        "foo",
-					alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 					finalized:        false,
 					accepted:         false,
 				},
 			},
 		},
+		{
+			"metadata may be supplied directly",
+			&mockQueries{
+				idSequence: []uuid.UUID{
+					uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+				},
+				balancesByUserId: map[string]queries.GetBalanceRow{
+					"1001": {
+						AvailablePoints: 1000,
+						TotalPoints:     1000,
+					},
+				},
+			},
+			"mock-token",
+			`{"type":"alert-redemption","numPointsToDebit":250,"metadata":{"type":"foo","x":42}}`,
+			http.StatusOK,
+			`{"flowId":"7784d456-c499-4d50-80ed-7feaa2757409"}`,
+			[]mockOutflow{
+				{
+					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+					userId:           "1001",
+					numPointsToDebit: 250,
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+				},
+			},
+		},
+		{
+			"metadata that does not match the registered schema is error",
+			&mockQueries{
+				balancesByUserId: map[string]queries.GetBalanceRow{
+					"1001": {
+						AvailablePoints: 1000,
+						TotalPoints:     1000,
+					},
+				},
+			},
+			"mock-token",
+			`{"type":"alert-redemption","numPointsToDebit":250,"metadata":{"x":42}}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid metadata for outflow type 'alert-redemption': at '/': missing properties: 'type'"}`,
+			nil,
+		},
+		{
+			"any registered outflow type is supported",
+			&mockQueries{
+				idSequence: []uuid.UUID{
+					uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+				},
+				balancesByUserId: map[string]queries.GetBalanceRow{
+					"1001": {
+						AvailablePoints: 1000,
+						TotalPoints:     1000,
+					},
+				},
+				outflowTypes: []queries.ListOutflowTypesRow{
+					{
+						Name:    "sticker-purchase",
+						Comment: "Outflow recorded when a user buys a sticker.",
+					},
+				},
+			},
+			"mock-token",
+			`{"type":"sticker-purchase","numPointsToDebit":100,"metadata":{"sticker":"cat"}}`,
+			http.StatusOK,
+			`{"flowId":"7784d456-c499-4d50-80ed-7feaa2757409"}`,
+			[]mockOutflow{
+				{
+					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+					userId:           "1001",
+					numPointsToDebit: 100,
+					flowType:         "sticker-purchase",
+					metadata:         json.RawMessage(`{"sticker":"cat"}`),
+				},
+			},
+		},
 		{
 			"insufficient point balance results in a 409 error",
 			&mockQueries{
@@ -84,7 +163,7 @@ func Test_Server_handleCreateOutflow(t *testing.T) {
 			"mock-token",
 			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo","alertMetadata":{"x":42}}`,
 			http.StatusConflict,
-			"not enough points",
+			`{"title":"Conflict","status":409,"code":"not_enough_points","detail":"not enough points"}`,
 			nil,
 		},
 	}
@@ -112,32 +191,302 @@ func Test_Server_handleCreateOutflow(t *testing.T) {
 			assert.Equal(t, tt.wantStatus, res.Code)
 			assert.Equal(t, tt.wantBody, body)
 
-			assert.Equal(t, tt.wantAlertRedemptions, tt.q.alertRedemptions)
+			assert.Equal(t, tt.wantOutflows, tt.q.outflows)
 		})
 	}
 }
 
-func Test_Server_handleFinalizeOutflow(t *testing.T) {
+func Test_Server_handleCreateOutflow_expiration(t *testing.T) {
 	tests := []struct {
 		name                 string
-		q                    *mockQueries
-		method               string
-		flowId               string
-		authorization        string
+		defaultTtl           time.Duration
+		requestBody          string
 		wantStatus           int
-		wantBody             string
-		wantAlertRedemptions []mockAlertRedemptionOutflow
+		wantExpiresInSeconds sql.NullInt32
+	}{
+		{
+			"server's default TTL is applied if not overridden",
+			10 * time.Minute,
+			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo"}`,
+			http.StatusOK,
+			sql.NullInt32{Valid: true, Int32: 600},
+		},
+		{
+			"caller may override default TTL",
+			10 * time.Minute,
+			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo","expiresInSeconds":30}`,
+			http.StatusOK,
+			sql.NullInt32{Valid: true, Int32: 30},
+		},
+		{
+			"outflow does not expire if there's no default TTL and none is requested",
+			0,
+			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo"}`,
+			http.StatusOK,
+			sql.NullInt32{},
+		},
+		{
+			"negative expiration is an error",
+			10 * time.Minute,
+			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo","expiresInSeconds":-30}`,
+			http.StatusBadRequest,
+			sql.NullInt32{},
+		},
+		{
+			"expiration beyond the maximum is an error",
+			10 * time.Minute,
+			`{"type":"alert-redemption","numPointsToDebit":250,"alertType":"foo","expiresInSeconds":4294967301}`,
+			http.StatusBadRequest,
+			sql.NullInt32{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authClient := authmock.NewClient().AllowTwitchUserAccessToken("mock-token", auth.RoleViewer, auth.UserDetails{
+				Id:          "1001",
+				Login:       "testuser",
+				DisplayName: "TestUser",
+			})
+			q := &mockQueries{
+				balancesByUserId: map[string]queries.GetBalanceRow{
+					"1001": {
+						AvailablePoints: 1000,
+						TotalPoints:     1000,
+					},
+				},
+			}
+			s := &Server{
+				q:          q,
+				defaultTtl: tt.defaultTtl,
+			}
+			f := http.HandlerFunc(s.handleCreateOutflow)
+			handler := auth.RequireAccess(authClient, auth.RoleViewer, f)
+
+			req := httptest.NewRequest(http.MethodPost, "/outflow", strings.NewReader(tt.requestBody))
+			req.Header.Set("authorization", "mock-token")
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			assert.Equal(t, tt.wantStatus, res.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Len(t, q.outflows, 1)
+				assert.Equal(t, tt.wantExpiresInSeconds, q.outflows[0].expiresInSeconds)
+			} else {
+				assert.Empty(t, q.outflows)
+			}
+		})
+	}
+}
+
+func Test_Server_rejectExpiredOutflows(t *testing.T) {
+	q := &mockQueries{
+		outflows: []mockOutflow{
+			{
+				id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+				userId:           "1001",
+				numPointsToDebit: 250,
+				flowType:         "alert-redemption",
+				expired:          true,
+			},
+			{
+				id:               uuid.MustParse("1d0ec8d3-b6bb-47c5-8bd1-7b1c2e1f35a3"),
+				userId:           "1001",
+				numPointsToDebit: 100,
+				flowType:         "alert-redemption",
+			},
+		},
+	}
+	s := &Server{q: q}
+
+	numRejected, err := s.rejectExpiredOutflows(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numRejected)
+	assert.Equal(t, []mockOutflow{
+		{
+			id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+			userId:           "1001",
+			numPointsToDebit: 250,
+			flowType:         "alert-redemption",
+			expired:          true,
+			finalized:        true,
+			accepted:         false,
+			rejectionReason:  "expired",
+		},
+		{
+			id:               uuid.MustParse("1d0ec8d3-b6bb-47c5-8bd1-7b1c2e1f35a3"),
+			userId:           "1001",
+			numPointsToDebit: 100,
+			flowType:         "alert-redemption",
+		},
+	}, q.outflows)
+
+	// A subsequent pass should find nothing left to reject
+	numRejected, err = s.rejectExpiredOutflows(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, numRejected)
+}
+
+func Test_Server_handleGetOutflowTypes(t *testing.T) {
+	q := &mockQueries{
+		outflowTypes: []queries.ListOutflowTypesRow{
+			{
+				Name:                "alert-redemption",
+				Comment:             "Outflow triggered when a user redeems points for an alert.",
+				MetadataSchema:      pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"type":"object"}`)},
+				DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
+			},
+			{
+				Name:    "sticker-purchase",
+				Comment: "Outflow recorded when a user buys a sticker.",
+			},
+		},
+	}
+	s := &Server{q: q}
+
+	req := httptest.NewRequest(http.MethodGet, "/outflow/types", nil)
+	res := httptest.NewRecorder()
+	s.handleGetOutflowTypes(res, req)
+
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	body := strings.TrimSuffix(string(b), "\n")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"items":[{"name":"alert-redemption","comment":"Outflow triggered when a user redeems points for an alert.","metadataSchema":{"type":"object"},"descriptionTemplate":"Redeemed alert of type '{{.type}}'"},{"name":"sticker-purchase","comment":"Outflow recorded when a user buys a sticker."}]}`, body)
+}
+
+func Test_Server_handleRegisterOutflowType(t *testing.T) {
+	tests := []struct {
+		name             string
+		typeName         string
+		requestBody      string
+		wantStatus       int
+		wantBody         string
+		wantOutflowTypes []queries.ListOutflowTypesRow
+	}{
+		{
+			"new outflow type is registered",
+			"sticker-purchase",
+			`{"comment":"Outflow recorded when a user buys a sticker.","metadataSchema":{"type":"object","required":["sticker"]},"descriptionTemplate":"Bought a {{.sticker}} sticker"}`,
+			http.StatusNoContent,
+			"",
+			[]queries.ListOutflowTypesRow{
+				{
+					Name:                "sticker-purchase",
+					Comment:             "Outflow recorded when a user buys a sticker.",
+					MetadataSchema:      pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"type":"object","required":["sticker"]}`)},
+					DescriptionTemplate: sql.NullString{Valid: true, String: "Bought a {{.sticker}} sticker"},
+				},
+			},
+		},
+		{
+			"name must be kebab-case",
+			"Sticker_Purchase",
+			`{"comment":"Outflow recorded when a user buys a sticker."}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"outflow type name must be kebab-case"}`,
+			[]queries.ListOutflowTypesRow{},
+		},
+		{
+			"comment is required",
+			"sticker-purchase",
+			`{}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"comment is required"}`,
+			[]queries.ListOutflowTypesRow{},
+		},
+		{
+			"invalid schema is error",
+			"sticker-purchase",
+			`{"comment":"Outflow recorded when a user buys a sticker.","metadataSchema":{"type":42}}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid metadataSchema: at '/type': value must be one of \"array\", \"boolean\", \"integer\", \"null\", \"number\", \"object\", \"string\"; at '/type': expected array, but got number"}`,
+			[]queries.ListOutflowTypesRow{},
+		},
+		{
+			"invalid description template is error",
+			"sticker-purchase",
+			`{"comment":"Outflow recorded when a user buys a sticker.","descriptionTemplate":"Bought a {{.sticker"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid descriptionTemplate: template: description:1: unclosed action"}`,
+			[]queries.ListOutflowTypesRow{},
+		},
+		{
+			"existing non-outflow type can not be registered as an outflow",
+			"manual-credit",
+			`{"comment":"Not an outflow."}`,
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"conflict","detail":"flow type 'manual-credit' already exists and is not a registered outflow type"}`,
+			[]queries.ListOutflowTypesRow{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{outflowTypes: []queries.ListOutflowTypesRow{}}
+			s := &Server{q: q}
+
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/outflow/types/%s", tt.typeName), strings.NewReader(tt.requestBody))
+			req = mux.SetURLVars(req, map[string]string{"name": tt.typeName})
+			res := httptest.NewRecorder()
+			s.handleRegisterOutflowType(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantOutflowTypes, q.outflowTypes)
+		})
+	}
+}
+
+func Test_Server_handleRegisterOutflowType_update(t *testing.T) {
+	q := &mockQueries{outflowTypes: []queries.ListOutflowTypesRow{
+		{
+			Name:                "sticker-purchase",
+			Comment:             "Outflow recorded when a user buys a sticker.",
+			MetadataSchema:      pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"type":"object","required":["sticker"]}`)},
+			DescriptionTemplate: sql.NullString{Valid: true, String: "Bought a {{.sticker}} sticker"},
+		},
+	}}
+	s := &Server{q: q}
+
+	// Omitting the schema and template should update the comment without clearing them
+	req := httptest.NewRequest(http.MethodPut, "/outflow/types/sticker-purchase", strings.NewReader(`{"comment":"Outflow recorded when a user buys a sticker of any kind."}`))
+	req = mux.SetURLVars(req, map[string]string{"name": "sticker-purchase"})
+	res := httptest.NewRecorder()
+	s.handleRegisterOutflowType(res, req)
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Equal(t, []queries.ListOutflowTypesRow{
+		{
+			Name:                "sticker-purchase",
+			Comment:             "Outflow recorded when a user buys a sticker of any kind.",
+			MetadataSchema:      pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"type":"object","required":["sticker"]}`)},
+			DescriptionTemplate: sql.NullString{Valid: true, String: "Bought a {{.sticker}} sticker"},
+		},
+	}, q.outflowTypes)
+}
+
+func Test_Server_handleFinalizeOutflow(t *testing.T) {
+	tests := []struct {
+		name          string
+		q             *mockQueries
+		method        string
+		flowId        string
+		authorization string
+		wantStatus    int
+		wantBody      string
+		wantOutflows  []mockOutflow
 	}{
 		{
 			"pending outflow can be finalized as accepted via PATCH",
 			&mockQueries{
-				alertRedemptions: []mockAlertRedemptionOutflow{
+				outflows: []mockOutflow{
 					{
 						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 						userId:           "1001",
 						numPointsToDebit: 250,
-						alertType:        "foo",
-						alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 						finalized:        false,
 						accepted:         false,
 					},
@@ -148,13 +497,13 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 			"mock-token",
 			http.StatusNoContent,
 			"",
-			[]mockAlertRedemptionOutflow{
+			[]mockOutflow{
 				{
 					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 					userId:           "1001",
 					numPointsToDebit: 250,
-					alertType:        "foo",
-					alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 					finalized:        true,
 					accepted:         true,
 				},
@@ -163,13 +512,13 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 		{
 			"pending outflow can be finalized as rejected via DELETE",
 			&mockQueries{
-				alertRedemptions: []mockAlertRedemptionOutflow{
+				outflows: []mockOutflow{
 					{
 						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 						userId:           "1001",
 						numPointsToDebit: 250,
-						alertType:        "foo",
-						alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 						finalized:        false,
 						accepted:         false,
 					},
@@ -180,13 +529,13 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 			"mock-token",
 			http.StatusNoContent,
 			"",
-			[]mockAlertRedemptionOutflow{
+			[]mockOutflow{
 				{
 					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 					userId:           "1001",
 					numPointsToDebit: 250,
-					alertType:        "foo",
-					alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 					finalized:        true,
 					accepted:         false,
 				},
@@ -195,13 +544,13 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 		{
 			"attempting to finalize already-finalized outflow results in 409",
 			&mockQueries{
-				alertRedemptions: []mockAlertRedemptionOutflow{
+				outflows: []mockOutflow{
 					{
 						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 						userId:           "1001",
 						numPointsToDebit: 250,
-						alertType:        "foo",
-						alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 						finalized:        true,
 						accepted:         true,
 					},
@@ -211,14 +560,14 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 			"7784d456-c499-4d50-80ed-7feaa2757409",
 			"mock-token",
 			http.StatusConflict,
-			"transaction is not pending",
-			[]mockAlertRedemptionOutflow{
+			`{"title":"Conflict","status":409,"code":"flow_already_finalized","detail":"transaction is not pending"}`,
+			[]mockOutflow{
 				{
 					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 					userId:           "1001",
 					numPointsToDebit: 250,
-					alertType:        "foo",
-					alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 					finalized:        true,
 					accepted:         true,
 				},
@@ -231,19 +580,19 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 			"7784d456-c499-4d50-80ed-7feaa2757409",
 			"mock-token",
 			http.StatusNotFound,
-			"no such transaction",
+			`{"title":"Not Found","status":404,"code":"flow_not_found","detail":"no such transaction"}`,
 			nil,
 		},
 		{
 			"attempting to finalize another user's outflow results in 409",
 			&mockQueries{
-				alertRedemptions: []mockAlertRedemptionOutflow{
+				outflows: []mockOutflow{
 					{
 						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 						userId:           "2002",
 						numPointsToDebit: 250,
-						alertType:        "foo",
-						alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
 						finalized:        false,
 						accepted:         false,
 					},
@@ -253,14 +602,152 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 			"7784d456-c499-4d50-80ed-7feaa2757409",
 			"mock-token",
 			http.StatusNotFound,
-			"no such transaction",
-			[]mockAlertRedemptionOutflow{
+			`{"title":"Not Found","status":404,"code":"flow_not_found","detail":"no such transaction"}`,
+			[]mockOutflow{
 				{
 					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
 					userId:           "2002",
 					numPointsToDebit: 250,
-					alertType:        "foo",
-					alertMetadata:    pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"x":42}`)},
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+					finalized:        false,
+					accepted:         false,
+				},
+			},
+		},
+		{
+			"expired outflow can not be accepted, even if not yet rejected",
+			&mockQueries{
+				outflows: []mockOutflow{
+					{
+						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+						userId:           "1001",
+						numPointsToDebit: 250,
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+						expired:          true,
+						finalized:        false,
+						accepted:         false,
+					},
+				},
+			},
+			http.MethodPatch,
+			"7784d456-c499-4d50-80ed-7feaa2757409",
+			"mock-token",
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"flow_already_finalized","detail":"transaction is not pending"}`,
+			[]mockOutflow{
+				{
+					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+					userId:           "1001",
+					numPointsToDebit: 250,
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+					expired:          true,
+					finalized:        false,
+					accepted:         false,
+				},
+			},
+		},
+		{
+			"expired outflow may still be rejected",
+			&mockQueries{
+				outflows: []mockOutflow{
+					{
+						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+						userId:           "1001",
+						numPointsToDebit: 250,
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+						expired:          true,
+						finalized:        false,
+						accepted:         false,
+					},
+				},
+			},
+			http.MethodDelete,
+			"7784d456-c499-4d50-80ed-7feaa2757409",
+			"mock-token",
+			http.StatusNoContent,
+			"",
+			[]mockOutflow{
+				{
+					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+					userId:           "1001",
+					numPointsToDebit: 250,
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+					expired:          true,
+					finalized:        true,
+					accepted:         false,
+				},
+			},
+		},
+		{
+			"outflow finalized concurrently results in 409",
+			&mockQueries{
+				finalizeRace: true,
+				outflows: []mockOutflow{
+					{
+						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+						userId:           "1001",
+						numPointsToDebit: 250,
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+						expired:          false,
+						finalized:        false,
+						accepted:         false,
+					},
+				},
+			},
+			http.MethodPatch,
+			"7784d456-c499-4d50-80ed-7feaa2757409",
+			"mock-token",
+			http.StatusConflict,
+			`{"title":"Conflict","status":409,"code":"flow_already_finalized","detail":"transaction is not pending"}`,
+			[]mockOutflow{
+				{
+					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+					userId:           "1001",
+					numPointsToDebit: 250,
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+					expired:          false,
+					finalized:        true,
+					accepted:         false,
+				},
+			},
+		},
+		{
+			"failure to finalize is a 500 error",
+			&mockQueries{
+				finalizeErr: fmt.Errorf("mock error"),
+				outflows: []mockOutflow{
+					{
+						id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+						userId:           "1001",
+						numPointsToDebit: 250,
+						flowType:         "alert-redemption",
+						metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+						expired:          false,
+						finalized:        false,
+						accepted:         false,
+					},
+				},
+			},
+			http.MethodPatch,
+			"7784d456-c499-4d50-80ed-7feaa2757409",
+			"mock-token",
+			http.StatusInternalServerError,
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"mock error"}`,
+			[]mockOutflow{
+				{
+					id:               uuid.MustParse("7784d456-c499-4d50-80ed-7feaa2757409"),
+					userId:           "1001",
+					numPointsToDebit: 250,
+					flowType:         "alert-redemption",
+					metadata:         json.RawMessage(`{"type":"foo","x":42}`),
+					expired:          false,
 					finalized:        false,
 					accepted:         false,
 				},
@@ -292,7 +779,7 @@ func Test_Server_handleFinalizeOutflow(t *testing.T) {
 			assert.Equal(t, tt.wantStatus, res.Code)
 			assert.Equal(t, tt.wantBody, body)
 
-			assert.Equal(t, tt.wantAlertRedemptions, tt.q.alertRedemptions)
+			assert.Equal(t, tt.wantOutflows, tt.q.outflows)
 		})
 	}
 }
@@ -301,41 +788,101 @@ type mockQueries struct {
 	idSequence       []uuid.UUID
 	nextIdIndex      int
 	balancesByUserId map[string]queries.GetBalanceRow
-	alertRedemptions []mockAlertRedemptionOutflow
+	outflows         []mockOutflow
+	outflowTypes     []queries.ListOutflowTypesRow
+	finalizeErr      error
+	// finalizeRace simulates our reaper rejecting an outflow after GetFlow but before
+	// FinalizeFlow
+	finalizeRace bool
 }
 
-type mockAlertRedemptionOutflow struct {
+func (m *mockQueries) getOutflowTypes() []queries.ListOutflowTypesRow {
+	if m.outflowTypes == nil {
+		m.outflowTypes = []queries.ListOutflowTypesRow{
+			{
+				Name:                "alert-redemption",
+				Comment:             "Outflow triggered when a user redeems points for an alert.",
+				MetadataSchema:      pqtype.NullRawMessage{Valid: true, RawMessage: []byte(`{"type":"object","required":["type"],"properties":{"type":{"type":"string","minLength":1}}}`)},
+				DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
+			},
+		}
+	}
+	return m.outflowTypes
+}
+
+type mockOutflow struct {
 	id               uuid.UUID
 	userId           string
 	numPointsToDebit int32
-	alertType        string
-	alertMetadata    pqtype.NullRawMessage
+	flowType         string
+	metadata         json.RawMessage
+	expiresInSeconds sql.NullInt32
+	expired          bool
 	finalized        bool
 	accepted         bool
+	rejectionReason  string
 }
 
-func (m *mockQueries) GetBalance(ctx context.Context, twitchUserID string) (queries.GetBalanceRow, error) {
-	balance, ok := m.balancesByUserId[twitchUserID]
-	if !ok {
-		return queries.GetBalanceRow{}, sql.ErrNoRows
+func (m *mockQueries) GetOutflowType(ctx context.Context, name string) (queries.GetOutflowTypeRow, error) {
+	for _, row := range m.getOutflowTypes() {
+		if row.Name == name {
+			return queries.GetOutflowTypeRow(row), nil
+		}
 	}
-	return balance, nil
+	return queries.GetOutflowTypeRow{}, sql.ErrNoRows
+}
+
+func (m *mockQueries) ListOutflowTypes(ctx context.Context) ([]queries.ListOutflowTypesRow, error) {
+	return m.getOutflowTypes(), nil
 }
 
-func (m *mockQueries) RecordPendingAlertRedemptionOutflow(ctx context.Context, arg queries.RecordPendingAlertRedemptionOutflowParams) (uuid.UUID, error) {
+func (m *mockQueries) RegisterOutflowType(ctx context.Context, arg queries.RegisterOutflowTypeParams) (sql.Result, error) {
+	if arg.Name == "manual-credit" {
+		return &mockSqlResult{0}, nil
+	}
+	row := queries.ListOutflowTypesRow(arg)
+	types := m.getOutflowTypes()
+	for i := range types {
+		if types[i].Name == arg.Name {
+			if !row.MetadataSchema.Valid {
+				row.MetadataSchema = types[i].MetadataSchema
+			}
+			if !row.DescriptionTemplate.Valid {
+				row.DescriptionTemplate = types[i].DescriptionTemplate
+			}
+			types[i] = row
+			return &mockSqlResult{1}, nil
+		}
+	}
+	m.outflowTypes = append(types, row)
+	return &mockSqlResult{1}, nil
+}
+
+func (m *mockQueries) RecordPendingOutflow(ctx context.Context, arg queries.RecordPendingOutflowParams) (uuid.UUID, error) {
+	if _, err := m.GetOutflowType(ctx, arg.Type); err != nil {
+		return uuid.UUID{}, err
+	}
+	if m.balancesByUserId[arg.TwitchUserID].AvailablePoints < arg.NumPointsToDebit {
+		return uuid.UUID{}, &pq.Error{
+			Code:       "23514",
+			Message:    "available balance may not go below zero",
+			Constraint: util.AvailableBalanceConstraint,
+		}
+	}
 	id := m.generateId()
-	m.alertRedemptions = append(m.alertRedemptions, mockAlertRedemptionOutflow{
+	m.outflows = append(m.outflows, mockOutflow{
 		id:               id,
 		userId:           arg.TwitchUserID,
 		numPointsToDebit: arg.NumPointsToDebit,
-		alertType:        arg.AlertType,
-		alertMetadata:    arg.AlertMetadata,
+		flowType:         arg.Type,
+		metadata:         arg.Metadata,
+		expiresInSeconds: arg.ExpiresInSeconds,
 	})
 	return id, nil
 }
 
 func (m *mockQueries) GetFlow(ctx context.Context, flowID uuid.UUID) (queries.GetFlowRow, error) {
-	for _, flow := range m.alertRedemptions {
+	for _, flow := range m.outflows {
 		if flow.id == flowID {
 			finalizedAt := sql.NullTime{}
 			if flow.finalized {
@@ -353,20 +900,38 @@ func (m *mockQueries) GetFlow(ctx context.Context, flowID uuid.UUID) (queries.Ge
 }
 
 func (m *mockQueries) FinalizeFlow(ctx context.Context, arg queries.FinalizeFlowParams) (sql.Result, error) {
-	for i := range m.alertRedemptions {
-		flow := &m.alertRedemptions[i]
+	if m.finalizeErr != nil {
+		return nil, m.finalizeErr
+	}
+	for i := range m.outflows {
+		flow := &m.outflows[i]
 		if flow.id == arg.FlowID {
-			if flow.finalized {
+			if m.finalizeRace {
+				flow.finalized = true
+				m.finalizeRace = false
+			}
+			if flow.finalized || (arg.Accepted && flow.expired) {
 				return &mockSqlResult{0}, nil
 			}
 			flow.finalized = true
 			flow.accepted = arg.Accepted
+			flow.rejectionReason = arg.RejectionReason.String
 			return &mockSqlResult{1}, nil
 		}
 	}
 	return &mockSqlResult{0}, nil
 }
 
+func (m *mockQueries) GetExpiredPendingFlowIds(ctx context.Context, numRecords int32) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0)
+	for _, flow := range m.outflows {
+		if flow.expired && !flow.finalized && len(ids) < int(numRecords) {
+			ids = append(ids, flow.id)
+		}
+	}
+	return ids, nil
+}
+
 func (m *mockQueries) generateId() uuid.UUID {
 	if m.nextIdIndex < len(m.idSequence) {
 		i := m.nextIdIndex
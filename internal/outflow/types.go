@@ -9,8 +9,11 @@ import (
 )
 
 type Queries interface {
-	GetBalance(ctx context.Context, twitchUserID string) (queries.GetBalanceRow, error)
-	RecordPendingAlertRedemptionOutflow(ctx context.Context, arg queries.RecordPendingAlertRedemptionOutflowParams) (uuid.UUID, error)
+	GetOutflowType(ctx context.Context, name string) (queries.GetOutflowTypeRow, error)
+	ListOutflowTypes(ctx context.Context) ([]queries.ListOutflowTypesRow, error)
+	RegisterOutflowType(ctx context.Context, arg queries.RegisterOutflowTypeParams) (sql.Result, error)
+	RecordPendingOutflow(ctx context.Context, arg queries.RecordPendingOutflowParams) (uuid.UUID, error)
 	GetFlow(ctx context.Context, flowID uuid.UUID) (queries.GetFlowRow, error)
 	FinalizeFlow(ctx context.Context, arg queries.FinalizeFlowParams) (sql.Result, error)
+	GetExpiredPendingFlowIds(ctx context.Context, numRecords int32) ([]uuid.UUID, error)
 }
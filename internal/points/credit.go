@@ -0,0 +1,42 @@
+package points
+
+import (
+	"errors"
+	"math"
+)
+
+// MaxCredit is the largest number of points that a single transaction may credit, as
+// limited by the int32 type of the flow.delta_points column
+const MaxCredit = math.MaxInt32
+
+// ErrCreditOverflow is returned when a credit would exceed MaxCredit
+var ErrCreditOverflow = errors.New("number of points to credit exceeds the maximum that can be recorded in a single transaction")
+
+// Credit is the shared calculation by which every credit is computed: it returns the
+// number of points to credit for quantity units (e.g. bits or subscriptions) that are
+// each worth basePoints, scaled by multiplier (e.g. to account for the subscription
+// tier). Any fractional result is rounded to the nearest whole point, with halves
+// rounded away from zero, so that the same purchase always earns the same number of
+// points regardless of how it's broken down into multiplier and quantity. If the result
+// (or the quantity itself) can't be stored as an int32, ErrCreditOverflow is returned.
+func Credit(basePoints int, quantity int, multiplier float64) (int32, error) {
+	if quantity > math.MaxInt32 {
+		return 0, ErrCreditOverflow
+	}
+	// Integers up to 2^53 are represented exactly as float64 values, so this product
+	// is exact for any result that we could actually record
+	numPoints := math.Round(float64(basePoints) * float64(quantity) * multiplier)
+	if numPoints > MaxCredit || math.IsNaN(numPoints) {
+		return 0, ErrCreditOverflow
+	}
+	return int32(numPoints), nil
+}
+
+// addCredit sums two credits, returning ErrCreditOverflow if the total exceeds MaxCredit
+func addCredit(a int32, b int32) (int32, error) {
+	total := int64(a) + int64(b)
+	if total > MaxCredit {
+		return 0, ErrCreditOverflow
+	}
+	return int32(total), nil
+}
@@ -0,0 +1,110 @@
+package points
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Credit(t *testing.T) {
+	tests := []struct {
+		name       string
+		basePoints int
+		quantity   int
+		multiplier float64
+		want       int32
+		wantErr    error
+	}{
+		{"Tier 1 subscription", 600, 1, 1.0, 600, nil},
+		{"Tier 2 subscription", 600, 1, 2.0, 1200, nil},
+		{"Tier 3 subscription", 600, 1, 5.0, 3000, nil},
+		{"Tier 1 gift subs", 200, 10, 1.0, 2000, nil},
+		{"Tier 2 gift subs", 200, 8, 2.0, 3200, nil},
+		{"Tier 3 gift subs", 200, 4, 5.0, 4000, nil},
+		{"fractional promotional multiplier", 600, 1, 1.5, 900, nil},
+		{"fractional promotional multiplier on gift subs", 200, 3, 1.25, 750, nil},
+		{"fractional result is rounded down below half", 1, 7, 0.3, 2, nil},
+		{"fractional result is rounded up from half", 1, 5, 0.5, 3, nil},
+		{"fractional result is rounded up above half", 1, 7, 0.4, 3, nil},
+		{"multiplier is applied to the total, not per unit", 1, 3, 0.5, 2, nil},
+		{"large gift bomb", 200, 1000, 5.0, 1000000, nil},
+		{"largest recordable credit", 1, math.MaxInt32, 1.0, math.MaxInt32, nil},
+		{"very large gift bomb overflows", 200, 10000000, 5.0, 0, ErrCreditOverflow},
+		{"credit just beyond int32 range overflows", 2, math.MaxInt32/2 + 1, 1.0, 0, ErrCreditOverflow},
+		{"quantity beyond int32 range overflows", 1, math.MaxInt32 + 1, 0.5, 0, ErrCreditOverflow},
+		{"multiplier rounding up beyond int32 range overflows", 1, math.MaxInt32, 1.0000001, 0, ErrCreditOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Credit(tt.basePoints, tt.quantity, tt.multiplier)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_Policy_credits(t *testing.T) {
+	p := Policy{
+		PointsPerBit:             1,
+		PointsPerSubscription:    600,
+		PointsPerGiftSub:         600,
+		Tier2Multiplier:          2.5,
+		Tier3Multiplier:          5.0,
+		InitialSubscriptionBonus: 100,
+	}
+	tiers := []ledger.SubscriptionTier{ledger.SubscriptionTier1, ledger.SubscriptionTier2, ledger.SubscriptionTier3}
+	for _, tier := range tiers {
+		t.Run(string(tier), func(t *testing.T) {
+			multiplier, ok := p.TierMultiplier(tier)
+			assert.True(t, ok)
+
+			// Gifting a single sub should earn the gifter exactly as many points as a
+			// renewal of the same tier, given the same base points for both
+			renewal, err := p.SubscriptionCredit(multiplier, false, false)
+			assert.NoError(t, err)
+			giftSub, err := p.GiftSubCredit(multiplier, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, renewal, giftSub)
+
+			// Only a purchased initial sub should earn the bonus
+			initial, err := p.SubscriptionCredit(multiplier, true, false)
+			assert.NoError(t, err)
+			assert.Equal(t, renewal+100, initial)
+			gifted, err := p.SubscriptionCredit(multiplier, true, true)
+			assert.NoError(t, err)
+			assert.Equal(t, renewal, gifted)
+		})
+	}
+
+	// The bonus must not be allowed to push a subscription credit beyond int32 range
+	p.PointsPerSubscription = math.MaxInt32
+	_, err := p.SubscriptionCredit(1.0, true, false)
+	assert.ErrorIs(t, err, ErrCreditOverflow)
+
+	// Cheering more bits than we can credit should fail rather than wrap around
+	_, err = p.CheerCredit(math.MaxInt32 + 1)
+	assert.ErrorIs(t, err, ErrCreditOverflow)
+
+	// Raids and hype trains scale with the number of viewers and the level reached
+	p = DefaultPolicy
+	raid, err := p.RaidCredit(42)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(420), raid)
+	hypeTrain, err := p.HypeTrainCredit(3)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(300), hypeTrain)
+	follow, err := p.FollowCredit()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(50), follow)
+
+	// Watch time scales with the number of minutes watched
+	watchTime, err := p.WatchTimeCredit(5)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(10), watchTime)
+}
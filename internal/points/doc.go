@@ -0,0 +1,5 @@
+// Package points implements the policy that determines how many points are credited
+// to users when they support the channel by cheering, subscribing, raiding, following,
+// or contributing to a hype train, and when they spend time watching streams, so that
+// internal services need only tell us what happened on Twitch
+package points
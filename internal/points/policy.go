@@ -0,0 +1,219 @@
+package points
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+)
+
+// Policy determines how many points are credited for each cheer, subscription, gift
+// sub, raid, follow, and hype train contribution, and for time spent watching streams
+type Policy struct {
+	// PointsPerBit is the number of points credited for each bit cheered
+	PointsPerBit int
+	// PointsPerSubscription is the number of points credited for a single month of a
+	// Tier 1 subscription, whether purchased, renewed, or received as a gift
+	PointsPerSubscription int
+	// PointsPerGiftSub is the number of points credited to the purchaser of a gift sub
+	// for each Tier 1 subscription they gift
+	PointsPerGiftSub int
+	// Tier2Multiplier scales subscription and gift sub credits for Tier 2 subs
+	Tier2Multiplier float64
+	// Tier3Multiplier scales subscription and gift sub credits for Tier 3 subs
+	Tier3Multiplier float64
+	// InitialSubscriptionBonus is the number of additional points credited when a user
+	// purchases an initial subscription: it does not apply to gift subs or renewals
+	InitialSubscriptionBonus int
+	// PointsPerRaidViewer is the number of points credited to a raider for each viewer
+	// who arrives with their raid
+	PointsPerRaidViewer int
+	// PointsPerFollow is the number of points credited to a user when they first follow
+	// the channel
+	PointsPerFollow int
+	// PointsPerHypeTrainLevel is the number of points credited to each contributor to a
+	// hype train for each level that the hype train reached
+	PointsPerHypeTrainLevel int
+	// PointsPerWatchMinute is the number of points credited to a viewer for each minute
+	// they spend watching a stream
+	PointsPerWatchMinute int
+	// WatchTimeDailyCap is the maximum number of points that may be credited to any one
+	// user for watch time on a single day (in UTC)
+	WatchTimeDailyCap int
+}
+
+// DefaultPolicy is the policy that the ledger applies unless configured otherwise
+var DefaultPolicy = Policy{
+	PointsPerBit:             1,
+	PointsPerSubscription:    600,
+	PointsPerGiftSub:         200,
+	Tier2Multiplier:          2.0,
+	Tier3Multiplier:          5.0,
+	InitialSubscriptionBonus: 0,
+	PointsPerRaidViewer:      10,
+	PointsPerFollow:          50,
+	PointsPerHypeTrainLevel:  100,
+	PointsPerWatchMinute:     2,
+	WatchTimeDailyCap:        480,
+}
+
+// Validate returns an error if the policy could credit a non-positive number of points
+func (p Policy) Validate() error {
+	if p.PointsPerBit <= 0 {
+		return fmt.Errorf("points per bit must be positive")
+	}
+	if p.PointsPerSubscription <= 0 {
+		return fmt.Errorf("points per subscription must be positive")
+	}
+	if p.PointsPerGiftSub <= 0 {
+		return fmt.Errorf("points per gift sub must be positive")
+	}
+	if p.Tier2Multiplier <= 0 || p.Tier3Multiplier <= 0 {
+		return fmt.Errorf("tier multipliers must be positive")
+	}
+	if p.InitialSubscriptionBonus < 0 {
+		return fmt.Errorf("initial subscription bonus must not be negative")
+	}
+	if p.PointsPerRaidViewer <= 0 {
+		return fmt.Errorf("points per raid viewer must be positive")
+	}
+	if p.PointsPerFollow <= 0 {
+		return fmt.Errorf("points per follow must be positive")
+	}
+	if p.PointsPerHypeTrainLevel <= 0 {
+		return fmt.Errorf("points per hype train level must be positive")
+	}
+	if p.PointsPerWatchMinute <= 0 {
+		return fmt.Errorf("points per watch minute must be positive")
+	}
+	if p.WatchTimeDailyCap < p.PointsPerWatchMinute {
+		return fmt.Errorf("watch time daily cap must be at least the number of points per watch minute")
+	}
+	for _, numPoints := range []int{p.PointsPerBit, p.PointsPerSubscription, p.PointsPerGiftSub, p.InitialSubscriptionBonus, p.PointsPerRaidViewer, p.PointsPerFollow, p.PointsPerHypeTrainLevel, p.PointsPerWatchMinute, p.WatchTimeDailyCap} {
+		if numPoints > MaxCredit {
+			return fmt.Errorf("number of points must not exceed %d", MaxCredit)
+		}
+	}
+	return nil
+}
+
+// Version returns a short string that uniquely identifies the policy, derived from its
+// parameters, so that any change to the policy results in a new version
+func (p Policy) Version() string {
+	s := fmt.Sprintf("%d/%d/%d/%g/%g/%d/%d/%d/%d/%d/%d",
+		p.PointsPerBit,
+		p.PointsPerSubscription,
+		p.PointsPerGiftSub,
+		p.Tier2Multiplier,
+		p.Tier3Multiplier,
+		p.InitialSubscriptionBonus,
+		p.PointsPerRaidViewer,
+		p.PointsPerFollow,
+		p.PointsPerHypeTrainLevel,
+		p.PointsPerWatchMinute,
+		p.WatchTimeDailyCap,
+	)
+	digest := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(digest[:6])
+}
+
+// Register records the policy in the database, so that the version recorded in the
+// metadata of each inflow can be traced back to the parameters that were applied
+func (p Policy) Register(ctx context.Context, q Queries) error {
+	return q.RegisterPointsPolicy(ctx, queries.RegisterPointsPolicyParams{
+		Version:                  p.Version(),
+		PointsPerBit:             int32(p.PointsPerBit),
+		PointsPerSubscription:    int32(p.PointsPerSubscription),
+		PointsPerGiftSub:         int32(p.PointsPerGiftSub),
+		Tier2Multiplier:          p.Tier2Multiplier,
+		Tier3Multiplier:          p.Tier3Multiplier,
+		InitialSubscriptionBonus: int32(p.InitialSubscriptionBonus),
+		PointsPerRaidViewer:      sql.NullInt32{Int32: int32(p.PointsPerRaidViewer), Valid: true},
+		PointsPerFollow:          sql.NullInt32{Int32: int32(p.PointsPerFollow), Valid: true},
+		PointsPerHypeTrainLevel:  sql.NullInt32{Int32: int32(p.PointsPerHypeTrainLevel), Valid: true},
+		PointsPerWatchMinute:     sql.NullInt32{Int32: int32(p.PointsPerWatchMinute), Valid: true},
+		WatchTimeDailyCap:        sql.NullInt32{Int32: int32(p.WatchTimeDailyCap), Valid: true},
+	})
+}
+
+// TierMultiplier returns the factor by which credits are scaled for subscriptions of
+// the given tier, or false if the tier is not recognized
+func (p Policy) TierMultiplier(tier ledger.SubscriptionTier) (float64, bool) {
+	switch tier {
+	case ledger.SubscriptionTier1:
+		return 1.0, true
+	case ledger.SubscriptionTier2:
+		return p.Tier2Multiplier, true
+	case ledger.SubscriptionTier3:
+		return p.Tier3Multiplier, true
+	}
+	return 0, false
+}
+
+// CheerCredit returns the number of points to credit for cheering the given number of
+// bits
+func (p Policy) CheerCredit(numBits int) (int32, error) {
+	return Credit(p.PointsPerBit, numBits, 1.0)
+}
+
+// SubscriptionCredit returns the number of points to credit to a user for a single
+// month of a subscription with the given tier multiplier, plus any bonus for an initial
+// subscription
+func (p Policy) SubscriptionCredit(multiplier float64, isInitial bool, isGift bool) (int32, error) {
+	numPoints, err := Credit(p.PointsPerSubscription, 1, multiplier)
+	if err != nil {
+		return 0, err
+	}
+	if isInitial && !isGift {
+		return addCredit(numPoints, int32(p.InitialSubscriptionBonus))
+	}
+	return numPoints, nil
+}
+
+// GiftSubCredit returns the number of points to credit to a user for gifting the given
+// number of subscriptions with the given tier multiplier
+func (p Policy) GiftSubCredit(multiplier float64, numSubscriptions int) (int32, error) {
+	return Credit(p.PointsPerGiftSub, numSubscriptions, multiplier)
+}
+
+// RaidCredit returns the number of points to credit to a user for raiding the channel
+// with the given number of viewers
+func (p Policy) RaidCredit(numViewers int) (int32, error) {
+	return Credit(p.PointsPerRaidViewer, numViewers, 1.0)
+}
+
+// FollowCredit returns the number of points to credit to a user for following the
+// channel for the first time
+func (p Policy) FollowCredit() (int32, error) {
+	return Credit(p.PointsPerFollow, 1, 1.0)
+}
+
+// HypeTrainCredit returns the number of points to credit to a user for contributing to
+// a hype train that reached the given level
+func (p Policy) HypeTrainCredit(level int) (int32, error) {
+	return Credit(p.PointsPerHypeTrainLevel, level, 1.0)
+}
+
+// WatchTimeCredit returns the number of points to credit to a user for watching a
+// stream for the given number of minutes, before the daily cap is applied
+func (p Policy) WatchTimeCredit(minutesWatched int) (int32, error) {
+	return Credit(p.PointsPerWatchMinute, minutesWatched, 1.0)
+}
+
+// CapWatchTimeCredit returns the portion of the given watch-time credit that may be
+// credited to a user who has already been credited with numPointsToday points for
+// watch time today, so that their daily total never exceeds the daily cap
+func (p Policy) CapWatchTimeCredit(numPoints int32, numPointsToday int32) int32 {
+	remaining := int32(p.WatchTimeDailyCap) - numPointsToday
+	if remaining <= 0 {
+		return 0
+	}
+	if numPoints > remaining {
+		return remaining
+	}
+	return numPoints
+}
@@ -0,0 +1,96 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Policy_Version(t *testing.T) {
+	// The version should be stable for a given set of parameters
+	assert.Equal(t, DefaultPolicy.Version(), DefaultPolicy.Version())
+	assert.Len(t, DefaultPolicy.Version(), 12)
+
+	// Changing any parameter should result in a new version
+	p := DefaultPolicy
+	p.InitialSubscriptionBonus = 100
+	assert.NotEqual(t, DefaultPolicy.Version(), p.Version())
+	p = DefaultPolicy
+	p.Tier2Multiplier = 2.5
+	assert.NotEqual(t, DefaultPolicy.Version(), p.Version())
+	p = DefaultPolicy
+	p.PointsPerFollow = 75
+	assert.NotEqual(t, DefaultPolicy.Version(), p.Version())
+	p = DefaultPolicy
+	p.WatchTimeDailyCap = 600
+	assert.NotEqual(t, DefaultPolicy.Version(), p.Version())
+}
+
+func Test_Policy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.Validate())
+
+	p := DefaultPolicy
+	p.PointsPerBit = 0
+	assert.Error(t, p.Validate())
+	p = DefaultPolicy
+	p.Tier3Multiplier = -1
+	assert.Error(t, p.Validate())
+	p = DefaultPolicy
+	p.InitialSubscriptionBonus = -100
+	assert.Error(t, p.Validate())
+	p = DefaultPolicy
+	p.PointsPerRaidViewer = 0
+	assert.Error(t, p.Validate())
+	p = DefaultPolicy
+	p.PointsPerHypeTrainLevel = -1
+	assert.Error(t, p.Validate())
+	p = DefaultPolicy
+	p.PointsPerWatchMinute = 0
+	assert.Error(t, p.Validate())
+	p = DefaultPolicy
+	p.WatchTimeDailyCap = 1
+	assert.Error(t, p.Validate())
+}
+
+func Test_Policy_CapWatchTimeCredit(t *testing.T) {
+	tests := []struct {
+		name           string
+		numPoints      int32
+		numPointsToday int32
+		want           int32
+	}{
+		{"credit well under the cap is unaffected", 10, 0, 10},
+		{"credit that exactly reaches the cap is unaffected", 80, 400, 80},
+		{"credit that would exceed the cap is reduced", 100, 400, 80},
+		{"nothing is credited once the cap is reached", 10, 480, 0},
+		{"nothing is credited if the cap has been lowered", 10, 500, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultPolicy.CapWatchTimeCredit(tt.numPoints, tt.numPointsToday)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_Policy_TierMultiplier(t *testing.T) {
+	tests := []struct {
+		tier   ledger.SubscriptionTier
+		want   float64
+		wantOk bool
+	}{
+		{ledger.SubscriptionTier1, 1.0, true},
+		{ledger.SubscriptionTier2, 2.0, true},
+		{ledger.SubscriptionTier3, 5.0, true},
+		{"", 0, false},
+		{"prime", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.tier), func(t *testing.T) {
+			got, ok := DefaultPolicy.TierMultiplier(tt.tier)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package points
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
+)
+
+// PromotionQueries is the subset of queries needed to look up the promotion that
+// applies to an inflow
+type PromotionQueries interface {
+	GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error)
+}
+
+// AppliedPromotion identifies the promotion that scaled the credit for an inflow, as
+// recorded in the inflow's metadata.promotion field
+type AppliedPromotion struct {
+	Id         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Multiplier float64   `json:"multiplier"`
+}
+
+// ApplyPromotion scales the given credit by the multiplier of the most generous
+// promotion that's currently active for the given flow type, returning the scaled
+// credit along with a description of the promotion to be recorded in the flow's
+// metadata. If no promotion is active, the credit is returned unchanged, and the
+// returned metadata is NULL. The scaled credit is computed via Credit, so it's subject
+// to the same rounding and overflow checks as any other credit.
+func ApplyPromotion(ctx context.Context, q PromotionQueries, flowType ledger.TransactionType, numPoints int32) (int32, pqtype.NullRawMessage, error) {
+	row, err := q.GetActivePromotion(ctx, string(flowType))
+	if errors.Is(err, sql.ErrNoRows) {
+		return numPoints, pqtype.NullRawMessage{}, nil
+	}
+	if err != nil {
+		return 0, pqtype.NullRawMessage{}, err
+	}
+
+	scaled, err := Credit(int(numPoints), 1, row.Multiplier)
+	if err != nil {
+		return 0, pqtype.NullRawMessage{}, err
+	}
+	data, err := json.Marshal(AppliedPromotion{
+		Id:         row.ID,
+		Name:       row.Name,
+		Multiplier: row.Multiplier,
+	})
+	if err != nil {
+		return 0, pqtype.NullRawMessage{}, err
+	}
+	return scaled, pqtype.NullRawMessage{RawMessage: data, Valid: true}, nil
+}
@@ -0,0 +1,116 @@
+package points
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ApplyPromotion(t *testing.T) {
+	tests := []struct {
+		name         string
+		q            *mockPromotionQueries
+		numPoints    int32
+		want         int32
+		wantMetadata string
+		wantErr      string
+	}{
+		{
+			"credit is unchanged when no promotion is active",
+			&mockPromotionQueries{},
+			600,
+			600,
+			"",
+			"",
+		},
+		{
+			"active promotion scales credit",
+			&mockPromotionQueries{
+				promotion: &queries.GetActivePromotionRow{
+					ID:         uuid.MustParse("7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e"),
+					Name:       "Double Points Night",
+					Multiplier: 2.0,
+				},
+			},
+			600,
+			1200,
+			`{"id":"7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e","name":"Double Points Night","multiplier":2}`,
+			"",
+		},
+		{
+			"fractional promotional credit is rounded",
+			&mockPromotionQueries{
+				promotion: &queries.GetActivePromotionRow{
+					ID:         uuid.MustParse("7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e"),
+					Name:       "Time and a Half",
+					Multiplier: 1.5,
+				},
+			},
+			5,
+			8,
+			`{"id":"7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e","name":"Time and a Half","multiplier":1.5}`,
+			"",
+		},
+		{
+			"promotional credit may not overflow",
+			&mockPromotionQueries{
+				promotion: &queries.GetActivePromotionRow{
+					ID:         uuid.MustParse("7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e"),
+					Name:       "Double Points Night",
+					Multiplier: 2.0,
+				},
+			},
+			MaxCredit,
+			0,
+			"",
+			ErrCreditOverflow.Error(),
+		},
+		{
+			"database error is returned",
+			&mockPromotionQueries{err: fmt.Errorf("mock error")},
+			600,
+			0,
+			"",
+			"mock error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, metadata, err := ApplyPromotion(context.Background(), tt.q, ledger.TransactionTypeCheer, tt.numPoints)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantMetadata != "", metadata.Valid)
+			if tt.wantMetadata != "" {
+				assert.Equal(t, tt.wantMetadata, string(metadata.RawMessage))
+			}
+			assert.Equal(t, "cheer", tt.q.flowType)
+		})
+	}
+}
+
+type mockPromotionQueries struct {
+	promotion *queries.GetActivePromotionRow
+	err       error
+	flowType  string
+}
+
+func (m *mockPromotionQueries) GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error) {
+	m.flowType = flowType
+	if m.err != nil {
+		return queries.GetActivePromotionRow{}, m.err
+	}
+	if m.promotion == nil {
+		return queries.GetActivePromotionRow{}, sql.ErrNoRows
+	}
+	return *m.promotion, nil
+}
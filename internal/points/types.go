@@ -0,0 +1,11 @@
+package points
+
+import (
+	"context"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+)
+
+type Queries interface {
+	RegisterPointsPolicy(ctx context.Context, arg queries.RegisterPointsPolicyParams) error
+}
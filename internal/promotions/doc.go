@@ -0,0 +1,5 @@
+// Package promotions implements the admin-only API routes that allow the broadcaster to
+// schedule time-boxed promotions, such as a "Double Points Night", during which inflows
+// earn a multiple of the points they would ordinarily be credited. Promotions are
+// applied by the inflow handlers themselves, via points.ApplyPromotion.
+package promotions
@@ -0,0 +1,194 @@
+package promotions
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MaxNameLen is the maximum length of a promotion's user-facing name
+const MaxNameLen = 64
+
+// PromotableFlowTypes lists the types of inflow to which promotions are applied
+var PromotableFlowTypes = []ledger.TransactionType{
+	ledger.TransactionTypeCheer,
+	ledger.TransactionTypeSubscription,
+	ledger.TransactionTypeGiftSub,
+	ledger.TransactionTypeRaid,
+	ledger.TransactionTypeFollow,
+	ledger.TransactionTypeHypeTrain,
+}
+
+type Server struct {
+	q Queries
+}
+
+func NewServer(q Queries) *Server {
+	return &Server{
+		q: q,
+	}
+}
+
+func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
+	r.Path("/admin/promotions").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostPromotion),
+		),
+	)
+	r.Path("/admin/promotions").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleGetPromotions),
+		),
+	)
+	r.Path("/admin/promotions/{id}").Methods("DELETE").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleDeletePromotion),
+		),
+	)
+}
+
+func (s *Server) handlePostPromotion(res http.ResponseWriter, req *http.Request) {
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ledger.Promotion
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if payload.Name == "" || len(payload.Name) > MaxNameLen {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: 'name' must be set to a string of at most %d characters", MaxNameLen))
+		return
+	}
+	if !(payload.Multiplier > 0) || math.IsInf(payload.Multiplier, 0) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'multiplier' must be set to a positive number")
+		return
+	}
+	for _, flowType := range payload.FlowTypes {
+		if !isPromotable(flowType) {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: promotions can not be applied to flow type '%s'", flowType))
+			return
+		}
+	}
+
+	// A promotion that doesn't specify a start time begins immediately, and every
+	// promotion must end at some point in the future
+	now := time.Now()
+	if payload.StartsAt.IsZero() {
+		payload.StartsAt = now
+	}
+	if payload.EndsAt.IsZero() || !payload.EndsAt.After(payload.StartsAt) || !payload.EndsAt.After(now) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'endsAt' must be set to a future time after 'startsAt'")
+		return
+	}
+
+	// Schedule the promotion
+	var flowTypes []string
+	for _, flowType := range payload.FlowTypes {
+		flowTypes = append(flowTypes, string(flowType))
+	}
+	row, err := s.q.SchedulePromotion(req.Context(), queries.SchedulePromotionParams{
+		Name:       payload.Name,
+		Multiplier: payload.Multiplier,
+		FlowTypes:  flowTypes,
+		StartsAt:   payload.StartsAt,
+		EndsAt:     payload.EndsAt,
+	})
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Return a JSON-serialized Promotion struct to the user
+	result := &ledger.Promotion{
+		Id:         row.ID,
+		Name:       payload.Name,
+		Multiplier: payload.Multiplier,
+		FlowTypes:  payload.FlowTypes,
+		StartsAt:   payload.StartsAt,
+		EndsAt:     payload.EndsAt,
+		CreatedAt:  row.CreatedAt,
+	}
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handleGetPromotions(res http.ResponseWriter, req *http.Request) {
+	// List all promotions that are currently active or yet to start
+	rows, err := s.q.ListPromotions(req.Context())
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	items := make([]ledger.Promotion, 0, len(rows))
+	for _, row := range rows {
+		var flowTypes []ledger.TransactionType
+		for _, flowType := range row.FlowTypes {
+			flowTypes = append(flowTypes, ledger.TransactionType(flowType))
+		}
+		items = append(items, ledger.Promotion{
+			Id:         row.ID,
+			Name:       row.Name,
+			Multiplier: row.Multiplier,
+			FlowTypes:  flowTypes,
+			StartsAt:   row.StartsAt,
+			EndsAt:     row.EndsAt,
+			CreatedAt:  row.CreatedAt,
+		})
+	}
+	if err := json.NewEncoder(res).Encode(ledger.PromotionList{Items: items}); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handleDeletePromotion(res http.ResponseWriter, req *http.Request) {
+	// Parse the ID of the promotion from the URL
+	promotionId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid promotion ID")
+		return
+	}
+
+	// Cancel the promotion, so long as it hasn't already ended: inflows that were
+	// credited while it was active are unaffected
+	result, err := s.q.CancelPromotion(req.Context(), promotionId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	if numRows != 1 {
+		util.Error(res, ledger.ErrorCodePromotionNotFound, "no such promotion")
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func isPromotable(flowType ledger.TransactionType) bool {
+	for _, t := range PromotableFlowTypes {
+		if t == flowType {
+			return true
+		}
+	}
+	return false
+}
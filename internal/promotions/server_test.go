@@ -0,0 +1,267 @@
+package promotions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostPromotion(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		wantStatus     int
+		wantBody       string
+		wantPromotions []mockPromotion
+	}{
+		{
+			"promotion is scheduled",
+			`{"name":"Double Points Night","multiplier":2,"startsAt":"2096-09-01T20:00:00Z","endsAt":"2096-09-02T02:00:00Z"}`,
+			http.StatusOK,
+			`{"id":"2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f","name":"Double Points Night","multiplier":2,"startsAt":"2096-09-01T20:00:00Z","endsAt":"2096-09-02T02:00:00Z","createdAt":"1997-09-01T12:00:00Z"}`,
+			[]mockPromotion{
+				{
+					id:         uuid.MustParse("2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f"),
+					name:       "Double Points Night",
+					multiplier: 2,
+					startsAt:   time.Date(2096, 9, 1, 20, 0, 0, 0, time.UTC),
+					endsAt:     time.Date(2096, 9, 2, 2, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			"promotion may be limited to specific flow types",
+			`{"name":"Sub Bonanza","multiplier":1.5,"flowTypes":["subscription","gift-sub"],"startsAt":"2096-09-01T20:00:00Z","endsAt":"2096-09-02T02:00:00Z"}`,
+			http.StatusOK,
+			`{"id":"2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f","name":"Sub Bonanza","multiplier":1.5,"flowTypes":["subscription","gift-sub"],"startsAt":"2096-09-01T20:00:00Z","endsAt":"2096-09-02T02:00:00Z","createdAt":"1997-09-01T12:00:00Z"}`,
+			[]mockPromotion{
+				{
+					id:         uuid.MustParse("2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f"),
+					name:       "Sub Bonanza",
+					multiplier: 1.5,
+					flowTypes:  []string{"subscription", "gift-sub"},
+					startsAt:   time.Date(2096, 9, 1, 20, 0, 0, 0, time.UTC),
+					endsAt:     time.Date(2096, 9, 2, 2, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			"name is required",
+			`{"multiplier":2,"endsAt":"2096-09-02T02:00:00Z"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'name' must be set to a string of at most 64 characters"}`,
+			nil,
+		},
+		{
+			"multiplier must be positive",
+			`{"name":"Zero Points Night","multiplier":0,"endsAt":"2096-09-02T02:00:00Z"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'multiplier' must be set to a positive number"}`,
+			nil,
+		},
+		{
+			"promotions can only be applied to supported inflows",
+			`{"name":"Double Debits","multiplier":2,"flowTypes":["alert-redemption"],"endsAt":"2096-09-02T02:00:00Z"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: promotions can not be applied to flow type 'alert-redemption'"}`,
+			nil,
+		},
+		{
+			"end time is required",
+			`{"name":"Double Points Forever","multiplier":2}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'endsAt' must be set to a future time after 'startsAt'"}`,
+			nil,
+		},
+		{
+			"promotion must end after it starts",
+			`{"name":"Double Points Night","multiplier":2,"startsAt":"2096-09-02T02:00:00Z","endsAt":"2096-09-01T20:00:00Z"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'endsAt' must be set to a future time after 'startsAt'"}`,
+			nil,
+		},
+		{
+			"promotion may not end in the past",
+			`{"name":"Double Points Night","multiplier":2,"startsAt":"1997-09-01T20:00:00Z","endsAt":"1997-09-02T02:00:00Z"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'endsAt' must be set to a future time after 'startsAt'"}`,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{}
+			s := &Server{q: q}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/promotions", strings.NewReader(tt.requestBody))
+			res := httptest.NewRecorder()
+			s.handlePostPromotion(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantPromotions, q.promotions)
+		})
+	}
+}
+
+func Test_Server_handleGetPromotions(t *testing.T) {
+	q := &mockQueries{
+		promotions: []mockPromotion{
+			{
+				id:         uuid.MustParse("2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f"),
+				name:       "Double Points Night",
+				multiplier: 2,
+				startsAt:   time.Date(2096, 9, 1, 20, 0, 0, 0, time.UTC),
+				endsAt:     time.Date(2096, 9, 2, 2, 0, 0, 0, time.UTC),
+			},
+			{
+				id:         uuid.MustParse("8d1e5f7a-3b9c-4e2d-a6f8-1c4b7e0d9a3f"),
+				name:       "Sub Bonanza",
+				multiplier: 1.5,
+				flowTypes:  []string{"subscription"},
+				startsAt:   time.Date(2096, 9, 8, 20, 0, 0, 0, time.UTC),
+				endsAt:     time.Date(2096, 9, 9, 2, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	s := &Server{q: q}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/promotions", nil)
+	res := httptest.NewRecorder()
+	s.handleGetPromotions(res, req)
+
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	body := strings.TrimSuffix(string(b), "\n")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"items":[{"id":"2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f","name":"Double Points Night","multiplier":2,"startsAt":"2096-09-01T20:00:00Z","endsAt":"2096-09-02T02:00:00Z","createdAt":"1997-09-01T12:00:00Z"},{"id":"8d1e5f7a-3b9c-4e2d-a6f8-1c4b7e0d9a3f","name":"Sub Bonanza","multiplier":1.5,"flowTypes":["subscription"],"startsAt":"2096-09-08T20:00:00Z","endsAt":"2096-09-09T02:00:00Z","createdAt":"1997-09-01T12:00:00Z"}]}`, body)
+}
+
+func Test_Server_handleDeletePromotion(t *testing.T) {
+	tests := []struct {
+		name        string
+		promotionId string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			"promotion is canceled",
+			"2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f",
+			http.StatusNoContent,
+			"",
+		},
+		{
+			"nonexistent promotion is 404",
+			"e9a4e0a2-3b7f-4d0e-8f0c-0c1b7b1e2f3a",
+			http.StatusNotFound,
+			`{"title":"Not Found","status":404,"code":"promotion_not_found","detail":"no such promotion"}`,
+		},
+		{
+			"invalid ID is error",
+			"foo",
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid promotion ID"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{
+				promotions: []mockPromotion{
+					{id: uuid.MustParse("2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f")},
+				},
+			}
+			s := &Server{q: q}
+
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/promotions/%s", tt.promotionId), nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.promotionId})
+			res := httptest.NewRecorder()
+			s.handleDeletePromotion(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+		})
+	}
+}
+
+var mockCreatedAt = time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)
+
+type mockQueries struct {
+	promotions []mockPromotion
+}
+
+type mockPromotion struct {
+	id         uuid.UUID
+	name       string
+	multiplier float64
+	flowTypes  []string
+	startsAt   time.Time
+	endsAt     time.Time
+}
+
+func (m *mockQueries) SchedulePromotion(ctx context.Context, arg queries.SchedulePromotionParams) (queries.SchedulePromotionRow, error) {
+	id := uuid.MustParse("2b4f8e3a-6c1d-4a9e-b7f2-5d8c0e3a1b6f")
+	m.promotions = append(m.promotions, mockPromotion{
+		id:         id,
+		name:       arg.Name,
+		multiplier: arg.Multiplier,
+		flowTypes:  arg.FlowTypes,
+		startsAt:   arg.StartsAt,
+		endsAt:     arg.EndsAt,
+	})
+	return queries.SchedulePromotionRow{ID: id, CreatedAt: mockCreatedAt}, nil
+}
+
+func (m *mockQueries) ListPromotions(ctx context.Context) ([]queries.ListPromotionsRow, error) {
+	rows := make([]queries.ListPromotionsRow, 0, len(m.promotions))
+	for _, promotion := range m.promotions {
+		rows = append(rows, queries.ListPromotionsRow{
+			ID:         promotion.id,
+			Name:       promotion.name,
+			Multiplier: promotion.multiplier,
+			FlowTypes:  promotion.flowTypes,
+			StartsAt:   promotion.startsAt,
+			EndsAt:     promotion.endsAt,
+			CreatedAt:  mockCreatedAt,
+		})
+	}
+	return rows, nil
+}
+
+func (m *mockQueries) CancelPromotion(ctx context.Context, promotionID uuid.UUID) (sql.Result, error) {
+	for i, promotion := range m.promotions {
+		if promotion.id == promotionID {
+			m.promotions = append(m.promotions[:i], m.promotions[i+1:]...)
+			return &mockSqlResult{1}, nil
+		}
+	}
+	return &mockSqlResult{0}, nil
+}
+
+type mockSqlResult struct {
+	numRows int64
+}
+
+func (m *mockSqlResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("not mocked")
+}
+
+func (m *mockSqlResult) RowsAffected() (int64, error) {
+	return m.numRows, nil
+}
@@ -0,0 +1,15 @@
+package promotions
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	SchedulePromotion(ctx context.Context, arg queries.SchedulePromotionParams) (queries.SchedulePromotionRow, error)
+	ListPromotions(ctx context.Context) ([]queries.ListPromotionsRow, error)
+	CancelPromotion(ctx context.Context, promotionID uuid.UUID) (sql.Result, error)
+}
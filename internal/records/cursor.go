@@ -0,0 +1,68 @@
+package records
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// historyCursorDirection indicates which way a history cursor pages, relative to the
+// transaction it identifies
+type historyCursorDirection string
+
+const (
+	// historyCursorOlder identifies a page of transactions strictly older than the
+	// cursor position, as obtained from nextCursor
+	historyCursorOlder historyCursorDirection = "n"
+	// historyCursorNewer identifies a page of transactions strictly newer than the
+	// cursor position, as obtained from prevCursor
+	historyCursorNewer historyCursorDirection = "p"
+)
+
+// historyCursor identifies a position in a user's transaction history. Transactions
+// are ordered by (created_at, id) descending, so the pair uniquely identifies a
+// position even when several transactions share the same timestamp.
+type historyCursor struct {
+	direction historyCursorDirection
+	createdAt time.Time
+	id        uuid.UUID
+}
+
+// encode returns an opaque string representation of the cursor, suitable for returning
+// to clients as nextCursor or prevCursor
+func (c historyCursor) encode() string {
+	s := fmt.Sprintf("%s|%s|%s", c.direction, c.createdAt.UTC().Format(time.RFC3339Nano), c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// parseHistoryCursor decodes a cursor value previously returned by encode
+func parseHistoryCursor(value string) (historyCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return historyCursor{}, fmt.Errorf("malformed cursor")
+	}
+	parts := strings.Split(string(data), "|")
+	if len(parts) != 3 {
+		return historyCursor{}, fmt.Errorf("malformed cursor")
+	}
+	direction := historyCursorDirection(parts[0])
+	if direction != historyCursorOlder && direction != historyCursorNewer {
+		return historyCursor{}, fmt.Errorf("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return historyCursor{}, fmt.Errorf("malformed cursor")
+	}
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return historyCursor{}, fmt.Errorf("malformed cursor")
+	}
+	return historyCursor{
+		direction: direction,
+		createdAt: createdAt,
+		id:        id,
+	}, nil
+}
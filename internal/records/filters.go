@@ -0,0 +1,63 @@
+package records
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+)
+
+// applyHistoryFilters parses the optional filter parameters accepted by GET /history
+// from the given URL query, applying them to the given query params. Returns an error
+// if any filter value is invalid.
+func applyHistoryFilters(values url.Values, params *queries.GetTransactionHistoryParams) error {
+	// 'type' may be repeated to include transactions of any of several types
+	for _, t := range values["type"] {
+		if t == "" {
+			return fmt.Errorf("type must not be empty")
+		}
+		params.Types = append(params.Types, t)
+	}
+
+	if stateStr := values.Get("state"); stateStr != "" {
+		switch ledger.TransactionState(stateStr) {
+		case ledger.TransactionStatePending, ledger.TransactionStateAccepted, ledger.TransactionStateRejected:
+			params.State = sql.NullString{Valid: true, String: stateStr}
+		default:
+			return fmt.Errorf("state must be one of 'pending', 'accepted', or 'rejected'")
+		}
+	}
+
+	if directionStr := values.Get("direction"); directionStr != "" {
+		switch ledger.TransactionDirection(directionStr) {
+		case ledger.TransactionDirectionInflow, ledger.TransactionDirectionOutflow:
+			params.Direction = sql.NullString{Valid: true, String: directionStr}
+		default:
+			return fmt.Errorf("direction must be one of 'inflow' or 'outflow'")
+		}
+	}
+
+	// 'since' is inclusive and 'until' is exclusive, so that consecutive date ranges
+	// never overlap
+	if sinceStr := values.Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			return fmt.Errorf("since must be an RFC 3339 timestamp")
+		}
+		params.Since = sql.NullTime{Valid: true, Time: since}
+	}
+	if untilStr := values.Get("until"); untilStr != "" {
+		until, err := time.Parse(time.RFC3339, untilStr)
+		if err != nil {
+			return fmt.Errorf("until must be an RFC 3339 timestamp")
+		}
+		params.Until = sql.NullTime{Valid: true, Time: until}
+	}
+	if params.Since.Valid && params.Until.Valid && !params.Until.Time.After(params.Since.Time) {
+		return fmt.Errorf("until must be later than since")
+	}
+	return nil
+}
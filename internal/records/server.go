@@ -3,24 +3,33 @@ package records
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/admin"
 	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
+// twitchUserIdRegex matches a numeric Twitch user ID, as opposed to a display name
+var twitchUserIdRegex = regexp.MustCompile(`^[0-9]+$`)
+
 type Server struct {
-	q Queries
+	q                   Queries
+	resolveTwitchUserId admin.ResolveTwitchUserIdFunc
 }
 
-func NewServer(q Queries) *Server {
+func NewServer(q Queries, resolveTwitchUserId admin.ResolveTwitchUserIdFunc) *Server {
 	return &Server{
-		q: q,
+		q:                   q,
+		resolveTwitchUserId: resolveTwitchUserId,
 	}
 }
 
@@ -35,43 +44,87 @@ func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
 			http.HandlerFunc(s.handleGetHistory),
 		),
 	)
+	r.Path("/admin/users/{twitchUserId}/balance").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleGetUserBalance),
+		),
+	)
+	r.Path("/admin/users/{twitchUserId}/history").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleGetUserHistory),
+		),
+	)
 }
 
 func (s *Server) handleGetBalance(res http.ResponseWriter, req *http.Request) {
 	// Identify the user making the request
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
+	s.writeBalance(res, req, claims.User.Id)
+}
 
-	// Query their balance, defaulting to 0 if no record exists
+func (s *Server) handleGetUserBalance(res http.ResponseWriter, req *http.Request) {
+	// Identify the user whose balance the broadcaster wants to see
+	twitchUserId, err := s.resolveTargetUserId(req)
+	if errors.Is(err, admin.ErrTwitchUserNotFound) {
+		util.Error(res, ledger.ErrorCodeUserNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	s.writeBalance(res, req, twitchUserId)
+}
+
+func (s *Server) writeBalance(res http.ResponseWriter, req *http.Request, twitchUserId string) {
+	// Query the user's balance, defaulting to 0 if no record exists
 	balance := &ledger.Balance{
 		TotalPoints:     0,
 		AvailablePoints: 0,
 	}
-	row, err := s.q.GetBalance(req.Context(), claims.User.Id)
+	row, err := s.q.GetBalance(req.Context(), twitchUserId)
 	if err == nil {
 		balance.TotalPoints = int(row.TotalPoints)
 		balance.AvailablePoints = int(row.AvailablePoints)
 	} else if err != sql.ErrNoRows {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
 	// Return the Balance struct as a JSON object
 	if err := json.NewEncoder(res).Encode(balance); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 }
 
 func (s *Server) handleGetHistory(res http.ResponseWriter, req *http.Request) {
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
+	s.writeHistory(res, req, claims.User.Id)
+}
 
+func (s *Server) handleGetUserHistory(res http.ResponseWriter, req *http.Request) {
+	// Identify the user whose history the broadcaster wants to see
+	twitchUserId, err := s.resolveTargetUserId(req)
+	if errors.Is(err, admin.ErrTwitchUserNotFound) {
+		util.Error(res, ledger.ErrorCodeUserNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	s.writeHistory(res, req, twitchUserId)
+}
+
+func (s *Server) writeHistory(res http.ResponseWriter, req *http.Request, twitchUserId string) {
 	limit := 50
 	maxStr := req.URL.Query().Get("max")
 	if maxStr != "" {
@@ -80,36 +133,111 @@ func (s *Server) handleGetHistory(res http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	startId := uuid.NullUUID{}
-	fromStr := req.URL.Query().Get("from")
-	if fromStr != "" {
-		if fromUUID, err := uuid.Parse(fromStr); err == nil {
-			startId.Valid = true
-			startId.UUID = fromUUID
+	params := queries.GetTransactionHistoryParams{
+		TwitchUserID: twitchUserId,
+		NumRecords:   int32(limit + 1),
+	}
+	if err := applyHistoryFilters(req.URL.Query(), &params); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	// If a cursor was supplied, we're fetching a page relative to the position it
+	// identifies: it must be well-formed and refer to one of this user's transactions
+	var cursor *historyCursor
+	if fromStr := req.URL.Query().Get("from"); fromStr != "" {
+		parsed, err := parseHistoryCursor(fromStr)
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, err.Error())
+			return
 		}
+		exists, err := s.q.HistoryCursorExists(req.Context(), queries.HistoryCursorExistsParams{
+			TwitchUserID: twitchUserId,
+			ID:           parsed.id,
+			CreatedAt:    parsed.createdAt,
+		})
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInternal, err.Error())
+			return
+		}
+		if !exists {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, "unknown cursor")
+			return
+		}
+		cursor = &parsed
+		params.CursorID = uuid.NullUUID{Valid: true, UUID: parsed.id}
+		params.CursorCreatedAt = sql.NullTime{Valid: true, Time: parsed.createdAt}
 	}
 
-	rows, err := s.q.GetTransactionHistory(req.Context(), queries.GetTransactionHistoryParams{
-		TwitchUserID: claims.User.Id,
-		NumRecords:   int32(limit + 1),
-		StartID:      startId,
-	})
-	numItemsToReturn := min(limit, len(rows))
-	items := make([]ledger.Transaction, 0, numItemsToReturn)
-	for i := 0; i < numItemsToReturn; i++ {
-		row := &rows[i]
-		items = append(items, util.BuildTransaction(row.ID, row.Type, row.Metadata, int(row.DeltaPoints), row.CreatedAt, row.FinalizedAt, row.Accepted))
+	// Fetch one more row than we need, so we know whether there's another page beyond
+	// this one
+	var rows []queries.GetTransactionHistoryRow
+	pagingNewer := cursor != nil && cursor.direction == historyCursorNewer
+	if pagingNewer {
+		// Paging backwards, we get the rows immediately newer than the cursor, in
+		// ascending order: reverse them so that the page is presented newest-first
+		newerRows, err := s.q.GetNewerTransactionHistory(req.Context(), queries.GetNewerTransactionHistoryParams(params))
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInternal, err.Error())
+			return
+		}
+		rows = make([]queries.GetTransactionHistoryRow, 0, len(newerRows))
+		for i := len(newerRows) - 1; i >= 0; i-- {
+			rows = append(rows, queries.GetTransactionHistoryRow(newerRows[i]))
+		}
+	} else {
+		olderRows, err := s.q.GetTransactionHistory(req.Context(), params)
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInternal, err.Error())
+			return
+		}
+		rows = olderRows
+	}
+	hasMore := len(rows) > limit
+	if hasMore {
+		if pagingNewer {
+			rows = rows[1:]
+		} else {
+			rows = rows[:limit]
+		}
 	}
-	nextCursor := ""
-	if len(rows) > limit {
-		nextCursor = rows[limit].ID.String()
+
+	items := make([]ledger.Transaction, 0, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		items = append(items, util.BuildTransaction(row.ID, row.Type, row.Metadata, int(row.DeltaPoints), row.CreatedAt, row.FinalizedAt, row.Accepted, row.DescriptionTemplate.String, int(row.ReversedDeltaPoints)))
 	}
 
+	// nextCursor pages onward to older transactions, and prevCursor pages back to newer
+	// ones: each is only supplied if there are in fact more transactions that way
 	history := &ledger.TransactionHistory{
-		Items:      items,
-		NextCursor: nextCursor,
+		Items: items,
+	}
+	if len(rows) > 0 {
+		first := &rows[0]
+		last := &rows[len(rows)-1]
+		if (pagingNewer && hasMore) || (!pagingNewer && cursor != nil) {
+			history.PrevCursor = historyCursor{historyCursorNewer, first.CreatedAt, first.ID}.encode()
+		}
+		if pagingNewer || hasMore {
+			history.NextCursor = historyCursor{historyCursorOlder, last.CreatedAt, last.ID}.encode()
+		}
 	}
 	if err := json.NewEncoder(res).Encode(history); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+// resolveTargetUserId identifies the user named in the URL of an admin request, which
+// may be either a numeric Twitch user ID or a Twitch display name
+func (s *Server) resolveTargetUserId(req *http.Request) (string, error) {
+	value := mux.Vars(req)["twitchUserId"]
+	if twitchUserIdRegex.MatchString(value) {
+		return value, nil
+	}
+	resolved, err := s.resolveTwitchUserId(req.Context(), value)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve twitch user ID from username: %w", err)
 	}
+	return resolved, nil
 }
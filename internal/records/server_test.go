@@ -3,6 +3,7 @@ package records
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,8 +14,11 @@ import (
 
 	"github.com/golden-vcr/auth"
 	authmock "github.com/golden-vcr/auth/mock"
+	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/admin"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -90,11 +94,12 @@ func Test_Server_handleGetHistory(t *testing.T) {
 				userId: "1001",
 				historyRows: []queries.GetTransactionHistoryRow{
 					{
-						ID:          uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
-						Type:        "alert-redemption",
-						Metadata:    []byte(`{"type":"whatever"}`),
-						DeltaPoints: -200,
-						CreatedAt:   time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						ID:                  uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+						Type:                "alert-redemption",
+						Metadata:            []byte(`{"type":"whatever"}`),
+						DeltaPoints:         -200,
+						CreatedAt:           time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
 					},
 					{
 						ID:          uuid.MustParse("18d3d13c-625e-46df-bd34-e2cc2b7be15e"),
@@ -128,11 +133,12 @@ func Test_Server_handleGetHistory(t *testing.T) {
 				userId: "1001",
 				historyRows: []queries.GetTransactionHistoryRow{
 					{
-						ID:          uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
-						Type:        "alert-redemption",
-						Metadata:    []byte(`{"type":"whatever"}`),
-						DeltaPoints: -200,
-						CreatedAt:   time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						ID:                  uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+						Type:                "alert-redemption",
+						Metadata:            []byte(`{"type":"whatever"}`),
+						DeltaPoints:         -200,
+						CreatedAt:           time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
 					},
 					{
 						ID:          uuid.MustParse("18d3d13c-625e-46df-bd34-e2cc2b7be15e"),
@@ -158,7 +164,7 @@ func Test_Server_handleGetHistory(t *testing.T) {
 			2,
 			"",
 			http.StatusOK,
-			`{"items":[{"id":"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f","timestamp":"1997-09-01T13:00:00Z","type":"alert-redemption","state":"pending","deltaPoints":-200,"description":"Redeemed alert of type 'whatever'"},{"id":"18d3d13c-625e-46df-bd34-e2cc2b7be15e","timestamp":"1997-09-01T12:30:00Z","type":"manual-credit","state":"rejected","deltaPoints":5000,"description":"Manual credit: will be rejected"}],"nextCursor":"0db47d1c-41f9-4808-bc8d-bf097eeb6319"}`,
+			`{"items":[{"id":"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f","timestamp":"1997-09-01T13:00:00Z","type":"alert-redemption","state":"pending","deltaPoints":-200,"description":"Redeemed alert of type 'whatever'"},{"id":"18d3d13c-625e-46df-bd34-e2cc2b7be15e","timestamp":"1997-09-01T12:30:00Z","type":"manual-credit","state":"rejected","deltaPoints":5000,"description":"Manual credit: will be rejected"}],"nextCursor":"bnwxOTk3LTA5LTAxVDEyOjMwOjAwWnwxOGQzZDEzYy02MjVlLTQ2ZGYtYmQzNC1lMmNjMmI3YmUxNWU"}`,
 		},
 		{
 			"paginated: second page",
@@ -166,11 +172,12 @@ func Test_Server_handleGetHistory(t *testing.T) {
 				userId: "1001",
 				historyRows: []queries.GetTransactionHistoryRow{
 					{
-						ID:          uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
-						Type:        "alert-redemption",
-						Metadata:    []byte(`{"type":"whatever"}`),
-						DeltaPoints: -200,
-						CreatedAt:   time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						ID:                  uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+						Type:                "alert-redemption",
+						Metadata:            []byte(`{"type":"whatever"}`),
+						DeltaPoints:         -200,
+						CreatedAt:           time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
 					},
 					{
 						ID:          uuid.MustParse("18d3d13c-625e-46df-bd34-e2cc2b7be15e"),
@@ -194,9 +201,83 @@ func Test_Server_handleGetHistory(t *testing.T) {
 			},
 			"mock-token",
 			2,
-			"0db47d1c-41f9-4808-bc8d-bf097eeb6319",
+			"bnwxOTk3LTA5LTAxVDEyOjMwOjAwWnwxOGQzZDEzYy02MjVlLTQ2ZGYtYmQzNC1lMmNjMmI3YmUxNWU",
 			http.StatusOK,
-			`{"items":[{"id":"0db47d1c-41f9-4808-bc8d-bf097eeb6319","timestamp":"1997-09-01T12:01:00Z","type":"manual-credit","state":"accepted","deltaPoints":2500,"description":"Manual credit: foo"}]}`,
+			`{"items":[{"id":"0db47d1c-41f9-4808-bc8d-bf097eeb6319","timestamp":"1997-09-01T12:01:00Z","type":"manual-credit","state":"accepted","deltaPoints":2500,"description":"Manual credit: foo"}],"prevCursor":"cHwxOTk3LTA5LTAxVDEyOjAwOjAwWnwwZGI0N2QxYy00MWY5LTQ4MDgtYmM4ZC1iZjA5N2VlYjYzMTk"}`,
+		},
+		{
+			"reversals are described on both sides",
+			&mockQueries{
+				userId: "1001",
+				historyRows: []queries.GetTransactionHistoryRow{
+					{
+						ID:          uuid.MustParse("c1e4f0b6-7b5e-4f4a-9f3c-2a6d8e1b0c57"),
+						Type:        "reversal",
+						Metadata:    []byte(`{"note":"alert failed on stream","reversed_type":"alert-redemption","reversed_metadata":{"type":"ghost"}}`),
+						DeltaPoints: 200,
+						CreatedAt:   time.Date(1997, 9, 1, 14, 0, 0, 0, time.UTC),
+						FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 14, 0, 0, 0, time.UTC)},
+						Accepted:    true,
+					},
+					{
+						ID:                  uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+						Type:                "alert-redemption",
+						Metadata:            []byte(`{"type":"ghost"}`),
+						DeltaPoints:         -200,
+						CreatedAt:           time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						FinalizedAt:         sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC)},
+						Accepted:            true,
+						DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
+						ReversedDeltaPoints: 200,
+					},
+					{
+						ID:                  uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+						Type:                "manual-credit",
+						Metadata:            []byte(`{"note":"foo"}`),
+						DeltaPoints:         2500,
+						CreatedAt:           time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						FinalizedAt:         sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 1, 0, 0, time.UTC)},
+						Accepted:            true,
+						ReversedDeltaPoints: -500,
+					},
+				},
+			},
+			"mock-token",
+			-1,
+			"",
+			http.StatusOK,
+			`{"items":[{"id":"c1e4f0b6-7b5e-4f4a-9f3c-2a6d8e1b0c57","timestamp":"1997-09-01T14:00:00Z","type":"reversal","state":"accepted","deltaPoints":200,"description":"Refund of alert 'ghost'"},{"id":"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f","timestamp":"1997-09-01T13:00:00Z","type":"alert-redemption","state":"accepted","deltaPoints":-200,"description":"Redeemed alert of type 'ghost' (Reversed by admin)"},{"id":"0db47d1c-41f9-4808-bc8d-bf097eeb6319","timestamp":"1997-09-01T12:01:00Z","type":"manual-credit","state":"accepted","deltaPoints":2500,"description":"Manual credit: foo (Reversed by admin: 500 of 2500 points)"}]}`,
+		},
+		{
+			"inflows credited during a promotion mention the bonus",
+			&mockQueries{
+				userId: "1001",
+				historyRows: []queries.GetTransactionHistoryRow{
+					{
+						ID:          uuid.MustParse("3f7a8c2e-5d1b-4e9a-8c6f-2b0d4e6a8c1f"),
+						Type:        "gift-sub",
+						Metadata:    []byte(`{"num_subscriptions":5,"tier":"1000","credit_multiplier":1,"policy_version":"0123456789ab","promotion":{"id":"7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e","name":"Sub Bonanza","multiplier":1.5}}`),
+						DeltaPoints: 1500,
+						CreatedAt:   time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC),
+						FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 13, 0, 0, 0, time.UTC)},
+						Accepted:    true,
+					},
+					{
+						ID:          uuid.MustParse("9b2e4d6f-1a3c-4e5b-8d7f-0c2a4e6b8d1a"),
+						Type:        "cheer",
+						Metadata:    []byte(`{"message":"","num_bits":400,"policy_version":"0123456789ab","promotion":{"id":"5e8f0a2c-4b6d-4f1e-9a3c-7d5b1e9f3a2c","name":"Double Points Night","multiplier":2}}`),
+						DeltaPoints: 800,
+						CreatedAt:   time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)},
+						Accepted:    true,
+					},
+				},
+			},
+			"mock-token",
+			-1,
+			"",
+			http.StatusOK,
+			`{"items":[{"id":"3f7a8c2e-5d1b-4e9a-8c6f-2b0d4e6a8c1f","timestamp":"1997-09-01T13:00:00Z","type":"gift-sub","state":"accepted","deltaPoints":1500,"description":"Thank you for gifting 5 subs! (1.5x Sub Bonanza)"},{"id":"9b2e4d6f-1a3c-4e5b-8d7f-0c2a4e6b8d1a","timestamp":"1997-09-01T12:00:00Z","type":"cheer","state":"accepted","deltaPoints":800,"description":"Thank you for cheering! (2x Double Points Night)"}]}`,
 		},
 	}
 	for _, tt := range tests {
@@ -234,6 +315,356 @@ func Test_Server_handleGetHistory(t *testing.T) {
 	}
 }
 
+func Test_Server_handleGetHistory_filters(t *testing.T) {
+	q := &mockQueries{
+		userId: "1001",
+		historyRows: []queries.GetTransactionHistoryRow{
+			{
+				ID:                  uuid.MustParse("6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"),
+				Type:                "alert-redemption",
+				Metadata:            []byte(`{"type":"whatever"}`),
+				DeltaPoints:         -200,
+				CreatedAt:           time.Date(1997, 9, 2, 1, 0, 0, 0, time.UTC),
+				DescriptionTemplate: sql.NullString{Valid: true, String: "Redeemed alert of type '{{.type}}'"},
+			},
+			{
+				ID:          uuid.MustParse("18d3d13c-625e-46df-bd34-e2cc2b7be15e"),
+				Type:        "manual-credit",
+				Metadata:    []byte(`{"note":"will be rejected"}`),
+				DeltaPoints: 5000,
+				CreatedAt:   time.Date(1997, 9, 1, 12, 30, 0, 0, time.UTC),
+				FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 30, 0, 0, time.UTC)},
+				Accepted:    false,
+			},
+			{
+				ID:          uuid.MustParse("a3c6b1e2-4f5d-4c7a-9b8e-1d2f3a4b5c6d"),
+				Type:        "cheer",
+				Metadata:    []byte(`{"message":""}`),
+				DeltaPoints: 300,
+				CreatedAt:   time.Date(1997, 9, 1, 12, 15, 0, 0, time.UTC),
+				FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 15, 0, 0, time.UTC)},
+				Accepted:    true,
+			},
+			{
+				ID:          uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+				Type:        "manual-credit",
+				Metadata:    []byte(`{"note":"foo"}`),
+				DeltaPoints: 2500,
+				CreatedAt:   time.Date(1997, 8, 31, 12, 0, 0, 0, time.UTC),
+				FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 8, 31, 12, 1, 0, 0, time.UTC)},
+				Accepted:    true,
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIds    []string
+		wantDetail string
+	}{
+		{
+			"type filter may be repeated",
+			"type=cheer&type=alert-redemption",
+			http.StatusOK,
+			[]string{"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f", "a3c6b1e2-4f5d-4c7a-9b8e-1d2f3a4b5c6d"},
+			"",
+		},
+		{
+			"state filter",
+			"state=accepted",
+			http.StatusOK,
+			[]string{"a3c6b1e2-4f5d-4c7a-9b8e-1d2f3a4b5c6d", "0db47d1c-41f9-4808-bc8d-bf097eeb6319"},
+			"",
+		},
+		{
+			"direction filter",
+			"direction=outflow",
+			http.StatusOK,
+			[]string{"6582a6f6-43e4-4d3d-9d34-0f2e58b41e5f"},
+			"",
+		},
+		{
+			"date range filter",
+			"since=1997-09-01T00:00:00Z&until=1997-09-02T00:00:00Z",
+			http.StatusOK,
+			[]string{"18d3d13c-625e-46df-bd34-e2cc2b7be15e", "a3c6b1e2-4f5d-4c7a-9b8e-1d2f3a4b5c6d"},
+			"",
+		},
+		{
+			"filters may be combined",
+			"type=manual-credit&direction=inflow&state=accepted",
+			http.StatusOK,
+			[]string{"0db47d1c-41f9-4808-bc8d-bf097eeb6319"},
+			"",
+		},
+		{
+			"invalid state is an error",
+			"state=bogus",
+			http.StatusBadRequest,
+			nil,
+			"state must be one of 'pending', 'accepted', or 'rejected'",
+		},
+		{
+			"invalid direction is an error",
+			"direction=sideways",
+			http.StatusBadRequest,
+			nil,
+			"direction must be one of 'inflow' or 'outflow'",
+		},
+		{
+			"invalid timestamp is an error",
+			"since=yesterday",
+			http.StatusBadRequest,
+			nil,
+			"since must be an RFC 3339 timestamp",
+		},
+		{
+			"empty date range is an error",
+			"since=1997-09-02T00:00:00Z&until=1997-09-01T00:00:00Z",
+			http.StatusBadRequest,
+			nil,
+			"until must be later than since",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{q: q}
+			req := httptest.NewRequest(http.MethodGet, "/history?"+tt.query, nil)
+			res := httptest.NewRecorder()
+			s.writeHistory(res, req, "1001")
+
+			assert.Equal(t, tt.wantStatus, res.Code)
+			if tt.wantStatus != http.StatusOK {
+				var problem ledger.Problem
+				err := json.NewDecoder(res.Body).Decode(&problem)
+				assert.NoError(t, err)
+				assert.Equal(t, ledger.ErrorCodeInvalidRequest, problem.Code)
+				assert.Equal(t, tt.wantDetail, problem.Detail)
+				return
+			}
+			var history ledger.TransactionHistory
+			err := json.NewDecoder(res.Body).Decode(&history)
+			assert.NoError(t, err)
+			ids := make([]string, 0, len(history.Items))
+			for _, item := range history.Items {
+				ids = append(ids, item.Id.String())
+			}
+			assert.Equal(t, tt.wantIds, ids)
+		})
+	}
+}
+
+func Test_Server_handleGetHistory_cursors(t *testing.T) {
+	// Several transactions share the same creation time: paging must still visit each
+	// of them exactly once, in both directions
+	createdAt := time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)
+	q := &mockQueries{
+		userId: "1001",
+		historyRows: []queries.GetTransactionHistoryRow{
+			{ID: uuid.MustParse("f0000000-0000-0000-0000-000000000000"), Type: "cheer", Metadata: []byte(`{"message":""}`), DeltaPoints: 100, CreatedAt: createdAt.Add(time.Minute)},
+			{ID: uuid.MustParse("c0000000-0000-0000-0000-000000000000"), Type: "cheer", Metadata: []byte(`{"message":""}`), DeltaPoints: 100, CreatedAt: createdAt},
+			{ID: uuid.MustParse("b0000000-0000-0000-0000-000000000000"), Type: "cheer", Metadata: []byte(`{"message":""}`), DeltaPoints: 100, CreatedAt: createdAt},
+			{ID: uuid.MustParse("a0000000-0000-0000-0000-000000000000"), Type: "cheer", Metadata: []byte(`{"message":""}`), DeltaPoints: 100, CreatedAt: createdAt},
+			{ID: uuid.MustParse("10000000-0000-0000-0000-000000000000"), Type: "cheer", Metadata: []byte(`{"message":""}`), DeltaPoints: 100, CreatedAt: createdAt.Add(-time.Minute)},
+		},
+	}
+	s := &Server{q: q}
+	getPage := func(query string) (int, ledger.TransactionHistory, ledger.Problem) {
+		req := httptest.NewRequest(http.MethodGet, "/history?"+query, nil)
+		res := httptest.NewRecorder()
+		s.writeHistory(res, req, "1001")
+		var history ledger.TransactionHistory
+		var problem ledger.Problem
+		if res.Code == http.StatusOK {
+			err := json.NewDecoder(res.Body).Decode(&history)
+			assert.NoError(t, err)
+		} else {
+			err := json.NewDecoder(res.Body).Decode(&problem)
+			assert.NoError(t, err)
+		}
+		return res.Code, history, problem
+	}
+	getIds := func(history ledger.TransactionHistory) []string {
+		ids := make([]string, 0, len(history.Items))
+		for _, item := range history.Items {
+			ids = append(ids, item.Id.String()[:1])
+		}
+		return ids
+	}
+
+	// Page forward two at a time
+	status, page1, _ := getPage("max=2")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"f", "c"}, getIds(page1))
+	assert.Equal(t, "", page1.PrevCursor)
+	assert.NotEqual(t, "", page1.NextCursor)
+
+	status, page2, _ := getPage("max=2&from=" + page1.NextCursor)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"b", "a"}, getIds(page2))
+	assert.NotEqual(t, "", page2.PrevCursor)
+	assert.NotEqual(t, "", page2.NextCursor)
+
+	status, page3, _ := getPage("max=2&from=" + page2.NextCursor)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"1"}, getIds(page3))
+	assert.NotEqual(t, "", page3.PrevCursor)
+	assert.Equal(t, "", page3.NextCursor)
+
+	// Page back again using prevCursor
+	status, back2, _ := getPage("max=2&from=" + page3.PrevCursor)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"b", "a"}, getIds(back2))
+	assert.NotEqual(t, "", back2.PrevCursor)
+	assert.Equal(t, page2.NextCursor, back2.NextCursor)
+
+	status, back1, _ := getPage("max=2&from=" + back2.PrevCursor)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"f", "c"}, getIds(back1))
+	assert.Equal(t, "", back1.PrevCursor)
+	assert.Equal(t, page1.NextCursor, back1.NextCursor)
+
+	// Cursors that can't be decoded, or that don't identify one of the user's
+	// transactions, are rejected rather than silently restarting from the first page
+	status, _, problem := getPage("from=0db47d1c-41f9-4808-bc8d-bf097eeb6319")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ledger.ErrorCodeInvalidRequest, problem.Code)
+	assert.Equal(t, "malformed cursor", problem.Detail)
+
+	unknown := historyCursor{historyCursorOlder, createdAt, uuid.MustParse("d0000000-0000-0000-0000-000000000000")}.encode()
+	status, _, problem = getPage("from=" + unknown)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ledger.ErrorCodeInvalidRequest, problem.Code)
+	assert.Equal(t, "unknown cursor", problem.Detail)
+}
+
+func Test_Server_handleGetUserBalance(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"user may be identified by ID",
+			"1001",
+			http.StatusOK,
+			`{"totalPoints":2500,"availablePoints":2300}`,
+		},
+		{
+			"user may be identified by display name",
+			"SomeUser",
+			http.StatusOK,
+			`{"totalPoints":2500,"availablePoints":2300}`,
+		},
+		{
+			"zero values are returned for a user with no balance record",
+			"1002",
+			http.StatusOK,
+			`{"totalPoints":0,"availablePoints":0}`,
+		},
+		{
+			"unknown display name is a 404 error",
+			"nobody",
+			http.StatusNotFound,
+			`{"title":"Not Found","status":404,"code":"user_not_found","detail":"failed to resolve twitch user ID from username: no such Twitch user: 'nobody'"}`,
+		},
+		{
+			"failure to resolve display name is a 500 error",
+			"twitchdown",
+			http.StatusInternalServerError,
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"failed to resolve twitch user ID from username: got status 503: Service Unavailable"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				q: &mockQueries{
+					userId: "1001",
+					balance: queries.GetBalanceRow{
+						TotalPoints:     2500,
+						AvailablePoints: 2300,
+					},
+				},
+				resolveTwitchUserId: mockResolveTwitchUserId,
+			}
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/admin/users/%s/balance", tt.user), nil)
+			req = mux.SetURLVars(req, map[string]string{"twitchUserId": tt.user})
+			res := httptest.NewRecorder()
+			s.handleGetUserBalance(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+		})
+	}
+}
+
+func Test_Server_handleGetUserHistory(t *testing.T) {
+	q := &mockQueries{
+		userId: "1001",
+		historyRows: []queries.GetTransactionHistoryRow{
+			{
+				ID:          uuid.MustParse("18d3d13c-625e-46df-bd34-e2cc2b7be15e"),
+				Type:        "manual-credit",
+				Metadata:    []byte(`{"note":"bar"}`),
+				DeltaPoints: 5000,
+				CreatedAt:   time.Date(1997, 9, 1, 12, 30, 0, 0, time.UTC),
+				FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 30, 0, 0, time.UTC)},
+				Accepted:    true,
+			},
+			{
+				ID:          uuid.MustParse("0db47d1c-41f9-4808-bc8d-bf097eeb6319"),
+				Type:        "manual-credit",
+				Metadata:    []byte(`{"note":"foo"}`),
+				DeltaPoints: 2500,
+				CreatedAt:   time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+				FinalizedAt: sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 1, 0, 0, time.UTC)},
+				Accepted:    true,
+			},
+		},
+	}
+	s := &Server{
+		q:                   q,
+		resolveTwitchUserId: mockResolveTwitchUserId,
+	}
+
+	// The broadcaster should be able to page through another user's history, identifying
+	// them by display name
+	req := httptest.NewRequest(http.MethodGet, "/admin/users/SomeUser/history?max=1", nil)
+	req = mux.SetURLVars(req, map[string]string{"twitchUserId": "SomeUser"})
+	res := httptest.NewRecorder()
+	s.handleGetUserHistory(res, req)
+
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"items":[{"id":"18d3d13c-625e-46df-bd34-e2cc2b7be15e","timestamp":"1997-09-01T12:30:00Z","type":"manual-credit","state":"accepted","deltaPoints":5000,"description":"Manual credit: bar"}],"nextCursor":"bnwxOTk3LTA5LTAxVDEyOjMwOjAwWnwxOGQzZDEzYy02MjVlLTQ2ZGYtYmQzNC1lMmNjMmI3YmUxNWU"}`, strings.TrimSuffix(string(b), "\n"))
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/users/1001/history?max=1&from=bnwxOTk3LTA5LTAxVDEyOjMwOjAwWnwxOGQzZDEzYy02MjVlLTQ2ZGYtYmQzNC1lMmNjMmI3YmUxNWU", nil)
+	req = mux.SetURLVars(req, map[string]string{"twitchUserId": "1001"})
+	res = httptest.NewRecorder()
+	s.handleGetUserHistory(res, req)
+
+	b, err = io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"items":[{"id":"0db47d1c-41f9-4808-bc8d-bf097eeb6319","timestamp":"1997-09-01T12:01:00Z","type":"manual-credit","state":"accepted","deltaPoints":2500,"description":"Manual credit: foo"}],"prevCursor":"cHwxOTk3LTA5LTAxVDEyOjAwOjAwWnwwZGI0N2QxYy00MWY5LTQ4MDgtYmM4ZC1iZjA5N2VlYjYzMTk"}`, strings.TrimSuffix(string(b), "\n"))
+}
+
+func mockResolveTwitchUserId(ctx context.Context, username string) (string, error) {
+	if strings.ToLower(username) == "someuser" {
+		return "1001", nil
+	}
+	if strings.ToLower(username) == "twitchdown" {
+		return "", fmt.Errorf("got status 503: Service Unavailable")
+	}
+	return "", fmt.Errorf("%w: '%s'", admin.ErrTwitchUserNotFound, username)
+}
+
 type mockQueries struct {
 	userId      string
 	balance     queries.GetBalanceRow
@@ -248,14 +679,17 @@ func (m *mockQueries) GetBalance(ctx context.Context, twitchUserID string) (quer
 }
 
 func (m *mockQueries) GetTransactionHistory(ctx context.Context, arg queries.GetTransactionHistoryParams) ([]queries.GetTransactionHistoryRow, error) {
-	startIndex := 0
-	if arg.StartID.Valid {
-		for m.historyRows[startIndex].ID != arg.StartID.UUID {
-			startIndex++
-		}
+	if arg.TwitchUserID != m.userId {
+		return []queries.GetTransactionHistoryRow{}, nil
 	}
 	rows := make([]queries.GetTransactionHistoryRow, 0, arg.NumRecords)
-	for i := startIndex; i < len(m.historyRows); i++ {
+	for i := 0; i < len(m.historyRows); i++ {
+		if arg.CursorID.Valid && compareHistoryPosition(&m.historyRows[i], arg.CursorCreatedAt.Time, arg.CursorID.UUID) >= 0 {
+			continue
+		}
+		if !matchesHistoryFilters(&m.historyRows[i], &arg) {
+			continue
+		}
 		rows = append(rows, m.historyRows[i])
 		if len(rows) == int(arg.NumRecords) {
 			break
@@ -263,3 +697,88 @@ func (m *mockQueries) GetTransactionHistory(ctx context.Context, arg queries.Get
 	}
 	return rows, nil
 }
+
+func (m *mockQueries) GetNewerTransactionHistory(ctx context.Context, arg queries.GetNewerTransactionHistoryParams) ([]queries.GetNewerTransactionHistoryRow, error) {
+	if arg.TwitchUserID != m.userId {
+		return []queries.GetNewerTransactionHistoryRow{}, nil
+	}
+	filterArg := queries.GetTransactionHistoryParams(arg)
+	rows := make([]queries.GetNewerTransactionHistoryRow, 0, arg.NumRecords)
+	for i := len(m.historyRows) - 1; i >= 0; i-- {
+		if arg.CursorID.Valid && compareHistoryPosition(&m.historyRows[i], arg.CursorCreatedAt.Time, arg.CursorID.UUID) <= 0 {
+			continue
+		}
+		if !matchesHistoryFilters(&m.historyRows[i], &filterArg) {
+			continue
+		}
+		rows = append(rows, queries.GetNewerTransactionHistoryRow(m.historyRows[i]))
+		if len(rows) == int(arg.NumRecords) {
+			break
+		}
+	}
+	return rows, nil
+}
+
+func (m *mockQueries) HistoryCursorExists(ctx context.Context, arg queries.HistoryCursorExistsParams) (bool, error) {
+	if arg.TwitchUserID != m.userId {
+		return false, nil
+	}
+	for i := range m.historyRows {
+		if compareHistoryPosition(&m.historyRows[i], arg.CreatedAt, arg.ID) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareHistoryPosition orders a history row relative to a (created_at, id) position
+// the same way the database does, returning -1 if the row is older, 1 if newer, and 0
+// if it's the same row
+func compareHistoryPosition(row *queries.GetTransactionHistoryRow, createdAt time.Time, id uuid.UUID) int {
+	if row.CreatedAt.Before(createdAt) {
+		return -1
+	}
+	if row.CreatedAt.After(createdAt) {
+		return 1
+	}
+	return strings.Compare(row.ID.String(), id.String())
+}
+
+func matchesHistoryFilters(row *queries.GetTransactionHistoryRow, arg *queries.GetTransactionHistoryParams) bool {
+	if len(arg.Types) > 0 {
+		found := false
+		for _, t := range arg.Types {
+			found = found || t == row.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if arg.State.Valid {
+		state := "pending"
+		if row.FinalizedAt.Valid {
+			state = "rejected"
+			if row.Accepted {
+				state = "accepted"
+			}
+		}
+		if state != arg.State.String {
+			return false
+		}
+	}
+	if arg.Since.Valid && row.CreatedAt.Before(arg.Since.Time) {
+		return false
+	}
+	if arg.Until.Valid && !row.CreatedAt.Before(arg.Until.Time) {
+		return false
+	}
+	if arg.Direction.Valid {
+		if arg.Direction.String == "inflow" && row.DeltaPoints <= 0 {
+			return false
+		}
+		if arg.Direction.String == "outflow" && row.DeltaPoints >= 0 {
+			return false
+		}
+	}
+	return true
+}
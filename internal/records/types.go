@@ -9,4 +9,6 @@ import (
 type Queries interface {
 	GetBalance(ctx context.Context, twitchUserID string) (queries.GetBalanceRow, error)
 	GetTransactionHistory(ctx context.Context, arg queries.GetTransactionHistoryParams) ([]queries.GetTransactionHistoryRow, error)
+	GetNewerTransactionHistory(ctx context.Context, arg queries.GetNewerTransactionHistoryParams) ([]queries.GetNewerTransactionHistoryRow, error)
+	HistoryCursorExists(ctx context.Context, arg queries.HistoryCursorExistsParams) (bool, error)
 }
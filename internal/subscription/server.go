@@ -2,27 +2,34 @@ package subscription
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
 	"strings"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/gorilla/mux"
 )
 
 const MaxStoredMessageLen = 128
 
 type Server struct {
-	q Queries
+	q      Queries
+	policy points.Policy
 }
 
-func NewServer(q Queries) *Server {
+// NewServer initializes a server that credits points for subscriptions and gift subs in
+// accordance with the given policy
+func NewServer(q Queries, policy points.Policy) *Server {
 	return &Server{
-		q: q,
+		q:      q,
+		policy: policy,
 	}
 }
 
@@ -41,28 +48,33 @@ func (s *Server) handlePostSubscription(res http.ResponseWriter, req *http.Reque
 	// Identify the user from the supplied JWT
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 
 	// The request's Content-Type must indicate JSON if set
 	contentType := req.Header.Get("content-type")
 	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
-		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
 		return
 	}
 
 	// Parse the payload from the request body
 	var payload ledger.SubscriptionRequest
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
-		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
 		return
 	}
-	if payload.BasePointsToCredit <= 0 {
-		http.Error(res, "invalid request payload: 'basePointsToCredit' must be set to a positive integer", http.StatusBadRequest)
+	multiplier, ok := s.policy.TierMultiplier(payload.Tier)
+	if !ok {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'tier' must be one of '1000', '2000', or '3000'")
 		return
 	}
-	if payload.CreditMultiplier <= 0 {
-		http.Error(res, "invalid request payload: 'creditMultiplier' must be set to a positive number", http.StatusBadRequest)
+
+	// If the caller has identified the originating event, use its ID as an idempotency
+	// key so that a retried request can't credit the user more than once
+	idempotencyKey, err := util.ResolveIdempotencyKey(req, payload.EventId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request: %v", err))
 		return
 	}
 
@@ -73,25 +85,57 @@ func (s *Server) handlePostSubscription(res http.ResponseWriter, req *http.Reque
 	}
 
 	// Create a finalized flow record representing the inflow transaction that credits
-	// our desired number of points to the target user
-	numPointsToCredit := int32(math.Round(float64(payload.BasePointsToCredit) * payload.CreditMultiplier))
+	// the target user with the number of points our policy awards for their
+	// subscription, scaled by any active promotion, recording which version of the
+	// policy (and which promotion, if any) was applied
+	numPointsToCredit, err := s.policy.SubscriptionCredit(multiplier, payload.IsInitial, payload.IsGift)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	numPointsToCredit, promotion, err := points.ApplyPromotion(req.Context(), s.q, ledger.TransactionTypeSubscription, numPointsToCredit)
+	if errors.Is(err, points.ErrCreditOverflow) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
 	flowId, err := s.q.RecordSubscriptionInflow(context.Background(), queries.RecordSubscriptionInflowParams{
 		TwitchUserID:      claims.User.Id,
 		NumPointsToCredit: numPointsToCredit,
 		Message:           message,
 		IsInitial:         payload.IsInitial,
 		IsGift:            payload.IsGift,
-		CreditMultiplier:  float64(payload.CreditMultiplier),
+		Tier:              string(payload.Tier),
+		CreditMultiplier:  multiplier,
+		PolicyVersion:     s.policy.Version(),
+		Promotion:         promotion,
+		IdempotencyKey:    idempotencyKey,
 	})
+	if errors.Is(err, sql.ErrNoRows) && idempotencyKey.Valid {
+		// No row was inserted because a subscription has already been recorded with this
+		// idempotency key: this is a replay, so respond with the original transaction
+		flowId, err = s.q.GetFlowIdByIdempotencyKey(req.Context(), queries.GetFlowIdByIdempotencyKeyParams{
+			Type:           string(ledger.TransactionTypeSubscription),
+			IdempotencyKey: idempotencyKey.String,
+			TwitchUserID:   claims.User.Id,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Error(res, ledger.ErrorCodeIdempotencyConflict, "idempotency key has already been used for another user")
+			return
+		}
+	}
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
 	// Return a JSON-serialized TransactionResult struct to the user
 	result := &TransactionResult{FlowId: flowId}
 	if err := json.NewEncoder(res).Encode(result); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 }
 
@@ -99,52 +143,89 @@ func (s *Server) handlePostGiftSub(res http.ResponseWriter, req *http.Request) {
 	// Identify the user from the supplied JWT
 	claims, err := auth.GetClaims(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 
 	// The request's Content-Type must indicate JSON if set
 	contentType := req.Header.Get("content-type")
 	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
-		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
 		return
 	}
 
 	// Parse the payload from the request body
 	var payload ledger.GiftSubRequest
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
-		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
 		return
 	}
-	if payload.BasePointsToCredit <= 0 {
-		http.Error(res, "invalid request payload: 'basePointsToCredit' must be set to a positive integer", http.StatusBadRequest)
+	multiplier, ok := s.policy.TierMultiplier(payload.Tier)
+	if !ok {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'tier' must be one of '1000', '2000', or '3000'")
 		return
 	}
 	if payload.NumSubscriptions <= 0 {
-		http.Error(res, "invalid request payload: 'numSubscriptions' must be set to a positive integer", http.StatusBadRequest)
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'numSubscriptions' must be set to a positive integer")
 		return
 	}
-	if payload.CreditMultiplier <= 0 {
-		http.Error(res, "invalid request payload: 'creditMultiplier' must be set to a positive number", http.StatusBadRequest)
+
+	// If the caller has identified the originating event, use its ID as an idempotency
+	// key so that a retried request can't credit the user more than once
+	idempotencyKey, err := util.ResolveIdempotencyKey(req, payload.EventId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request: %v", err))
 		return
 	}
 
 	// Create a finalized flow record representing the inflow transaction that credits
-	// our desired number of points to the target user
-	numPointsToCredit := payload.BasePointsToCredit * payload.NumSubscriptions * int(payload.CreditMultiplier)
+	// the target user with the number of points our policy awards for their gift subs,
+	// scaled by any active promotion, recording which version of the policy (and which
+	// promotion, if any) was applied
+	numPointsToCredit, err := s.policy.GiftSubCredit(multiplier, payload.NumSubscriptions)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	numPointsToCredit, promotion, err := points.ApplyPromotion(req.Context(), s.q, ledger.TransactionTypeGiftSub, numPointsToCredit)
+	if errors.Is(err, points.ErrCreditOverflow) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
 	flowId, err := s.q.RecordGiftSubInflow(context.Background(), queries.RecordGiftSubInflowParams{
 		TwitchUserID:      claims.User.Id,
-		NumPointsToCredit: int32(numPointsToCredit),
+		NumPointsToCredit: numPointsToCredit,
 		NumSubscriptions:  int32(payload.NumSubscriptions),
-		CreditMultiplier:  float64(payload.CreditMultiplier),
+		Tier:              string(payload.Tier),
+		CreditMultiplier:  multiplier,
+		PolicyVersion:     s.policy.Version(),
+		Promotion:         promotion,
+		IdempotencyKey:    idempotencyKey,
 	})
+	if errors.Is(err, sql.ErrNoRows) && idempotencyKey.Valid {
+		// No row was inserted because a gift sub has already been recorded with this
+		// idempotency key: this is a replay, so respond with the original transaction
+		flowId, err = s.q.GetFlowIdByIdempotencyKey(req.Context(), queries.GetFlowIdByIdempotencyKeyParams{
+			Type:           string(ledger.TransactionTypeGiftSub),
+			IdempotencyKey: idempotencyKey.String,
+			TwitchUserID:   claims.User.Id,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			util.Error(res, ledger.ErrorCodeIdempotencyConflict, "idempotency key has already been used for another user")
+			return
+		}
+	}
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 		return
 	}
 
 	// Return a JSON-serialized TransactionResult struct to the user
 	result := &TransactionResult{FlowId: flowId}
 	if err := json.NewEncoder(res).Encode(result); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
 	}
 }
@@ -2,6 +2,7 @@ package subscription
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/golden-vcr/auth"
 	authmock "github.com/golden-vcr/auth/mock"
 	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,16 +32,16 @@ func Test_Server_handlePostSubscription(t *testing.T) {
 			"user purchases an initial sub",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":600,"isInitial":true,"isGift":false,"message":"","creditMultiplier":1}`,
+			`{"tier":"1000","isInitial":true,"isGift":false,"message":""}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
-			600,
+			700,
 		},
 		{
 			"user receives a gift sub",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":600,"isInitial":true,"isGift":true,"message":"","creditMultiplier":1}`,
+			`{"tier":"1000","isInitial":true,"isGift":true,"message":""}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			600,
@@ -48,16 +50,16 @@ func Test_Server_handlePostSubscription(t *testing.T) {
 			"user purchases an initial sub at Tier 3",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":600,"isInitial":true,"isGift":false,"message":"","creditMultiplier":5}`,
+			`{"tier":"3000","isInitial":true,"isGift":false,"message":""}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
-			3000,
+			3100,
 		},
 		{
 			"user receives a gift sub at Tier 2",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":600,"isInitial":true,"isGift":true,"message":"","creditMultiplier":2}`,
+			`{"tier":"2000","isInitial":true,"isGift":true,"message":""}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			1200,
@@ -66,11 +68,29 @@ func Test_Server_handlePostSubscription(t *testing.T) {
 			"user resubscribes at Tier 2",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":600,"isInitial":false,"isGift":false,"message":"","creditMultiplier":2}`,
+			`{"tier":"2000","isInitial":false,"isGift":false,"message":""}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			1200,
 		},
+		{
+			"user purchases an initial sub during a promotion",
+			&mockQueries{promotion: mockPromotion},
+			"internal-jwt",
+			`{"tier":"1000","isInitial":true,"isGift":false,"message":""}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1400,
+		},
+		{
+			"unrecognized tier is a 400 error",
+			&mockQueries{},
+			"internal-jwt",
+			`{"tier":"4000","isInitial":true,"isGift":false,"message":""}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'tier' must be one of '1000', '2000', or '3000'"}`,
+			0,
+		},
 	}
 	for _, tt := range tests {
 		c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
@@ -80,7 +100,8 @@ func Test_Server_handlePostSubscription(t *testing.T) {
 		})
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Server{
-				q: tt.q,
+				q:      tt.q,
+				policy: mockPolicy,
 			}
 			handler := auth.RequireAuthority(c, http.HandlerFunc(s.handlePostSubscription))
 			req := httptest.NewRequest(http.MethodPost, "/inflow/subscription", strings.NewReader(tt.body))
@@ -99,6 +120,8 @@ func Test_Server_handlePostSubscription(t *testing.T) {
 			if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusCreated {
 				assert.Len(t, tt.q.subscriptionCalls, 1)
 				assert.Equal(t, tt.wantNumPointsCredited, tt.q.subscriptionCalls[0].NumPointsToCredit)
+				assert.Equal(t, mockPolicy.Version(), tt.q.subscriptionCalls[0].PolicyVersion)
+				assert.Equal(t, tt.q.promotion != nil, tt.q.subscriptionCalls[0].Promotion.Valid)
 			} else {
 				assert.Len(t, tt.q.subscriptionCalls, 0)
 			}
@@ -120,7 +143,7 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 			"user gifts a single sub",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":200,"numSubscriptions":1,"creditMultiplier":1}`,
+			`{"tier":"1000","numSubscriptions":1}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			200,
@@ -129,7 +152,7 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 			"user gifts ten subs",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":200,"numSubscriptions":10,"creditMultiplier":1}`,
+			`{"tier":"1000","numSubscriptions":10}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			2000,
@@ -138,7 +161,7 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 			"user gifts four Tier 3 subs",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":200,"numSubscriptions":4,"creditMultiplier":5}`,
+			`{"tier":"3000","numSubscriptions":4}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			4000,
@@ -147,11 +170,65 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 			"user gifts eight Tier 2 subs",
 			&mockQueries{},
 			"internal-jwt",
-			`{"basePointsToCredit":200,"numSubscriptions":8,"creditMultiplier":2}`,
+			`{"tier":"2000","numSubscriptions":8}`,
 			http.StatusOK,
 			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
 			3200,
 		},
+		{
+			"legacy payload without tier is rejected",
+			&mockQueries{},
+			"internal-jwt",
+			`{"basePointsToCredit":200,"numSubscriptions":1,"creditMultiplier":1}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'tier' must be one of '1000', '2000', or '3000'"}`,
+			0,
+		},
+		{
+			"user gifts a thousand Tier 3 subs",
+			&mockQueries{},
+			"internal-jwt",
+			`{"tier":"3000","numSubscriptions":1000}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			1000000,
+		},
+		{
+			"gift bomb too large to be credited is a 400 error",
+			&mockQueries{},
+			"internal-jwt",
+			`{"tier":"3000","numSubscriptions":10000000}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: number of points to credit exceeds the maximum that can be recorded in a single transaction"}`,
+			0,
+		},
+		{
+			"user gifts ten subs during a promotion",
+			&mockQueries{promotion: mockPromotion},
+			"internal-jwt",
+			`{"tier":"1000","numSubscriptions":10}`,
+			http.StatusOK,
+			`{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`,
+			4000,
+		},
+		{
+			"promotion may not push gift bomb past the maximum credit",
+			&mockQueries{promotion: mockPromotion},
+			"internal-jwt",
+			`{"tier":"3000","numSubscriptions":2000000}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: number of points to credit exceeds the maximum that can be recorded in a single transaction"}`,
+			0,
+		},
+		{
+			"number of subscriptions must be positive",
+			&mockQueries{},
+			"internal-jwt",
+			`{"tier":"1000","numSubscriptions":0}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'numSubscriptions' must be set to a positive integer"}`,
+			0,
+		},
 	}
 	for _, tt := range tests {
 		c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
@@ -161,7 +238,8 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 		})
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Server{
-				q: tt.q,
+				q:      tt.q,
+				policy: mockPolicy,
 			}
 			handler := auth.RequireAuthority(c, http.HandlerFunc(s.handlePostGiftSub))
 			req := httptest.NewRequest(http.MethodPost, "/inflow/gift-sub", strings.NewReader(tt.body))
@@ -180,6 +258,8 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 			if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusCreated {
 				assert.Len(t, tt.q.giftSubCalls, 1)
 				assert.Equal(t, tt.wantNumPointsCredited, tt.q.giftSubCalls[0].NumPointsToCredit)
+				assert.Equal(t, mockPolicy.Version(), tt.q.giftSubCalls[0].PolicyVersion)
+				assert.Equal(t, tt.q.promotion != nil, tt.q.giftSubCalls[0].Promotion.Valid)
 			} else {
 				assert.Len(t, tt.q.giftSubCalls, 0)
 			}
@@ -187,16 +267,88 @@ func Test_Server_handlePostGiftSub(t *testing.T) {
 	}
 }
 
+func Test_Server_idempotency(t *testing.T) {
+	c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
+		Id:          "1337",
+		Login:       "leetman",
+		DisplayName: "LEETman",
+	})
+	q := &mockQueries{}
+	s := &Server{
+		q:      q,
+		policy: mockPolicy,
+	}
+
+	post := func(handlerFunc http.HandlerFunc, url string, body string) (int, string) {
+		handler := auth.RequireAuthority(c, handlerFunc)
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Add("authorization", "Bearer internal-jwt")
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res.Code, strings.TrimSuffix(string(b), "\n")
+	}
+
+	// Sending the same subscription event twice should only credit the user once
+	for i := 0; i < 2; i++ {
+		status, body := post(s.handlePostSubscription, "/inflow/subscription", `{"tier":"1000","isInitial":true,"isGift":false,"message":"","eventId":"sub-event"}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, `{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`, body)
+	}
+	assert.Len(t, q.subscriptionCalls, 1)
+
+	// Likewise for gift subs
+	for i := 0; i < 2; i++ {
+		status, body := post(s.handlePostGiftSub, "/inflow/gift-sub", `{"tier":"1000","numSubscriptions":5,"eventId":"gift-event"}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, `{"flowId":"0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"}`, body)
+	}
+	assert.Len(t, q.giftSubCalls, 1)
+}
+
+// mockPolicy awards a bonus of 100 points for initial subscriptions
+var mockPolicy = points.Policy{
+	PointsPerBit:             1,
+	PointsPerSubscription:    600,
+	PointsPerGiftSub:         200,
+	Tier2Multiplier:          2.0,
+	Tier3Multiplier:          5.0,
+	InitialSubscriptionBonus: 100,
+}
+
 type mockQueries struct {
 	err               error
+	promotion         *queries.GetActivePromotionRow
 	subscriptionCalls []queries.RecordSubscriptionInflowParams
 	giftSubCalls      []queries.RecordGiftSubInflowParams
 }
 
+func (m *mockQueries) GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error) {
+	if m.promotion == nil {
+		return queries.GetActivePromotionRow{}, sql.ErrNoRows
+	}
+	return *m.promotion, nil
+}
+
+// mockPromotion doubles the points credited for any inflow
+var mockPromotion = &queries.GetActivePromotionRow{
+	ID:         uuid.MustParse("7c0bc3fe-43bb-4b79-9e48-ad4b2c5c1a2e"),
+	Name:       "Double Points Night",
+	Multiplier: 2.0,
+}
+
 func (m *mockQueries) RecordSubscriptionInflow(ctx context.Context, arg queries.RecordSubscriptionInflowParams) (uuid.UUID, error) {
 	if m.err != nil {
 		return uuid.UUID{}, m.err
 	}
+	if arg.IdempotencyKey.Valid {
+		for _, call := range m.subscriptionCalls {
+			if call.IdempotencyKey == arg.IdempotencyKey {
+				return uuid.UUID{}, sql.ErrNoRows
+			}
+		}
+	}
 	m.subscriptionCalls = append(m.subscriptionCalls, arg)
 	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
 }
@@ -205,6 +357,31 @@ func (m *mockQueries) RecordGiftSubInflow(ctx context.Context, arg queries.Recor
 	if m.err != nil {
 		return uuid.UUID{}, m.err
 	}
+	if arg.IdempotencyKey.Valid {
+		for _, call := range m.giftSubCalls {
+			if call.IdempotencyKey == arg.IdempotencyKey {
+				return uuid.UUID{}, sql.ErrNoRows
+			}
+		}
+	}
 	m.giftSubCalls = append(m.giftSubCalls, arg)
 	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
 }
+
+func (m *mockQueries) GetFlowIdByIdempotencyKey(ctx context.Context, arg queries.GetFlowIdByIdempotencyKeyParams) (uuid.UUID, error) {
+	if arg.Type == "subscription" {
+		for _, call := range m.subscriptionCalls {
+			if call.IdempotencyKey.String == arg.IdempotencyKey && call.TwitchUserID == arg.TwitchUserID {
+				return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+			}
+		}
+	}
+	if arg.Type == "gift-sub" {
+		for _, call := range m.giftSubCalls {
+			if call.IdempotencyKey.String == arg.IdempotencyKey && call.TwitchUserID == arg.TwitchUserID {
+				return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+			}
+		}
+	}
+	return uuid.UUID{}, sql.ErrNoRows
+}
@@ -8,6 +8,8 @@ import (
 )
 
 type Queries interface {
+	GetActivePromotion(ctx context.Context, flowType string) (queries.GetActivePromotionRow, error)
+	GetFlowIdByIdempotencyKey(ctx context.Context, arg queries.GetFlowIdByIdempotencyKeyParams) (uuid.UUID, error)
 	RecordSubscriptionInflow(ctx context.Context, arg queries.RecordSubscriptionInflowParams) (uuid.UUID, error)
 	RecordGiftSubInflow(ctx context.Context, arg queries.RecordGiftSubInflowParams) (uuid.UUID, error)
 }
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// AvailableBalanceConstraint is the name reported by the database when a change to
+// ledger.flow is refused because it would reduce a user's available balance below zero
+const AvailableBalanceConstraint = "flow_available_balance_check"
+
+// IsInsufficientBalanceError returns true if the given error was raised by the database
+// in response to a transaction that the user can not afford, i.e. an outflow that
+// would bring their available balance below zero
+func IsInsufficientBalanceError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Name() == "check_violation" && pqErr.Constraint == AvailableBalanceConstraint
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golden-vcr/ledger"
+)
+
+// Error replies to the request with a JSON problem details body describing an error of
+// the given type, using the HTTP status code associated with that type. Handlers should
+// use Error in place of http.Error, so that clients can identify errors by their codes.
+func Error(res http.ResponseWriter, code ledger.ErrorCode, detail string) {
+	status := code.Status()
+	problem := ledger.Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+	res.Header().Del("content-length")
+	res.Header().Set("content-type", ledger.ProblemContentType)
+	res.Header().Set("x-content-type-options", "nosniff")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(problem)
+}
@@ -0,0 +1,24 @@
+package util
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/golden-vcr/ledger"
+)
+
+// ResolveIdempotencyKey determines the idempotency key that should be recorded for an
+// inflow, which may be supplied either via the Idempotency-Key header or as the eventId
+// value from the request payload. If neither is set, the inflow is not idempotent and
+// the resulting value will be NULL.
+func ResolveIdempotencyKey(req *http.Request, eventId string) (sql.NullString, error) {
+	key := req.Header.Get(ledger.IdempotencyKeyHeader)
+	if key != "" && eventId != "" && key != eventId {
+		return sql.NullString{}, fmt.Errorf("%s header and 'eventId' must match if both are supplied", ledger.IdempotencyKeyHeader)
+	}
+	if key == "" {
+		key = eventId
+	}
+	return sql.NullString{Valid: key != "", String: key}, nil
+}
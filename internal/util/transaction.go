@@ -4,33 +4,94 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/golden-vcr/ledger"
 	"github.com/google/uuid"
 )
 
-func BuildTransaction(id uuid.UUID, flowType string, metadata json.RawMessage, deltaPoints int, createdAt time.Time, finalizedAt sql.NullTime, accepted bool) ledger.Transaction {
+// RejectionReasonExpired is recorded as metadata.rejection_reason for any pending
+// transaction that was automatically rejected because it was never finalized before its
+// expiration time
+const RejectionReasonExpired = "expired"
+
+func BuildTransaction(id uuid.UUID, flowType string, metadata json.RawMessage, deltaPoints int, createdAt time.Time, finalizedAt sql.NullTime, accepted bool, descriptionTemplate string, reversedDeltaPoints int) ledger.Transaction {
 	timestamp := createdAt
 	state := ledger.TransactionStatePending
+	description, err := RenderDescriptionTemplate(descriptionTemplate, metadata)
+	if err != nil || description == "" {
+		description = formatTransactionDescription(flowType, metadata)
+	}
 	if finalizedAt.Valid {
 		timestamp = finalizedAt.Time
 		if accepted {
 			state = ledger.TransactionStateAccepted
 		} else {
 			state = ledger.TransactionStateRejected
+			var md rejectionMetadata
+			if err := json.Unmarshal(metadata, &md); err == nil && md.RejectionReason == RejectionReasonExpired {
+				description += " (expired before it was completed)"
+			}
 		}
 	}
+	if reversedDeltaPoints != 0 {
+		description += formatReversalSuffix(deltaPoints, reversedDeltaPoints)
+	}
 	return ledger.Transaction{
 		Id:          id,
 		Timestamp:   timestamp,
 		Type:        ledger.TransactionType(flowType),
 		State:       state,
 		DeltaPoints: int(deltaPoints),
-		Description: formatTransactionDescription(flowType, metadata),
+		Description: description,
 	}
 }
 
+// ParseDescriptionTemplate parses the description template registered for a flow type,
+// returning an error if it's not a valid text/template string
+func ParseDescriptionTemplate(descriptionTemplate string) (*template.Template, error) {
+	return template.New("description").Option("missingkey=error").Parse(descriptionTemplate)
+}
+
+// parsedDescriptionTemplates caches the result of ParseDescriptionTemplate, keyed by
+// template text, so that rendering a page of history doesn't re-parse the same
+// template for every row
+var parsedDescriptionTemplates sync.Map
+
+// RenderDescriptionTemplate produces a user-facing description of a transaction by
+// executing the given template with the transaction's metadata object as its data. If
+// the template is empty, the result is an empty string.
+func RenderDescriptionTemplate(descriptionTemplate string, metadata json.RawMessage) (string, error) {
+	if descriptionTemplate == "" {
+		return "", nil
+	}
+	var tmpl *template.Template
+	if cached, ok := parsedDescriptionTemplates.Load(descriptionTemplate); ok {
+		tmpl = cached.(*template.Template)
+	} else {
+		parsed, err := ParseDescriptionTemplate(descriptionTemplate)
+		if err != nil {
+			return "", err
+		}
+		parsedDescriptionTemplates.Store(descriptionTemplate, parsed)
+		tmpl = parsed
+	}
+	var data map[string]interface{}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &data); err != nil {
+			return "", err
+		}
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
 func formatTransactionDescription(flowType string, metadata json.RawMessage) string {
 	if flowType == string(ledger.TransactionTypeManualCredit) {
 		s := "Manual credit"
@@ -40,10 +101,25 @@ func formatTransactionDescription(flowType string, metadata json.RawMessage) str
 		}
 		return s
 	}
+	if flowType == string(ledger.TransactionTypeManualDebit) {
+		s := "Manual debit"
+		var md manualDebitMetadata
+		if err := json.Unmarshal(metadata, &md); err == nil {
+			s += fmt.Sprintf(": %s", md.Note)
+		}
+		return s
+	}
+	if flowType == string(ledger.TransactionTypeReversal) {
+		var md reversalMetadata
+		if err := json.Unmarshal(metadata, &md); err != nil {
+			return "Reversal of a previous transaction"
+		}
+		return formatReversalDescription(md.ReversedType, md.ReversedMetadata)
+	}
 	if flowType == string(ledger.TransactionTypeAlertRedemption) {
 		s := "Redeemed alert"
 		var md alertRedemptionMetadata
-		if err := json.Unmarshal(metadata, &md); err == nil {
+		if err := json.Unmarshal(metadata, &md); err == nil && md.Type != "" {
 			s += fmt.Sprintf(" of type '%s'", md.Type)
 		}
 		return s
@@ -55,6 +131,7 @@ func formatTransactionDescription(flowType string, metadata json.RawMessage) str
 			s += fmt.Sprintf(" with the message '%s'", md.Message)
 		}
 		s += "!"
+		s += formatPromotionSuffix(md.Promotion)
 		return s
 	}
 	if flowType == string(ledger.TransactionTypeSubscription) {
@@ -77,6 +154,7 @@ func formatTransactionDescription(flowType string, metadata json.RawMessage) str
 			s += fmt.Sprintf(" with the message '%s'", md.Message)
 		}
 		s += "!"
+		s += formatPromotionSuffix(md.Promotion)
 		return s
 	}
 	if flowType == string(ledger.TransactionTypeGiftSub) {
@@ -94,31 +172,176 @@ func formatTransactionDescription(flowType string, metadata json.RawMessage) str
 			s += fmt.Sprintf(" (at a tier with %.fx credit)", md.CreditMultiplier)
 		}
 		s += "!"
+		s += formatPromotionSuffix(md.Promotion)
+		return s
+	}
+	if flowType == string(ledger.TransactionTypeRaid) {
+		var md raidMetadata
+		if err := json.Unmarshal(metadata, &md); err != nil {
+			return "Thank you for raiding!"
+		}
+		s := ""
+		if md.NumViewers == 1 {
+			s = "Thank you for raiding with 1 viewer!"
+		} else {
+			s = fmt.Sprintf("Thank you for raiding with %d viewers!", md.NumViewers)
+		}
+		s += formatPromotionSuffix(md.Promotion)
+		return s
+	}
+	if flowType == string(ledger.TransactionTypeFollow) {
+		s := "Thank you for following!"
+		var md followMetadata
+		if err := json.Unmarshal(metadata, &md); err == nil {
+			s += formatPromotionSuffix(md.Promotion)
+		}
+		return s
+	}
+	if flowType == string(ledger.TransactionTypeHypeTrain) {
+		var md hypeTrainMetadata
+		if err := json.Unmarshal(metadata, &md); err != nil {
+			return "Thank you for contributing to a hype train!"
+		}
+		s := fmt.Sprintf("Thank you for contributing to a level %d hype train!", md.Level)
+		s += formatPromotionSuffix(md.Promotion)
 		return s
 	}
-	return ""
+	if flowType == string(ledger.TransactionTypeWatchTime) {
+		var md watchTimeMetadata
+		if err := json.Unmarshal(metadata, &md); err != nil {
+			return "Thank you for watching!"
+		}
+		if md.MinutesWatched == 1 {
+			return "Thank you for watching for 1 minute!"
+		}
+		return fmt.Sprintf("Thank you for watching for %d minutes!", md.MinutesWatched)
+	}
+	// Any other type is an outflow registered by another service: if it has no usable
+	// description template, we can at least say what the points were spent on
+	return fmt.Sprintf("Spent points on %s", flowType)
 }
 
-type manualCreditMetadata struct {
-	Note string `json:"note"`
+// formatReversalDescription describes a reversal in terms of the original transaction
+// that it reverses: undoing an outflow is a refund, e.g. "Refund of alert 'ghost'"
+func formatReversalDescription(reversedType string, reversedMetadata json.RawMessage) string {
+	switch ledger.TransactionType(reversedType) {
+	case ledger.TransactionTypeAlertRedemption:
+		var md struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(reversedMetadata, &md); err == nil && md.Type != "" {
+			return fmt.Sprintf("Refund of alert '%s'", md.Type)
+		}
+		return "Refund of alert"
+	case ledger.TransactionTypeManualCredit:
+		return "Reversal of manual credit"
+	case ledger.TransactionTypeManualDebit:
+		return "Refund of manual debit"
+	case ledger.TransactionTypeCheer:
+		return "Reversal of points credited for cheer"
+	case ledger.TransactionTypeSubscription:
+		return "Reversal of points credited for subscription"
+	case ledger.TransactionTypeGiftSub:
+		return "Reversal of points credited for gift subs"
+	case ledger.TransactionTypeRaid:
+		return "Reversal of points credited for raid"
+	case ledger.TransactionTypeFollow:
+		return "Reversal of points credited for follow"
+	case ledger.TransactionTypeHypeTrain:
+		return "Reversal of points credited for hype train"
+	case ledger.TransactionTypeWatchTime:
+		return "Reversal of points credited for watch time"
+	}
+	return fmt.Sprintf("Reversal of transaction of type '%s'", reversedType)
+}
+
+// formatReversalSuffix describes the extent to which a transaction has been reversed,
+// given the sum of the delta_points values of all reversals recorded against it
+func formatReversalSuffix(deltaPoints int, reversedDeltaPoints int) string {
+	numReversed := reversedDeltaPoints
+	if numReversed < 0 {
+		numReversed = -numReversed
+	}
+	numTotal := deltaPoints
+	if numTotal < 0 {
+		numTotal = -numTotal
+	}
+	if numReversed >= numTotal {
+		return " (Reversed by admin)"
+	}
+	return fmt.Sprintf(" (Reversed by admin: %d of %d points)", numReversed, numTotal)
+}
+
+// formatPromotionSuffix mentions the promotion, if any, that scaled the number of points
+// credited by an inflow, e.g. " (2x Double Points Night)"
+func formatPromotionSuffix(promotion *promotionMetadata) string {
+	if promotion == nil || promotion.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%gx %s)", promotion.Multiplier, promotion.Name)
+}
+
+type rejectionMetadata struct {
+	RejectionReason string `json:"rejection_reason"`
 }
 
 type alertRedemptionMetadata struct {
 	Type string `json:"type"`
 }
 
+type manualCreditMetadata struct {
+	Note string `json:"note"`
+}
+
+type manualDebitMetadata struct {
+	Note                 string `json:"note"`
+	AllowNegativeBalance bool   `json:"allow_negative_balance"`
+}
+
+type reversalMetadata struct {
+	Note             string          `json:"note"`
+	ReversedType     string          `json:"reversed_type"`
+	ReversedMetadata json.RawMessage `json:"reversed_metadata"`
+}
+
+type promotionMetadata struct {
+	Name       string  `json:"name"`
+	Multiplier float64 `json:"multiplier"`
+}
+
 type cheerMetadata struct {
-	Message string `json:"message"`
+	Message   string             `json:"message"`
+	Promotion *promotionMetadata `json:"promotion"`
 }
 
 type subscriptionMetadata struct {
-	Message          string  `json:"message"`
-	IsInitial        bool    `json:"is_initial"`
-	IsGift           bool    `json:"is_gift"`
-	CreditMultiplier float64 `json:"credit_multiplier"`
+	Message          string             `json:"message"`
+	IsInitial        bool               `json:"is_initial"`
+	IsGift           bool               `json:"is_gift"`
+	CreditMultiplier float64            `json:"credit_multiplier"`
+	Promotion        *promotionMetadata `json:"promotion"`
 }
 
 type giftSubMetadata struct {
-	NumSubscriptions int     `json:"num_subscriptions"`
-	CreditMultiplier float64 `json:"credit_multiplier"`
+	NumSubscriptions int                `json:"num_subscriptions"`
+	CreditMultiplier float64            `json:"credit_multiplier"`
+	Promotion        *promotionMetadata `json:"promotion"`
+}
+
+type raidMetadata struct {
+	NumViewers int                `json:"num_viewers"`
+	Promotion  *promotionMetadata `json:"promotion"`
+}
+
+type followMetadata struct {
+	Promotion *promotionMetadata `json:"promotion"`
+}
+
+type hypeTrainMetadata struct {
+	Level     int                `json:"level"`
+	Promotion *promotionMetadata `json:"promotion"`
+}
+
+type watchTimeMetadata struct {
+	MinutesWatched int `json:"minutes_watched"`
 }
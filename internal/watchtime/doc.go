@@ -0,0 +1,4 @@
+// Package watchtime implements an endpoint that allows our internal services to credit
+// points to viewers for the time they spend watching streams, in batches that each
+// cover a single interval of a stream
+package watchtime
@@ -0,0 +1,225 @@
+package watchtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/golden-vcr/ledger/internal/util"
+	"github.com/gorilla/mux"
+)
+
+// MaxIdLen is the maximum length of the stream and interval IDs that identify a batch
+const MaxIdLen = 128
+
+// MaxViewersPerBatch is the maximum number of viewers that may be credited in a single
+// request
+const MaxViewersPerBatch = 5000
+
+// MaxMinutesPerInterval is the maximum number of minutes that any one viewer may be
+// credited with for a single interval
+const MaxMinutesPerInterval = 60
+
+type Server struct {
+	runInTx RunInTxFunc
+	policy  points.Policy
+}
+
+// NewServer initializes a server that credits points for watch time in accordance with
+// the given policy, crediting each batch in a single database transaction
+func NewServer(runInTx RunInTxFunc, policy points.Policy) *Server {
+	return &Server{
+		runInTx: runInTx,
+		policy:  policy,
+	}
+}
+
+func (s *Server) RegisterRoutes(r *mux.Router, c auth.Client) {
+	r.Path("/inflow/watch-time").Methods("POST").Handler(
+		// Only internal services may call this endpoint, by supplying the JWT they've
+		// been issued by the auth service (with the 'authoritative' claim)
+		auth.RequireAuthority(c, http.HandlerFunc(s.handlePostWatchTime)),
+	)
+}
+
+func (s *Server) handlePostWatchTime(res http.ResponseWriter, req *http.Request) {
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ledger.WatchTimeRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if err := validatePayload(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+
+	// Determine how many points our policy awards each viewer for their watch time,
+	// before any daily caps are applied
+	credits := make([]int32, len(payload.Viewers))
+	for i, viewer := range payload.Viewers {
+		numPoints, err := s.policy.WatchTimeCredit(viewer.MinutesWatched)
+		if err != nil {
+			util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+			return
+		}
+		credits[i] = numPoints
+	}
+
+	// Credit all viewers in a single transaction, so that the batch is either recorded
+	// in its entirety or not at all
+	var result ledger.WatchTimeResult
+	err := s.runInTx(req.Context(), func(q Queries) error {
+		var err error
+		result, err = s.creditBatch(req.Context(), q, &payload, credits)
+		return err
+	})
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Return a JSON-serialized WatchTimeResult struct to the caller
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+// creditBatch credits each viewer in the given batch with the corresponding number of
+// points, subject to their daily cap, and returns a summary of the points credited. If
+// the batch's interval has already been credited, nothing is changed, and the summary
+// of the original batch is returned instead.
+func (s *Server) creditBatch(ctx context.Context, q Queries, payload *ledger.WatchTimeRequest, credits []int32) (ledger.WatchTimeResult, error) {
+	// Claim the interval before crediting anyone: if a concurrent request is crediting
+	// the same interval, this blocks until that transaction completes
+	interval, err := q.RecordWatchTimeInterval(ctx, queries.RecordWatchTimeIntervalParams{
+		StreamID:   payload.StreamId,
+		IntervalID: payload.IntervalId,
+	})
+	if err != nil {
+		return ledger.WatchTimeResult{}, err
+	}
+	if numRows, err := interval.RowsAffected(); err != nil {
+		return ledger.WatchTimeResult{}, err
+	} else if numRows == 0 {
+		// This interval has already been credited: this is a replay, so respond with
+		// the result of the original batch
+		row, err := q.GetWatchTimeInterval(ctx, queries.GetWatchTimeIntervalParams{
+			StreamID:   payload.StreamId,
+			IntervalID: payload.IntervalId,
+		})
+		if err != nil {
+			return ledger.WatchTimeResult{}, err
+		}
+		return ledger.WatchTimeResult{
+			NumViewersCredited: int(row.NumViewersCredited),
+			NumPointsCredited:  int(row.NumPointsCredited),
+		}, nil
+	}
+
+	// Credit viewers in order of user ID, so that concurrent batches always lock each
+	// user's daily total in the same order and can't deadlock one another
+	order := make([]int, len(payload.Viewers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return payload.Viewers[order[a]].TwitchUserId < payload.Viewers[order[b]].TwitchUserId
+	})
+
+	var result ledger.WatchTimeResult
+	for _, i := range order {
+		viewer := payload.Viewers[i]
+
+		// Reduce the credit as needed to keep the viewer within their daily cap,
+		// skipping viewers who have already reached it
+		numPointsToday, err := q.LockWatchTimeDailyTotal(ctx, viewer.TwitchUserId)
+		if err != nil {
+			return ledger.WatchTimeResult{}, err
+		}
+		numPointsToCredit := s.policy.CapWatchTimeCredit(credits[i], numPointsToday)
+		if numPointsToCredit <= 0 {
+			continue
+		}
+		if err := q.IncrementWatchTimeDailyTotal(ctx, queries.IncrementWatchTimeDailyTotalParams{
+			NumPoints:    numPointsToCredit,
+			TwitchUserID: viewer.TwitchUserId,
+		}); err != nil {
+			return ledger.WatchTimeResult{}, err
+		}
+
+		// Record a new inflow for this interval: the viewer's history combines all the
+		// inflows for a stream into a single entry
+		if _, err := q.RecordWatchTimeInflow(ctx, queries.RecordWatchTimeInflowParams{
+			StreamID:          payload.StreamId,
+			IntervalID:        payload.IntervalId,
+			MinutesWatched:    int32(viewer.MinutesWatched),
+			PolicyVersion:     s.policy.Version(),
+			TwitchUserID:      viewer.TwitchUserId,
+			NumPointsToCredit: numPointsToCredit,
+		}); err != nil {
+			return ledger.WatchTimeResult{}, err
+		}
+		result.NumViewersCredited++
+		result.NumPointsCredited += int(numPointsToCredit)
+	}
+
+	// Record the outcome of the batch, so that a retried request can be given the same
+	// result
+	if err := q.FinishWatchTimeInterval(ctx, queries.FinishWatchTimeIntervalParams{
+		NumViewersCredited: int32(result.NumViewersCredited),
+		NumPointsCredited:  int32(result.NumPointsCredited),
+		StreamID:           payload.StreamId,
+		IntervalID:         payload.IntervalId,
+	}); err != nil {
+		return ledger.WatchTimeResult{}, err
+	}
+	return result, nil
+}
+
+// validatePayload returns an error if the given request can not be credited
+func validatePayload(payload *ledger.WatchTimeRequest) error {
+	if payload.StreamId == "" {
+		return fmt.Errorf("'streamId' is required")
+	}
+	if len(payload.StreamId) > MaxIdLen {
+		return fmt.Errorf("'streamId' must not exceed %d characters", MaxIdLen)
+	}
+	if payload.IntervalId == "" {
+		return fmt.Errorf("'intervalId' is required")
+	}
+	if len(payload.IntervalId) > MaxIdLen {
+		return fmt.Errorf("'intervalId' must not exceed %d characters", MaxIdLen)
+	}
+	if len(payload.Viewers) > MaxViewersPerBatch {
+		return fmt.Errorf("'viewers' must not list more than %d viewers", MaxViewersPerBatch)
+	}
+	seen := make(map[string]struct{}, len(payload.Viewers))
+	for _, viewer := range payload.Viewers {
+		if viewer.TwitchUserId == "" {
+			return fmt.Errorf("'twitchUserId' is required for each viewer")
+		}
+		if viewer.MinutesWatched <= 0 || viewer.MinutesWatched > MaxMinutesPerInterval {
+			return fmt.Errorf("'minutesWatched' must be set to a positive integer no greater than %d", MaxMinutesPerInterval)
+		}
+		if _, ok := seen[viewer.TwitchUserId]; ok {
+			return fmt.Errorf("viewer '%s' is listed more than once", viewer.TwitchUserId)
+		}
+		seen[viewer.TwitchUserId] = struct{}{}
+	}
+	return nil
+}
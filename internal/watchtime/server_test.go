@@ -0,0 +1,310 @@
+package watchtime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golden-vcr/auth"
+	authmock "github.com/golden-vcr/auth/mock"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostWatchTime(t *testing.T) {
+	tests := []struct {
+		name          string
+		q             *mockQueries
+		authorization string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantFlows     map[string]mockWatchTimeFlow
+	}{
+		{
+			"normal usage",
+			&mockQueries{},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":5},{"twitchUserId":"1002","minutesWatched":3}]}`,
+			http.StatusOK,
+			`{"numViewersCredited":2,"numPointsCredited":16}`,
+			map[string]mockWatchTimeFlow{
+				"1001/stream-1/interval-1": {minutesWatched: 5, numPoints: 10},
+				"1002/stream-1/interval-1": {minutesWatched: 3, numPoints: 6},
+			},
+		},
+		{
+			"subsequent intervals are recorded as separate flows",
+			&mockQueries{
+				flows: map[string]mockWatchTimeFlow{
+					"1001/stream-1/interval-1": {minutesWatched: 5, numPoints: 10},
+				},
+			},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-2","viewers":[{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusOK,
+			`{"numViewersCredited":1,"numPointsCredited":10}`,
+			map[string]mockWatchTimeFlow{
+				"1001/stream-1/interval-1": {minutesWatched: 5, numPoints: 10},
+				"1001/stream-1/interval-2": {minutesWatched: 5, numPoints: 10},
+			},
+		},
+		{
+			"viewers are credited no more than their daily cap",
+			&mockQueries{
+				dailyTotals: map[string]int32{"1001": 95, "1002": 100},
+			},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":5},{"twitchUserId":"1002","minutesWatched":5}]}`,
+			http.StatusOK,
+			`{"numViewersCredited":1,"numPointsCredited":5}`,
+			map[string]mockWatchTimeFlow{
+				"1001/stream-1/interval-1": {minutesWatched: 5, numPoints: 5},
+			},
+		},
+		{
+			"replayed interval returns original result without crediting again",
+			&mockQueries{
+				intervals: map[string]queries.GetWatchTimeIntervalRow{
+					"stream-1/interval-1": {NumViewersCredited: 2, NumPointsCredited: 16},
+				},
+			},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusOK,
+			`{"numViewersCredited":2,"numPointsCredited":16}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"interval with no viewers is recorded",
+			&mockQueries{},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[]}`,
+			http.StatusOK,
+			`{"numViewersCredited":0,"numPointsCredited":0}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"stream ID is required",
+			&mockQueries{},
+			"internal-jwt",
+			`{"intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'streamId' is required"}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"interval ID is required",
+			&mockQueries{},
+			"internal-jwt",
+			`{"streamId":"stream-1","viewers":[{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'intervalId' is required"}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"minutes watched must be positive",
+			&mockQueries{},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":0}]}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'minutesWatched' must be set to a positive integer no greater than 60"}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"minutes watched may not exceed the maximum interval length",
+			&mockQueries{},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":61}]}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'minutesWatched' must be set to a positive integer no greater than 60"}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"viewers may not be listed more than once",
+			&mockQueries{},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":5},{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: viewer '1001' is listed more than once"}`,
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"invalid JWT is a 401 error",
+			&mockQueries{},
+			"twitch-user-access-token",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusUnauthorized,
+			"access denied",
+			map[string]mockWatchTimeFlow{},
+		},
+		{
+			"failure partway through the batch credits nobody",
+			&mockQueries{
+				failForUser: "1002",
+			},
+			"internal-jwt",
+			`{"streamId":"stream-1","intervalId":"interval-1","viewers":[{"twitchUserId":"1002","minutesWatched":5},{"twitchUserId":"1001","minutesWatched":5}]}`,
+			http.StatusInternalServerError,
+			`{"title":"Internal Server Error","status":500,"code":"internal_error","detail":"mock error"}`,
+			map[string]mockWatchTimeFlow{},
+		},
+	}
+	for _, tt := range tests {
+		c := authmock.NewClient().AllowAuthoritativeJWT("internal-jwt", auth.UserDetails{
+			Id:          "1337",
+			Login:       "leetman",
+			DisplayName: "LEETman",
+		}).AllowTwitchUserAccessToken("twitch-user-access-token", auth.RoleViewer, auth.UserDetails{
+			Id:          "100",
+			Login:       "badman",
+			DisplayName: "Badman",
+		})
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				runInTx: tt.q.runInTx,
+				policy:  mockPolicy,
+			}
+			handler := auth.RequireAuthority(c, http.HandlerFunc(s.handlePostWatchTime))
+			req := httptest.NewRequest(http.MethodPost, "/inflow/watch-time", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Add("authorization", fmt.Sprintf("Bearer %s", tt.authorization))
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+
+			flows := tt.q.flows
+			if flows == nil {
+				flows = map[string]mockWatchTimeFlow{}
+			}
+			assert.Equal(t, tt.wantFlows, flows)
+		})
+	}
+}
+
+// mockPolicy credits 2 points per minute watched, up to 100 points per day
+var mockPolicy = points.Policy{
+	PointsPerBit:            1,
+	PointsPerSubscription:   600,
+	PointsPerGiftSub:        200,
+	Tier2Multiplier:         2.0,
+	Tier3Multiplier:         5.0,
+	PointsPerRaidViewer:     10,
+	PointsPerFollow:         50,
+	PointsPerHypeTrainLevel: 100,
+	PointsPerWatchMinute:    2,
+	WatchTimeDailyCap:       100,
+}
+
+type mockWatchTimeFlow struct {
+	minutesWatched int32
+	numPoints      int32
+}
+
+type mockSqlResult struct {
+	numRows int64
+}
+
+func (r *mockSqlResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r *mockSqlResult) RowsAffected() (int64, error) {
+	return r.numRows, nil
+}
+
+// mockQueries stores watch-time state in memory. Its runInTx method mimics a database
+// transaction, discarding all changes made by a function that fails.
+type mockQueries struct {
+	failForUser string
+	intervals   map[string]queries.GetWatchTimeIntervalRow
+	dailyTotals map[string]int32
+	flows       map[string]mockWatchTimeFlow
+}
+
+func (m *mockQueries) runInTx(ctx context.Context, f func(q Queries) error) error {
+	tx := &mockQueries{
+		failForUser: m.failForUser,
+		intervals:   make(map[string]queries.GetWatchTimeIntervalRow),
+		dailyTotals: make(map[string]int32),
+		flows:       make(map[string]mockWatchTimeFlow),
+	}
+	for k, v := range m.intervals {
+		tx.intervals[k] = v
+	}
+	for k, v := range m.dailyTotals {
+		tx.dailyTotals[k] = v
+	}
+	for k, v := range m.flows {
+		tx.flows[k] = v
+	}
+	if err := f(tx); err != nil {
+		return err
+	}
+	m.intervals = tx.intervals
+	m.dailyTotals = tx.dailyTotals
+	m.flows = tx.flows
+	return nil
+}
+
+func (m *mockQueries) RecordWatchTimeInterval(ctx context.Context, arg queries.RecordWatchTimeIntervalParams) (sql.Result, error) {
+	key := arg.StreamID + "/" + arg.IntervalID
+	if _, ok := m.intervals[key]; ok {
+		return &mockSqlResult{numRows: 0}, nil
+	}
+	m.intervals[key] = queries.GetWatchTimeIntervalRow{}
+	return &mockSqlResult{numRows: 1}, nil
+}
+
+func (m *mockQueries) GetWatchTimeInterval(ctx context.Context, arg queries.GetWatchTimeIntervalParams) (queries.GetWatchTimeIntervalRow, error) {
+	row, ok := m.intervals[arg.StreamID+"/"+arg.IntervalID]
+	if !ok {
+		return queries.GetWatchTimeIntervalRow{}, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+func (m *mockQueries) FinishWatchTimeInterval(ctx context.Context, arg queries.FinishWatchTimeIntervalParams) error {
+	m.intervals[arg.StreamID+"/"+arg.IntervalID] = queries.GetWatchTimeIntervalRow{
+		NumViewersCredited: arg.NumViewersCredited,
+		NumPointsCredited:  arg.NumPointsCredited,
+	}
+	return nil
+}
+
+func (m *mockQueries) LockWatchTimeDailyTotal(ctx context.Context, twitchUserID string) (int32, error) {
+	return m.dailyTotals[twitchUserID], nil
+}
+
+func (m *mockQueries) IncrementWatchTimeDailyTotal(ctx context.Context, arg queries.IncrementWatchTimeDailyTotalParams) error {
+	m.dailyTotals[arg.TwitchUserID] += arg.NumPoints
+	return nil
+}
+
+func (m *mockQueries) RecordWatchTimeInflow(ctx context.Context, arg queries.RecordWatchTimeInflowParams) (uuid.UUID, error) {
+	if arg.TwitchUserID == m.failForUser {
+		return uuid.UUID{}, fmt.Errorf("mock error")
+	}
+	key := arg.TwitchUserID + "/" + arg.StreamID + "/" + arg.IntervalID
+	if _, ok := m.flows[key]; ok {
+		return uuid.UUID{}, fmt.Errorf("mock error: flow already recorded for %s", key)
+	}
+	m.flows[key] = mockWatchTimeFlow{
+		minutesWatched: arg.MinutesWatched,
+		numPoints:      arg.NumPointsToCredit,
+	}
+	return uuid.MustParse("0dc95aba-6f8f-4e13-9081-ba1b2ced8f39"), nil
+}
@@ -0,0 +1,38 @@
+package watchtime
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	RecordWatchTimeInterval(ctx context.Context, arg queries.RecordWatchTimeIntervalParams) (sql.Result, error)
+	GetWatchTimeInterval(ctx context.Context, arg queries.GetWatchTimeIntervalParams) (queries.GetWatchTimeIntervalRow, error)
+	FinishWatchTimeInterval(ctx context.Context, arg queries.FinishWatchTimeIntervalParams) error
+	LockWatchTimeDailyTotal(ctx context.Context, twitchUserID string) (int32, error)
+	IncrementWatchTimeDailyTotal(ctx context.Context, arg queries.IncrementWatchTimeDailyTotalParams) error
+	RecordWatchTimeInflow(ctx context.Context, arg queries.RecordWatchTimeInflowParams) (uuid.UUID, error)
+}
+
+// RunInTxFunc calls f with a Queries value that's bound to a single database
+// transaction, committing the transaction only if f succeeds
+type RunInTxFunc func(ctx context.Context, f func(q Queries) error) error
+
+// NewTxRunner returns a RunInTxFunc that runs each transaction against the given
+// database
+func NewTxRunner(db *sql.DB) RunInTxFunc {
+	return func(ctx context.Context, f func(q Queries) error) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := f(queries.New(tx)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+}
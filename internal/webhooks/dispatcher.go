@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/notifications"
+	"github.com/google/uuid"
+)
+
+const (
+	// deliveryTimeout is how long we'll wait for a webhook to respond to a delivery
+	deliveryTimeout = 10 * time.Second
+	// maxDeliveriesPerPass limits the number of deliveries we'll attempt in a single
+	// pass, so that a large backlog is worked through gradually
+	maxDeliveriesPerPass = 20
+	// deliveryLease is how long a delivery is hidden from other servers once we've
+	// claimed it, so that no two servers will attempt the same delivery concurrently.
+	// Deliveries are attempted one at a time, so the lease must outlast a pass in which
+	// every delivery we claimed times out, plus some slack to record the results.
+	deliveryLease = maxDeliveriesPerPass*deliveryTimeout + time.Minute
+	// maxDeliveryAttempts is the number of times we'll attempt a delivery before giving
+	// up on it, at which point it must be redriven manually
+	maxDeliveryAttempts = 10
+	// initialRetryDelay is how long we'll wait before retrying a failed delivery for the
+	// first time; the delay doubles with each subsequent failure, up to maxRetryDelay
+	initialRetryDelay = 30 * time.Second
+	maxRetryDelay     = 6 * time.Hour
+)
+
+// DeliverWebhooks runs until the given context is canceled, checking for pending
+// webhook deliveries once every interval. Each delivery is POSTed to its webhook's URL,
+// signed with that webhook's secret; any delivery that fails is retried with
+// exponential backoff until it succeeds or we give up on it.
+func (s *Server) DeliverWebhooks(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			numDelivered, numFailed, err := s.deliverPendingWebhooks(ctx)
+			if err != nil {
+				fmt.Printf("Failed to deliver webhooks: %v\n", err)
+			} else if numDelivered > 0 || numFailed > 0 {
+				fmt.Printf("Delivered %d webhook event(s); %d delivery attempt(s) failed.\n", numDelivered, numFailed)
+			}
+		}
+	}
+}
+
+func (s *Server) deliverPendingWebhooks(ctx context.Context) (int, int, error) {
+	rows, err := s.q.ClaimWebhookDeliveries(ctx, queries.ClaimWebhookDeliveriesParams{
+		LeaseSeconds: int32(deliveryLease.Seconds()),
+		NumRecords:   maxDeliveriesPerPass,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	numDelivered := 0
+	numFailed := 0
+	for _, row := range rows {
+		body, err := buildDeliveryBody(row.Payload)
+		if err == nil {
+			err = s.deliver(ctx, row.ID, row.Url, row.Secret, body)
+		}
+		if err == nil {
+			if err := s.q.RecordWebhookDeliverySuccess(ctx, row.ID); err != nil {
+				return numDelivered, numFailed, fmt.Errorf("failed to record delivery %s: %w", row.ID, err)
+			}
+			numDelivered++
+			continue
+		}
+
+		// The delivery failed: schedule a retry, unless we've run out of attempts (or
+		// the payload itself is unusable, in which case no retry could succeed)
+		numAttempts := int(row.NumAttempts) + 1
+		giveUp := body == nil || numAttempts >= maxDeliveryAttempts
+		if err := s.q.RecordWebhookDeliveryFailure(ctx, queries.RecordWebhookDeliveryFailureParams{
+			LastError:         sql.NullString{Valid: true, String: err.Error()},
+			RetryDelaySeconds: int32(retryDelay(numAttempts).Seconds()),
+			GiveUp:            giveUp,
+			DeliveryID:        row.ID,
+		}); err != nil {
+			return numDelivered, numFailed, fmt.Errorf("failed to record failure of delivery %s: %w", row.ID, err)
+		}
+		if giveUp {
+			fmt.Printf("Giving up on webhook delivery %s after %d attempt(s): %v\n", row.ID, numAttempts, err)
+		}
+		numFailed++
+	}
+	return numDelivered, numFailed, nil
+}
+
+// buildDeliveryBody converts the payload recorded in the outbox, which has the same
+// format as a ledger_flow_change notification, to the JSON-serialized Activity that's
+// sent to the webhook
+func buildDeliveryBody(payload json.RawMessage) ([]byte, error) {
+	var notification notifications.FlowChangeNotification
+	if err := json.Unmarshal(payload, &notification); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	return json.Marshal(ledger.Activity{
+		TwitchUserId: notification.TwitchUserId,
+		Transaction:  notification.Transaction(),
+	})
+}
+
+// deliver POSTs the given body to a webhook, returning an error unless the webhook
+// responds with a 2xx status
+func (s *Server) deliver(ctx context.Context, deliveryId uuid.UUID, url string, secret string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set(ledger.WebhookDeliveryHeader, deliveryId.String())
+	req.Header.Set(ledger.WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(ledger.WebhookSignatureHeader, ledger.SignWebhook(secret, now, body))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("got response %d from %s", res.StatusCode, url)
+	}
+	return nil
+}
+
+// retryDelay returns how long we should wait before retrying a delivery that has
+// failed the given number of times
+func retryDelay(numAttempts int) time.Duration {
+	delay := initialRetryDelay
+	for i := 1; i < numAttempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,6 @@
+// Package webhooks implements delivery of ledger events to other services via signed
+// HTTP requests, along with the admin-only API routes used to manage webhooks: whenever
+// a transaction is created or updated, the database records a delivery in an outbox
+// table for each webhook registered for that transaction's type, and we work through
+// that outbox in the background, retrying failed deliveries with exponential backoff
+package webhooks
@@ -0,0 +1,33 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// flowTypeForeignKeyConstraint is the name reported by the database when a webhook is
+// registered for a flow type that doesn't exist
+const flowTypeForeignKeyConstraint = "webhook_flow_type_fkey"
+
+type GenerateSecretFunc func() (string, error)
+
+func generateSecret() (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secretBytes), nil
+}
+
+// isUnknownFlowTypeError returns true if the given error was raised by the database in
+// response to an attempt to register a webhook for a nonexistent flow type
+func isUnknownFlowTypeError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Name() == "foreign_key_violation" && pqErr.Constraint == flowTypeForeignKeyConstraint
+	}
+	return false
+}
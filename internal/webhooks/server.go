@@ -0,0 +1,229 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/golden-vcr/ledger/internal/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxFailedDeliveries is the maximum number of failed deliveries that will be listed
+// in response to a single request
+const maxFailedDeliveries = 100
+
+type Server struct {
+	q              Queries
+	generateSecret GenerateSecretFunc
+	client         *http.Client
+}
+
+func NewServer(q Queries) *Server {
+	return &Server{
+		q:              q,
+		generateSecret: generateSecret,
+		client:         &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
+	r.Path("/admin/webhooks").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostWebhook),
+		),
+	)
+	r.Path("/admin/webhooks").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleGetWebhooks),
+		),
+	)
+	r.Path("/admin/webhooks/deliveries/failed").Methods("GET").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleGetFailedDeliveries),
+		),
+	)
+	r.Path("/admin/webhooks/deliveries/{id}/redrive").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostRedrive),
+		),
+	)
+	r.Path("/admin/webhooks/{id}").Methods("DELETE").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handleDeleteWebhook),
+		),
+	)
+}
+
+func (s *Server) handlePostWebhook(res http.ResponseWriter, req *http.Request) {
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "content-type not supported")
+		return
+	}
+
+	// Parse the payload from the request body
+	var payload ledger.Webhook
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: %v", err))
+		return
+	}
+	if !isValidWebhookUrl(payload.Url) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'url' must be set to an absolute http or https URL")
+		return
+	}
+	if payload.FlowType == "" {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid request payload: 'flowType' must be set")
+		return
+	}
+
+	// Generate a secret with which deliveries to this webhook will be signed: this is
+	// the only time the caller will see it
+	secret, err := s.generateSecret()
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Register the webhook: the database will refuse if the flow type doesn't exist
+	row, err := s.q.RegisterWebhook(req.Context(), queries.RegisterWebhookParams{
+		Url:      payload.Url,
+		FlowType: string(payload.FlowType),
+		Secret:   secret,
+	})
+	if isUnknownFlowTypeError(err) {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, fmt.Sprintf("invalid request payload: flow type '%s' does not exist", payload.FlowType))
+		return
+	}
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+
+	// Return a JSON-serialized Webhook struct to the user, including the secret
+	result := &ledger.Webhook{
+		Id:        row.ID,
+		Url:       payload.Url,
+		FlowType:  payload.FlowType,
+		Secret:    secret,
+		CreatedAt: row.CreatedAt,
+	}
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handleGetWebhooks(res http.ResponseWriter, req *http.Request) {
+	rows, err := s.q.ListWebhooks(req.Context())
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	items := make([]ledger.Webhook, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, ledger.Webhook{
+			Id:        row.ID,
+			Url:       row.Url,
+			FlowType:  ledger.TransactionType(row.FlowType),
+			CreatedAt: row.CreatedAt,
+		})
+	}
+	if err := json.NewEncoder(res).Encode(ledger.WebhookList{Items: items}); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handleDeleteWebhook(res http.ResponseWriter, req *http.Request) {
+	// Parse the ID of the webhook from the URL
+	webhookId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid webhook ID")
+		return
+	}
+
+	// Delete the webhook, along with any deliveries that are still outstanding
+	result, err := s.q.DeleteWebhook(req.Context(), webhookId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	if numRows != 1 {
+		util.Error(res, ledger.ErrorCodeWebhookNotFound, "no such webhook")
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetFailedDeliveries(res http.ResponseWriter, req *http.Request) {
+	rows, err := s.q.ListFailedWebhookDeliveries(req.Context(), maxFailedDeliveries)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	items := make([]ledger.WebhookDelivery, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, ledger.WebhookDelivery{
+			Id:          row.ID,
+			WebhookId:   row.WebhookID,
+			Url:         row.Url,
+			FlowType:    ledger.TransactionType(row.FlowType),
+			FlowId:      row.FlowID,
+			CreatedAt:   row.CreatedAt,
+			NumAttempts: int(row.NumAttempts),
+			LastError:   row.LastError.String,
+			FailedAt:    row.FailedAt.Time,
+		})
+	}
+	if err := json.NewEncoder(res).Encode(ledger.WebhookDeliveryList{Items: items}); err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+	}
+}
+
+func (s *Server) handlePostRedrive(res http.ResponseWriter, req *http.Request) {
+	// Parse the ID of the delivery from the URL
+	deliveryId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInvalidRequest, "invalid delivery ID")
+		return
+	}
+
+	// Queue the delivery to be attempted again as soon as possible, with a full
+	// complement of retries: only a delivery that we've given up on can be redriven
+	result, err := s.q.RedriveWebhookDelivery(req.Context(), deliveryId)
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		util.Error(res, ledger.ErrorCodeInternal, err.Error())
+		return
+	}
+	if numRows != 1 {
+		util.Error(res, ledger.ErrorCodeWebhookDeliveryNotFound, "no such failed delivery")
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// isValidWebhookUrl returns true if the given string is an absolute URL to which we can
+// deliver events
+func isValidWebhookUrl(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
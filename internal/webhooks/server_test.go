@@ -0,0 +1,502 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostWebhook(t *testing.T) {
+	tests := []struct {
+		name         string
+		requestBody  string
+		wantStatus   int
+		wantBody     string
+		wantWebhooks []mockWebhook
+	}{
+		{
+			"webhook is registered, and its secret is returned",
+			`{"url":"https://alerts.example.com/ledger","flowType":"alert-redemption"}`,
+			http.StatusOK,
+			`{"id":"9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04","url":"https://alerts.example.com/ledger","flowType":"alert-redemption","secret":"mock-secret","createdAt":"1997-09-01T12:00:00Z"}`,
+			[]mockWebhook{
+				{
+					id:       uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+					url:      "https://alerts.example.com/ledger",
+					flowType: "alert-redemption",
+					secret:   "mock-secret",
+				},
+			},
+		},
+		{
+			"url must be an absolute http(s) URL",
+			`{"url":"/ledger","flowType":"alert-redemption"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'url' must be set to an absolute http or https URL"}`,
+			nil,
+		},
+		{
+			"flow type is required",
+			`{"url":"https://alerts.example.com/ledger"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: 'flowType' must be set"}`,
+			nil,
+		},
+		{
+			"flow type must exist",
+			`{"url":"https://alerts.example.com/ledger","flowType":"bad-type"}`,
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid request payload: flow type 'bad-type' does not exist"}`,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{}
+			s := &Server{
+				q:              q,
+				generateSecret: func() (string, error) { return "mock-secret", nil },
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/webhooks", strings.NewReader(tt.requestBody))
+			res := httptest.NewRecorder()
+			s.handlePostWebhook(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantWebhooks, q.webhooks)
+		})
+	}
+}
+
+func Test_Server_handleGetWebhooks(t *testing.T) {
+	q := &mockQueries{
+		webhooks: []mockWebhook{
+			{
+				id:       uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+				url:      "https://alerts.example.com/ledger",
+				flowType: "alert-redemption",
+				secret:   "mock-secret",
+			},
+		},
+	}
+	s := &Server{q: q}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/webhooks", nil)
+	res := httptest.NewRecorder()
+	s.handleGetWebhooks(res, req)
+
+	// Secrets should never be listed
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	body := strings.TrimSuffix(string(b), "\n")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"items":[{"id":"9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04","url":"https://alerts.example.com/ledger","flowType":"alert-redemption","createdAt":"1997-09-01T12:00:00Z"}]}`, body)
+}
+
+func Test_Server_handleDeleteWebhook(t *testing.T) {
+	tests := []struct {
+		name       string
+		webhookId  string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"webhook is deleted",
+			"9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04",
+			http.StatusNoContent,
+			"",
+		},
+		{
+			"nonexistent webhook is 404",
+			"e9a4e0a2-3b7f-4d0e-8f0c-0c1b7b1e2f3a",
+			http.StatusNotFound,
+			`{"title":"Not Found","status":404,"code":"webhook_not_found","detail":"no such webhook"}`,
+		},
+		{
+			"invalid ID is error",
+			"foo",
+			http.StatusBadRequest,
+			`{"title":"Bad Request","status":400,"code":"invalid_request","detail":"invalid webhook ID"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{
+				webhooks: []mockWebhook{
+					{id: uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04")},
+				},
+			}
+			s := &Server{q: q}
+
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/webhooks/%s", tt.webhookId), nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.webhookId})
+			res := httptest.NewRecorder()
+			s.handleDeleteWebhook(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+		})
+	}
+}
+
+func Test_Server_handleGetFailedDeliveries(t *testing.T) {
+	q := &mockQueries{
+		webhooks: []mockWebhook{
+			{
+				id:       uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+				url:      "https://alerts.example.com/ledger",
+				flowType: "alert-redemption",
+			},
+		},
+		deliveries: []*mockDelivery{
+			{
+				id:          uuid.MustParse("3d0f6a0e-2b8d-4d8c-9d55-2b6d3b6c1f01"),
+				webhookId:   uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+				numAttempts: 10,
+				lastError:   "got response 500 from https://alerts.example.com/ledger",
+				failed:      true,
+			},
+			{
+				id:        uuid.MustParse("b7f9e3c1-0a4e-4f0a-8d1c-6f2e8a9b7c02"),
+				webhookId: uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+			},
+		},
+	}
+	s := &Server{q: q}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/webhooks/deliveries/failed", nil)
+	res := httptest.NewRecorder()
+	s.handleGetFailedDeliveries(res, req)
+
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	body := strings.TrimSuffix(string(b), "\n")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"items":[{"id":"3d0f6a0e-2b8d-4d8c-9d55-2b6d3b6c1f01","webhookId":"9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04","url":"https://alerts.example.com/ledger","flowType":"alert-redemption","flowId":"00000000-0000-0000-0000-000000000000","createdAt":"1997-09-01T12:00:00Z","numAttempts":10,"lastError":"got response 500 from https://alerts.example.com/ledger","failedAt":"1997-09-01T13:00:00Z"}]}`, body)
+}
+
+func Test_Server_handlePostRedrive(t *testing.T) {
+	tests := []struct {
+		name            string
+		deliveryId      string
+		wantStatus      int
+		wantBody        string
+		wantNumFailures int
+	}{
+		{
+			"failed delivery is redriven",
+			"3d0f6a0e-2b8d-4d8c-9d55-2b6d3b6c1f01",
+			http.StatusNoContent,
+			"",
+			0,
+		},
+		{
+			"delivery that has not failed can not be redriven",
+			"b7f9e3c1-0a4e-4f0a-8d1c-6f2e8a9b7c02",
+			http.StatusNotFound,
+			`{"title":"Not Found","status":404,"code":"webhook_delivery_not_found","detail":"no such failed delivery"}`,
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{
+				deliveries: []*mockDelivery{
+					{
+						id:          uuid.MustParse("3d0f6a0e-2b8d-4d8c-9d55-2b6d3b6c1f01"),
+						numAttempts: 10,
+						failed:      true,
+					},
+					{
+						id: uuid.MustParse("b7f9e3c1-0a4e-4f0a-8d1c-6f2e8a9b7c02"),
+					},
+				},
+			}
+			s := &Server{q: q}
+
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/webhooks/deliveries/%s/redrive", tt.deliveryId), nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.deliveryId})
+			res := httptest.NewRecorder()
+			s.handlePostRedrive(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+
+			numFailures := 0
+			for _, delivery := range q.deliveries {
+				if delivery.failed {
+					numFailures++
+				}
+			}
+			assert.Equal(t, tt.wantNumFailures, numFailures)
+		})
+	}
+}
+
+func Test_Server_deliverPendingWebhooks(t *testing.T) {
+	// Run a webhook that accepts the first request and fails the second
+	var received []*http.Request
+	var receivedBodies []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(req.Body)
+		received = append(received, req)
+		receivedBodies = append(receivedBodies, string(body))
+		if len(received) > 1 {
+			res.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	payload := []byte(`{"twitch_user_id":"1001","id":"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6","type":"manual-credit","metadata":{"note":"foo"},"delta_points":150,"created_at":"1997-09-01T12:00:00Z","finalized_at":"1997-09-01T12:00:00Z","accepted":true,"description_template":"","change_seq":42}`)
+	q := &mockQueries{
+		webhooks: []mockWebhook{
+			{
+				id:     uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+				url:    srv.URL,
+				secret: "mock-secret",
+			},
+		},
+		deliveries: []*mockDelivery{
+			{
+				id:        uuid.MustParse("3d0f6a0e-2b8d-4d8c-9d55-2b6d3b6c1f01"),
+				webhookId: uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+				payload:   payload,
+			},
+			{
+				id:          uuid.MustParse("b7f9e3c1-0a4e-4f0a-8d1c-6f2e8a9b7c02"),
+				webhookId:   uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04"),
+				payload:     payload,
+				numAttempts: 2,
+			},
+		},
+	}
+	s := &Server{q: q, client: srv.Client()}
+
+	numDelivered, numFailed, err := s.deliverPendingWebhooks(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numDelivered)
+	assert.Equal(t, 1, numFailed)
+
+	// Each request should carry the transaction, signed with the webhook's secret
+	assert.Len(t, received, 2)
+	wantBody := `{"twitchUserId":"1001","id":"ffc921c7-24da-4f1d-9d0d-0d7c17d0a8b6","timestamp":"1997-09-01T12:00:00Z","type":"manual-credit","state":"accepted","deltaPoints":150,"description":"Manual credit: foo"}`
+	assert.Equal(t, wantBody, receivedBodies[0])
+	assert.Equal(t, "3d0f6a0e-2b8d-4d8c-9d55-2b6d3b6c1f01", received[0].Header.Get(ledger.WebhookDeliveryHeader))
+	assert.NoError(t, ledger.VerifyWebhook(
+		"mock-secret",
+		received[0].Header.Get(ledger.WebhookTimestampHeader),
+		received[0].Header.Get(ledger.WebhookSignatureHeader),
+		[]byte(receivedBodies[0]),
+		time.Minute,
+	))
+
+	// The first delivery should be recorded as a success; the second should be
+	// scheduled for a retry after an exponentially-increasing delay
+	assert.True(t, q.deliveries[0].delivered)
+	assert.Equal(t, 1, q.deliveries[0].numAttempts)
+	assert.False(t, q.deliveries[1].delivered)
+	assert.False(t, q.deliveries[1].failed)
+	assert.Equal(t, 3, q.deliveries[1].numAttempts)
+	assert.Equal(t, 2*time.Minute, q.deliveries[1].retryDelay)
+	assert.Equal(t, "got response 500 from "+srv.URL, q.deliveries[1].lastError)
+}
+
+func Test_deliveryLease(t *testing.T) {
+	// A delivery claimed at the start of a pass must not become visible to other
+	// servers before we've finished attempting the last delivery in that pass
+	assert.Greater(t, deliveryLease, maxDeliveriesPerPass*deliveryTimeout)
+}
+
+func Test_retryDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Second, retryDelay(1))
+	assert.Equal(t, time.Minute, retryDelay(2))
+	assert.Equal(t, 2*time.Minute, retryDelay(3))
+	assert.Equal(t, 6*time.Hour, retryDelay(maxDeliveryAttempts*2))
+}
+
+var mockCreatedAt = time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)
+
+type mockQueries struct {
+	webhooks   []mockWebhook
+	deliveries []*mockDelivery
+}
+
+type mockWebhook struct {
+	id       uuid.UUID
+	url      string
+	flowType string
+	secret   string
+}
+
+type mockDelivery struct {
+	id          uuid.UUID
+	webhookId   uuid.UUID
+	payload     []byte
+	numAttempts int
+	lastError   string
+	retryDelay  time.Duration
+	delivered   bool
+	failed      bool
+}
+
+func (m *mockQueries) getWebhook(id uuid.UUID) mockWebhook {
+	for _, webhook := range m.webhooks {
+		if webhook.id == id {
+			return webhook
+		}
+	}
+	return mockWebhook{}
+}
+
+func (m *mockQueries) getDelivery(id uuid.UUID) *mockDelivery {
+	for _, delivery := range m.deliveries {
+		if delivery.id == id {
+			return delivery
+		}
+	}
+	return nil
+}
+
+func (m *mockQueries) RegisterWebhook(ctx context.Context, arg queries.RegisterWebhookParams) (queries.RegisterWebhookRow, error) {
+	if arg.FlowType == "bad-type" {
+		return queries.RegisterWebhookRow{}, &pq.Error{
+			Code:       "23503",
+			Constraint: flowTypeForeignKeyConstraint,
+		}
+	}
+	id := uuid.MustParse("9c8f0fb0-6d8b-4b8d-a9a5-d1e66f3f6b04")
+	m.webhooks = append(m.webhooks, mockWebhook{
+		id:       id,
+		url:      arg.Url,
+		flowType: arg.FlowType,
+		secret:   arg.Secret,
+	})
+	return queries.RegisterWebhookRow{ID: id, CreatedAt: mockCreatedAt}, nil
+}
+
+func (m *mockQueries) ListWebhooks(ctx context.Context) ([]queries.ListWebhooksRow, error) {
+	rows := make([]queries.ListWebhooksRow, 0, len(m.webhooks))
+	for _, webhook := range m.webhooks {
+		rows = append(rows, queries.ListWebhooksRow{
+			ID:        webhook.id,
+			Url:       webhook.url,
+			FlowType:  webhook.flowType,
+			CreatedAt: mockCreatedAt,
+		})
+	}
+	return rows, nil
+}
+
+func (m *mockQueries) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (sql.Result, error) {
+	for i, webhook := range m.webhooks {
+		if webhook.id == webhookID {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			return &mockSqlResult{1}, nil
+		}
+	}
+	return &mockSqlResult{0}, nil
+}
+
+func (m *mockQueries) ListFailedWebhookDeliveries(ctx context.Context, numRecords int32) ([]queries.ListFailedWebhookDeliveriesRow, error) {
+	rows := make([]queries.ListFailedWebhookDeliveriesRow, 0)
+	for _, delivery := range m.deliveries {
+		if !delivery.failed {
+			continue
+		}
+		webhook := m.getWebhook(delivery.webhookId)
+		rows = append(rows, queries.ListFailedWebhookDeliveriesRow{
+			ID:          delivery.id,
+			WebhookID:   webhook.id,
+			Url:         webhook.url,
+			FlowType:    webhook.flowType,
+			CreatedAt:   mockCreatedAt,
+			NumAttempts: int32(delivery.numAttempts),
+			LastError:   sql.NullString{Valid: delivery.lastError != "", String: delivery.lastError},
+			FailedAt:    sql.NullTime{Valid: true, Time: mockCreatedAt.Add(time.Hour)},
+		})
+	}
+	return rows, nil
+}
+
+func (m *mockQueries) RedriveWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (sql.Result, error) {
+	delivery := m.getDelivery(deliveryID)
+	if delivery == nil || !delivery.failed {
+		return &mockSqlResult{0}, nil
+	}
+	delivery.failed = false
+	delivery.numAttempts = 0
+	return &mockSqlResult{1}, nil
+}
+
+func (m *mockQueries) ClaimWebhookDeliveries(ctx context.Context, arg queries.ClaimWebhookDeliveriesParams) ([]queries.ClaimWebhookDeliveriesRow, error) {
+	rows := make([]queries.ClaimWebhookDeliveriesRow, 0)
+	for _, delivery := range m.deliveries {
+		if delivery.delivered || delivery.failed || len(rows) >= int(arg.NumRecords) {
+			continue
+		}
+		webhook := m.getWebhook(delivery.webhookId)
+		rows = append(rows, queries.ClaimWebhookDeliveriesRow{
+			ID:          delivery.id,
+			Payload:     delivery.payload,
+			NumAttempts: int32(delivery.numAttempts),
+			Url:         webhook.url,
+			Secret:      webhook.secret,
+		})
+	}
+	return rows, nil
+}
+
+func (m *mockQueries) RecordWebhookDeliverySuccess(ctx context.Context, deliveryID uuid.UUID) error {
+	delivery := m.getDelivery(deliveryID)
+	delivery.numAttempts++
+	delivery.delivered = true
+	return nil
+}
+
+func (m *mockQueries) RecordWebhookDeliveryFailure(ctx context.Context, arg queries.RecordWebhookDeliveryFailureParams) error {
+	delivery := m.getDelivery(arg.DeliveryID)
+	delivery.numAttempts++
+	delivery.lastError = arg.LastError.String
+	delivery.retryDelay = time.Duration(arg.RetryDelaySeconds) * time.Second
+	delivery.failed = arg.GiveUp
+	return nil
+}
+
+type mockSqlResult struct {
+	numRows int64
+}
+
+func (m *mockSqlResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("not mocked")
+}
+
+func (m *mockSqlResult) RowsAffected() (int64, error) {
+	return m.numRows, nil
+}
@@ -0,0 +1,20 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/golden-vcr/ledger/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	RegisterWebhook(ctx context.Context, arg queries.RegisterWebhookParams) (queries.RegisterWebhookRow, error)
+	ListWebhooks(ctx context.Context) ([]queries.ListWebhooksRow, error)
+	DeleteWebhook(ctx context.Context, webhookID uuid.UUID) (sql.Result, error)
+	ListFailedWebhookDeliveries(ctx context.Context, numRecords int32) ([]queries.ListFailedWebhookDeliveriesRow, error)
+	RedriveWebhookDelivery(ctx context.Context, deliveryID uuid.UUID) (sql.Result, error)
+	ClaimWebhookDeliveries(ctx context.Context, arg queries.ClaimWebhookDeliveriesParams) ([]queries.ClaimWebhookDeliveriesRow, error)
+	RecordWebhookDeliverySuccess(ctx context.Context, deliveryID uuid.UUID) error
+	RecordWebhookDeliveryFailure(ctx context.Context, arg queries.RecordWebhookDeliveryFailureParams) error
+}
@@ -0,0 +1,71 @@
+package ledgermock
+
+import (
+	"fmt"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+// AssertCredited asserts that the given user has been credited with exactly the given
+// number of points by an accepted inflow of the given type, e.g. that user 1001 was
+// credited 500 points for a cheer:
+//
+//	c.AssertCredited(t, "1001", ledger.TransactionTypeCheer, 500)
+func (c *Client) AssertCredited(t assert.TestingT, twitchUserId string, inflowType ledger.TransactionType, numPoints int, msgAndArgs ...interface{}) bool {
+	if c.hasAcceptedTransaction(twitchUserId, inflowType, numPoints) {
+		return true
+	}
+	return assert.Fail(t, fmt.Sprintf("User %s was not credited %d points for a transaction of type '%s'%s", twitchUserId, numPoints, inflowType, c.describeHistory(twitchUserId)), msgAndArgs...)
+}
+
+// AssertDebited asserts that the given user has been debited exactly the given number
+// of points by an accepted outflow of the given type
+func (c *Client) AssertDebited(t assert.TestingT, twitchUserId string, outflowType ledger.TransactionType, numPoints int, msgAndArgs ...interface{}) bool {
+	if c.hasAcceptedTransaction(twitchUserId, outflowType, -numPoints) {
+		return true
+	}
+	return assert.Fail(t, fmt.Sprintf("User %s was not debited %d points for a transaction of type '%s'%s", twitchUserId, numPoints, outflowType, c.describeHistory(twitchUserId)), msgAndArgs...)
+}
+
+// AssertBalance asserts that the given user's total and available balances are as
+// expected
+func (c *Client) AssertBalance(t assert.TestingT, twitchUserId string, totalPoints int, availablePoints int, msgAndArgs ...interface{}) bool {
+	want := ledger.Balance{
+		TotalPoints:     totalPoints,
+		AvailablePoints: availablePoints,
+	}
+	return assert.Equal(t, want, c.Balance(twitchUserId), msgAndArgs...)
+}
+
+// AssertNoTransactions asserts that no transactions have been recorded for the given
+// user, aside from any initial balance they were granted
+func (c *Client) AssertNoTransactions(t assert.TestingT, twitchUserId string, msgAndArgs ...interface{}) bool {
+	for _, item := range c.History(twitchUserId) {
+		if item.Type != ledger.TransactionTypeManualCredit {
+			return assert.Fail(t, fmt.Sprintf("Expected no transactions for user %s%s", twitchUserId, c.describeHistory(twitchUserId)), msgAndArgs...)
+		}
+	}
+	return true
+}
+
+func (c *Client) hasAcceptedTransaction(twitchUserId string, transactionType ledger.TransactionType, deltaPoints int) bool {
+	for _, item := range c.History(twitchUserId) {
+		if item.Type == transactionType && item.State == ledger.TransactionStateAccepted && item.DeltaPoints == deltaPoints {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) describeHistory(twitchUserId string) string {
+	history := c.History(twitchUserId)
+	if len(history) == 0 {
+		return "; user has no transactions"
+	}
+	s := "; user has transactions:"
+	for _, item := range history {
+		s += fmt.Sprintf("\n\t%s %s %+d: %s", item.Type, item.State, item.DeltaPoints, item.Description)
+	}
+	return s
+}
@@ -2,99 +2,679 @@ package ledgermock
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/ledger/internal/points"
+	"github.com/golden-vcr/ledger/internal/util"
 	"github.com/google/uuid"
 )
 
+// maxStoredMessageLen mirrors the length at which the server truncates messages that
+// are recorded with cheers and subscriptions
+const maxStoredMessageLen = 128
+
+// Client is an in-memory implementation of ledger.Client, suitable for use in tests of
+// services that request transactions from the ledger. It mirrors the semantics of the
+// ledger server: each access token identifies a user, inflows are credited to that
+// user immediately, and outflows remain pending (deducted from the user's available
+// balance but not their total balance) until they're accepted or rejected. Cheers,
+// subscriptions, raids, follows, hype trains, and watch time are credited in accordance
+// with the ledger's default points policy.
+//
+// Tests can inspect the resulting state of each user's account via Balance and History,
+// or make assertions about it via AssertCredited, AssertDebited, and AssertBalance.
 type Client struct {
-	statesByUserAccessToken map[string]*mockUserState
+	mu                         sync.Mutex
+	twitchUserIdsByAccessToken map[string]string
+	descriptionTemplatesByType map[ledger.TransactionType]string
+	flows                      []*mockFlow
+	subscribers                map[string][]chan ledger.Transaction
+	watchTimeIntervals         map[string]ledger.WatchTimeResult
+	watchTimeDailyTotals       map[string]int32
 }
 
-type mockUserState struct {
-	initialBalance int
-	debits         []*mockDebit
+// mockFlow is the in-memory equivalent of a ledger.flow record
+type mockFlow struct {
+	c              *Client
+	id             uuid.UUID
+	flowType       ledger.TransactionType
+	metadata       json.RawMessage
+	twitchUserId   string
+	deltaPoints    int
+	createdAt      time.Time
+	finalizedAt    sql.NullTime
+	accepted       bool
+	idempotencyKey string
 }
 
-type mockDebit struct {
-	id               uuid.UUID
-	numPointsToDebit int
-	isFinalized      bool
-	isAccepted       bool
+// mockWatchTimeMetadata is the metadata recorded with each watch-time flow
+type mockWatchTimeMetadata struct {
+	StreamId       string `json:"stream_id"`
+	IntervalId     string `json:"interval_id"`
+	MinutesWatched int    `json:"minutes_watched"`
+	PolicyVersion  string `json:"policy_version"`
 }
 
+// NewClient initializes an in-memory ledger with no users. The 'alert-redemption'
+// outflow type is registered by default, as it is on the real server.
 func NewClient() *Client {
 	return &Client{
-		statesByUserAccessToken: make(map[string]*mockUserState),
+		twitchUserIdsByAccessToken: make(map[string]string),
+		descriptionTemplatesByType: map[ledger.TransactionType]string{
+			ledger.TransactionTypeAlertRedemption: "Redeemed alert of type '{{.type}}'",
+		},
+		subscribers:          make(map[string][]chan ledger.Transaction),
+		watchTimeIntervals:   make(map[string]ledger.WatchTimeResult),
+		watchTimeDailyTotals: make(map[string]int32),
 	}
 }
 
+// Grant registers a user who may be identified by the given access token, crediting
+// them with an initial balance. The access token doubles as the user's Twitch user ID.
 func (c *Client) Grant(accessToken string, initialBalance int) *Client {
-	c.statesByUserAccessToken[accessToken] = &mockUserState{
-		initialBalance: initialBalance,
+	return c.GrantUser(accessToken, accessToken, initialBalance)
+}
+
+// GrantUser registers the given access token as identifying the user with the given
+// Twitch user ID, crediting them with an initial balance via a manual credit if
+// non-zero. GrantUser may be called several times for the same user in order to
+// register additional access tokens.
+func (c *Client) GrantUser(accessToken string, twitchUserId string, initialBalance int) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.twitchUserIdsByAccessToken[accessToken] = twitchUserId
+	if initialBalance != 0 {
+		c.recordFlow(twitchUserId, ledger.TransactionTypeManualCredit, mustMarshalMetadata(map[string]interface{}{
+			"note": "Initial balance",
+		}), initialBalance, true, "")
 	}
 	return c
 }
 
-func (c *Client) RequestCreditFromCheer(ctx context.Context, accessToken string, numPointsToCredit int, message string) (uuid.UUID, error) {
-	return uuid.UUID{}, fmt.Errorf("not mocked")
+// RegisterOutflowType allows outflows of the given type to be requested via
+// RequestOutflow, with history descriptions rendered from the given template (or
+// generated from the transaction type if empty)
+func (c *Client) RegisterOutflowType(outflowType ledger.TransactionType, descriptionTemplate string) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.descriptionTemplatesByType[outflowType] = descriptionTemplate
+	return c
+}
+
+// AddPendingInflow records an inflow that credits the given user with the given number
+// of points, leaving it pending: the points count toward the user's total balance but
+// can't be spent until the returned transaction is accepted
+func (c *Client) AddPendingInflow(twitchUserId string, inflowType ledger.TransactionType, metadata json.RawMessage, numPointsToCredit int) ledger.TransactionContext {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.recordFlow(twitchUserId, inflowType, metadata, numPointsToCredit, false, "")
+}
+
+// ExpirePendingOutflows rejects all pending outflows, as the server does when they're
+// not finalized before their expiration time
+func (c *Client) ExpirePendingOutflows() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, flow := range c.flows {
+		if flow.deltaPoints < 0 && !flow.finalizedAt.Valid {
+			var metadata map[string]interface{}
+			if err := json.Unmarshal(flow.metadata, &metadata); err != nil || metadata == nil {
+				metadata = make(map[string]interface{})
+			}
+			metadata["rejection_reason"] = util.RejectionReasonExpired
+			if metadataBytes, err := json.Marshal(metadata); err == nil {
+				flow.metadata = metadataBytes
+			}
+			flow.finalizedAt = sql.NullTime{Valid: true, Time: time.Now()}
+			flow.accepted = false
+			c.notify(flow)
+		}
+	}
+}
+
+func (c *Client) RequestCreditFromCheer(ctx context.Context, accessToken string, eventId string, numBits int, message string) (uuid.UUID, error) {
+	if numBits <= 0 {
+		return uuid.UUID{}, fmt.Errorf("%w: 'numBits' must be set to a positive integer", ledger.ErrInvalidRequest)
+	}
+	numPointsToCredit, err := points.DefaultPolicy.CheerCredit(numBits)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+	}
+	return c.recordInflow(accessToken, eventId, ledger.TransactionTypeCheer, mustMarshalMetadata(map[string]interface{}{
+		"message":        truncateMessage(message),
+		"num_bits":       numBits,
+		"policy_version": points.DefaultPolicy.Version(),
+	}), int(numPointsToCredit))
+}
+
+func (c *Client) RequestCreditFromSubscription(ctx context.Context, accessToken string, eventId string, tier ledger.SubscriptionTier, isInitial bool, isGift bool, message string) (uuid.UUID, error) {
+	multiplier, ok := points.DefaultPolicy.TierMultiplier(tier)
+	if !ok {
+		return uuid.UUID{}, fmt.Errorf("%w: 'tier' must be one of '1000', '2000', or '3000'", ledger.ErrInvalidRequest)
+	}
+	numPointsToCredit, err := points.DefaultPolicy.SubscriptionCredit(multiplier, isInitial, isGift)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+	}
+	return c.recordInflow(accessToken, eventId, ledger.TransactionTypeSubscription, mustMarshalMetadata(map[string]interface{}{
+		"message":           truncateMessage(message),
+		"is_initial":        isInitial,
+		"is_gift":           isGift,
+		"tier":              tier,
+		"credit_multiplier": multiplier,
+		"policy_version":    points.DefaultPolicy.Version(),
+	}), int(numPointsToCredit))
+}
+
+func (c *Client) RequestCreditFromGiftSub(ctx context.Context, accessToken string, eventId string, tier ledger.SubscriptionTier, numSubscriptions int) (uuid.UUID, error) {
+	multiplier, ok := points.DefaultPolicy.TierMultiplier(tier)
+	if !ok {
+		return uuid.UUID{}, fmt.Errorf("%w: 'tier' must be one of '1000', '2000', or '3000'", ledger.ErrInvalidRequest)
+	}
+	if numSubscriptions <= 0 {
+		return uuid.UUID{}, fmt.Errorf("%w: 'numSubscriptions' must be set to a positive integer", ledger.ErrInvalidRequest)
+	}
+	numPointsToCredit, err := points.DefaultPolicy.GiftSubCredit(multiplier, numSubscriptions)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+	}
+	return c.recordInflow(accessToken, eventId, ledger.TransactionTypeGiftSub, mustMarshalMetadata(map[string]interface{}{
+		"num_subscriptions": numSubscriptions,
+		"tier":              tier,
+		"credit_multiplier": multiplier,
+		"policy_version":    points.DefaultPolicy.Version(),
+	}), int(numPointsToCredit))
+}
+
+func (c *Client) RequestCreditFromRaid(ctx context.Context, accessToken string, eventId string, numViewers int) (uuid.UUID, error) {
+	if numViewers <= 0 {
+		return uuid.UUID{}, fmt.Errorf("%w: 'numViewers' must be set to a positive integer", ledger.ErrInvalidRequest)
+	}
+	numPointsToCredit, err := points.DefaultPolicy.RaidCredit(numViewers)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+	}
+	return c.recordInflow(accessToken, eventId, ledger.TransactionTypeRaid, mustMarshalMetadata(map[string]interface{}{
+		"num_viewers":    numViewers,
+		"policy_version": points.DefaultPolicy.Version(),
+	}), int(numPointsToCredit))
+}
+
+func (c *Client) RequestCreditFromFollow(ctx context.Context, accessToken string, eventId string) (uuid.UUID, error) {
+	numPointsToCredit, err := points.DefaultPolicy.FollowCredit()
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+	}
+	return c.recordInflow(accessToken, eventId, ledger.TransactionTypeFollow, mustMarshalMetadata(map[string]interface{}{
+		"policy_version": points.DefaultPolicy.Version(),
+	}), int(numPointsToCredit))
 }
 
-func (c *Client) RequestCreditFromSubscription(ctx context.Context, accessToken string, basePointsToCredit int, isInitial bool, isGift bool, message string, creditMultiplier float64) (uuid.UUID, error) {
-	return uuid.UUID{}, fmt.Errorf("not mocked")
+func (c *Client) RequestCreditFromHypeTrain(ctx context.Context, accessToken string, eventId string, level int) (uuid.UUID, error) {
+	if level <= 0 {
+		return uuid.UUID{}, fmt.Errorf("%w: 'level' must be set to a positive integer", ledger.ErrInvalidRequest)
+	}
+	numPointsToCredit, err := points.DefaultPolicy.HypeTrainCredit(level)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+	}
+	return c.recordInflow(accessToken, eventId, ledger.TransactionTypeHypeTrain, mustMarshalMetadata(map[string]interface{}{
+		"level":          level,
+		"policy_version": points.DefaultPolicy.Version(),
+	}), int(numPointsToCredit))
 }
 
-func (c *Client) RequestCreditFromGiftSub(ctx context.Context, accessToken string, basePointsToCredit int, numSubscriptions int, creditMultiplier float64) (uuid.UUID, error) {
-	return uuid.UUID{}, fmt.Errorf("not mocked")
+// RequestCreditFromWatchTime credits each viewer in the batch as the server does: each
+// viewer is credited via a separate inflow for each interval, which History combines
+// into a single entry per stream, no viewer is credited beyond the daily cap, and a
+// repeated interval is a replay of the original batch. Since the mock does not model internal JWTs, the access token is not checked.
+func (c *Client) RequestCreditFromWatchTime(ctx context.Context, accessToken string, streamId string, intervalId string, viewers []ledger.WatchTimeViewer) (ledger.WatchTimeResult, error) {
+	if streamId == "" || intervalId == "" {
+		return ledger.WatchTimeResult{}, fmt.Errorf("%w: 'streamId' and 'intervalId' are required", ledger.ErrInvalidRequest)
+	}
+	credits := make([]int32, len(viewers))
+	for i, viewer := range viewers {
+		if viewer.TwitchUserId == "" || viewer.MinutesWatched <= 0 {
+			return ledger.WatchTimeResult{}, fmt.Errorf("%w: each viewer must have a 'twitchUserId' and a positive 'minutesWatched'", ledger.ErrInvalidRequest)
+		}
+		numPoints, err := points.DefaultPolicy.WatchTimeCredit(viewer.MinutesWatched)
+		if err != nil {
+			return ledger.WatchTimeResult{}, fmt.Errorf("%w: %v", ledger.ErrInvalidRequest, err)
+		}
+		credits[i] = numPoints
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	intervalKey := streamId + "/" + intervalId
+	if result, ok := c.watchTimeIntervals[intervalKey]; ok {
+		return result, nil
+	}
+
+	var result ledger.WatchTimeResult
+	day := time.Now().UTC().Format(time.DateOnly)
+	for i, viewer := range viewers {
+		dailyTotalKey := viewer.TwitchUserId + "/" + day
+		numPointsToCredit := points.DefaultPolicy.CapWatchTimeCredit(credits[i], c.watchTimeDailyTotals[dailyTotalKey])
+		if numPointsToCredit <= 0 {
+			continue
+		}
+		c.watchTimeDailyTotals[dailyTotalKey] += numPointsToCredit
+		c.recordWatchTime(viewer.TwitchUserId, streamId, intervalId, viewer.MinutesWatched, int(numPointsToCredit))
+		result.NumViewersCredited++
+		result.NumPointsCredited += int(numPointsToCredit)
+	}
+	c.watchTimeIntervals[intervalKey] = result
+	return result, nil
 }
 
 func (c *Client) RequestAlertRedemption(ctx context.Context, accessToken string, numPointsToDebit int, alertType string, alertMetadata *json.RawMessage) (ledger.TransactionContext, error) {
-	balance, err := c.getAvailableBalance(accessToken)
+	metadata := make(map[string]interface{})
+	if alertMetadata != nil {
+		if err := json.Unmarshal(*alertMetadata, &metadata); err != nil {
+			return nil, fmt.Errorf("alert metadata must be a JSON object: %w", err)
+		}
+	}
+	metadata["type"] = alertType
+	metadataBytes, err := json.Marshal(metadata)
 	if err != nil {
 		return nil, err
 	}
-	if balance < numPointsToDebit {
+	return c.RequestOutflow(ctx, accessToken, ledger.TransactionTypeAlertRedemption, numPointsToDebit, metadataBytes)
+}
+
+func (c *Client) RequestOutflow(ctx context.Context, accessToken string, outflowType ledger.TransactionType, numPointsToDebit int, metadata json.RawMessage) (ledger.TransactionContext, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	twitchUserId, ok := c.twitchUserIdsByAccessToken[accessToken]
+	if !ok {
+		return nil, auth.ErrUnauthorized
+	}
+	if numPointsToDebit <= 0 {
+		return nil, fmt.Errorf("%w: numPointsToDebit must be positive", ledger.ErrInvalidRequest)
+	}
+	if _, ok := c.descriptionTemplatesByType[outflowType]; !ok {
+		return nil, fmt.Errorf("%w: unsupported transaction type", ledger.ErrInvalidRequest)
+	}
+	if metadata == nil {
+		metadata = json.RawMessage("{}")
+	}
+	if c.getBalance(twitchUserId).AvailablePoints < numPointsToDebit {
 		return nil, ledger.ErrNotEnoughPoints
 	}
-	debit := &mockDebit{
-		numPointsToDebit: numPointsToDebit,
+	return c.recordFlow(twitchUserId, outflowType, metadata, -numPointsToDebit, false, ""), nil
+}
+
+// Balance returns the current balance of the user with the given Twitch user ID
+func (c *Client) Balance(twitchUserId string) ledger.Balance {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.getBalance(twitchUserId)
+}
+
+// History returns all transactions recorded for the user with the given Twitch user
+// ID, most recent first, described exactly as they would be by GET /history
+func (c *Client) History(twitchUserId string) []ledger.Transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := make([]ledger.Transaction, 0)
+	for i := len(c.flows) - 1; i >= 0; i-- {
+		flow := c.flows[i]
+		if flow.twitchUserId != twitchUserId {
+			continue
+		}
+		entry := c.entry(flow)
+		if entry.id != flow.id {
+			continue
+		}
+		items = append(items, entry.transaction())
+	}
+	return items
+}
+
+func (c *Client) GetBalance(ctx context.Context, accessToken string) (ledger.Balance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	twitchUserId, ok := c.twitchUserIdsByAccessToken[accessToken]
+	if !ok {
+		return ledger.Balance{}, auth.ErrUnauthorized
 	}
-	c.statesByUserAccessToken[accessToken].debits = append(c.statesByUserAccessToken[accessToken].debits, debit)
-	return debit, nil
+	return c.getBalance(twitchUserId), nil
 }
 
-func (c *Client) getAvailableBalance(accessToken string) (int, error) {
-	state, ok := c.statesByUserAccessToken[accessToken]
+func (c *Client) GetHistory(ctx context.Context, accessToken string, options ledger.HistoryOptions) (ledger.TransactionHistory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	twitchUserId, ok := c.twitchUserIdsByAccessToken[accessToken]
 	if !ok {
-		return -1, auth.ErrUnauthorized
+		return ledger.TransactionHistory{}, auth.ErrUnauthorized
+	}
+	limit := 50
+	if options.Max > 0 {
+		limit = min(options.Max, 100)
 	}
-	balance := state.initialBalance
-	for _, debit := range state.debits {
-		if debit.isFinalized && !debit.isAccepted {
+
+	// Cursors issued by the mock are simply flow IDs: each page begins after the flow
+	// identified by the cursor
+	start := len(c.flows) - 1
+	if options.From != "" {
+		for start >= 0 && c.flows[start].id.String() != options.From {
+			start--
+		}
+		if start < 0 {
+			return ledger.TransactionHistory{}, fmt.Errorf("%w: unknown cursor", ledger.ErrInvalidRequest)
+		}
+		start--
+	}
+
+	history := ledger.TransactionHistory{
+		Items: make([]ledger.Transaction, 0),
+	}
+	for i := start; i >= 0; i-- {
+		flow := c.flows[i]
+		if flow.twitchUserId != twitchUserId {
 			continue
 		}
-		balance -= debit.numPointsToDebit
+		entry := c.entry(flow)
+		if entry.id != flow.id || !entry.matchesHistoryOptions(&options) {
+			continue
+		}
+		if len(history.Items) == limit {
+			history.NextCursor = history.Items[limit-1].Id.String()
+			break
+		}
+		history.Items = append(history.Items, entry.transaction())
 	}
-	return balance, nil
+	return history, nil
+}
+
+func (c *Client) IterateHistory(accessToken string, options ledger.HistoryOptions) *ledger.HistoryIterator {
+	return ledger.NewHistoryIterator(func(ctx context.Context, options ledger.HistoryOptions) (ledger.TransactionHistory, error) {
+		return c.GetHistory(ctx, accessToken, options)
+	}, options)
 }
 
-func (m *mockDebit) Accept(ctx context.Context) error {
-	if m.isFinalized {
-		return fmt.Errorf("already finalized")
+func (c *Client) SubscribeNotifications(ctx context.Context, accessToken string) (<-chan ledger.Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	twitchUserId, ok := c.twitchUserIdsByAccessToken[accessToken]
+	if !ok {
+		return nil, auth.ErrUnauthorized
+	}
+	ch := make(chan ledger.Transaction, 32)
+	c.subscribers[twitchUserId] = append(c.subscribers[twitchUserId], ch)
+
+	// Stop notifying the subscriber once their context is done
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		chs := c.subscribers[twitchUserId]
+		for i := range chs {
+			if chs[i] == ch {
+				c.subscribers[twitchUserId] = append(chs[:i], chs[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (c *Client) recordInflow(accessToken string, eventId string, inflowType ledger.TransactionType, metadata json.RawMessage, numPointsToCredit int) (uuid.UUID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	twitchUserId, ok := c.twitchUserIdsByAccessToken[accessToken]
+	if !ok {
+		return uuid.UUID{}, auth.ErrUnauthorized
+	}
+
+	// As on the server, a repeated event ID for the same inflow type is a replay of the
+	// original request, not a new inflow
+	if eventId != "" {
+		for _, flow := range c.flows {
+			if flow.flowType == inflowType && flow.idempotencyKey == eventId {
+				if flow.twitchUserId != twitchUserId {
+					return uuid.UUID{}, ledger.ErrIdempotencyConflict
+				}
+				return flow.id, nil
+			}
+		}
+	}
+
+	// Likewise, each user may only be credited for following once
+	if inflowType == ledger.TransactionTypeFollow {
+		for _, flow := range c.flows {
+			if flow.flowType == inflowType && flow.twitchUserId == twitchUserId {
+				return uuid.UUID{}, ledger.ErrFollowAlreadyCredited
+			}
+		}
+	}
+	return c.recordFlow(twitchUserId, inflowType, metadata, numPointsToCredit, true, eventId).id, nil
+}
+
+// recordFlow adds a new flow to the ledger, finalizing and accepting it immediately if
+// requested. The caller must hold c.mu.
+func (c *Client) recordFlow(twitchUserId string, flowType ledger.TransactionType, metadata json.RawMessage, deltaPoints int, accepted bool, idempotencyKey string) *mockFlow {
+	now := time.Now()
+	flow := &mockFlow{
+		c:              c,
+		id:             uuid.New(),
+		flowType:       flowType,
+		metadata:       metadata,
+		twitchUserId:   twitchUserId,
+		deltaPoints:    deltaPoints,
+		createdAt:      now,
+		accepted:       accepted,
+		idempotencyKey: idempotencyKey,
+	}
+	if accepted {
+		flow.finalizedAt = sql.NullTime{Valid: true, Time: now}
+	}
+	c.flows = append(c.flows, flow)
+	c.notify(flow)
+	return flow
+}
+
+// recordWatchTime records a new inflow crediting the user for the given interval of
+// watch time. The caller must hold c.mu.
+func (c *Client) recordWatchTime(twitchUserId string, streamId string, intervalId string, minutesWatched int, numPointsToCredit int) {
+	c.recordFlow(twitchUserId, ledger.TransactionTypeWatchTime, mustMarshalMetadata(map[string]interface{}{
+		"stream_id":       streamId,
+		"interval_id":     intervalId,
+		"minutes_watched": minutesWatched,
+		"policy_version":  points.DefaultPolicy.Version(),
+	}), numPointsToCredit, true, "")
+}
+
+// entry returns the given flow as it appears in the user's history. As on the server,
+// the separate watch-time inflows recorded for each interval of a stream are combined
+// into a single entry, identified by the stream's first interval and showing the
+// totals credited so far. The caller must hold c.mu.
+func (c *Client) entry(flow *mockFlow) *mockFlow {
+	if flow.flowType != ledger.TransactionTypeWatchTime {
+		return flow
+	}
+	streamId := flow.watchTimeMetadata().StreamId
+	var first *mockFlow
+	minutesWatched := 0
+	deltaPoints := 0
+	for _, f := range c.flows {
+		if f.flowType != ledger.TransactionTypeWatchTime || f.twitchUserId != flow.twitchUserId {
+			continue
+		}
+		metadata := f.watchTimeMetadata()
+		if metadata.StreamId != streamId {
+			continue
+		}
+		if first == nil {
+			first = f
+		}
+		minutesWatched += metadata.MinutesWatched
+		deltaPoints += f.deltaPoints
 	}
-	m.isAccepted = true
-	m.isFinalized = true
+
+	entry := *first
+	entry.metadata = mustMarshalMetadata(map[string]interface{}{
+		"stream_id":       streamId,
+		"minutes_watched": minutesWatched,
+		"policy_version":  first.watchTimeMetadata().PolicyVersion,
+	})
+	entry.deltaPoints = deltaPoints
+	return &entry
+}
+
+// notify sends the current state of the given flow to all subscribers who are
+// listening for the affected user's transactions. A subscriber who isn't keeping up
+// with notifications will miss them rather than blocking the ledger. The caller must
+// hold c.mu.
+func (c *Client) notify(flow *mockFlow) {
+	transaction := c.entry(flow).transaction()
+	for _, ch := range c.subscribers[flow.twitchUserId] {
+		select {
+		case ch <- transaction:
+		default:
+		}
+	}
+}
+
+// getBalance computes a user's balance in the same way as the ledger.balance view:
+// pending inflows count toward the total but aren't available, and pending outflows
+// are deducted from the available balance but not the total. The caller must hold c.mu.
+func (c *Client) getBalance(twitchUserId string) ledger.Balance {
+	var balance ledger.Balance
+	for _, flow := range c.flows {
+		if flow.twitchUserId != twitchUserId {
+			continue
+		}
+		if flow.finalizedAt.Valid {
+			if flow.accepted {
+				balance.TotalPoints += flow.deltaPoints
+				balance.AvailablePoints += flow.deltaPoints
+			}
+		} else if flow.deltaPoints > 0 {
+			balance.TotalPoints += flow.deltaPoints
+		} else {
+			balance.AvailablePoints += flow.deltaPoints
+		}
+	}
+	return balance
+}
+
+func (f *mockFlow) Accept(ctx context.Context) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	if f.finalizedAt.Valid {
+		return fmt.Errorf("%w upon call to Accept", ledger.ErrFlowAlreadyFinalized)
+	}
+	f.finalizedAt = sql.NullTime{Valid: true, Time: time.Now()}
+	f.accepted = true
+	f.c.notify(f)
 	return nil
 }
 
-func (m *mockDebit) Finalize(ctx context.Context) error {
-	if !m.isFinalized {
-		m.isFinalized = true
+func (f *mockFlow) Finalize(ctx context.Context) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	if !f.finalizedAt.Valid {
+		f.finalizedAt = sql.NullTime{Valid: true, Time: time.Now()}
+		f.accepted = false
+		f.c.notify(f)
 	}
 	return nil
 }
 
+// transaction describes the flow exactly as it would be described by the server. The
+// caller must hold f.c.mu.
+func (f *mockFlow) transaction() ledger.Transaction {
+	descriptionTemplate := f.c.descriptionTemplatesByType[f.flowType]
+	return util.BuildTransaction(f.id, string(f.flowType), f.metadata, f.deltaPoints, f.createdAt, f.finalizedAt, f.accepted, descriptionTemplate, 0)
+}
+
+// watchTimeMetadata parses the metadata recorded with a watch-time flow, which is
+// always marshaled by recordWatchTime and so can't fail to unmarshal
+func (f *mockFlow) watchTimeMetadata() mockWatchTimeMetadata {
+	var metadata mockWatchTimeMetadata
+	if err := json.Unmarshal(f.metadata, &metadata); err != nil {
+		panic(err)
+	}
+	return metadata
+}
+
+// matchesHistoryOptions returns true if the flow satisfies all the filters specified
+// in the given history options, mirroring the filters applied by the server
+func (f *mockFlow) matchesHistoryOptions(options *ledger.HistoryOptions) bool {
+	if len(options.Types) > 0 {
+		found := false
+		for _, t := range options.Types {
+			found = found || t == f.flowType
+		}
+		if !found {
+			return false
+		}
+	}
+	if options.State != "" {
+		state := ledger.TransactionStatePending
+		if f.finalizedAt.Valid {
+			state = ledger.TransactionStateRejected
+			if f.accepted {
+				state = ledger.TransactionStateAccepted
+			}
+		}
+		if state != options.State {
+			return false
+		}
+	}
+	if options.Direction == ledger.TransactionDirectionInflow && f.deltaPoints <= 0 {
+		return false
+	}
+	if options.Direction == ledger.TransactionDirectionOutflow && f.deltaPoints >= 0 {
+		return false
+	}
+	if !options.Since.IsZero() && f.createdAt.Before(options.Since) {
+		return false
+	}
+	if !options.Until.IsZero() && !f.createdAt.Before(options.Until) {
+		return false
+	}
+	return true
+}
+
+func truncateMessage(message string) string {
+	if len(message) > maxStoredMessageLen {
+		return message[:maxStoredMessageLen]
+	}
+	return message
+}
+
+// mustMarshalMetadata serializes a metadata object built from plain values, which can't
+// fail to marshal
+func mustMarshalMetadata(metadata map[string]interface{}) json.RawMessage {
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		panic(err)
+	}
+	return metadataBytes
+}
+
 var _ ledger.Client = (*Client)(nil)
@@ -2,6 +2,8 @@ package ledgermock
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/golden-vcr/auth"
@@ -38,8 +40,279 @@ func Test_Client(t *testing.T) {
 	assertCurrentBalance(t, c, "token-a", 700)
 }
 
+func Test_Client_inflows(t *testing.T) {
+	c := NewClient().GrantUser("token-a", "1001", 0)
+
+	cheerId, err := c.RequestCreditFromCheer(context.Background(), "token-a", "event-1", 500, "hello")
+	assert.NoError(t, err)
+	_, err = c.RequestCreditFromSubscription(context.Background(), "token-a", "event-2", ledger.SubscriptionTier2, true, false, "")
+	assert.NoError(t, err)
+	_, err = c.RequestCreditFromGiftSub(context.Background(), "token-a", "event-3", ledger.SubscriptionTier1, 5)
+	assert.NoError(t, err)
+
+	// Retrying a request for the same event should not credit the user again
+	replayedCheerId, err := c.RequestCreditFromCheer(context.Background(), "token-a", "event-1", 500, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, cheerId, replayedCheerId)
+
+	_, err = c.RequestCreditFromCheer(context.Background(), "bad-token", "", 500, "")
+	assert.ErrorIs(t, err, auth.ErrUnauthorized)
+	_, err = c.RequestCreditFromCheer(context.Background(), "token-a", "", 0, "")
+	assert.ErrorIs(t, err, ledger.ErrInvalidRequest)
+	_, err = c.RequestCreditFromSubscription(context.Background(), "token-a", "", "4000", false, false, "")
+	assert.ErrorIs(t, err, ledger.ErrInvalidRequest)
+
+	_, err = c.RequestCreditFromRaid(context.Background(), "token-a", "event-4", 42)
+	assert.NoError(t, err)
+	_, err = c.RequestCreditFromFollow(context.Background(), "token-a", "event-5")
+	assert.NoError(t, err)
+	_, err = c.RequestCreditFromHypeTrain(context.Background(), "token-a", "event-6", 3)
+	assert.NoError(t, err)
+
+	// A user may only be credited for following once, but retrying the same follow
+	// event is a replay rather than a second follow
+	_, err = c.RequestCreditFromFollow(context.Background(), "token-a", "event-5")
+	assert.NoError(t, err)
+	_, err = c.RequestCreditFromFollow(context.Background(), "token-a", "event-7")
+	assert.ErrorIs(t, err, ledger.ErrFollowAlreadyCredited)
+
+	c.AssertCredited(t, "1001", ledger.TransactionTypeCheer, 500)
+	c.AssertCredited(t, "1001", ledger.TransactionTypeSubscription, 1200)
+	c.AssertCredited(t, "1001", ledger.TransactionTypeGiftSub, 1000)
+	c.AssertCredited(t, "1001", ledger.TransactionTypeRaid, 420)
+	c.AssertCredited(t, "1001", ledger.TransactionTypeFollow, 50)
+	c.AssertCredited(t, "1001", ledger.TransactionTypeHypeTrain, 300)
+	c.AssertBalance(t, "1001", 3470, 3470)
+
+	history := c.History("1001")
+	descriptions := make([]string, 0, len(history))
+	for _, item := range history {
+		descriptions = append(descriptions, item.Description)
+	}
+	assert.Equal(t, []string{
+		"Thank you for contributing to a level 3 hype train!",
+		"Thank you for following!",
+		"Thank you for raiding with 42 viewers!",
+		"Thank you for gifting 5 subs!",
+		"Thank you for becoming a subscriber (at a tier with 2x credit)!",
+		"Thank you for cheering with the message 'hello'!",
+	}, descriptions)
+}
+
+func Test_Client_watchTime(t *testing.T) {
+	c := NewClient()
+
+	// Each viewer should be credited 2 points per minute watched
+	result, err := c.RequestCreditFromWatchTime(context.Background(), "internal-jwt", "stream-1", "interval-1", []ledger.WatchTimeViewer{
+		{TwitchUserId: "1001", MinutesWatched: 30},
+		{TwitchUserId: "1002", MinutesWatched: 5},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ledger.WatchTimeResult{NumViewersCredited: 2, NumPointsCredited: 70}, result)
+
+	// Retrying the same interval should return the original result without crediting
+	// anyone again
+	result, err = c.RequestCreditFromWatchTime(context.Background(), "internal-jwt", "stream-1", "interval-1", []ledger.WatchTimeViewer{
+		{TwitchUserId: "1001", MinutesWatched: 30},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ledger.WatchTimeResult{NumViewersCredited: 2, NumPointsCredited: 70}, result)
+
+	// Subsequent intervals should be recorded as separate transactions, but combined
+	// into the same entry in the user's history, identified by the first interval
+	firstEntry := c.History("1001")[0]
+	c.GrantUser("token-1001", "1001", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifications, err := c.SubscribeNotifications(ctx, "token-1001")
+	assert.NoError(t, err)
+	_, err = c.RequestCreditFromWatchTime(context.Background(), "internal-jwt", "stream-1", "interval-2", []ledger.WatchTimeViewer{
+		{TwitchUserId: "1001", MinutesWatched: 30},
+	})
+	assert.NoError(t, err)
+	history := c.History("1001")
+	assert.Len(t, history, 1)
+	assert.Equal(t, firstEntry.Id, history[0].Id)
+	assert.Equal(t, 120, history[0].DeltaPoints)
+	assert.Equal(t, "Thank you for watching for 60 minutes!", history[0].Description)
+	page, err := c.GetHistory(context.Background(), "token-1001", ledger.HistoryOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, history, page.Items)
+
+	// Subscribers should be notified of the updated entry, not the new interval
+	select {
+	case transaction := <-notifications:
+		assert.Equal(t, history[0], transaction)
+	default:
+		t.Fatal("expected a notification for the updated watch-time entry")
+	}
+
+	// No viewer should be credited beyond the daily cap of 480 points
+	for i, wantNumPoints := range []int{120, 120, 120, 110, 0} {
+		result, err := c.RequestCreditFromWatchTime(context.Background(), "internal-jwt", "stream-2", fmt.Sprintf("interval-%d", i), []ledger.WatchTimeViewer{
+			{TwitchUserId: "1002", MinutesWatched: 60},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, wantNumPoints, result.NumPointsCredited)
+	}
+	c.AssertBalance(t, "1002", 480, 480)
+	assert.Len(t, c.History("1002"), 2)
+
+	_, err = c.RequestCreditFromWatchTime(context.Background(), "internal-jwt", "stream-1", "interval-3", []ledger.WatchTimeViewer{
+		{TwitchUserId: "1001", MinutesWatched: 0},
+	})
+	assert.ErrorIs(t, err, ledger.ErrInvalidRequest)
+}
+
+func Test_Client_outflows(t *testing.T) {
+	c := NewClient().GrantUser("token-a", "1001", 1000)
+	c.AssertBalance(t, "1001", 1000, 1000)
+
+	// A pending outflow is deducted from the available balance, but not the total
+	accepted, err := c.RequestAlertRedemption(context.Background(), "token-a", 300, "ghost", nil)
+	assert.NoError(t, err)
+	c.AssertBalance(t, "1001", 1000, 700)
+	assert.NoError(t, accepted.Accept(context.Background()))
+	assert.ErrorIs(t, accepted.Accept(context.Background()), ledger.ErrFlowAlreadyFinalized)
+	c.AssertBalance(t, "1001", 700, 700)
+	c.AssertDebited(t, "1001", ledger.TransactionTypeAlertRedemption, 300)
+
+	rejected, err := c.RequestAlertRedemption(context.Background(), "token-a", 200, "tone", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, rejected.Finalize(context.Background()))
+	c.AssertBalance(t, "1001", 700, 700)
+
+	_, err = c.RequestAlertRedemption(context.Background(), "token-a", 100, "oops", nil)
+	assert.NoError(t, err)
+	c.AssertBalance(t, "1001", 700, 600)
+	c.ExpirePendingOutflows()
+	c.AssertBalance(t, "1001", 700, 700)
+
+	// Only registered outflow types may be requested
+	_, err = c.RequestOutflow(context.Background(), "token-a", "tape-request", 100, json.RawMessage(`{"tape_id":42}`))
+	assert.ErrorIs(t, err, ledger.ErrInvalidRequest)
+	c.RegisterOutflowType("tape-request", "Requested tape {{.tape_id}}")
+	tapeRequest, err := c.RequestOutflow(context.Background(), "token-a", "tape-request", 100, json.RawMessage(`{"tape_id":42}`))
+	assert.NoError(t, err)
+	assert.NoError(t, tapeRequest.Accept(context.Background()))
+	c.AssertDebited(t, "1001", "tape-request", 100)
+
+	history := c.History("1001")
+	descriptions := make([]string, 0, len(history))
+	for _, item := range history {
+		descriptions = append(descriptions, fmt.Sprintf("%s %d: %s", item.State, item.DeltaPoints, item.Description))
+	}
+	assert.Equal(t, []string{
+		"accepted -100: Requested tape 42",
+		"rejected -100: Redeemed alert of type 'oops' (expired before it was completed)",
+		"rejected -200: Redeemed alert of type 'tone'",
+		"accepted -300: Redeemed alert of type 'ghost'",
+		"accepted 1000: Manual credit: Initial balance",
+	}, descriptions)
+}
+
+func Test_Client_pendingInflow(t *testing.T) {
+	c := NewClient().GrantUser("token-a", "1001", 100)
+
+	// A pending inflow counts toward the total balance, but can't be spent yet
+	inflow := c.AddPendingInflow("1001", ledger.TransactionTypeManualCredit, json.RawMessage(`{"note":"pending"}`), 500)
+	c.AssertBalance(t, "1001", 600, 100)
+	_, err := c.RequestAlertRedemption(context.Background(), "token-a", 200, "foo", nil)
+	assert.ErrorIs(t, err, ledger.ErrNotEnoughPoints)
+
+	assert.NoError(t, inflow.Accept(context.Background()))
+	c.AssertBalance(t, "1001", 600, 600)
+	_, err = c.RequestAlertRedemption(context.Background(), "token-a", 200, "foo", nil)
+	assert.NoError(t, err)
+}
+
+func Test_Client_assertions(t *testing.T) {
+	c := NewClient().GrantUser("token-a", "1001", 0)
+	_, err := c.RequestCreditFromCheer(context.Background(), "token-a", "", 500, "")
+	assert.NoError(t, err)
+
+	mockT := &recordingT{}
+	assert.False(t, c.AssertCredited(mockT, "1001", ledger.TransactionTypeCheer, 400))
+	assert.False(t, c.AssertCredited(mockT, "1002", ledger.TransactionTypeCheer, 500))
+	assert.False(t, c.AssertDebited(mockT, "1001", ledger.TransactionTypeAlertRedemption, 500))
+	assert.False(t, c.AssertNoTransactions(mockT, "1001"))
+	assert.True(t, c.AssertNoTransactions(mockT, "1002"))
+	assert.True(t, c.AssertCredited(mockT, "1001", ledger.TransactionTypeCheer, 500))
+	assert.Len(t, mockT.errors, 4)
+}
+
+// recordingT captures assertion failures so that we can test our assertion helpers
+// without failing the test that calls them
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
 func assertCurrentBalance(t *testing.T, c *Client, token string, want int) {
-	got, err := c.getAvailableBalance(token)
+	assert.Equal(t, want, c.Balance(token).AvailablePoints)
+}
+
+func Test_Client_reads(t *testing.T) {
+	c := NewClient().GrantUser("token-a", "1001", 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifications, err := c.SubscribeNotifications(ctx, "token-a")
 	assert.NoError(t, err)
-	assert.Equal(t, want, got)
+	_, err = c.SubscribeNotifications(ctx, "bad-token")
+	assert.ErrorIs(t, err, auth.ErrUnauthorized)
+
+	for i := 1; i <= 5; i++ {
+		_, err := c.RequestCreditFromCheer(context.Background(), "token-a", "", i*100, "")
+		assert.NoError(t, err)
+	}
+	transaction, err := c.RequestAlertRedemption(context.Background(), "token-a", 50, "foo", nil)
+	assert.NoError(t, err)
+
+	balance, err := c.GetBalance(context.Background(), "token-a")
+	assert.NoError(t, err)
+	assert.Equal(t, ledger.Balance{TotalPoints: 2500, AvailablePoints: 2450}, balance)
+
+	// Iterating through history should visit every matching transaction exactly once
+	it := c.IterateHistory("token-a", ledger.HistoryOptions{Max: 2, Types: []ledger.TransactionType{ledger.TransactionTypeCheer}})
+	deltas := make([]int, 0)
+	numPages := 0
+	for it.Next(context.Background()) {
+		numPages++
+		for _, item := range it.Page().Items {
+			deltas = append(deltas, item.DeltaPoints)
+		}
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, 3, numPages)
+	assert.Equal(t, []int{500, 400, 300, 200, 100}, deltas)
+
+	history, err := c.GetHistory(context.Background(), "token-a", ledger.HistoryOptions{State: ledger.TransactionStatePending})
+	assert.NoError(t, err)
+	assert.Len(t, history.Items, 1)
+	assert.Equal(t, "", history.NextCursor)
+
+	// Every change should have been announced to the subscriber
+	assert.NoError(t, transaction.Accept(context.Background()))
+	states := make([]string, 0)
+	for i := 0; i < 7; i++ {
+		n := <-notifications
+		states = append(states, fmt.Sprintf("%s %d", n.State, n.DeltaPoints))
+	}
+	assert.Equal(t, []string{
+		"accepted 100",
+		"accepted 200",
+		"accepted 300",
+		"accepted 400",
+		"accepted 500",
+		"pending -50",
+		"accepted -50",
+	}, states)
+
+	cancel()
+	_, ok := <-notifications
+	assert.False(t, ok)
 }
@@ -0,0 +1,199 @@
+package ledger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/server-common/entry"
+)
+
+const (
+	// notificationsMinReconnectDelay is how long SubscribeNotifications waits before
+	// reconnecting after its connection to the notifications stream is lost
+	notificationsMinReconnectDelay = time.Second
+	// notificationsMaxReconnectDelay is the upper bound on the reconnect delay, which
+	// doubles after each consecutive failed attempt to reconnect
+	notificationsMaxReconnectDelay = 30 * time.Second
+)
+
+// SubscribeNotifications opens a connection to the ledger's notifications stream,
+// returning a channel that will receive each transaction recorded for the user
+// identified by accessToken as it's created or updated. If the connection is lost, the
+// client reconnects with exponential backoff. The channel is closed once ctx is done,
+// or if the ledger stops accepting accessToken. Upon reconnecting, the ledger replays
+// any changes that occurred while the client was disconnected.
+func (c *client) SubscribeNotifications(ctx context.Context, accessToken string) (<-chan Transaction, error) {
+	// Connect once up-front, so that the caller finds out immediately if they're unable
+	// to subscribe at all
+	body, err := c.openNotificationsStream(ctx, accessToken, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Transaction)
+	go c.readNotifications(ctx, accessToken, body, ch)
+	return ch, nil
+}
+
+// readNotifications sends all transactions read from the given notifications stream to
+// ch, reconnecting whenever the stream is interrupted, until ctx is done
+func (c *client) readNotifications(ctx context.Context, accessToken string, body io.ReadCloser, ch chan<- Transaction) {
+	defer close(ch)
+
+	delay := c.minReconnectDelay
+	lastEventId := ""
+	for {
+		if body != nil {
+			if id, _ := readTransactionEvents(ctx, body, ch); id != "" {
+				lastEventId = id
+			}
+			body.Close()
+			body = nil
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Wait a bit before reconnecting, backing off further after each failed attempt
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		var err error
+		body, err = c.openNotificationsStream(ctx, accessToken, lastEventId)
+		if err != nil {
+			// If the ledger no longer accepts our access token, retrying won't help
+			if errors.Is(err, auth.ErrUnauthorized) {
+				return
+			}
+			delay = min(delay*2, c.maxReconnectDelay)
+			continue
+		}
+		delay = c.minReconnectDelay
+	}
+}
+
+// openNotificationsStream exchanges the given access token for a short-lived SSE token
+// via POST /notifications, then uses that token to open the text/event-stream response
+// from GET /notifications, returning its body. If lastEventId is set, the ledger will
+// begin by replaying any changes that have occurred since that event.
+func (c *client) openNotificationsStream(ctx context.Context, accessToken string, lastEventId string) (io.ReadCloser, error) {
+	// Make a request to POST /notifications to get an SSE token
+	tokenUrl := c.ledgerUrl + "/notifications"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, parseErrorResponse(res, http.MethodPost, tokenUrl)
+	}
+	tokenBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SSE token from response body: %w", err)
+	}
+	token := strings.TrimSpace(string(tokenBytes))
+
+	// Open a connection to GET /notifications, supplying our SSE token
+	streamUrl := c.ledgerUrl + "/notifications?" + url.Values{"token": {token}}.Encode()
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, streamUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("accept", "text/event-stream")
+	if lastEventId != "" {
+		req.Header.Set("last-event-id", lastEventId)
+	}
+	res, err = c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, parseErrorResponse(res, http.MethodGet, c.ledgerUrl+"/notifications")
+	}
+	return res.Body, nil
+}
+
+// errResyncRequired is returned by readTransactionEvents if the ledger indicates that
+// the client has fallen too far behind and needs to resync: we handle this by
+// reconnecting, which replays any changes we missed
+var errResyncRequired = errors.New("resync required")
+
+// readTransactionEvents parses a text/event-stream body in which each message carries
+// a JSON-serialized Transaction as its data, sending each transaction to ch. Returns
+// once the stream ends, once ctx is done, or once a 'resync' event is received, along
+// with the ID of the last event that was dispatched, if any.
+func readTransactionEvents(ctx context.Context, r io.Reader, ch chan<- Transaction) (string, error) {
+	scanner := bufio.NewScanner(r)
+	eventType := ""
+	data := ""
+	id := ""
+	lastEventId := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line dispatches the message that we've accumulated so far
+		if line == "" {
+			if eventType == "resync" {
+				return lastEventId, errResyncRequired
+			}
+			if data != "" {
+				// Events of any other type aren't transactions, so we ignore them
+				if eventType == "" || eventType == "message" || eventType == "transaction" {
+					var transaction Transaction
+					if err := json.Unmarshal([]byte(data), &transaction); err == nil {
+						select {
+						case <-ctx.Done():
+							return lastEventId, ctx.Err()
+						case ch <- transaction:
+						}
+					}
+				}
+				if id != "" {
+					lastEventId = id
+				}
+			}
+			eventType = ""
+			data = ""
+			continue
+		}
+
+		// Lines starting with a colon are comments, used as keepalives; we're only
+		// interested in event, data and id fields
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		case "id":
+			id = value
+		}
+	}
+	return lastEventId, scanner.Err()
+}
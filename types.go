@@ -11,12 +11,23 @@ type TransactionType string
 
 const (
 	TransactionTypeManualCredit    TransactionType = "manual-credit"
+	TransactionTypeManualDebit     TransactionType = "manual-debit"
 	TransactionTypeCheer           TransactionType = "cheer"
 	TransactionTypeSubscription    TransactionType = "subscription"
 	TransactionTypeGiftSub         TransactionType = "gift-sub"
+	TransactionTypeRaid            TransactionType = "raid"
+	TransactionTypeFollow          TransactionType = "follow"
+	TransactionTypeHypeTrain       TransactionType = "hype-train"
+	TransactionTypeWatchTime       TransactionType = "watch-time"
 	TransactionTypeAlertRedemption TransactionType = "alert-redemption"
+	TransactionTypeReversal        TransactionType = "reversal"
 )
 
+// IdempotencyKeyHeader is the name of the HTTP header that may be used to supply an
+// idempotency key when requesting an inflow: if a request is retried with the same key,
+// the ledger returns the original transaction rather than crediting the user again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type TransactionState string
 
 const (
@@ -25,6 +36,25 @@ const (
 	TransactionStateRejected TransactionState = "rejected"
 )
 
+// TransactionDirection distinguishes inflows (which credit points to a user) from
+// outflows (which debit points from a user), for the purpose of filtering history
+type TransactionDirection string
+
+const (
+	TransactionDirectionInflow  TransactionDirection = "inflow"
+	TransactionDirectionOutflow TransactionDirection = "outflow"
+)
+
+// SubscriptionTier identifies the tier of a Twitch subscription, using the same values
+// that Twitch itself reports in EventSub payloads
+type SubscriptionTier string
+
+const (
+	SubscriptionTier1 SubscriptionTier = "1000"
+	SubscriptionTier2 SubscriptionTier = "2000"
+	SubscriptionTier3 SubscriptionTier = "3000"
+)
+
 type Balance struct {
 	TotalPoints     int `json:"totalPoints"`
 	AvailablePoints int `json:"availablePoints"`
@@ -33,6 +63,7 @@ type Balance struct {
 type TransactionHistory struct {
 	Items      []Transaction `json:"items"`
 	NextCursor string        `json:"nextCursor,omitempty"`
+	PrevCursor string        `json:"prevCursor,omitempty"`
 }
 
 type Transaction struct {
@@ -44,16 +75,31 @@ type Transaction struct {
 	Description string           `json:"description"`
 }
 
+// Activity describes a change to any user's transaction, as sent to the broadcaster via
+// the GET /notifications/firehose stream, and to webhooks
+type Activity struct {
+	TwitchUserId string `json:"twitchUserId"`
+	// TwitchDisplayName is the user's display name, if known
+	TwitchDisplayName string `json:"twitchDisplayName,omitempty"`
+	Transaction
+}
+
+// CheerRequest is the payload sent with a POST /inflow/cheer request. The number of
+// points credited is determined by the ledger's points policy.
 type CheerRequest struct {
-	NumPointsToCredit int    `json:"numPointsToCredit"`
-	Message           string `json:"message"`
+	// NumBits is the number of bits that the user cheered
+	NumBits int    `json:"numBits"`
+	Message string `json:"message"`
+	// EventId is the ID of the originating Twitch event, if known: it's used as an
+	// idempotency key, so that a retried request will not credit the user twice
+	EventId string `json:"eventId,omitempty"`
 }
 
-// SubscriptionRequest is the payload sent with a POST /inflow/subscription request
+// SubscriptionRequest is the payload sent with a POST /inflow/subscription request. The
+// number of points credited is determined by the ledger's points policy.
 type SubscriptionRequest struct {
-	// BasePointsToCredit is the number of points that a user should receive for
-	// purchasing a Tier 1 subscription for a single month
-	BasePointsToCredit int `json:"basePointsToCredit"`
+	// Tier is the tier of the subscription
+	Tier SubscriptionTier `json:"tier"`
 	// IsInitial indicates that points are being credit for an initial subscription
 	// purchase, as opposed to a subscription renewal / resub message
 	IsInitial bool `json:"isInitial"`
@@ -63,30 +109,185 @@ type SubscriptionRequest struct {
 	// Message indicates the resub message sent with the originating event, if the event
 	// was a resub and the user provided a message. This value may always be empty.
 	Message string `json:"message"`
-	// CreditMulitplier is an additional scale factor applied based on the Tier of the
-	// subscription purchased; e.g. 2.0 for a Tier 2 sub, 5.0 for a Tier 3 sub
-	CreditMultiplier float64 `json:"creditMultiplier"`
+	// EventId is the ID of the originating Twitch event, if known: it's used as an
+	// idempotency key, so that a retried request will not credit the user twice
+	EventId string `json:"eventId,omitempty"`
 }
 
-// GiftSubRequest is the payload sent with a POST /inflow/gift-sub request
+// GiftSubRequest is the payload sent with a POST /inflow/gift-sub request. The number
+// of points credited is determined by the ledger's points policy.
 type GiftSubRequest struct {
-	// BasePointsToCredit is the number of points that a user should receive for
-	// purchasing a single Tier 1 gift sub and granting it to a recipient
-	BasePointsToCredit int `json:"basePointsToCredit"`
-	// NumSubscriptions indicates the number of subscriptions gifted, used as a scale
-	// factor to compute the number of points to credit for this transaction
-	// (i.e. BasePointsToCredit * NumSubscriptions)
+	// Tier is the tier of the subscriptions gifted
+	Tier SubscriptionTier `json:"tier"`
+	// NumSubscriptions indicates the number of subscriptions gifted
 	NumSubscriptions int `json:"numSubscriptions"`
-	// CreditMulitplier is an additional scale factor applied based on the Tier of the
-	// subscriptions gifted; e.g. 2.0 for a Tier 2 sub, 5.0 for a Tier 3 sub
-	CreditMultiplier float64 `json:"creditMultiplier"`
+	// EventId is the ID of the originating Twitch event, if known: it's used as an
+	// idempotency key, so that a retried request will not credit the user twice
+	EventId string `json:"eventId,omitempty"`
+}
+
+// RaidRequest is the payload sent with a POST /inflow/raid request, on behalf of the
+// user who raided the channel. The number of points credited is determined by the
+// ledger's points policy.
+type RaidRequest struct {
+	// NumViewers is the number of viewers who arrived with the raid
+	NumViewers int `json:"numViewers"`
+	// EventId is the ID of the originating Twitch event, if known: it's used as an
+	// idempotency key, so that a retried request will not credit the user twice
+	EventId string `json:"eventId,omitempty"`
+}
+
+// FollowRequest is the payload sent with a POST /inflow/follow request. Each user may
+// only be credited for following once.
+type FollowRequest struct {
+	// EventId is the ID of the originating Twitch event, if known: it's used as an
+	// idempotency key, so that a retried request will not credit the user twice
+	EventId string `json:"eventId,omitempty"`
+}
+
+// HypeTrainRequest is the payload sent with a POST /inflow/hype-train request, on
+// behalf of a user who contributed to a hype train. The number of points credited is
+// determined by the ledger's points policy.
+type HypeTrainRequest struct {
+	// Level is the level that the hype train reached
+	Level int `json:"level"`
+	// EventId is the ID of the originating Twitch event, if known: it's used as an
+	// idempotency key, so that a retried request will not credit the user twice. Since
+	// a single hype train may credit many users, callers should combine the ID of the
+	// hype train with the ID of the user.
+	EventId string `json:"eventId,omitempty"`
+}
+
+// WatchTimeRequest is the payload sent with a POST /inflow/watch-time request, in order
+// to credit a batch of viewers for the time they spent watching during a single
+// interval of a stream. The number of points credited to each viewer is determined by
+// the ledger's points policy, subject to a daily cap.
+type WatchTimeRequest struct {
+	// StreamId identifies the stream: each viewer's history shows a single entry for
+	// all the watch time credited during a stream
+	StreamId string `json:"streamId"`
+	// IntervalId uniquely identifies the interval within the stream: if a batch is
+	// retried for the same interval, it will not be credited again
+	IntervalId string `json:"intervalId"`
+	// Viewers lists each viewer who watched during the interval, with no viewer listed
+	// more than once
+	Viewers []WatchTimeViewer `json:"viewers"`
+}
+
+// WatchTimeViewer records how many minutes a single viewer spent watching during an
+// interval of a stream
+type WatchTimeViewer struct {
+	TwitchUserId   string `json:"twitchUserId"`
+	MinutesWatched int    `json:"minutesWatched"`
+}
+
+// WatchTimeResult is the response to a POST /inflow/watch-time request, summarizing the
+// points credited for the interval. If the request is a retry of an interval that was
+// already credited, the result describes the original batch.
+type WatchTimeResult struct {
+	// NumViewersCredited is the number of viewers who were credited with points: viewers
+	// who had already reached their daily cap are not included
+	NumViewersCredited int `json:"numViewersCredited"`
+	// NumPointsCredited is the total number of points credited to all viewers
+	NumPointsCredited int `json:"numPointsCredited"`
+}
+
+// OutflowRequest is the payload sent with a POST /outflow request
+type OutflowRequest struct {
+	// Type is the name of a registered outflow type, e.g. 'alert-redemption'
+	Type TransactionType `json:"type"`
+	// NumPointsToDebit is the number of points to deduct from the user's balance
+	NumPointsToDebit int `json:"numPointsToDebit"`
+	// Metadata is an arbitrary JSON object to be recorded with the transaction: if the
+	// outflow type has a registered metadata schema, the object must conform to it
+	Metadata *json.RawMessage `json:"metadata,omitempty"`
+	// AlertType and AlertMetadata are supported for backwards-compatibility with
+	// 'alert-redemption' requests that predate the generic Metadata field: if Metadata
+	// is not set, the resulting metadata is AlertMetadata with AlertType as its 'type'
+	AlertType     string           `json:"alertType,omitempty"`
+	AlertMetadata *json.RawMessage `json:"alertMetadata,omitempty"`
+	// ExpiresInSeconds optionally overrides the server's default timeout for the
+	// resulting pending outflow: if the outflow has not been finalized by the time it
+	// expires, the server will automatically reject it
+	ExpiresInSeconds int `json:"expiresInSeconds,omitempty"`
+}
+
+// AlertRedemptionRequest is the payload formerly used to request an alert redemption.
+//
+// Deprecated: Use OutflowRequest instead.
+type AlertRedemptionRequest = OutflowRequest
+
+// OutflowType describes a type of outflow that has been registered with the ledger, and
+// which may therefore be requested via POST /outflow
+type OutflowType struct {
+	// Name is the unique, kebab-case name of the outflow type
+	Name TransactionType `json:"name"`
+	// Comment is a developer-facing description of the purpose of this outflow type
+	Comment string `json:"comment"`
+	// MetadataSchema is an optional JSON Schema document: if set, the metadata of any
+	// outflow of this type must validate against it
+	MetadataSchema *json.RawMessage `json:"metadataSchema,omitempty"`
+	// DescriptionTemplate is an optional Go text/template string which is executed with
+	// each transaction's metadata object to produce a user-facing description, e.g.
+	// "Redeemed alert of type '{{.type}}'"
+	DescriptionTemplate string `json:"descriptionTemplate,omitempty"`
+}
+
+// OutflowTypeList is the response body returned by GET /outflow/types
+type OutflowTypeList struct {
+	Items []OutflowType `json:"items"`
+}
+
+// Webhook describes a URL to which the ledger delivers a signed Activity payload
+// whenever a transaction of the given type is created or updated
+type Webhook struct {
+	Id       uuid.UUID       `json:"id"`
+	Url      string          `json:"url"`
+	FlowType TransactionType `json:"flowType"`
+	// Secret is the key with which each delivery is signed: it's only returned once, in
+	// response to the request that registers the webhook
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookList struct {
+	Items []Webhook `json:"items"`
+}
+
+// WebhookDelivery describes an attempt to deliver an event to a webhook which has
+// failed permanently, and which may be redriven
+type WebhookDelivery struct {
+	Id          uuid.UUID       `json:"id"`
+	WebhookId   uuid.UUID       `json:"webhookId"`
+	Url         string          `json:"url"`
+	FlowType    TransactionType `json:"flowType"`
+	FlowId      uuid.UUID       `json:"flowId"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	NumAttempts int             `json:"numAttempts"`
+	LastError   string          `json:"lastError,omitempty"`
+	FailedAt    time.Time       `json:"failedAt"`
+}
+
+type WebhookDeliveryList struct {
+	Items []WebhookDelivery `json:"items"`
+}
+
+// Promotion describes a window of time during which inflows earn a multiple of the
+// points they would ordinarily be credited, e.g. for a "Double Points Night"
+type Promotion struct {
+	Id         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Multiplier float64   `json:"multiplier"`
+	// FlowTypes lists the types of inflow to which the promotion applies: if empty, it
+	// applies to all inflows that support promotions
+	FlowTypes []TransactionType `json:"flowTypes,omitempty"`
+	StartsAt  time.Time         `json:"startsAt"`
+	EndsAt    time.Time         `json:"endsAt"`
+	CreatedAt time.Time         `json:"createdAt"`
 }
 
-type AlertRedemptionRequest struct {
-	Type             TransactionType  `json:"type"`
-	NumPointsToDebit int              `json:"numPointsToDebit"`
-	AlertType        string           `json:"alertType"`
-	AlertMetadata    *json.RawMessage `json:"alertMetadata,omitempty"`
+type PromotionList struct {
+	Items []Promotion `json:"items"`
 }
 
 type TransactionResult struct {
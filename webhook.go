@@ -0,0 +1,63 @@
+package ledger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WebhookDeliveryHeader carries the unique ID of each webhook delivery: if an event
+	// is redelivered (e.g. because the recipient failed to respond in time), its ID will
+	// be unchanged
+	WebhookDeliveryHeader = "X-Ledger-Delivery"
+	// WebhookTimestampHeader carries the time at which a webhook delivery was signed, in
+	// Unix seconds
+	WebhookTimestampHeader = "X-Ledger-Timestamp"
+	// WebhookSignatureHeader carries the signature of a webhook delivery, in the form
+	// 'sha256=<hex-encoded HMAC>', as computed by SignWebhook
+	WebhookSignatureHeader = "X-Ledger-Signature"
+)
+
+// ErrInvalidWebhookSignature indicates that a webhook delivery was not signed with the
+// expected secret, or was signed too long ago to be trusted
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// SignWebhook computes the signature sent with a webhook delivery: an HMAC-SHA256 of
+// the timestamp (in Unix seconds), a '.', and the request body, keyed with the secret
+// that was issued when the webhook was registered
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook allows the recipient of a webhook delivery to check that it was sent by
+// the ledger, given the values of the WebhookTimestampHeader and WebhookSignatureHeader
+// headers. Deliveries signed more than maxAge ago are rejected, so that a captured
+// request can't be replayed indefinitely.
+func VerifyWebhook(secret string, timestampHeader string, signatureHeader string, body []byte, maxAge time.Duration) error {
+	seconds, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidWebhookSignature)
+	}
+	timestamp := time.Unix(seconds, 0)
+	if age := time.Since(timestamp); age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: timestamp is out of range", ErrInvalidWebhookSignature)
+	}
+	if !strings.HasPrefix(signatureHeader, "sha256=") {
+		return fmt.Errorf("%w: unsupported signature format", ErrInvalidWebhookSignature)
+	}
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signatureHeader)) {
+		return ErrInvalidWebhookSignature
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package ledger
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VerifyWebhook(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"twitchUserId":"1001"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := SignWebhook("mock-secret", now, body)
+
+	assert.NoError(t, VerifyWebhook("mock-secret", timestamp, signature, body, time.Minute))
+	assert.ErrorIs(t, VerifyWebhook("wrong-secret", timestamp, signature, body, time.Minute), ErrInvalidWebhookSignature)
+	assert.ErrorIs(t, VerifyWebhook("mock-secret", timestamp, signature, []byte(`{}`), time.Minute), ErrInvalidWebhookSignature)
+	assert.ErrorIs(t, VerifyWebhook("mock-secret", "foo", signature, body, time.Minute), ErrInvalidWebhookSignature)
+	assert.ErrorIs(t, VerifyWebhook("mock-secret", timestamp, "md5=abc", body, time.Minute), ErrInvalidWebhookSignature)
+
+	stale := now.Add(-10 * time.Minute)
+	staleSignature := SignWebhook("mock-secret", stale, body)
+	assert.ErrorIs(t, VerifyWebhook("mock-secret", strconv.FormatInt(stale.Unix(), 10), staleSignature, body, time.Minute), ErrInvalidWebhookSignature)
+}